@@ -17,10 +17,12 @@ import (
 
 	"github.com/CanonicalLtd/candid"
 	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/local"
 	"github.com/CanonicalLtd/candid/idp/static"
 	"github.com/CanonicalLtd/candid/meeting"
 	"github.com/CanonicalLtd/candid/store"
 	"github.com/CanonicalLtd/candid/store/memstore"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 type Server struct {
@@ -38,14 +40,17 @@ type Server struct {
 	ProviderDataStore store.ProviderDataStore
 	RootKeyStore      bakery.RootKeyStore
 	ACLStore          aclstore.ACLStore
+	AuditStore        store.AuditStore
+	WebhookQueue      webhook.Queue
 
 	listener net.Listener
 	server   *http.Server
 }
 
 // New creates a new candid server for use in tests. The server will use
-// a static IDP with the given set of users. The server must be closed
-// when finished with.
+// a static IDP with the given set of users, and a local IDP named
+// "local" for users whose passwords are managed by candid. The server
+// must be closed when finished with.
 func New(users map[string]static.UserInfo) (*Server, error) {
 	s := new(Server)
 	s.Store = memstore.NewStore()
@@ -53,6 +58,8 @@ func New(users map[string]static.UserInfo) (*Server, error) {
 	s.ProviderDataStore = memstore.NewProviderDataStore()
 	s.RootKeyStore = bakery.NewMemRootKeyStore()
 	s.ACLStore = aclstore.NewACLStore(memsimplekv.NewStore())
+	s.AuditStore = memstore.NewAuditStore()
+	s.WebhookQueue = memstore.NewWebhookQueue()
 	key, err := bakery.GenerateKey()
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -77,14 +84,19 @@ func New(users map[string]static.UserInfo) (*Server, error) {
 		RootKeyStore:      s.RootKeyStore,
 		Store:             s.Store,
 		ACLStore:          s.ACLStore,
+		AuditStore:        s.AuditStore,
+		WebhookQueue:      s.WebhookQueue,
 		Key:               key,
 		Location:          s.URL,
 		IdentityProviders: []idp.IdentityProvider{
 			staticIDP,
+			local.NewIdentityProvider(local.Params{
+				Domain: "local",
+			}),
 		},
 		AdminAgentPublicKey: &s.AdminAgentKey.Public,
 		PrivateAddr:         "127.0.0.1",
-	}, candid.Debug, candid.Discharger, candid.V1)
+	}, candid.Debug, candid.Discharger, candid.SCIM, candid.V1)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -129,6 +141,12 @@ func (s *Server) AddIdentity(ctx context.Context, identity *store.Identity) {
 	if identity.Owner != "" {
 		update[store.Owner] = store.Set
 	}
+	if identity.Suspended {
+		update[store.Suspended] = store.Set
+	}
+	if !identity.NotBefore.IsZero() {
+		update[store.NotBefore] = store.Set
+	}
 	if err := s.Store.UpdateIdentity(ctx, identity, update); err != nil {
 		panic(err)
 	}
@@ -57,9 +57,18 @@ func New() cmd.Command {
 	supercmd.Register(newACLCommand(c))
 	supercmd.Register(newAddGroupCommand(c))
 	supercmd.Register(newCreateAgentCommand(c))
+	supercmd.Register(newDeleteUserCommand(c))
 	supercmd.Register(newFindCommand(c))
+	supercmd.Register(newListGroupsCommand(c))
 	supercmd.Register(newRemoveGroupCommand(c))
+	supercmd.Register(newResetPasswordCommand(c))
+	supercmd.Register(newResumeCommand(c))
+	supercmd.Register(newRevokeMacaroonCommand(c))
+	supercmd.Register(newRevokeSessionsCommand(c))
+	supercmd.Register(newSetPasswordCommand(c))
 	supercmd.Register(newShowCommand(c))
+	supercmd.Register(newShowGroupCommand(c))
+	supercmd.Register(newSuspendCommand(c))
 	return supercmd
 }
 
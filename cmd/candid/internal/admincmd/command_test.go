@@ -6,6 +6,7 @@ package admincmd_test
 import (
 	"bytes"
 	"path/filepath"
+	"strings"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/juju/cmd"
@@ -72,11 +73,16 @@ func (s *fixture) CheckError(c *qt.C, expectCode int, expectMessage string, args
 }
 
 func (s *fixture) Run(args ...string) (code int, stdout, stderr string) {
+	return s.RunWithInput("", args...)
+}
+
+// RunWithInput runs the command with the given standard input.
+func (s *fixture) RunWithInput(stdin string, args ...string) (code int, stdout, stderr string) {
 	outbuf := new(bytes.Buffer)
 	errbuf := new(bytes.Buffer)
 	ctxt := &cmd.Context{
 		Dir:    s.Dir,
-		Stdin:  bytes.NewReader(nil),
+		Stdin:  strings.NewReader(stdin),
 		Stdout: outbuf,
 		Stderr: errbuf,
 	}
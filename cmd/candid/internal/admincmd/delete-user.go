@@ -0,0 +1,72 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type deleteUserCommand struct {
+	userCommand
+
+	anonymise bool
+}
+
+func newDeleteUserCommand(cc *candidCommand) cmd.Command {
+	c := &deleteUserCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var deleteUserDoc = `
+The delete-user command removes a user, along with any agents that the
+user owns.
+
+If --anonymise is specified then the user's record is kept, but its
+name, email address, groups, keys and other information are removed
+and the user is suspended. Only the user's identifier at its identity
+provider is kept, which stops the same external identity from logging
+in again.
+
+To delete the user bob:
+    candid delete-user -u bob
+
+To anonymise the user with the email address bob@example.com:
+    candid delete-user --anonymise -e bob@example.com
+`
+
+func (c *deleteUserCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "delete-user",
+		Purpose: "delete a user",
+		Doc:     deleteUserDoc,
+	}
+}
+
+func (c *deleteUserCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.userCommand.SetFlags(f)
+	f.BoolVar(&c.anonymise, "anonymise", false, "remove all personal information but keep the user's record")
+}
+
+func (c *deleteUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.Client.Call(context.Background(), &candidparams.DeleteUserRequest{
+		Username:  username,
+		Anonymise: c.anonymise,
+	}, nil))
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type deleteUserSuite struct {
+	fixture *fixture
+}
+
+func TestDeleteUser(t *testing.T) {
+	qtsuite.Run(qt.New(t), &deleteUserSuite{})
+}
+
+func (s *deleteUserSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *deleteUserSuite) TestDeleteUser(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"g1"},
+	})
+	s.fixture.CheckNoOutput(c, "delete-user", "-a", "admin.agent", "-u", "bob")
+	err := s.fixture.server.Store.Identity(context.Background(), &store.Identity{
+		Username: "bob",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *deleteUserSuite) TestDeleteUserAnonymise(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Email:      "bob@example.com",
+		Groups:     []string{"g1"},
+	})
+	s.fixture.CheckNoOutput(c, "delete-user", "-a", "admin.agent", "--anonymise", "-e", "bob@example.com")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.Username, qt.Equals, "deleted-"+identity.ID)
+	c.Assert(identity.Email, qt.Equals, "")
+	c.Assert(identity.Groups, qt.HasLen, 0)
+	c.Assert(identity.Suspended, qt.Equals, true)
+}
+
+func (s *deleteUserSuite) TestDeleteUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Delete .*/v1/u/bob: user bob not found`,
+		"delete-user", "-a", "admin.agent", "-u", "bob",
+	)
+}
+
+func (s *deleteUserSuite) TestDeleteUserNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"delete-user", "-a", "admin.agent",
+	)
+}
@@ -5,7 +5,6 @@ package admincmd
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -14,6 +13,8 @@ import (
 	"github.com/juju/gnuflag"
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
 	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
 )
 
 type findCommand struct {
@@ -23,8 +24,13 @@ type findCommand struct {
 
 	detail            string
 	email             string
+	name              string
+	groups            string
+	provider          string
+	match             string
 	lastLoginDays     uint
 	lastDischargeDays uint
+	pageSize          int
 }
 
 func newFindCommand(c *candidCommand) cmd.Command {
@@ -38,6 +44,13 @@ The find command finds users that match the request parameters.
 
     candid find -e bob@example.com
     candid find --last-login=30
+    candid find --name smith --match contains
+    candid find --group admins,ops --provider usso
+
+By default the email address and name must match exactly. Use --match
+to match on a case-insensitive prefix or substring instead. Results are
+fetched from the identity server a page at a time; use --page-size to
+change the number of users requested in each page.
 `
 
 func (c *findCommand) Info() *cmd.Info {
@@ -61,11 +74,26 @@ func (c *findCommand) SetFlags(f *gnuflag.FlagSet) {
 	f.StringVar(&c.detail, "d", "", "include user details, comma separated list of external_id, email, gravatar_id, or fullname output is forced to tab separated")
 	f.StringVar(&c.email, "e", "", "email address of the user")
 	f.StringVar(&c.email, "email", "", "")
+	f.StringVar(&c.name, "n", "", "full name of the user")
+	f.StringVar(&c.name, "name", "", "")
+	f.StringVar(&c.groups, "g", "", "comma separated list of groups of which the user must be a member")
+	f.StringVar(&c.groups, "group", "", "")
+	f.StringVar(&c.provider, "provider", "", "name of the identity provider of the user")
+	f.StringVar(&c.match, "match", "exact", "how to match the email address and name, one of exact, prefix or contains")
 	f.UintVar(&c.lastLoginDays, "last-login", 0, "users whose last successful login was within this number of days")
 	f.UintVar(&c.lastDischargeDays, "last-discharge", 0, "users whose last successful discharge was within this number of days")
+	f.IntVar(&c.pageSize, "page-size", 0, "number of users to request from the identity server at a time")
 }
 
 func (c *findCommand) Init(args []string) error {
+	switch candidparams.MatchType(c.match) {
+	case candidparams.MatchExact, candidparams.MatchPrefix, candidparams.MatchContains:
+	default:
+		return errgo.Newf("invalid match type %q", c.match)
+	}
+	if c.pageSize < 0 {
+		return errgo.Newf("invalid page size %d", c.pageSize)
+	}
 	return errgo.Mask(c.candidCommand.Init(nil))
 }
 
@@ -75,8 +103,18 @@ func (c *findCommand) Run(ctxt *cmd.Context) error {
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	req := params.QueryUsersRequest{
-		Email: c.email,
+	req := candidparams.SearchUsersRequest{
+		Name:     c.name,
+		Email:    c.email,
+		Match:    candidparams.MatchType(c.match),
+		Provider: c.provider,
+		Full:     c.detail != "",
+		Limit:    c.pageSize,
+	}
+	if c.groups != "" {
+		for _, g := range strings.Split(c.groups, ",") {
+			req.Groups = append(req.Groups, strings.TrimSpace(g))
+		}
 	}
 	if c.lastLoginDays > 0 {
 		req.LastLoginSince = daysAgo(c.lastLoginDays)
@@ -84,24 +122,28 @@ func (c *findCommand) Run(ctxt *cmd.Context) error {
 	if c.lastDischargeDays > 0 {
 		req.LastDischargeSince = daysAgo(c.lastDischargeDays)
 	}
-	usernames, err := client.QueryUsers(context.Background(), &req)
-	if err != nil {
-		return errgo.Mask(err)
+	usernames := []string{}
+	var users []params.User
+	for {
+		var resp candidparams.SearchUsersResponse
+		if err := client.Client.Call(context.Background(), &req, &resp); err != nil {
+			return errgo.Mask(err)
+		}
+		usernames = append(usernames, resp.Usernames...)
+		users = append(users, resp.Users...)
+		if resp.Cursor == "" {
+			break
+		}
+		req.Cursor = resp.Cursor
 	}
 	if "" == c.detail {
 		return c.out.Write(ctxt, usernames)
 	}
 	fields := strings.Split(c.detail, ",")
 	var user_output []map[string]string
-	for _, u := range usernames {
+	for _, user := range users {
 		user_out := make(map[string]string)
-		user_out["username"] = u
-		user, err2 := client.User(context.Background(), &params.UserRequest{
-			Username: params.Username(u),
-		})
-		if err2 != nil {
-			fmt.Fprintf(ctxt.Stderr, "%v ... continuing\n", err2)
-		}
+		user_out["username"] = string(user.Username)
 		for _, f := range fields {
 			switch strings.ToLower(strings.Trim(f, " ")) {
 			case "email":
@@ -120,16 +162,9 @@ func (c *findCommand) Run(ctxt *cmd.Context) error {
 }
 
 // daysAgo returns the current time less the given
-// number of days, formatted as a string as required
-// by time fields in params.QueryUsersRequest.
-func daysAgo(days uint) string {
-	t := time.Now().AddDate(0, 0, -int(days))
-	b, err := t.MarshalText()
-	if err != nil {
-		// This should be impossible unless things are severly wrong.
-		panic(err)
-	}
-	return string(b)
+// number of days.
+func daysAgo(days uint) time.Time {
+	return time.Now().AddDate(0, 0, -int(days))
 }
 
 func (c *findCommand) formatTab(writer io.Writer, value interface{}) error {
@@ -177,3 +177,94 @@ func (s *findSuite) TestFindWithEmailAndGravatar(c *qt.C) {
 		{"username": "charlie", "email": "charlie@example.com", "gravatar_id": "426b189df1e2f359efe6ee90f2d2030f"},
 	})
 }
+
+func addSearchIdentities(f *fixture) {
+	ctx := context.Background()
+	identities := []store.Identity{{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Name:       "Bob Smith",
+		Email:      "bob@example.com",
+		Groups:     []string{"g1", "g2"},
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Name:       "Alice Smithson",
+		Groups:     []string{"g1"},
+	}, {
+		ProviderID: store.MakeProviderIdentity("other", "charlie"),
+		Username:   "charlie",
+		Name:       "Charlie Brown",
+		Groups:     []string{"g2"},
+	}}
+	for _, id := range identities {
+		f.server.AddIdentity(ctx, &id)
+	}
+}
+
+var findSearchTests = []struct {
+	about       string
+	args        []string
+	expectUsers []string
+}{{
+	about:       "name exact",
+	args:        []string{"--name", "Bob Smith"},
+	expectUsers: []string{"bob"},
+}, {
+	about:       "name contains",
+	args:        []string{"--name", "smith", "--match", "contains"},
+	expectUsers: []string{"alice", "bob"},
+}, {
+	about:       "name prefix",
+	args:        []string{"-n", "charlie", "--match", "prefix"},
+	expectUsers: []string{"charlie"},
+}, {
+	about:       "email prefix",
+	args:        []string{"-e", "BOB@", "--match", "prefix"},
+	expectUsers: []string{"bob"},
+}, {
+	about:       "groups",
+	args:        []string{"--group", "g1, g2"},
+	expectUsers: []string{"bob"},
+}, {
+	about:       "provider",
+	args:        []string{"--provider", "test"},
+	expectUsers: []string{"alice", "bob"},
+}, {
+	about:       "paged",
+	args:        []string{"--page-size", "1"},
+	expectUsers: []string{"admin@candid", "alice", "bob", "charlie"},
+}}
+
+func (s *findSuite) TestFindSearch(c *qt.C) {
+	for _, test := range findSearchTests {
+		c.Run(test.about, func(c *qt.C) {
+			// Each command may only be run once, so use a new
+			// fixture for each test.
+			f := newFixture(c)
+			addSearchIdentities(f)
+			args := append([]string{"find", "-a", "admin.agent", "--format", "json"}, test.args...)
+			stdout := f.CheckSuccess(c, args...)
+			var usernames []string
+			err := json.Unmarshal([]byte(stdout), &usernames)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(usernames, qt.DeepEquals, test.expectUsers)
+		})
+	}
+}
+
+func (s *findSuite) TestFindPagedWithDetail(c *qt.C) {
+	addSearchIdentities(s.fixture)
+	stdout := s.fixture.CheckSuccess(c, "find", "-a", "admin.agent", "--provider", "test", "--page-size", "1", "-d", "fullname", "--format", "json")
+	var users []map[string]string
+	err := json.Unmarshal([]byte(stdout), &users)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []map[string]string{
+		{"username": "alice", "fullname": "Alice Smithson"},
+		{"username": "bob", "fullname": "Bob Smith"},
+	})
+}
+
+func (s *findSuite) TestFindInvalidMatch(c *qt.C) {
+	s.fixture.CheckError(c, 2, `invalid match type "regexp"`, "find", "-a", "admin.agent", "--match", "regexp")
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type listGroupsCommand struct {
+	*candidCommand
+
+	out cmd.Output
+}
+
+func newListGroupsCommand(c *candidCommand) cmd.Command {
+	return &listGroupsCommand{
+		candidCommand: c,
+	}
+}
+
+var listGroupsDoc = `
+The list-groups command lists the groups known to the identity server.
+
+    candid list-groups
+`
+
+func (c *listGroupsCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "list-groups",
+		Purpose: "list groups",
+		Doc:     listGroupsDoc,
+	}
+}
+
+func (c *listGroupsCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+
+	c.out.AddFlags(f, "tab", map[string]cmd.Formatter{
+		"yaml": cmd.FormatYaml,
+		"json": cmd.FormatJson,
+		"tab":  formatGroupsTab,
+	})
+}
+
+func (c *listGroupsCommand) Init(args []string) error {
+	return errgo.Mask(c.candidCommand.Init(args))
+}
+
+func (c *listGroupsCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var groups []candidparams.Group
+	if err := client.Client.Call(context.Background(), &candidparams.GroupsRequest{}, &groups); err != nil {
+		return errgo.Mask(err)
+	}
+	gs := make([]group, len(groups))
+	for i, g := range groups {
+		gs[i] = group{
+			Name:        g.Name,
+			Description: g.Description,
+			Owner:       string(g.Owner),
+			Groups:      g.Groups,
+		}
+	}
+	return c.out.Write(ctxt, gs)
+}
+
+// formatGroupsTab writes the name and description of each group in
+// aligned columns.
+func formatGroupsTab(writer io.Writer, value interface{}) error {
+	groups, ok := value.([]group)
+	if !ok {
+		return errgo.Newf("unexpected value %T", value)
+	}
+	width := 0
+	for _, g := range groups {
+		if len(g.Name) > width {
+			width = len(g.Name)
+		}
+	}
+	lines := make([]string, len(groups))
+	for i, g := range groups {
+		lines[i] = strings.TrimRight(fmt.Sprintf("%-*s  %s", width, g.Name, g.Description), " ")
+	}
+	// The final newline is added by cmd.Output.
+	_, err := io.WriteString(writer, strings.Join(lines, "\n"))
+	return errgo.Mask(err)
+}
+
+// group represents a group in the system.
+type group struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Groups      []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Members     []string `json:"members,omitempty" yaml:"members,omitempty"`
+}
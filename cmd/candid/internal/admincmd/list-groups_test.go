@@ -0,0 +1,76 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type listGroupsSuite struct {
+	fixture *fixture
+}
+
+func TestListGroups(t *testing.T) {
+	qtsuite.Run(qt.New(t), &listGroupsSuite{})
+}
+
+func (s *listGroupsSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *listGroupsSuite) TestListGroups(c *qt.C) {
+	s.createGroups(c)
+	stdout := s.fixture.CheckSuccess(c, "list-groups", "-a", "admin.agent")
+	c.Assert(stdout, qt.Equals, `
+engineering  All engineers
+ops
+team-a       Team A
+`[1:])
+}
+
+func (s *listGroupsSuite) TestListGroupsYAML(c *qt.C) {
+	s.createGroups(c)
+	stdout := s.fixture.CheckSuccess(c, "list-groups", "-a", "admin.agent", "--format", "yaml")
+	c.Assert(stdout, qt.Equals, `
+- name: engineering
+  description: All engineers
+- name: ops
+- name: team-a
+  description: Team A
+  groups:
+  - engineering
+`[1:])
+}
+
+func (s *listGroupsSuite) createGroups(c *qt.C) {
+	ctx := context.Background()
+	for _, g := range []store.Group{{
+		Name:        "team-a",
+		Description: "Team A",
+		Groups:      []string{"engineering"},
+	}, {
+		Name:        "engineering",
+		Description: "All engineers",
+	}, {
+		Name: "ops",
+	}} {
+		err := s.fixture.server.Store.CreateGroup(ctx, &g)
+		c.Assert(err, qt.Equals, nil)
+	}
+}
+
+func (s *listGroupsSuite) TestListGroupsNoGroups(c *qt.C) {
+	stdout := s.fixture.CheckSuccess(c, "list-groups", "-a", "admin.agent")
+	c.Assert(stdout, qt.Equals, "\n")
+}
+
+func (s *listGroupsSuite) TestListGroupsUnexpectedArguments(c *qt.C) {
+	s.fixture.CheckError(c, 2, `unrecognized args: \["extra"\]`, "list-groups", "extra")
+}
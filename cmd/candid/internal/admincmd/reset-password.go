@@ -0,0 +1,67 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type resetPasswordCommand struct {
+	userCommand
+}
+
+func newResetPasswordCommand(cc *candidCommand) cmd.Command {
+	c := &resetPasswordCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var resetPasswordDoc = `
+The reset-password command resets the password of a user of a local
+identity provider to a new temporary password, which is printed. The
+user must change the temporary password the next time they log in.
+Resetting a password also unlocks an account that has been locked
+after too many failed login attempts.
+
+To reset the password of the user bob:
+    candid reset-password -u bob
+
+To reset the password of the user with the email address
+bob@example.com:
+    candid reset-password -e bob@example.com
+`
+
+func (c *resetPasswordCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "reset-password",
+		Purpose: "reset the password of a local user",
+		Doc:     resetPasswordDoc,
+	}
+}
+
+func (c *resetPasswordCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var resp candidparams.ResetPasswordResponse
+	if err := client.Client.Call(context.Background(), &candidparams.ResetPasswordRequest{
+		Username: username,
+	}, &resp); err != nil {
+		return errgo.Mask(err)
+	}
+	fmt.Fprintln(ctxt.Stdout, resp.Password)
+	return nil
+}
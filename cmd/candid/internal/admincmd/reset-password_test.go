@@ -0,0 +1,50 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type resetPasswordSuite struct {
+	fixture *fixture
+}
+
+func TestResetPassword(t *testing.T) {
+	qtsuite.Run(qt.New(t), &resetPasswordSuite{})
+}
+
+func (s *resetPasswordSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *resetPasswordSuite) TestResetPassword(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("local", "bob@local"),
+		Username:   "bob@local",
+	})
+	stdout := s.fixture.CheckSuccess(c, "reset-password", "-a", "admin.agent", "-u", "bob@local")
+	c.Assert(stdout, qt.Matches, `[A-Za-z0-9_-]{16}\n`)
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("local", "bob@local"),
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.ProviderInfo["password-expired"], qt.DeepEquals, []string{"true"})
+}
+
+func (s *resetPasswordSuite) TestResetPasswordNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post .*/v1/u/bob@local/password/reset: user "bob@local" not found`,
+		"reset-password", "-a", "admin.agent", "-u", "bob@local",
+	)
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type resumeCommand struct {
+	userCommand
+}
+
+func newResumeCommand(cc *candidCommand) cmd.Command {
+	c := &resumeCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var resumeDoc = `
+The resume command restores a user that was previously suspended with
+the suspend command.
+
+To resume the user bob:
+    candid resume -u bob
+
+To resume the user with the email address bob@example.com:
+    candid resume -e bob@example.com
+`
+
+func (c *resumeCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "resume",
+		Purpose: "resume a suspended user",
+		Doc:     resumeDoc,
+	}
+}
+
+func (c *resumeCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	return errgo.Mask(c.setStatus(ctxt, candidparams.UserActive), errgo.Any)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type resumeSuite struct {
+	fixture *fixture
+}
+
+func TestResume(t *testing.T) {
+	qtsuite.Run(qt.New(t), &resumeSuite{})
+}
+
+func (s *resumeSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *resumeSuite) TestResume(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Email:      "bob@example.com",
+		Suspended:  true,
+	})
+	s.fixture.CheckNoOutput(c, "resume", "-a", "admin.agent", "-e", "bob@example.com")
+	identity := store.Identity{
+		Username: "bob",
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.Suspended, qt.Equals, false)
+}
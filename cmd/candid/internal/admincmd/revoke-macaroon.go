@@ -0,0 +1,63 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type revokeMacaroonCommand struct {
+	*candidCommand
+
+	ids []string
+}
+
+func newRevokeMacaroonCommand(c *candidCommand) cmd.Command {
+	return &revokeMacaroonCommand{
+		candidCommand: c,
+	}
+}
+
+var revokeMacaroonDoc = `
+The revoke-macaroon command adds macaroons to the revocation list so
+that they are no longer accepted by the identity manager. Each macaroon
+is specified by its ID encoded with unpadded URL-safe base64.
+
+    candid revoke-macaroon AwoQY2Fu...
+`
+
+func (c *revokeMacaroonCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "revoke-macaroon",
+		Args:    "id...",
+		Purpose: "revoke individual macaroons",
+		Doc:     revokeMacaroonDoc,
+	}
+}
+
+func (c *revokeMacaroonCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errgo.New("macaroon id not specified")
+	}
+	c.ids = args
+	return errgo.Mask(c.candidCommand.Init(nil))
+}
+
+func (c *revokeMacaroonCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.Client.Call(context.Background(), &candidparams.RevokeMacaroonsRequest{
+		Body: candidparams.RevokeMacaroonsBody{
+			IDs: c.ids,
+		},
+	}, nil))
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+)
+
+type revokeMacaroonSuite struct {
+	fixture *fixture
+}
+
+func TestRevokeMacaroon(t *testing.T) {
+	qtsuite.Run(qt.New(t), &revokeMacaroonSuite{})
+}
+
+func (s *revokeMacaroonSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *revokeMacaroonSuite) TestRevokeMacaroon(c *qt.C) {
+	s.fixture.CheckNoOutput(c, "revoke-macaroon", "-a", "admin.agent", "AQID", "BAUG")
+}
+
+func (s *revokeMacaroonSuite) TestRevokeMacaroonInvalidID(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post .*/v1/revoke-macaroons: invalid macaroon id "not base64!"`,
+		"revoke-macaroon", "-a", "admin.agent", "not base64!",
+	)
+}
+
+func (s *revokeMacaroonSuite) TestRevokeMacaroonNoID(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`macaroon id not specified`,
+		"revoke-macaroon", "-a", "admin.agent",
+	)
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type revokeSessionsCommand struct {
+	userCommand
+}
+
+func newRevokeSessionsCommand(cc *candidCommand) cmd.Command {
+	c := &revokeSessionsCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var revokeSessionsDoc = `
+The revoke-sessions command revokes all of the discharge tokens and
+identity macaroons that have been issued to a user. The user will have
+to log in again before they can use any service, which cuts off any
+device that the user is no longer in control of.
+
+To revoke the sessions of the user bob:
+    candid revoke-sessions -u bob
+
+To revoke the sessions of the user with the email address
+bob@example.com:
+    candid revoke-sessions -e bob@example.com
+`
+
+func (c *revokeSessionsCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "revoke-sessions",
+		Purpose: "revoke all sessions of a user",
+		Doc:     revokeSessionsDoc,
+	}
+}
+
+func (c *revokeSessionsCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.Client.Call(context.Background(), &candidparams.RevokeSessionsRequest{
+		Username: username,
+	}, nil))
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type revokeSessionsSuite struct {
+	fixture *fixture
+}
+
+func TestRevokeSessions(t *testing.T) {
+	qtsuite.Run(qt.New(t), &revokeSessionsSuite{})
+}
+
+func (s *revokeSessionsSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *revokeSessionsSuite) TestRevokeSessions(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	})
+	s.fixture.CheckNoOutput(c, "revoke-sessions", "-a", "admin.agent", "-u", "bob")
+	identity := store.Identity{
+		Username: "bob",
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.NotBefore.IsZero(), qt.Equals, false)
+}
+
+func (s *revokeSessionsSuite) TestRevokeSessionsNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post .*/v1/u/bob/revoke-sessions: user bob not found`,
+		"revoke-sessions", "-a", "admin.agent", "-u", "bob",
+	)
+}
+
+func (s *revokeSessionsSuite) TestRevokeSessionsNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"revoke-sessions", "-a", "admin.agent",
+	)
+}
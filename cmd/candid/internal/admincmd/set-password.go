@@ -0,0 +1,68 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type setPasswordCommand struct {
+	userCommand
+}
+
+func newSetPasswordCommand(cc *candidCommand) cmd.Command {
+	c := &setPasswordCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var setPasswordDoc = `
+The set-password command sets the password of a user of a local
+identity provider. If the user does not already exist it will be
+created. The new password is read from the first line of standard
+input and must follow the password policy of the identity provider.
+
+To set the password of the user bob:
+    candid set-password -u bob < password.txt
+`
+
+func (c *setPasswordCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "set-password",
+		Purpose: "set the password of a local user",
+		Doc:     setPasswordDoc,
+	}
+}
+
+func (c *setPasswordCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	password, err := bufio.NewReader(ctxt.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		if err != nil {
+			return errgo.Notef(err, "cannot read password")
+		}
+		return errgo.New("no password specified")
+	}
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req := &candidparams.SetPasswordRequest{
+		Username: username,
+	}
+	req.Body.Password = password
+	return errgo.Mask(client.Client.Call(context.Background(), req, nil))
+}
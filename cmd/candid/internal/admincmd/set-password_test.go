@@ -0,0 +1,70 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type setPasswordSuite struct {
+	fixture *fixture
+}
+
+func TestSetPassword(t *testing.T) {
+	qtsuite.Run(qt.New(t), &setPasswordSuite{})
+}
+
+func (s *setPasswordSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *setPasswordSuite) TestSetPassword(c *qt.C) {
+	code, stdout, stderr := s.fixture.RunWithInput("correct horse\n", "set-password", "-a", "admin.agent", "-u", "bob@local")
+	c.Assert(code, qt.Equals, 0, qt.Commentf("%s", stderr))
+	c.Assert(stdout, qt.Equals, "")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("local", "bob@local"),
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.Username, qt.Equals, "bob@local")
+	c.Assert(identity.ProviderInfo["password"], qt.HasLen, 1)
+}
+
+func (s *setPasswordSuite) TestSetPasswordPolicy(c *qt.C) {
+	code, stdout, stderr := s.fixture.RunWithInput("short\n", "set-password", "-a", "admin.agent", "-u", "bob@local")
+	c.Assert(code, qt.Equals, 1)
+	c.Assert(stdout, qt.Equals, "")
+	c.Assert(stderr, qt.Matches, `ERROR Put .*/v1/u/bob@local/password: password must be at least 8 characters long\n`)
+}
+
+func (s *setPasswordSuite) TestSetPasswordNoPassword(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`cannot read password: EOF`,
+		"set-password", "-a", "admin.agent", "-u", "bob@local",
+	)
+}
+
+func (s *setPasswordSuite) TestSetPasswordNotLocal(c *qt.C) {
+	code, _, stderr := s.fixture.RunWithInput("correct horse\n", "set-password", "-a", "admin.agent", "-u", "bob@example")
+	c.Assert(code, qt.Equals, 1)
+	c.Assert(stderr, qt.Matches, `ERROR Put .*/v1/u/bob@example/password: no password identity provider for user "bob@example"\n`)
+}
+
+func (s *setPasswordSuite) TestSetPasswordNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"set-password", "-a", "admin.agent",
+	)
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type showGroupCommand struct {
+	*candidCommand
+
+	out cmd.Output
+
+	name string
+}
+
+func newShowGroupCommand(c *candidCommand) cmd.Command {
+	return &showGroupCommand{
+		candidCommand: c,
+	}
+}
+
+var showGroupDoc = `
+The show-group command shows the details of the specified group,
+including the users that are members of it.
+
+    candid show-group engineering
+`
+
+func (c *showGroupCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "show-group",
+		Args:    "group",
+		Purpose: "show group details",
+		Doc:     showGroupDoc,
+	}
+}
+
+func (c *showGroupCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+
+	c.out.AddFlags(f, "smart", cmd.DefaultFormatters)
+}
+
+func (c *showGroupCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errgo.New("group not specified")
+	}
+	c.name, args = args[0], args[1:]
+	return errgo.Mask(c.candidCommand.Init(args))
+}
+
+func (c *showGroupCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var g candidparams.Group
+	if err := client.Client.Call(ctx, &candidparams.GroupRequest{Name: c.name}, &g); err != nil {
+		return errgo.Mask(err)
+	}
+	var members []string
+	if err := client.Client.Call(ctx, &candidparams.GroupMembersRequest{Name: c.name}, &members); err != nil {
+		return errgo.Mask(err)
+	}
+	return c.out.Write(ctxt, group{
+		Name:        g.Name,
+		Description: g.Description,
+		Owner:       string(g.Owner),
+		Groups:      g.Groups,
+		Members:     members,
+	})
+}
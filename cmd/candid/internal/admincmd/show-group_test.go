@@ -0,0 +1,66 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type showGroupSuite struct {
+	fixture *fixture
+}
+
+func TestShowGroup(t *testing.T) {
+	qtsuite.Run(qt.New(t), &showGroupSuite{})
+}
+
+func (s *showGroupSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *showGroupSuite) TestShowGroup(c *qt.C) {
+	ctx := context.Background()
+	s.fixture.server.AddIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"team-a"},
+	})
+	s.fixture.server.AddIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Groups:     []string{"team-a", "team-b"},
+	})
+	err := s.fixture.server.Store.CreateGroup(ctx, &store.Group{
+		Name:        "team-a",
+		Description: "Team A",
+		Owner:       store.MakeProviderIdentity("test", "bob"),
+		Groups:      []string{"engineering"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	stdout := s.fixture.CheckSuccess(c, "show-group", "-a", "admin.agent", "team-a")
+	c.Assert(stdout, qt.Equals, `
+name: team-a
+description: Team A
+owner: bob
+groups:
+- engineering
+members:
+- alice
+- bob
+`[1:])
+}
+
+func (s *showGroupSuite) TestShowGroupNotFound(c *qt.C) {
+	s.fixture.CheckError(c, 1, `Get .*: group team-a not found`, "show-group", "-a", "admin.agent", "team-a")
+}
+
+func (s *showGroupSuite) TestShowGroupNoGroup(c *qt.C) {
+	s.fixture.CheckError(c, 2, `group not specified`, "show-group")
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+type suspendCommand struct {
+	userCommand
+}
+
+func newSuspendCommand(cc *candidCommand) cmd.Command {
+	c := &suspendCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var suspendDoc = `
+The suspend command suspends a user. A suspended user cannot log in or
+obtain discharges, and any identity macaroons previously issued to the
+user are rejected. The user's groups and other details are unchanged,
+so the user can later be restored with the resume command.
+
+To suspend the user bob:
+    candid suspend -u bob
+
+To suspend the user with the email address bob@example.com:
+    candid suspend -e bob@example.com
+`
+
+func (c *suspendCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "suspend",
+		Purpose: "suspend a user",
+		Doc:     suspendDoc,
+	}
+}
+
+func (c *suspendCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	return errgo.Mask(c.setStatus(ctxt, candidparams.UserSuspended), errgo.Any)
+}
+
+// setStatus sets the status of the user specified on the command line.
+func (c *userCommand) setStatus(ctxt *cmd.Context, status candidparams.UserStatus) error {
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.Client.Call(context.Background(), &candidparams.SetUserStatusRequest{
+		Username: username,
+		Body: candidparams.SetUserStatusBody{
+			Status: status,
+		},
+	}, nil))
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type suspendSuite struct {
+	fixture *fixture
+}
+
+func TestSuspend(t *testing.T) {
+	qtsuite.Run(qt.New(t), &suspendSuite{})
+}
+
+func (s *suspendSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *suspendSuite) TestSuspend(c *qt.C) {
+	s.fixture.server.AddIdentity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"g1"},
+	})
+	s.fixture.CheckNoOutput(c, "suspend", "-a", "admin.agent", "-u", "bob")
+	identity := store.Identity{
+		Username: "bob",
+	}
+	err := s.fixture.server.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.Suspended, qt.Equals, true)
+	c.Assert(identity.Groups, qt.DeepEquals, []string{"g1"})
+}
+
+func (s *suspendSuite) TestSuspendNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Put .*/v1/u/bob/status: user bob not found`,
+		"suspend", "-a", "admin.agent", "-u", "bob",
+	)
+}
+
+func (s *suspendSuite) TestSuspendNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"suspend", "-a", "admin.agent",
+	)
+}
@@ -4,6 +4,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"html/template"
@@ -27,10 +29,17 @@ import (
 	_ "github.com/CanonicalLtd/candid/idp/google"
 	_ "github.com/CanonicalLtd/candid/idp/keystone"
 	_ "github.com/CanonicalLtd/candid/idp/ldap"
+	_ "github.com/CanonicalLtd/candid/idp/local"
+	_ "github.com/CanonicalLtd/candid/idp/saml"
 	_ "github.com/CanonicalLtd/candid/idp/static"
+	_ "github.com/CanonicalLtd/candid/idp/totp"
 	"github.com/CanonicalLtd/candid/idp/usso"
 	_ "github.com/CanonicalLtd/candid/idp/usso/ussodischarge"
 	_ "github.com/CanonicalLtd/candid/idp/usso/ussooauth"
+	_ "github.com/CanonicalLtd/candid/idp/webauthn"
+	"github.com/CanonicalLtd/candid/internal/tracing"
+	"github.com/CanonicalLtd/candid/policy"
+	"github.com/CanonicalLtd/candid/store"
 	_ "github.com/CanonicalLtd/candid/store/memstore"
 	_ "github.com/CanonicalLtd/candid/store/mgostore"
 	_ "github.com/CanonicalLtd/candid/store/sqlstore"
@@ -58,7 +67,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "STOP cannot configure loggers: %v", err)
 		exit(2)
 	}
-	if err := serve(conf); err != nil {
+	if err := serve(confPath, conf); err != nil {
 		fmt.Fprintf(os.Stderr, "STOP %v\n", err)
 		exit(1)
 	}
@@ -78,30 +87,93 @@ func exit(code int) {
 }
 
 // serve starts the identity service.
-func serve(conf *config.Config) error {
+func serve(confPath string, conf *config.Config) error {
 	if conf.HTTPProxy != "" {
 		os.Setenv("HTTP_PROXY", conf.HTTPProxy)
 	}
 	if conf.NoProxy != "" {
 		os.Setenv("NO_PROXY", conf.NoProxy)
 	}
+	if conf.Tracing != nil {
+		shutdown, err := tracing.Setup(context.Background(), tracing.Params{
+			Endpoint:    conf.Tracing.Endpoint,
+			Insecure:    conf.Tracing.Insecure,
+			ServiceName: conf.Tracing.ServiceName,
+			SampleRatio: conf.Tracing.SampleRatio,
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer shutdown(context.Background())
+	}
 	backend, err := conf.Storage.NewBackend()
 	if err != nil {
 		return errgo.Mask(err)
 	}
 	defer backend.Close()
-	return serveIdentity(conf, candid.ServerParams{
+	return serveIdentity(confPath, conf, backendParams(backend))
+}
+
+// backendParams returns the server parameters that are provided by the
+// given storage backend.
+func backendParams(backend store.Backend) candid.ServerParams {
+	return candid.ServerParams{
 		Store:                   backend.Store(),
 		ProviderDataStore:       backend.ProviderDataStore(),
 		MeetingStore:            backend.MeetingStore(),
 		RootKeyStore:            backend.BakeryRootKeyStore(),
 		DebugStatusCheckerFuncs: backend.DebugStatusCheckerFuncs(),
 		ACLStore:                backend.ACLStore(),
-	})
+		AuditStore:              backend.AuditStore(),
+		WebhookQueue:            backend.WebhookQueue(),
+	}
 }
 
-func serveIdentity(conf *config.Config, params candid.ServerParams) error {
+func serveIdentity(confPath string, conf *config.Config, params candid.ServerParams) error {
 	logger.Infof("setting up the identity server")
+	srv, err := newServer(conf, params)
+	if err != nil {
+		return errgo.Notef(err, "cannot create new server at %q", conf.ListenAddress)
+	}
+	r := newReloader(confPath, conf, params, srv)
+	defer r.Close()
+
+	// Cast the reloader to an http.Handler so that it can be
+	// optionally wrapped by the logging handler below.
+	var server http.Handler = r
+
+	if conf.AccessLog != "" {
+		accesslog := &lumberjack.Logger{
+			Filename:   conf.AccessLog,
+			MaxSize:    500, // megabytes
+			MaxBackups: 3,
+			MaxAge:     28, //days
+		}
+		server = handlers.CombinedLoggingHandler(accesslog, server)
+	}
+
+	logger.Infof("starting the identity server")
+
+	httpServer := &http.Server{
+		Addr:    conf.ListenAddress,
+		Handler: server,
+	}
+	fmt.Println("START")
+	if conf.TLSConfig() != nil {
+		// Use the TLS configuration that was most recently
+		// loaded so that the certificate can be replaced by
+		// reloading the configuration.
+		httpServer.TLSConfig = &tls.Config{
+			GetConfigForClient: r.TLSConfig,
+		}
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	return httpServer.ListenAndServe()
+}
+
+// newServer returns a new identity server configured with the given
+// configuration. The storage parameters must already be set in params.
+func newServer(conf *config.Config, params candid.ServerParams) (candid.HandlerCloser, error) {
 	params.IdentityProviders = defaultIDPs
 	if len(conf.IdentityProviders) > 0 {
 		params.IdentityProviders = make([]idp.IdentityProvider, len(conf.IdentityProviders))
@@ -114,55 +186,41 @@ func serveIdentity(conf *config.Config, params candid.ServerParams) error {
 	var err error
 	params.Template, err = template.New("").ParseGlob(filepath.Join(conf.ResourcePath, "templates", "*"))
 	if err != nil {
-		return errgo.Notef(err, "cannot parse templates")
+		return nil, errgo.Notef(err, "cannot parse templates")
 	}
 
 	params.AdminPassword = conf.AdminPassword
-	params.Key = &bakery.KeyPair{
-		Private: *conf.PrivateKey,
-		Public:  *conf.PublicKey,
+	if conf.PrivateKey != nil {
+		params.Key = &bakery.KeyPair{
+			Private: *conf.PrivateKey,
+			Public:  *conf.PublicKey,
+		}
 	}
+	params.KeyRotationPeriod = conf.KeyRotationPeriod.Duration
+	params.KeyGracePeriod = conf.KeyGracePeriod.Duration
 	params.RendezvousTimeout = conf.RendezvousTimeout.Duration
 	params.Location = conf.Location
 	params.PrivateAddr = conf.PrivateAddr
 	params.AdminAgentPublicKey = conf.AdminAgentPublicKey
+	params.Webhooks = conf.Webhooks
+	params.Lifetimes = conf.Lifetimes
+	if conf.PolicyFile != "" {
+		params.Policy, err = policy.ReadFile(conf.PolicyFile)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
 	srv, err := candid.NewServer(
 		params,
 		candid.V1,
 		candid.Debug,
 		candid.Discharger,
+		candid.SCIM,
 	)
 	if err != nil {
-		return errgo.Notef(err, "cannot create new server at %q", conf.ListenAddress)
+		return nil, errgo.Mask(err)
 	}
-	defer srv.Close()
-
-	// Cast the Server to an http.Handler so that it can be
-	// optionally wrapped by the logging handler below.
-	var server http.Handler = srv
-
-	if conf.AccessLog != "" {
-		accesslog := &lumberjack.Logger{
-			Filename:   conf.AccessLog,
-			MaxSize:    500, // megabytes
-			MaxBackups: 3,
-			MaxAge:     28, //days
-		}
-		server = handlers.CombinedLoggingHandler(accesslog, server)
-	}
-
-	logger.Infof("starting the identity server")
-
-	httpServer := &http.Server{
-		Addr:      conf.ListenAddress,
-		Handler:   server,
-		TLSConfig: conf.TLSConfig(),
-	}
-	fmt.Println("START")
-	if conf.TLSConfig() != nil {
-		return httpServer.ListenAndServeTLS("", "")
-	}
-	return httpServer.ListenAndServe()
+	return srv, nil
 }
 
 var defaultIDPs = []idp.IdentityProvider{
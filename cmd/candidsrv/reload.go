@@ -0,0 +1,177 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid"
+	"github.com/CanonicalLtd/candid/config"
+)
+
+// drainPeriod holds the length of time for which a server that has
+// been replaced by a reload keeps running. This is the length of time
+// for which a rendezvous is kept, so that users who started logging in
+// with the old server can complete their login.
+var drainPeriod = time.Hour
+
+// A drainer is a server that can stop its background work, such as
+// webhook delivery, while it continues to serve the requests that are
+// in progress.
+type drainer interface {
+	Drain()
+}
+
+// A reloader is an http.Handler that serves requests with an identity
+// server built from the configuration file. When the process receives
+// a SIGHUP the configuration file is read again and a new server is
+// built to serve subsequent requests.
+type reloader struct {
+	confPath string
+	params   candid.ServerParams
+	sighup   chan os.Signal
+
+	mu        sync.Mutex
+	conf      *config.Config
+	srv       candid.HandlerCloser
+	tlsConfig *tls.Config
+	draining  map[candid.HandlerCloser]*time.Timer
+}
+
+// newReloader returns a reloader that initially serves requests with
+// srv, which was built from conf. The params hold the server
+// parameters that do not depend on the configuration, such as the
+// storage.
+func newReloader(confPath string, conf *config.Config, params candid.ServerParams, srv candid.HandlerCloser) *reloader {
+	r := &reloader{
+		confPath:  confPath,
+		params:    params,
+		sighup:    make(chan os.Signal, 1),
+		conf:      conf,
+		srv:       srv,
+		tlsConfig: conf.TLSConfig(),
+		draining:  make(map[candid.HandlerCloser]*time.Timer),
+	}
+	signal.Notify(r.sighup, syscall.SIGHUP)
+	go r.run()
+	return r
+}
+
+func (r *reloader) run() {
+	for range r.sighup {
+		logger.Infof("reloading configuration")
+		if err := r.reload(); err != nil {
+			logger.Errorf("cannot reload configuration: %s", err)
+			continue
+		}
+		logger.Infof("configuration reloaded")
+	}
+}
+
+// reload reads the configuration file and replaces the server with one
+// using the new configuration. If there is an error then the current
+// server is left in place.
+func (r *reloader) reload() error {
+	conf, err := config.Read(r.confPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	r.mu.Lock()
+	old := r.conf
+	r.mu.Unlock()
+	if conf.ListenAddress != old.ListenAddress {
+		logger.Warningf("listen-address cannot be changed without a restart")
+	}
+	if !reflect.DeepEqual(conf.Storage, old.Storage) {
+		logger.Warningf("storage cannot be changed without a restart")
+	}
+	if conf.AccessLog != old.AccessLog {
+		logger.Warningf("access-log cannot be changed without a restart")
+	}
+	if !reflect.DeepEqual(conf.Tracing, old.Tracing) {
+		logger.Warningf("tracing cannot be changed without a restart")
+	}
+	tlsConfig := conf.TLSConfig()
+	if (tlsConfig == nil) != (old.TLSConfig() == nil) {
+		logger.Warningf("TLS cannot be enabled or disabled without a restart")
+		tlsConfig = r.currentTLSConfig()
+	}
+	if _, err := loggo.ParseConfigString(conf.LoggingConfig); err != nil {
+		return errgo.Notef(err, "cannot configure loggers")
+	}
+	srv, err := newServer(conf, r.params)
+	if err != nil {
+		return errgo.Notef(err, "cannot create new server")
+	}
+	loggo.DefaultContext().ResetLoggerLevels()
+	loggo.ConfigureLoggers(conf.LoggingConfig)
+
+	r.mu.Lock()
+	oldSrv := r.srv
+	r.conf = conf
+	r.srv = srv
+	r.tlsConfig = tlsConfig
+	// Keep the old server running for a while so that any
+	// rendezvous it holds can complete.
+	r.draining[oldSrv] = time.AfterFunc(drainPeriod, func() {
+		r.mu.Lock()
+		delete(r.draining, oldSrv)
+		r.mu.Unlock()
+		oldSrv.Close()
+	})
+	r.mu.Unlock()
+	// The old server must stop everything else straight away, so
+	// that, for example, it does not deliver webhooks using the old
+	// configuration.
+	if d, ok := oldSrv.(drainer); ok {
+		d.Drain()
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler by serving the request with the
+// current server.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	srv := r.srv
+	r.mu.Unlock()
+	srv.ServeHTTP(w, req)
+}
+
+// TLSConfig returns the current TLS configuration. It is suitable for
+// use as tls.Config.GetConfigForClient.
+func (r *reloader) TLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.currentTLSConfig(), nil
+}
+
+// currentTLSConfig returns the current TLS configuration.
+func (r *reloader) currentTLSConfig() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tlsConfig
+}
+
+// Close stops reloading the configuration and closes all the servers.
+func (r *reloader) Close() {
+	signal.Stop(r.sighup)
+	close(r.sighup)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for srv, t := range r.draining {
+		if t.Stop() {
+			srv.Close()
+		}
+	}
+	r.srv.Close()
+}
@@ -0,0 +1,417 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid"
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp/test"
+)
+
+func TestReloadReplacesServer(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	f := newReloadFixture(c, nil)
+
+	f.get(c)
+	c.Assert(f.first.requestCount(), qt.Equals, 1)
+
+	f.conf["rendezvous-timeout"] = "5m"
+	f.writeConfig(c)
+	err := f.r.reload()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(f.r.conf.RendezvousTimeout.Duration, qt.Equals, 5*time.Minute)
+	c.Assert(f.r.srv, qt.Not(qt.Equals), candid.HandlerCloser(f.first))
+
+	// New requests are served by the new server.
+	f.get(c)
+	c.Assert(f.first.requestCount(), qt.Equals, 1)
+	c.Assert(f.first.isClosed(), qt.Equals, false)
+}
+
+func TestReloadDrainsOldServer(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	c.Patch(&drainPeriod, 500*time.Millisecond)
+	f := newReloadFixture(c, nil)
+
+	start := time.Now()
+	err := f.r.reload()
+	c.Assert(err, qt.Equals, nil)
+	// The old server stops its background work immediately, but
+	// is not closed until the drain period has passed.
+	c.Assert(f.first.isDrained(), qt.Equals, true)
+	c.Assert(f.first.isClosed(), qt.Equals, false)
+	select {
+	case <-f.first.closed:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("old server not closed")
+	}
+	c.Assert(time.Since(start) >= drainPeriod, qt.Equals, true)
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	c.Assert(f.r.draining, qt.HasLen, 0)
+}
+
+func TestCloseClosesDrainingServers(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	f := newReloadFixture(c, nil)
+
+	err := f.r.reload()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(f.first.isClosed(), qt.Equals, false)
+	f.Close()
+	c.Assert(f.first.isClosed(), qt.Equals, true)
+}
+
+func TestReloadCompletesRendezvousOnOldServer(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	f := newReloadFixture(c, nil)
+
+	key, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	var candidKey bakery.PublicKey
+	err = candidKey.UnmarshalText([]byte(testPublicKey))
+	c.Assert(err, qt.Equals, nil)
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo(f.location, bakery.ThirdPartyInfo{
+		PublicKey: candidKey,
+		Version:   bakery.LatestVersion,
+	})
+	oven := bakery.NewOven(bakery.OvenParams{
+		Key:      key,
+		Locator:  locator,
+		Location: "reload-test",
+	})
+	m, err := oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  f.location,
+		Condition: "is-authenticated-user",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+
+	interactor := test.Interactor{
+		User: &params.User{
+			Username:   "bob",
+			ExternalID: "test:bob",
+		},
+	}
+	var oldRequests int
+	client := httpbakery.NewClient()
+	client.AddInteractor(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: func(u *url.URL) error {
+			// The rendezvous has been created by the first
+			// server. Reload the configuration so that the login
+			// and the wait are handled by the new server.
+			if err := f.r.reload(); err != nil {
+				return err
+			}
+			oldRequests = f.first.requestCount()
+			return interactor.OpenWebBrowser(u)
+		},
+	})
+	ms, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 2)
+	c.Assert(oldRequests, qt.Not(qt.Equals), 0)
+	c.Assert(f.first.requestCount(), qt.Equals, oldRequests)
+	c.Assert(f.first.isClosed(), qt.Equals, false)
+}
+
+var reloadFailureTests = []struct {
+	about       string
+	config      string
+	expectError string
+}{{
+	about:       "invalid yaml",
+	config:      "storage: [",
+	expectError: `cannot parse .*`,
+}, {
+	about:       "invalid configuration",
+	config:      "listen-address: localhost:0\n",
+	expectError: `missing fields storage, location, private-addr in config file`,
+}, {
+	about: "cannot create server",
+	config: `
+storage:
+  type: memory
+listen-address: localhost:0
+location: http://localhost
+private-addr: localhost
+resource-path: /no/such/path
+`,
+	expectError: `cannot create new server: cannot parse templates: .*`,
+}}
+
+func TestReloadFailureKeepsServer(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	for _, test := range reloadFailureTests {
+		c.Run(test.about, func(c *qt.C) {
+			f := newReloadFixture(c, nil)
+			oldConf := f.r.conf
+			err := ioutil.WriteFile(f.confPath, []byte(test.config), 0600)
+			c.Assert(err, qt.Equals, nil)
+			err = f.r.reload()
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(f.r.conf, qt.Equals, oldConf)
+			c.Assert(f.r.srv, qt.Equals, candid.HandlerCloser(f.first))
+			c.Assert(f.r.draining, qt.HasLen, 0)
+
+			f.get(c)
+			c.Assert(f.first.requestCount(), qt.Equals, 1)
+			c.Assert(f.first.isClosed(), qt.Equals, false)
+		})
+	}
+}
+
+func TestReloadTLSConfig(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	cert1, key1 := newTLSCert(c, "cert1")
+	f := newReloadFixture(c, map[string]interface{}{
+		"tls-cert": cert1,
+		"tls-key":  key1,
+	})
+
+	cert, proto := tlsHandshake(c, f.r)
+	c.Assert(cert.Subject.CommonName, qt.Equals, "cert1")
+	c.Assert(proto, qt.Equals, "h2")
+
+	cert2, key2 := newTLSCert(c, "cert2")
+	f.conf["tls-cert"] = cert2
+	f.conf["tls-key"] = key2
+	f.writeConfig(c)
+	err := f.r.reload()
+	c.Assert(err, qt.Equals, nil)
+	cert, proto = tlsHandshake(c, f.r)
+	c.Assert(cert.Subject.CommonName, qt.Equals, "cert2")
+	c.Assert(proto, qt.Equals, "h2")
+
+	// TLS cannot be disabled by a reload, so the most recent
+	// certificate remains in use.
+	delete(f.conf, "tls-cert")
+	delete(f.conf, "tls-key")
+	f.writeConfig(c)
+	err = f.r.reload()
+	c.Assert(err, qt.Equals, nil)
+	cert, _ = tlsHandshake(c, f.r)
+	c.Assert(cert.Subject.CommonName, qt.Equals, "cert2")
+}
+
+const (
+	testPublicKey  = "CIdWcEUN+0OZnKW9KwruRQnQDY/qqzVdD30CijwiWCk="
+	testPrivateKey = "8PjzjakvIlh3BVFKe8axinRDutF6EDIfjtuf4+JaNow="
+)
+
+// reloadFixture holds a reloader serving requests from an HTTP server
+// and the configuration file it reloads.
+type reloadFixture struct {
+	confPath string
+	conf     map[string]interface{}
+	location string
+	first    *recordingServer
+	r        *reloader
+	closed   bool
+}
+
+// newReloadFixture creates a reloader using a configuration file that
+// holds a minimal configuration, with any additional fields in extra.
+// The reloader serves requests from an HTTP server at the configured
+// location.
+func newReloadFixture(c *qt.C, extra map[string]interface{}) *reloadFixture {
+	hsrv := httptest.NewUnstartedServer(nil)
+	f := &reloadFixture{
+		confPath: filepath.Join(c.Mkdir(), "config.yaml"),
+		location: "http://" + hsrv.Listener.Addr().String(),
+	}
+	f.conf = map[string]interface{}{
+		"storage": map[string]interface{}{
+			"type": "memory",
+		},
+		"listen-address": hsrv.Listener.Addr().String(),
+		"location":       f.location,
+		"private-addr":   "localhost",
+		"public-key":     testPublicKey,
+		"private-key":    testPrivateKey,
+		"resource-path":  filepath.Join("..", ".."),
+		"identity-providers": []interface{}{
+			map[string]interface{}{"type": "test"},
+		},
+	}
+	for k, v := range extra {
+		f.conf[k] = v
+	}
+	f.writeConfig(c)
+	conf, err := config.Read(f.confPath)
+	c.Assert(err, qt.Equals, nil)
+	backend, err := conf.Storage.NewBackend()
+	c.Assert(err, qt.Equals, nil)
+	c.Defer(backend.Close)
+	params := backendParams(backend)
+	srv, err := newServer(conf, params)
+	c.Assert(err, qt.Equals, nil)
+	f.first = &recordingServer{
+		HandlerCloser: srv,
+		closed:        make(chan struct{}),
+	}
+	f.r = newReloader(f.confPath, conf, params, f.first)
+	c.Defer(f.Close)
+	hsrv.Config.Handler = f.r
+	hsrv.Start()
+	c.Defer(hsrv.Close)
+	return f
+}
+
+// writeConfig writes the fixture's configuration to the configuration
+// file.
+func (f *reloadFixture) writeConfig(c *qt.C) {
+	data, err := yaml.Marshal(f.conf)
+	c.Assert(err, qt.Equals, nil)
+	err = ioutil.WriteFile(f.confPath, data, 0600)
+	c.Assert(err, qt.Equals, nil)
+}
+
+// Close closes the reloader if it has not already been closed.
+func (f *reloadFixture) Close() {
+	if !f.closed {
+		f.closed = true
+		f.r.Close()
+	}
+}
+
+// get makes a request to the reloader.
+func (f *reloadFixture) get(c *qt.C) {
+	resp, err := http.Get(f.location + "/debug/info")
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+}
+
+// recordingServer is a candid.HandlerCloser that records the requests
+// served and whether the server has been closed.
+type recordingServer struct {
+	candid.HandlerCloser
+
+	mu       sync.Mutex
+	requests int
+	drained  bool
+	closed   chan struct{}
+}
+
+// Drain implements drainer.
+func (s *recordingServer) Drain() {
+	s.mu.Lock()
+	s.drained = true
+	s.mu.Unlock()
+	s.HandlerCloser.(drainer).Drain()
+}
+
+func (s *recordingServer) isDrained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drained
+}
+
+// ServeHTTP implements http.Handler.
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	s.mu.Unlock()
+	s.HandlerCloser.ServeHTTP(w, req)
+}
+
+// Close implements candid.HandlerCloser.Close.
+func (s *recordingServer) Close() {
+	close(s.closed)
+	s.HandlerCloser.Close()
+}
+
+func (s *recordingServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *recordingServer) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// newTLSCert returns a new PEM encoded self-signed certificate with the
+// given common name and its PEM encoded private key.
+func newTLSCert(c *qt.C, name string) (cert, key string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.Equals, nil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	c.Assert(err, qt.Equals, nil)
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	c.Assert(err, qt.Equals, nil)
+	cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	key = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return cert, key
+}
+
+// tlsHandshake performs a TLS handshake with a listener that uses the
+// reloader's TLS configuration, in the same way as the identity server,
+// and returns the certificate presented and the negotiated protocol.
+func tlsHandshake(c *qt.C, r *reloader) (*x509.Certificate, string) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetConfigForClient: r.TLSConfig,
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	state := conn.ConnectionState()
+	return state.PeerCertificates[0], state.NegotiatedProtocol
+}
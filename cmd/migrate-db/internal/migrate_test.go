@@ -123,14 +123,46 @@ func (s errorStore) FindIdentities(_ context.Context, _ *store.Identity, _ store
 	return nil, s.err
 }
 
+func (s errorStore) CountIdentities(_ context.Context, _ *store.Identity, _ store.Filter) (int, error) {
+	return 0, s.err
+}
+
 func (s errorStore) UpdateIdentity(_ context.Context, _ *store.Identity, _ store.Update) error {
 	return s.err
 }
 
+func (s errorStore) DeleteIdentity(_ context.Context, _ *store.Identity) error {
+	return s.err
+}
+
 func (s errorStore) IdentityCounts(_ context.Context) (map[string]int, error) {
 	return nil, s.err
 }
 
+func (s errorStore) IdentityGroups(_ context.Context) ([]string, error) {
+	return nil, s.err
+}
+
+func (s errorStore) Group(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
+func (s errorStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	return nil, s.err
+}
+
+func (s errorStore) CreateGroup(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
+func (s errorStore) UpdateGroup(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
+func (s errorStore) RemoveGroup(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
 func TestCopy(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
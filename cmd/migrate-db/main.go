@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	_ "github.com/lib/pq"
 	errgo "gopkg.in/errgo.v1"
@@ -24,12 +25,21 @@ import (
 var (
 	from = flag.String("from", "legacy:mongodb://localhost/identity", "store `specification` to copy the identities from.")
 	to   = flag.String("to", "mgo:mongodb://localhost/idm", "store `specification` to copy the identities to.")
+
+	schema = flag.String("schema", "", "migrate the schema of the SQL store with the given `specification` rather than copying identities.")
+	dryRun = flag.Bool("dry-run", false, "with -schema, print the schema migrations that would be applied without applying them.")
 )
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
-	if err := migrate(context.Background()); err != nil {
+	var err error
+	if *schema != "" {
+		err = migrateSchema()
+	} else {
+		err = migrate(context.Background())
+	}
+	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
@@ -54,6 +64,15 @@ For "legacy" and "mgo" type stores the connection string is a mgo URL
 stores the connection string is as documented in
 https://godoc.org/github.com/lib/pq.
 
+When -schema is specified the schema of the given SQL store is migrated
+to the latest version instead, printing each migration applied. Valid
+prefixes are "postgres", "sqlite" and "mysql". For "sqlite" type stores
+the connection string is the path of the database file and for "mysql"
+type stores it is as documented in
+https://github.com/go-sql-driver/mysql#dsn-data-source-name. With
+-dry-run the migrations that would be applied are printed along with
+their SQL statements, but the database is not changed.
+
 `)
 	flag.PrintDefaults()
 }
@@ -133,3 +152,39 @@ func migrate(ctx context.Context) error {
 
 	return errgo.Mask(internal.Copy(ctx, store, source))
 }
+
+func migrateSchema() error {
+	var migrator interface {
+		Migrate(dryRun bool) ([]sqlstore.Migration, error)
+	}
+	type_, addr := internal.SplitStoreSpecification(*schema)
+	switch type_ {
+	case "postgres":
+		migrator = sqlstore.Params{ConnectionString: addr}
+	case "sqlite":
+		migrator = sqlstore.SQLiteParams{Path: addr}
+	case "mysql":
+		migrator = sqlstore.MySQLParams{ConnectionString: addr}
+	default:
+		return errgo.Newf("invalid schema store type %q", type_)
+	}
+	migrations, err := migrator.Migrate(*dryRun)
+	if err != nil {
+		return errgo.Notef(err, "cannot migrate schema")
+	}
+	if len(migrations) == 0 {
+		fmt.Println("schema is up to date")
+		return nil
+	}
+	for _, m := range migrations {
+		if !*dryRun {
+			fmt.Printf("applied schema migration %d: %s\n", m.Version, m.Description)
+			continue
+		}
+		fmt.Printf("would apply schema migration %d: %s\n", m.Version, m.Description)
+		for _, s := range m.Statements {
+			fmt.Printf("%s;\n", strings.TrimSpace(s))
+		}
+	}
+	return nil
+}
@@ -17,7 +17,9 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/lifetime"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 var logger = loggo.GetLogger("candid.config")
@@ -62,12 +64,22 @@ type Config struct {
 
 	// PublicKey and PrivateKey holds the key pair used by the Candid
 	// server for encryption and decryption of third party caveats.
-	// These must be specified.
-	// TODO generate these automatically if not specified and store
-	// them in the database.
+	// If these are not specified then a key pair is generated and
+	// stored in the database.
 	PublicKey  *bakery.PublicKey  `yaml:"public-key"`
 	PrivateKey *bakery.PrivateKey `yaml:"private-key"`
 
+	// KeyRotationPeriod holds the interval at which the key used
+	// to decrypt third party caveats is replaced with a newly
+	// generated key. If this is zero then the key is not rotated.
+	KeyRotationPeriod DurationString `yaml:"key-rotation-period"`
+
+	// KeyGracePeriod holds the length of time for which a key that
+	// has been replaced can still be used to decrypt third party
+	// caveats. If this is zero then the key rotation period is
+	// used.
+	KeyGracePeriod DurationString `yaml:"key-grace-period"`
+
 	// AdminAgentPublicKey holds the public part of a key pair that
 	// can be used to authenticate as the admin user. If not specified
 	// no public-key-based authentication can be used for the admin
@@ -91,6 +103,44 @@ type Config struct {
 	// NoProxy holds which hosts not to use the HTTProxy for,
 	// in the same form as the NO_PROXY environment variable.
 	NoProxy string `yaml:"no-proxy"`
+
+	// Webhooks holds the endpoints that are sent notifications of
+	// identity lifecycle events.
+	Webhooks []webhook.Hook `yaml:"webhooks"`
+
+	// Lifetimes holds the lifetimes of the macaroons issued by the
+	// identity server. Any lifetime that is not specified takes its
+	// default value.
+	Lifetimes *lifetime.Config `yaml:"lifetimes"`
+
+	// PolicyFile holds the path of a file containing the policy
+	// that is evaluated when discharging third-party caveats. If
+	// this is empty then no policy is applied.
+	PolicyFile string `yaml:"policy-file"`
+
+	// Tracing holds the configuration for exporting trace spans. If
+	// this is nil then spans are not exported.
+	Tracing *TracingConfig `yaml:"tracing"`
+}
+
+// TracingConfig holds the configuration for exporting trace spans to
+// an OpenTelemetry collector.
+type TracingConfig struct {
+	// Endpoint holds the host and port of the OTLP/HTTP collector
+	// to which spans are exported.
+	Endpoint string `yaml:"endpoint"`
+
+	// Insecure holds whether plain HTTP, rather than HTTPS, is used
+	// to connect to the collector.
+	Insecure bool `yaml:"insecure"`
+
+	// ServiceName holds the service name reported with each span.
+	// It defaults to "candid".
+	ServiceName string `yaml:"service-name"`
+
+	// SampleRatio holds the fraction of traces started by the
+	// identity server that are sampled. It defaults to 1.
+	SampleRatio float64 `yaml:"sample-ratio"`
 }
 
 // TLSConfig returns a TLS configuration to be used for serving
@@ -109,6 +159,10 @@ func (c *Config) TLSConfig() *tls.Config {
 		Certificates: []tls.Certificate{
 			cert,
 		},
+		// The configuration is returned from GetConfigForClient, so
+		// HTTP/2 must be enabled here as the http.Server does not
+		// add it to per-client configurations.
+		NextProtos: []string{"h2", "http/1.1"},
 	}
 }
 
@@ -121,10 +175,10 @@ func (c *Config) validate() error {
 	if c.ListenAddress == "" {
 		missing = append(missing, "listen-address")
 	}
-	if c.PrivateKey == nil {
+	if c.PrivateKey == nil && c.PublicKey != nil {
 		missing = append(missing, "private-key")
 	}
-	if c.PublicKey == nil {
+	if c.PublicKey == nil && c.PrivateKey != nil {
 		missing = append(missing, "public-key")
 	}
 	if c.Location == "" {
@@ -137,6 +191,17 @@ func (c *Config) validate() error {
 	if len(missing) != 0 {
 		return errgo.Newf("missing fields %s in config file", strings.Join(missing, ", "))
 	}
+	for i, h := range c.Webhooks {
+		if h.URL == "" {
+			return errgo.Newf("missing url in webhook %d", i)
+		}
+		if h.Secret == "" {
+			return errgo.Newf("missing secret in webhook %d", i)
+		}
+	}
+	if c.Tracing != nil && c.Tracing.Endpoint == "" {
+		return errgo.Newf("missing endpoint in tracing")
+	}
 	return nil
 }
 
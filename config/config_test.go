@@ -14,8 +14,10 @@ import (
 
 	"github.com/CanonicalLtd/candid/config"
 	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/lifetime"
 	"github.com/CanonicalLtd/candid/store"
 	_ "github.com/CanonicalLtd/candid/store/memstore"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 const testConfig = `
@@ -31,6 +33,8 @@ storage:
   type: test
   attribute: hello
 rendezvous-timeout: 1m
+key-rotation-period: 720h
+key-grace-period: 168h
 identity-providers:
  - type: usso
  - type: keystone
@@ -88,6 +92,21 @@ tls-key: |
 resource-path: /resources
 http-proxy: http://proxy.example.com:3128
 no-proxy: localhost,.example.com
+webhooks:
+ - url: https://hooks.example.com/candid
+   secret: s3cret
+lifetimes:
+  discharge: 12h
+  identity-providers:
+    ks1:
+      discharge-token: 24h
+  groups:
+    admin:
+      discharge: 1h
+policy-file: /etc/candid/policy.yaml
+tracing:
+    endpoint: otel-collector.example.com:4318
+    sample-ratio: 0.5
 `
 
 func readConfig(c *qt.C, content string) (*config.Config, error) {
@@ -112,6 +131,7 @@ func TestRead(t *testing.T) {
 	// Check that the TLS configuration creates a valid *tls.Config
 	tlsConfig := conf.TLSConfig()
 	c.Assert(tlsConfig, qt.Not(qt.IsNil))
+	c.Assert(tlsConfig.NextProtos, qt.DeepEquals, []string{"h2", "http/1.1"})
 	conf.TLSCert = ""
 	conf.TLSKey = ""
 
@@ -153,6 +173,8 @@ func TestRead(t *testing.T) {
 		AdminPassword:       "mypasswd",
 		PrivateKey:          &key.Private,
 		PublicKey:           &key.Public,
+		KeyRotationPeriod:   config.DurationString{Duration: 720 * time.Hour},
+		KeyGracePeriod:      config.DurationString{Duration: 168 * time.Hour},
 		AdminAgentPublicKey: &adminPubKey,
 		Location:            "http://foo.com:1234",
 		RendezvousTimeout:   config.DurationString{Duration: time.Minute},
@@ -160,6 +182,30 @@ func TestRead(t *testing.T) {
 		ResourcePath:        "/resources",
 		HTTPProxy:           "http://proxy.example.com:3128",
 		NoProxy:             "localhost,.example.com",
+		Webhooks: []webhook.Hook{{
+			URL:    "https://hooks.example.com/candid",
+			Secret: "s3cret",
+		}},
+		Lifetimes: &lifetime.Config{
+			Durations: lifetime.Durations{
+				Discharge: 12 * time.Hour,
+			},
+			IdentityProviders: map[string]lifetime.Durations{
+				"ks1": {
+					DischargeToken: 24 * time.Hour,
+				},
+			},
+			Groups: map[string]lifetime.Durations{
+				"admin": {
+					Discharge: time.Hour,
+				},
+			},
+		},
+		PolicyFile: "/etc/candid/policy.yaml",
+		Tracing: &config.TracingConfig{
+			Endpoint:    "otel-collector.example.com:4318",
+			SampleRatio: 0.5,
+		},
 	})
 }
 
@@ -177,7 +223,24 @@ func TestReadErrorEmpty(t *testing.T) {
 	defer c.Done()
 
 	cfg, err := readConfig(c, "")
-	c.Assert(err, qt.ErrorMatches, "missing fields storage, listen-address, private-key, public-key, location, private-addr in config file")
+	c.Assert(err, qt.ErrorMatches, "missing fields storage, listen-address, location, private-addr in config file")
+	c.Assert(cfg, qt.IsNil)
+}
+
+func TestReadErrorPublicKeyWithoutPrivateKey(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	store.Register("test", testStorageBackend)
+	cfg, err := readConfig(c, `
+listen-address: 1.2.3.4:5678
+public-key: CIdWcEUN+0OZnKW9KwruRQnQDY/qqzVdD30CijwiWCk=
+location: http://foo.com:1234
+private-addr: localhost
+storage:
+  type: test
+`)
+	c.Assert(err, qt.ErrorMatches, "missing fields private-key in config file")
 	c.Assert(cfg, qt.IsNil)
 }
 
@@ -202,6 +265,64 @@ identity-providers:
 	c.Assert(cfg, qt.IsNil)
 }
 
+func TestWebhookWithoutURL(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	store.Register("test", testStorageBackend)
+	cfg, err := readConfig(c, `
+listen-address: 1.2.3.4:5678
+private-key: 8PjzjakvIlh3BVFKe8axinRDutF6EDIfjtuf4+JaNow=
+public-key: CIdWcEUN+0OZnKW9KwruRQnQDY/qqzVdD30CijwiWCk=
+location: http://foo.com:1234
+private-addr: localhost
+storage:
+  type: test
+webhooks:
+ - secret: s3cret
+`)
+	c.Assert(err, qt.ErrorMatches, `missing url in webhook 0`)
+	c.Assert(cfg, qt.IsNil)
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	store.Register("test", testStorageBackend)
+	cfg, err := readConfig(c, `
+listen-address: 1.2.3.4:5678
+private-key: 8PjzjakvIlh3BVFKe8axinRDutF6EDIfjtuf4+JaNow=
+public-key: CIdWcEUN+0OZnKW9KwruRQnQDY/qqzVdD30CijwiWCk=
+location: http://foo.com:1234
+private-addr: localhost
+storage:
+  type: test
+webhooks:
+ - url: https://hooks.example.com/candid
+`)
+	c.Assert(err, qt.ErrorMatches, `missing secret in webhook 0`)
+	c.Assert(cfg, qt.IsNil)
+}
+
+func TestTracingWithoutEndpoint(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	store.Register("test", testStorageBackend)
+	cfg, err := readConfig(c, `
+listen-address: 1.2.3.4:5678
+location: http://foo.com:1234
+private-addr: localhost
+storage:
+  type: test
+tracing:
+  insecure: true
+`)
+	c.Assert(err, qt.ErrorMatches, `missing endpoint in tracing`)
+	c.Assert(cfg, qt.IsNil)
+}
+
 type identityProvider struct {
 	idp.IdentityProvider
 	Params map[string]string
@@ -127,3 +127,14 @@ type IdentityProvider interface {
 	// TODO define what happens when the identity doesn't exist.
 	GetGroups(ctx context.Context, id *store.Identity) (groups []string, err error)
 }
+
+// An IdentityDataRemover is an identity provider that keeps data about
+// its identities in its key-value store. Such data is removed when an
+// identity is deleted or anonymised so that it does not outlive the
+// identity, or get inherited by a new identity with the same provider
+// ID.
+type IdentityDataRemover interface {
+	// RemoveIdentityData removes any data the identity provider
+	// holds about the given identity.
+	RemoveIdentityData(ctx context.Context, id *store.Identity) error
+}
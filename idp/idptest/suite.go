@@ -13,6 +13,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 
 	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
 	"github.com/CanonicalLtd/candid/internal/candidtest"
 	"github.com/CanonicalLtd/candid/store"
 )
@@ -87,6 +88,13 @@ func (s *Fixture) AssertLoginSuccess(c *qt.C, username string) {
 	c.Assert(s.visitCompleter.id.Username, qt.Equals, username)
 }
 
+// AssertLoginMFA asserts that the login test has resulted in a
+// successful login that completed multi-factor authentication.
+func (s *Fixture) AssertLoginMFA(c *qt.C) {
+	c.Assert(s.visitCompleter.called, qt.Equals, true)
+	c.Assert(s.visitCompleter.mfa, qt.Equals, true)
+}
+
 // AssertLoginFailure asserts that the login test has resulted in a
 // failure with an error that matches the given regex.
 func (s *Fixture) AssertLoginFailureMatches(c *qt.C, regex string) {
@@ -107,10 +115,11 @@ type visitCompleter struct {
 	returnTo    string
 	state       string
 	id          *store.Identity
+	mfa         bool
 	err         error
 }
 
-func (l *visitCompleter) Success(_ context.Context, _ http.ResponseWriter, _ *http.Request, dischargeID string, id *store.Identity) {
+func (l *visitCompleter) Success(ctx context.Context, _ http.ResponseWriter, _ *http.Request, dischargeID string, id *store.Identity) {
 	if l.called {
 		l.c.Error("login completion method called more than once")
 		return
@@ -118,6 +127,7 @@ func (l *visitCompleter) Success(_ context.Context, _ http.ResponseWriter, _ *ht
 	l.called = true
 	l.dischargeID = dischargeID
 	l.id = id
+	l.mfa = idputil.MFAFromContext(ctx)
 }
 
 func (l *visitCompleter) Failure(_ context.Context, _ http.ResponseWriter, _ *http.Request, dischargeID string, err error) {
@@ -130,7 +140,7 @@ func (l *visitCompleter) Failure(_ context.Context, _ http.ResponseWriter, _ *ht
 	l.err = err
 }
 
-func (l *visitCompleter) RedirectSuccess(_ context.Context, _ http.ResponseWriter, _ *http.Request, returnTo, state string, id *store.Identity) {
+func (l *visitCompleter) RedirectSuccess(ctx context.Context, _ http.ResponseWriter, _ *http.Request, returnTo, state string, id *store.Identity) {
 	if l.called {
 		l.c.Error("login completion method called more than once")
 		return
@@ -139,6 +149,7 @@ func (l *visitCompleter) RedirectSuccess(_ context.Context, _ http.ResponseWrite
 	l.returnTo = returnTo
 	l.state = state
 	l.id = id
+	l.mfa = idputil.MFAFromContext(ctx)
 }
 
 func (l *visitCompleter) RedirectFailure(_ context.Context, _ http.ResponseWriter, _ *http.Request, returnTo, state string, err error) {
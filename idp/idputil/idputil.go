@@ -14,6 +14,9 @@ import (
 	"github.com/juju/loggo"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/CanonicalLtd/candid/store"
 )
 
 var logger = loggo.GetLogger("candid.idp.idputil")
@@ -121,6 +124,36 @@ func NameWithDomain(name, domain string) string {
 	return name + "@" + domain
 }
 
+// ErrInvalidUser is the error cause returned by RegisterUser when the
+// requested username cannot be used. The error message is suitable for
+// showing to the user.
+var ErrInvalidUser = errgo.New("invalid user")
+
+// RegisterUser creates the given user in the given store with the given
+// username in the given domain.
+func RegisterUser(ctx context.Context, st store.Store, username, domain string, u *store.Identity) error {
+	if !names.IsValidUserName(username) {
+		return errgo.WithCausef(nil, ErrInvalidUser, "invalid user name. The username must contain only A-Z, a-z, 0-9, '.', '-', & '+', and must start and end with a letter or number.")
+	}
+	if ReservedUsernames[username] {
+		return errgo.WithCausef(nil, ErrInvalidUser, "username %s is not allowed, please choose another.", username)
+	}
+	u.Username = NameWithDomain(username, domain)
+	err := st.UpdateIdentity(ctx, u, store.Update{
+		store.Username:     store.Set,
+		store.Name:         store.Set,
+		store.Email:        store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	if err == nil {
+		return nil
+	}
+	if errgo.Cause(err) != store.ErrDuplicateUsername {
+		return errgo.Mask(err)
+	}
+	return errgo.WithCausef(nil, ErrInvalidUser, "Username already taken, please pick a different one.")
+}
+
 // RedirectCookieName is the name of the cookie used to store
 // RedirectState whilst a login is being processed by a third-party
 // server.
@@ -132,3 +165,20 @@ type RedirectState struct {
 	ReturnTo string
 	State    string
 }
+
+type mfaKey struct{}
+
+// ContextWithMFA returns a context that records that the user being
+// logged in has completed multi-factor authentication. An identity
+// provider that verifies a second factor should pass such a context to
+// the idp.VisitCompleter when the login succeeds.
+func ContextWithMFA(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mfaKey{}, true)
+}
+
+// MFAFromContext reports whether the given context was created with
+// ContextWithMFA.
+func MFAFromContext(ctx context.Context) bool {
+	mfa, _ := ctx.Value(mfaKey{}).(bool)
+	return mfa
+}
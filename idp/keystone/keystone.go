@@ -17,6 +17,7 @@ import (
 	"github.com/CanonicalLtd/candid/idp"
 	"github.com/CanonicalLtd/candid/idp/idputil"
 	"github.com/CanonicalLtd/candid/idp/keystone/internal/keystone"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -175,11 +176,13 @@ const loginPage = `<!doctype html>
 
 // doLogin performs the login with the keystone server.
 func (idp *identityProvider) doLogin(ctx context.Context, a keystone.Auth) (*store.Identity, error) {
-	resp, err := idp.client.Tokens(ctx, &keystone.TokensRequest{
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "tokens")
+	resp, err := idp.client.Tokens(upctx, &keystone.TokensRequest{
 		Body: keystone.TokensBody{
 			Auth: a,
 		},
 	})
+	upstream.End(err)
 	if err != nil {
 		return nil, errgo.WithCausef(err, params.ErrUnauthorized, "cannot log in")
 	}
@@ -212,9 +215,11 @@ func (idp *identityProvider) doLogin(ctx context.Context, a keystone.Auth) (*sto
 // associated with the token. The tenants are then converted to groups
 // names by suffixing with the domain, if configured.
 func (idp *identityProvider) getGroups(ctx context.Context, token string) ([]string, error) {
-	resp, err := idp.client.Tenants(ctx, &keystone.TenantsRequest{
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "tenants")
+	resp, err := idp.client.Tenants(upctx, &keystone.TenantsRequest{
 		AuthToken: token,
 	})
+	upstream.End(err)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot get tenants")
 	}
@@ -227,11 +232,13 @@ func (idp *identityProvider) getGroups(ctx context.Context, token string) ([]str
 
 // doLoginV3 performs the login with the keystone (version 3) server.
 func (idp *identityProvider) doLoginV3(ctx context.Context, a keystone.AuthV3) (*store.Identity, error) {
-	resp, err := idp.client.AuthTokens(ctx, &keystone.AuthTokensRequest{
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "tokens")
+	resp, err := idp.client.AuthTokens(upctx, &keystone.AuthTokensRequest{
 		Body: keystone.AuthTokensBody{
 			Auth: a,
 		},
 	})
+	upstream.End(err)
 	if err != nil {
 		return nil, errgo.WithCausef(err, params.ErrUnauthorized, "cannot log in")
 	}
@@ -264,10 +271,12 @@ func (idp *identityProvider) doLoginV3(ctx context.Context, a keystone.AuthV3) (
 // associated with the user. The group names are suffixing with the
 // domain, if configured.
 func (idp *identityProvider) getGroupsV3(ctx context.Context, token, user string) ([]string, error) {
-	resp, err := idp.client.UserGroups(ctx, &keystone.UserGroupsRequest{
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "user-groups")
+	resp, err := idp.client.UserGroups(upctx, &keystone.UserGroupsRequest{
 		AuthToken: token,
 		UserID:    user,
 	})
+	upstream.End(err)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot get groups")
 	}
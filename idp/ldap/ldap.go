@@ -24,6 +24,7 @@ import (
 
 	"github.com/CanonicalLtd/candid/idp"
 	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -229,7 +230,7 @@ func (idp *identityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID
 
 //  GetGroups implements idp.IdentityProvider.GetGroups.
 func (idp *identityProvider) GetGroups(ctx context.Context, identity *store.Identity) ([]string, error) {
-	conn, err := idp.dial()
+	conn, err := idp.dial(ctx)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -291,7 +292,7 @@ func (idp *identityProvider) handleLogin(ctx context.Context, w http.ResponseWri
 }
 
 func (idp *identityProvider) loginUser(ctx context.Context, username, password string) (*store.Identity, error) {
-	conn, err := idp.dial()
+	conn, err := idp.dial(ctx)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -312,7 +313,7 @@ func (idp *identityProvider) loginUser(ctx context.Context, username, password s
 }
 
 func (idp *identityProvider) loginDN(ctx context.Context, conn ldapConn, dn, password string) (*store.Identity, error) {
-	if err := conn.Bind(dn, password); err != nil {
+	if err := idp.bind(ctx, conn, dn, password); err != nil {
 		return nil, errgo.Mask(err)
 	}
 	req := &ldap.SearchRequest{
@@ -381,7 +382,7 @@ func (idp *identityProvider) resolveUsername(conn ldapConn, username string) (st
 
 // dial establishes a connection to the LDAP server and binds as the
 // search user (if specified).
-func (idp *identityProvider) dial() (ldapConn, error) {
+func (idp *identityProvider) dial(ctx context.Context) (ldapConn, error) {
 	conn, err := idp.dialLDAP(idp.network, idp.address)
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -390,13 +391,22 @@ func (idp *identityProvider) dial() (ldapConn, error) {
 		return nil, errgo.Mask(err)
 	}
 	if idp.params.DN != "" {
-		if err := conn.Bind(idp.params.DN, idp.params.Password); err != nil {
+		if err := idp.bind(ctx, conn, idp.params.DN, idp.params.Password); err != nil {
 			return nil, errgo.Mask(err)
 		}
 	}
 	return conn, nil
 }
 
+// bind binds the given connection as the given DN, recording the time
+// taken by the LDAP server.
+func (idp *identityProvider) bind(ctx context.Context, conn ldapConn, dn, password string) error {
+	_, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "bind")
+	err := conn.Bind(dn, password)
+	upstream.End(err)
+	return err
+}
+
 func renderTemplate(tmpl *template.Template, ctx interface{}) (string, error) {
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, ctx); err != nil {
@@ -0,0 +1,22 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package local
+
+import "github.com/CanonicalLtd/candid/idp"
+
+var (
+	CheckPassword = checkPassword
+	HashPassword  = hashPassword
+)
+
+// CheckPolicy checks the given password against the given policy.
+func CheckPolicy(p PasswordPolicy, password string) error {
+	return p.check(password)
+}
+
+// DummyHash returns the hash that the given local identity provider
+// checks the passwords of unknown users against.
+func DummyHash(i idp.IdentityProvider) string {
+	return i.(*identityProvider).dummyHash
+}
@@ -0,0 +1,473 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package local is an identity provider that authenticates users with
+// passwords that are managed by candid and stored, hashed, in the
+// identity store.
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// passwordKey is the ProviderInfo key that holds the password
+	// hash.
+	passwordKey = "password"
+
+	// passwordExpiredKey is the ProviderInfo key that is set when
+	// the user must change their password before logging in.
+	passwordExpiredKey = "password-expired"
+
+	// failuresPrefix is the prefix of the key-value store keys that
+	// record login attempts since the last successful login.
+	failuresPrefix = "failures#"
+)
+
+func init() {
+	idp.Register("local", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal local parameters")
+		}
+		switch p.Hash {
+		case "", hashBcrypt, hashArgon2id:
+		default:
+			return nil, errgo.Newf("unsupported hash %q", p.Hash)
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+// Params holds the parameters for a local identity provider.
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	// If this is not set then "local" will be used.
+	Name string `yaml:"name"`
+
+	// Description is the description that will be used with the
+	// identity provider. If this is not set then "Password" will be
+	// used.
+	Description string `yaml:"description"`
+
+	// Domain is the domain with which all identities created by
+	// this identity provider will be tagged (not including the @
+	// separator).
+	Domain string `yaml:"domain"`
+
+	// Hash is the algorithm used to hash new passwords, either
+	// "bcrypt" or "argon2id". If this is not set then "bcrypt" will
+	// be used. Existing passwords are rehashed with the configured
+	// algorithm the next time the user logs in.
+	Hash string `yaml:"hash"`
+
+	// MaxFailures is the number of consecutive failed login
+	// attempts after which an account is locked. If this is zero
+	// then 5 will be used, if it is negative then accounts are never
+	// locked.
+	MaxFailures int `yaml:"max-failures"`
+
+	// LockoutDuration is the length of time for which an account
+	// remains locked. If this is zero then 15 minutes will be used.
+	LockoutDuration time.Duration `yaml:"lockout-duration"`
+
+	// PasswordPolicy holds the rules that new passwords must
+	// follow.
+	PasswordPolicy PasswordPolicy `yaml:"password-policy"`
+}
+
+// NewIdentityProvider creates a new local identity provider.
+func NewIdentityProvider(p Params) idp.IdentityProvider {
+	if p.Name == "" {
+		p.Name = "local"
+	}
+	if p.Description == "" {
+		p.Description = "Password"
+	}
+	if p.Hash == "" {
+		p.Hash = hashBcrypt
+	}
+	if p.MaxFailures == 0 {
+		p.MaxFailures = 5
+	}
+	if p.LockoutDuration == 0 {
+		p.LockoutDuration = 15 * time.Minute
+	}
+	if p.PasswordPolicy.MinLength == 0 {
+		p.PasswordPolicy.MinLength = 8
+	}
+	dummyHash, _ := hashPassword(p.Hash, "dummy password")
+	return &identityProvider{
+		params:    p,
+		dummyHash: dummyHash,
+	}
+}
+
+type identityProvider struct {
+	params     Params
+	initParams idp.InitParams
+
+	// dummyHash is compared against the password given for unknown
+	// users so that they take as long to reject as known users. It
+	// uses the configured algorithm as that is what the passwords of
+	// known users will be hashed with.
+	dummyHash string
+}
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *identityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain.
+func (idp *identityProvider) Domain() string {
+	return idp.params.Domain
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *identityProvider) Description() string {
+	return idp.params.Description
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*identityProvider) Interactive() bool {
+	return true
+}
+
+// Init implements idp.IdentityProvider.Init.
+func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *identityProvider) URL(dischargeID string) string {
+	return idputil.URL(idp.initParams.URLPrefix, "/login", dischargeID)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *identityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups. Local users
+// have no identity provider groups.
+func (*identityProvider) GetGroups(context.Context, *store.Identity) ([]string, error) {
+	return nil, nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var err error
+	switch req.URL.Path {
+	case "/login":
+		err = idp.handleLogin(ctx, w, req)
+	case "/password":
+		err = idp.handlePassword(ctx, w, req)
+	default:
+		err = errgo.WithCausef(nil, params.ErrNotFound, "path %q not found", req.URL.Path)
+	}
+	if err != nil {
+		idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), err)
+	}
+}
+
+func (idp *identityProvider) handleLogin(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	default:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	case "GET":
+		return errgo.Mask(idp.initParams.Template.ExecuteTemplate(w, "login-form", nil))
+	case "POST":
+		user := req.Form.Get("username")
+		id, err := idp.loginUser(ctx, user, req.Form.Get("password"))
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+		}
+		if len(id.ProviderInfo[passwordExpiredKey]) > 0 {
+			return errgo.Mask(idp.passwordForm(w, req, passwordParams{
+				Username: user,
+				Error:    "Your password has expired and must be changed.",
+			}))
+		}
+		idp.initParams.VisitCompleter.Success(ctx, w, req, idputil.DischargeID(req), id)
+		return nil
+	}
+}
+
+// passwordParams holds the parameters passed to the password-change
+// template.
+type passwordParams struct {
+	// Action holds the URL to which the form should be posted.
+	Action string
+
+	// Username holds the username to fill in the form.
+	Username string
+
+	// Error holds an error to display to the user.
+	Error string
+
+	// Message holds an informational message to display to the
+	// user.
+	Message string
+}
+
+// passwordForm writes the password change form.
+func (idp *identityProvider) passwordForm(w http.ResponseWriter, req *http.Request, p passwordParams) error {
+	p.Action = idputil.URL(idp.initParams.URLPrefix, "/password", idputil.DischargeID(req))
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if err := idp.initParams.Template.ExecuteTemplate(w, "password-change", p); err != nil {
+		return errgo.Notef(err, "cannot process password-change template")
+	}
+	return nil
+}
+
+// handlePassword handles self-service password changes. If the change
+// is made as part of a login then the login completes once the
+// password has been changed.
+func (idp *identityProvider) handlePassword(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	default:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	case "GET":
+		return errgo.Mask(idp.passwordForm(w, req, passwordParams{}))
+	case "POST":
+	}
+	user := req.Form.Get("username")
+	id, err := idp.changePassword(ctx, user, req.Form.Get("password"), req.Form.Get("new-password"), req.Form.Get("confirm-password"))
+	if err != nil {
+		cause := errgo.Cause(err)
+		if cause != params.ErrBadRequest && cause != params.ErrUnauthorized {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(idp.passwordForm(w, req, passwordParams{
+			Username: user,
+			Error:    err.Error(),
+		}))
+	}
+	if dischargeID := idputil.DischargeID(req); dischargeID != "" {
+		idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, id)
+		return nil
+	}
+	return errgo.Mask(idp.passwordForm(w, req, passwordParams{
+		Message: "Your password has been changed.",
+	}))
+}
+
+// changePassword changes the password of the given user after checking
+// their current password.
+func (idp *identityProvider) changePassword(ctx context.Context, user, password, newPassword, confirmPassword string) (*store.Identity, error) {
+	id, err := idp.loginUser(ctx, user, password)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+	}
+	if newPassword != confirmPassword {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "passwords do not match")
+	}
+	if newPassword == password {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "new password must be different from the current password")
+	}
+	if err := idp.setPassword(ctx, id, newPassword, true); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return id, nil
+}
+
+// loginUser checks the password of the given user, who may not have
+// their domain specified, and returns their identity.
+func (idp *identityProvider) loginUser(ctx context.Context, user, password string) (*store.Identity, error) {
+	id := store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.params.Name, idputil.NameWithDomain(user, idp.params.Domain)),
+	}
+	// The attempt is counted before the password is checked so
+	// that concurrent guesses cannot exceed MaxFailures.
+	if err := idp.countAttempt(ctx, id.ProviderID); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+	}
+	err := idp.initParams.Store.Identity(ctx, &id)
+	if err != nil && errgo.Cause(err) != store.ErrNotFound {
+		return nil, errgo.Mask(err)
+	}
+	hash := idp.dummyHash
+	hs := id.ProviderInfo[passwordKey]
+	if len(hs) > 0 {
+		hash = hs[0]
+	}
+	ok, err := checkPassword(hash, password)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !ok || len(hs) == 0 {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %q", user)
+	}
+	if err := idp.clearFailures(ctx, id.ProviderID); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if hashAlgorithm(hash) != idp.params.Hash {
+		// The password was hashed with a different algorithm,
+		// rehash it now that we know what it is.
+		hash, err := hashPassword(idp.params.Hash, password)
+		if err == nil {
+			err = idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+				ProviderID: id.ProviderID,
+				ProviderInfo: map[string][]string{
+					passwordKey: {hash},
+				},
+			}, store.Update{
+				store.ProviderInfo: store.Set,
+			})
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return &id, nil
+}
+
+// SetPassword sets the password of the user with the given username,
+// creating the user if it does not already exist. The password must
+// follow the configured password policy.
+func (idp *identityProvider) SetPassword(ctx context.Context, username params.Username, password string) error {
+	id := &store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.params.Name, string(username)),
+		Username:   string(username),
+	}
+	if err := idp.setPassword(ctx, id, password, true); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return nil
+}
+
+// ResetPassword resets the password of the user with the given
+// username to a new random password, which is returned. The user must
+// change the password the next time they log in. Resetting a password
+// also unlocks the user's account. If there is no such user then an
+// error with a cause of params.ErrNotFound is returned.
+func (idp *identityProvider) ResetPassword(ctx context.Context, username params.Username) (string, error) {
+	id := &store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.params.Name, string(username)),
+	}
+	if err := idp.initParams.Store.Identity(ctx, id); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return "", errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", username)
+		}
+		return "", errgo.Mask(err)
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errgo.Mask(err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	if err := idp.setPassword(ctx, id, password, false); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return password, nil
+}
+
+// setPassword stores a hash of the given password in the given
+// identity, which is created if necessary, and unlocks it. If check is
+// true then the password must follow the password policy, otherwise
+// the password is marked as expired.
+func (idp *identityProvider) setPassword(ctx context.Context, id *store.Identity, password string, check bool) error {
+	var expired []string
+	if check {
+		if err := idp.params.PasswordPolicy.check(password); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+	} else {
+		expired = []string{"true"}
+	}
+	hash, err := hashPassword(idp.params.Hash, password)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	update := store.Update{
+		store.ProviderInfo: store.Set,
+	}
+	if id.Username != "" {
+		update[store.Username] = store.Set
+	}
+	err = idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: id.ProviderID,
+		Username:   id.Username,
+		ProviderInfo: map[string][]string{
+			passwordKey:        {hash},
+			passwordExpiredKey: expired,
+		},
+	}, update)
+	if errgo.Cause(err) == store.ErrDuplicateUsername {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "username %q is already in use", id.Username)
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(idp.clearFailures(ctx, id.ProviderID))
+}
+
+// RemoveIdentityData implements idp.IdentityDataRemover by removing the
+// record of failed login attempts, and any lock, for the given identity.
+func (idp *identityProvider) RemoveIdentityData(ctx context.Context, id *store.Identity) error {
+	return errgo.Mask(idp.clearFailures(ctx, id.ProviderID))
+}
+
+// failures holds the record of login attempts for a user since their
+// last successful login.
+type failures struct {
+	Count       int       `json:"count,omitempty"`
+	LockedUntil time.Time `json:"locked-until,omitempty"`
+}
+
+var (
+	errLocked       = errgo.WithCausef(nil, params.ErrUnauthorized, "account locked after too many failed login attempts, try again later")
+	errLockedUpdate = errgo.New("account locked")
+)
+
+// countAttempt records a login attempt for the given identity, locking
+// it if there have been too many since the last successful login. If
+// the identity is already locked then the attempt is not counted and an
+// error with a cause of params.ErrUnauthorized is returned.
+func (idp *identityProvider) countAttempt(ctx context.Context, pid store.ProviderIdentity) error {
+	if idp.params.MaxFailures < 0 {
+		return nil
+	}
+	now := time.Now()
+	err := idp.initParams.KeyValueStore.Update(ctx, failuresPrefix+string(pid), now.Add(idp.params.LockoutDuration), func(old []byte) ([]byte, error) {
+		var f failures
+		if len(old) > 0 {
+			if err := json.Unmarshal(old, &f); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		if now.Before(f.LockedUntil) {
+			return nil, errLockedUpdate
+		}
+		f.Count++
+		if f.Count >= idp.params.MaxFailures {
+			f.Count = 0
+			f.LockedUntil = now.Add(idp.params.LockoutDuration)
+		}
+		return json.Marshal(f)
+	})
+	if errgo.Cause(err) == errLockedUpdate {
+		return errLocked
+	}
+	return errgo.Mask(err)
+}
+
+// clearFailures removes any record of login attempts, and any lock,
+// from the given identity.
+func (idp *identityProvider) clearFailures(ctx context.Context, pid store.ProviderIdentity) error {
+	return errgo.Mask(idp.initParams.KeyValueStore.Set(ctx, failuresPrefix+string(pid), []byte("{}"), time.Now()))
+}
@@ -0,0 +1,399 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package local_test
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/local"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var configTests = []struct {
+	about       string
+	yaml        string
+	expectName  string
+	expectError string
+}{{
+	about: "default config",
+	yaml: `
+identity-providers:
+ - type: local
+`,
+	expectName: "local",
+}, {
+	about: "full config",
+	yaml: `
+identity-providers:
+ - type: local
+   name: passwords
+   domain: example
+   hash: argon2id
+   max-failures: 3
+   lockout-duration: 1h
+   password-policy:
+     min-length: 12
+     require-digit: true
+`,
+	expectName: "passwords",
+}, {
+	about: "unsupported hash",
+	yaml: `
+identity-providers:
+ - type: local
+   hash: md5
+`,
+	expectError: `cannot unmarshal local configuration: unsupported hash "md5"`,
+}}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range configTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, test.expectName)
+			c.Assert(conf.IdentityProviders[0].Interactive(), qt.Equals, true)
+		})
+	}
+}
+
+// passwordManager holds the methods used to administer passwords.
+type passwordManager interface {
+	SetPassword(ctx context.Context, username params.Username, password string) error
+	ResetPassword(ctx context.Context, username params.Username) (string, error)
+}
+
+type localSuite struct {
+	idptest *idptest.Fixture
+	idp     idp.IdentityProvider
+}
+
+func TestLocal(t *testing.T) {
+	qtsuite.Run(qt.New(t), &localSuite{})
+}
+
+func (s *localSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	s.idp = s.setupIdp(c, s.idptest, local.Params{
+		Domain:      "example",
+		MaxFailures: 3,
+	})
+}
+
+func (s *localSuite) setupIdp(c *qt.C, f *idptest.Fixture, p local.Params) idp.IdentityProvider {
+	i := local.NewIdentityProvider(p)
+	t := template.Must(template.New("login-form").Parse("login"))
+	template.Must(t.New("password-change").Parse("{{.Action}}|{{.Username}}|{{.Error}}|{{.Message}}"))
+	initParams := f.InitParams(c, "https://candid.example.com/login/local")
+	initParams.Template = t
+	err := i.Init(f.Ctx, initParams)
+	c.Assert(err, qt.Equals, nil)
+	return i
+}
+
+func (s *localSuite) TestLoginForm(c *qt.C) {
+	body := s.do(c, s.idp, "GET", "/login", nil)
+	c.Assert(body, qt.Equals, "login")
+}
+
+func (s *localSuite) TestLogin(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	s.login(c, s.idp, "bob", "correct horse")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+	id := s.userInfo(c)
+	c.Assert(id.Username, qt.Equals, "bob@example")
+	c.Assert(id.ProviderInfo["password"][0], qt.Matches, `\$2a\$.*`)
+}
+
+func (s *localSuite) TestLoginWrongPassword(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	s.login(c, s.idp, "bob", "battery staple")
+	s.idptest.AssertLoginFailureMatches(c, `authentication failed for user "bob"`)
+}
+
+func (s *localSuite) TestLoginUnknownUser(c *qt.C) {
+	s.login(c, s.idp, "alice", "dummy password")
+	s.idptest.AssertLoginFailureMatches(c, `authentication failed for user "alice"`)
+}
+
+func (s *localSuite) TestLockout(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	for n := 0; n < 3; n++ {
+		f := idptest.NewFixture(c, s.idptest.Store)
+		i := s.setupIdp(c, f, local.Params{Domain: "example", MaxFailures: 3})
+		s.login(c, i, "bob", "wrong")
+		f.AssertLoginFailureMatches(c, `authentication failed for user "bob"`)
+	}
+	// The account is now locked, so even the correct password
+	// fails.
+	s.login(c, s.idp, "bob", "correct horse")
+	s.idptest.AssertLoginFailureMatches(c, `account locked after too many failed login attempts, try again later`)
+
+	// An administrator can unlock the account by resetting the
+	// password.
+	password, err := s.idp.(passwordManager).ResetPassword(s.idptest.Ctx, "bob@example")
+	c.Assert(err, qt.Equals, nil)
+	f := idptest.NewFixture(c, s.idptest.Store)
+	i := s.setupIdp(c, f, local.Params{Domain: "example", MaxFailures: 3})
+	body := s.login(c, i, "bob", password)
+	f.AssertLoginNotComplete(c)
+	c.Assert(body, qt.Equals, "https://candid.example.com/login/local/password?id=1|bob|Your password has expired and must be changed.|")
+}
+
+func (s *localSuite) TestConcurrentLockout(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	i := local.NewIdentityProvider(local.Params{Domain: "example", MaxFailures: 3})
+	vc := &countingVisitCompleter{
+		failures: make(map[string]int),
+	}
+	initParams := s.idptest.InitParams(c, "https://candid.example.com/login/local")
+	initParams.VisitCompleter = vc
+	err = i.Init(s.idptest.Ctx, initParams)
+	c.Assert(err, qt.Equals, nil)
+
+	// Only MaxFailures of the concurrent guesses have their
+	// password checked, the rest find the account locked.
+	form := url.Values{
+		"username": {"bob"},
+		"password": {"wrong"},
+	}.Encode()
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "/login?id=1", strings.NewReader(form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.ParseForm()
+			i.Handle(context.Background(), httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+	c.Assert(vc.failures, qt.DeepEquals, map[string]int{
+		`authentication failed for user "bob"`:                                 3,
+		`account locked after too many failed login attempts, try again later`: 7,
+	})
+}
+
+func (s *localSuite) TestDummyHashUsesConfiguredAlgorithm(c *qt.C) {
+	i := local.NewIdentityProvider(local.Params{Hash: "argon2id"})
+	c.Assert(local.DummyHash(i), qt.Matches, `\$argon2id\$.*`)
+	i = local.NewIdentityProvider(local.Params{})
+	c.Assert(local.DummyHash(i), qt.Matches, `\$2a\$.*`)
+}
+
+func (s *localSuite) TestLockoutExpires(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	i := s.setupIdp(c, idptest.NewFixture(c, s.idptest.Store), local.Params{
+		Domain:          "example",
+		MaxFailures:     1,
+		LockoutDuration: time.Millisecond,
+	})
+	s.login(c, i, "bob", "wrong")
+	time.Sleep(5 * time.Millisecond)
+	i = s.setupIdp(c, s.idptest, local.Params{Domain: "example", MaxFailures: 1})
+	s.login(c, i, "bob", "correct horse")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+}
+
+func (s *localSuite) TestRemoveIdentityData(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	i := s.setupIdp(c, idptest.NewFixture(c, s.idptest.Store), local.Params{Domain: "example", MaxFailures: 1})
+	s.login(c, i, "bob", "wrong")
+
+	err = s.idp.(idp.IdentityDataRemover).RemoveIdentityData(s.idptest.Ctx, s.userInfo(c))
+	c.Assert(err, qt.Equals, nil)
+
+	// The lock has been removed along with the identity's data.
+	i = s.setupIdp(c, s.idptest, local.Params{Domain: "example", MaxFailures: 1})
+	s.login(c, i, "bob", "correct horse")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+}
+
+func (s *localSuite) TestResetPasswordAndChange(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	password, err := s.idp.(passwordManager).ResetPassword(s.idptest.Ctx, "bob@example")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(password, qt.Not(qt.Equals), "")
+
+	// The old password no longer works.
+	f := idptest.NewFixture(c, s.idptest.Store)
+	i := s.setupIdp(c, f, local.Params{Domain: "example"})
+	s.login(c, i, "bob", "correct horse")
+	f.AssertLoginFailureMatches(c, `authentication failed for user "bob"`)
+
+	// The temporary password must be changed.
+	body := s.login(c, s.idp, "bob", password)
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(body, qt.Matches, `.*\|Your password has expired and must be changed.\|`)
+
+	body = s.changePassword(c, s.idp, "bob", password, "battery staple", "battery stapel")
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(body, qt.Equals, "https://candid.example.com/login/local/password?id=1|bob|passwords do not match|")
+
+	body = s.changePassword(c, s.idp, "bob", password, "short", "short")
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(body, qt.Matches, `.*\|password must be at least 8 characters long\|`)
+
+	body = s.changePassword(c, s.idp, "bob", password, password, password)
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(body, qt.Matches, `.*\|new password must be different from the current password\|`)
+
+	s.changePassword(c, s.idp, "bob", password, "battery staple", "battery staple")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+	c.Assert(s.userInfo(c).ProviderInfo["password-expired"], qt.HasLen, 0)
+}
+
+func (s *localSuite) TestChangePasswordWithoutLogin(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	body := s.do(c, s.idp, "GET", "/password", nil)
+	c.Assert(body, qt.Equals, "https://candid.example.com/login/local/password|||")
+
+	body = s.do(c, s.idp, "POST", "/password", url.Values{
+		"username":         {"bob"},
+		"password":         {"wrong"},
+		"new-password":     {"battery staple"},
+		"confirm-password": {"battery staple"},
+	})
+	c.Assert(body, qt.Equals, `https://candid.example.com/login/local/password|bob|authentication failed for user &#34;bob&#34;|`)
+
+	body = s.do(c, s.idp, "POST", "/password", url.Values{
+		"username":         {"bob"},
+		"password":         {"correct horse"},
+		"new-password":     {"battery staple"},
+		"confirm-password": {"battery staple"},
+	})
+	c.Assert(body, qt.Equals, "https://candid.example.com/login/local/password|||Your password has been changed.")
+	s.idptest.AssertLoginNotComplete(c)
+
+	s.login(c, s.idp, "bob", "battery staple")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+}
+
+func (s *localSuite) TestSetPasswordPolicy(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "short")
+	c.Assert(err, qt.ErrorMatches, `password must be at least 8 characters long`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *localSuite) TestSetPasswordDuplicateUsername(c *qt.C) {
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "bob@example"),
+		Username:   "bob@example",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.ErrorMatches, `username "bob@example" is already in use`)
+}
+
+func (s *localSuite) TestResetPasswordNotFound(c *qt.C) {
+	_, err := s.idp.(passwordManager).ResetPassword(s.idptest.Ctx, "bob@example")
+	c.Assert(err, qt.ErrorMatches, `user "bob@example" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *localSuite) TestRehash(c *qt.C) {
+	err := s.idp.(passwordManager).SetPassword(s.idptest.Ctx, "bob@example", "correct horse")
+	c.Assert(err, qt.Equals, nil)
+	i := s.setupIdp(c, s.idptest, local.Params{
+		Domain: "example",
+		Hash:   "argon2id",
+	})
+	s.login(c, i, "bob", "correct horse")
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+	c.Assert(s.userInfo(c).ProviderInfo["password"][0], qt.Matches, `\$argon2id\$.*`)
+}
+
+func (s *localSuite) userInfo(c *qt.C) *store.Identity {
+	id := store.Identity{
+		ProviderID: store.MakeProviderIdentity("local", "bob@example"),
+	}
+	err := s.idptest.Store.Store.Identity(s.idptest.Ctx, &id)
+	c.Assert(err, qt.Equals, nil)
+	return &id
+}
+
+func (s *localSuite) login(c *qt.C, i idp.IdentityProvider, username, password string) string {
+	return s.do(c, i, "POST", "/login?id=1", url.Values{
+		"username": {username},
+		"password": {password},
+	})
+}
+
+func (s *localSuite) changePassword(c *qt.C, i idp.IdentityProvider, username, password, newPassword, confirmPassword string) string {
+	return s.do(c, i, "POST", "/password?id=1", url.Values{
+		"username":         {username},
+		"password":         {password},
+		"new-password":     {newPassword},
+		"confirm-password": {confirmPassword},
+	})
+}
+
+func (s *localSuite) do(c *qt.C, i idp.IdentityProvider, method, path string, form url.Values) string {
+	req, err := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr.Body.String()
+}
+
+// countingVisitCompleter is an idp.VisitCompleter that counts the
+// failed logins with each error message. It is safe to use
+// concurrently.
+type countingVisitCompleter struct {
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func (*countingVisitCompleter) Success(context.Context, http.ResponseWriter, *http.Request, string, *store.Identity) {
+}
+
+func (v *countingVisitCompleter) Failure(_ context.Context, _ http.ResponseWriter, _ *http.Request, _ string, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.failures[err.Error()]++
+}
+
+func (*countingVisitCompleter) RedirectFailure(context.Context, http.ResponseWriter, *http.Request, string, string, error) {
+}
+
+func (*countingVisitCompleter) RedirectSuccess(context.Context, http.ResponseWriter, *http.Request, string, string, *store.Identity) {
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package local
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	hashBcrypt   = "bcrypt"
+	hashArgon2id = "argon2id"
+
+	// argon2idPrefix is the prefix of all argon2id hashes.
+	argon2idPrefix = "$argon2id$"
+
+	// maxBcryptPasswordLength is the maximum length of password
+	// that bcrypt will hash, any further bytes are ignored.
+	maxBcryptPasswordLength = 72
+)
+
+// argon2id parameters, these follow the recommendations in RFC 9106.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// PasswordPolicy holds the rules that new passwords must follow.
+type PasswordPolicy struct {
+	// MinLength holds the minimum number of characters in a
+	// password. If this is zero then 8 is used.
+	MinLength int `yaml:"min-length"`
+
+	// RequireUpper specifies that passwords must contain an upper
+	// case letter.
+	RequireUpper bool `yaml:"require-upper"`
+
+	// RequireLower specifies that passwords must contain a lower
+	// case letter.
+	RequireLower bool `yaml:"require-lower"`
+
+	// RequireDigit specifies that passwords must contain a digit.
+	RequireDigit bool `yaml:"require-digit"`
+
+	// RequireSymbol specifies that passwords must contain a
+	// character that is not a letter or a digit.
+	RequireSymbol bool `yaml:"require-symbol"`
+}
+
+// check checks that the given password follows the policy. If it does
+// not then an error with a cause of params.ErrBadRequest is returned.
+func (p PasswordPolicy) check(password string) error {
+	if n := len([]rune(password)); n < p.MinLength {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "password must be at least %d characters long", p.MinLength)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "password must contain an upper case letter")
+	case p.RequireLower && !lower:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "password must contain a lower case letter")
+	case p.RequireDigit && !digit:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "password must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "password must contain a symbol")
+	}
+	return nil
+}
+
+// hashPassword hashes the given password using the given hash
+// algorithm.
+func hashPassword(hash, password string) (string, error) {
+	switch hash {
+	case hashBcrypt:
+		if len(password) > maxBcryptPasswordLength {
+			return "", errgo.WithCausef(nil, params.ErrBadRequest, "password must be at most %d bytes long", maxBcryptPasswordLength)
+		}
+		b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		return string(b), nil
+	case hashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", errgo.Mask(err)
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2idPrefix,
+			argon2.Version,
+			argon2Memory,
+			argon2Time,
+			argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	}
+	return "", errgo.Newf("unsupported hash %q", hash)
+}
+
+// checkPassword reports whether the given password matches the given
+// hash, which may have been created with any supported algorithm.
+func checkPassword(hash, password string) (bool, error) {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		if err != nil {
+			return false, errgo.Notef(err, "invalid password hash")
+		}
+		return true, nil
+	}
+	var version int
+	var memory uint32
+	var time uint32
+	var threads uint8
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if len(parts) != 4 {
+		return false, errgo.New("invalid password hash")
+	}
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errgo.New("invalid password hash: unsupported version")
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, errgo.New("invalid password hash: invalid parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, errgo.New("invalid password hash: invalid salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, errgo.New("invalid password hash: invalid key")
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// hashAlgorithm returns the algorithm used to create the given hash.
+func hashAlgorithm(hash string) string {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return hashArgon2id
+	}
+	return hashBcrypt
+}
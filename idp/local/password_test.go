@@ -0,0 +1,113 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package local_test
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/idp/local"
+)
+
+func TestHashPassword(t *testing.T) {
+	c := qt.New(t)
+	for _, hash := range []string{"bcrypt", "argon2id"} {
+		c.Run(hash, func(c *qt.C) {
+			h, err := local.HashPassword(hash, "s3cret passw0rd")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(strings.Contains(h, "s3cret"), qt.Equals, false)
+			ok, err := local.CheckPassword(h, "s3cret passw0rd")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, true)
+			ok, err = local.CheckPassword(h, "s3cret passw0rd!")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, false)
+
+			// Hashing the same password again uses a new salt.
+			h2, err := local.HashPassword(hash, "s3cret passw0rd")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(h2, qt.Not(qt.Equals), h)
+		})
+	}
+}
+
+func TestHashPasswordBcryptTooLong(t *testing.T) {
+	c := qt.New(t)
+	_, err := local.HashPassword("bcrypt", strings.Repeat("x", 73))
+	c.Assert(err, qt.ErrorMatches, `password must be at most 72 bytes long`)
+}
+
+func TestCheckPasswordInvalidHash(t *testing.T) {
+	c := qt.New(t)
+	_, err := local.CheckPassword("$argon2id$v=19$m=1,t=1$x$y", "pass")
+	c.Assert(err, qt.ErrorMatches, `invalid password hash: invalid parameters`)
+	_, err = local.CheckPassword("not a hash", "pass")
+	c.Assert(err, qt.ErrorMatches, `invalid password hash: .*`)
+}
+
+var policyTests = []struct {
+	about       string
+	policy      local.PasswordPolicy
+	password    string
+	expectError string
+}{{
+	about:    "long enough",
+	policy:   local.PasswordPolicy{MinLength: 4},
+	password: "abcd",
+}, {
+	about:       "too short",
+	policy:      local.PasswordPolicy{MinLength: 4},
+	password:    "abc",
+	expectError: `password must be at least 4 characters long`,
+}, {
+	about:       "characters not bytes",
+	policy:      local.PasswordPolicy{MinLength: 4},
+	password:    "ééé",
+	expectError: `password must be at least 4 characters long`,
+}, {
+	about: "all classes",
+	policy: local.PasswordPolicy{
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	},
+	password: "aB3$",
+}, {
+	about:       "no upper",
+	policy:      local.PasswordPolicy{RequireUpper: true},
+	password:    "ab3$",
+	expectError: `password must contain an upper case letter`,
+}, {
+	about:       "no lower",
+	policy:      local.PasswordPolicy{RequireLower: true},
+	password:    "AB3$",
+	expectError: `password must contain a lower case letter`,
+}, {
+	about:       "no digit",
+	policy:      local.PasswordPolicy{RequireDigit: true},
+	password:    "aB$",
+	expectError: `password must contain a digit`,
+}, {
+	about:       "no symbol",
+	policy:      local.PasswordPolicy{RequireSymbol: true},
+	password:    "aB3",
+	expectError: `password must contain a symbol`,
+}}
+
+func TestPasswordPolicy(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range policyTests {
+		c.Run(test.about, func(c *qt.C) {
+			err := local.CheckPolicy(test.policy, test.password)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+		})
+	}
+}
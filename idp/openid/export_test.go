@@ -0,0 +1,14 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid
+
+// JSONPathStrings parses the given path and returns the strings it
+// selects from the given value.
+func JSONPathStrings(path string, v interface{}) ([]string, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.strings(v), nil
+}
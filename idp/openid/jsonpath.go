@@ -0,0 +1,171 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// A jsonPath is a parsed simplified JSONPath expression that can be
+// used to select values from a JSON document. The supported syntax is
+// an optional leading "$" followed by any number of ".name", "[n]" and
+// "[*]" selectors, for example "$.orgs[*].name". The leading "." may be
+// omitted from the first selector. If the whole expression is the name
+// of a member of the top-level object then that member is selected
+// instead, so that names such as "https://example.com/groups" can be
+// used directly.
+type jsonPath struct {
+	// expr holds the expression the path was parsed from.
+	expr string
+
+	// selectors holds the parsed selectors.
+	selectors []pathSelector
+}
+
+// pathSelector selects values from within a JSON value.
+type pathSelector struct {
+	// key holds the key of the object member to select.
+	key string
+
+	// index holds the index of the array element to select, if
+	// isIndex is true.
+	index   int
+	isIndex bool
+
+	// wildcard is true if all array elements should be selected.
+	wildcard bool
+}
+
+// parseJSONPath parses the given simplified JSONPath expression. An
+// empty expression is parsed as a nil path.
+func parseJSONPath(s string) (*jsonPath, error) {
+	if s == "" {
+		return nil, nil
+	}
+	p := strings.TrimPrefix(s, "$")
+	if p != "" && p[0] != '.' && p[0] != '[' {
+		p = "." + p
+	}
+	path := &jsonPath{
+		expr: s,
+	}
+	for p != "" {
+		switch p[0] {
+		case '.':
+			n := strings.IndexAny(p[1:], ".[")
+			if n == -1 {
+				n = len(p) - 1
+			}
+			key := p[1 : n+1]
+			if key == "" {
+				return nil, errgo.Newf("invalid path %q: empty member name", s)
+			}
+			path.selectors = append(path.selectors, pathSelector{key: key})
+			p = p[n+1:]
+		case '[':
+			n := strings.IndexByte(p, ']')
+			if n == -1 {
+				return nil, errgo.Newf("invalid path %q: missing ]", s)
+			}
+			sel := p[1:n]
+			if sel == "*" {
+				path.selectors = append(path.selectors, pathSelector{wildcard: true})
+			} else {
+				index, err := strconv.Atoi(sel)
+				if err != nil || index < 0 {
+					return nil, errgo.Newf("invalid path %q: invalid index %q", s, sel)
+				}
+				path.selectors = append(path.selectors, pathSelector{index: index, isIndex: true})
+			}
+			p = p[n+1:]
+		default:
+			return nil, errgo.Newf("invalid path %q: unexpected %q", s, p[0])
+		}
+	}
+	return path, nil
+}
+
+// strings returns the string form of all the scalar values selected by
+// the path from the given decoded JSON value. If a selected value is an
+// array then each of its scalar elements is included. A nil path
+// selects no values. The value should have been decoded using
+// json.Decoder.UseNumber so that numbers are represented exactly.
+func (p *jsonPath) strings(v interface{}) []string {
+	if p == nil {
+		return nil
+	}
+	var vs []interface{}
+	if m, ok := v.(map[string]interface{}); ok && m[p.expr] != nil {
+		vs = []interface{}{m[p.expr]}
+	} else {
+		vs = []interface{}{v}
+		for _, sel := range p.selectors {
+			var next []interface{}
+			for _, v := range vs {
+				next = append(next, sel.selectValues(v)...)
+			}
+			vs = next
+		}
+	}
+	var ss []string
+	for _, v := range vs {
+		if a, ok := v.([]interface{}); ok {
+			for _, v := range a {
+				ss = appendScalar(ss, v)
+			}
+			continue
+		}
+		ss = appendScalar(ss, v)
+	}
+	return ss
+}
+
+// appendScalar appends the string form of v to ss if v is a scalar
+// value.
+func appendScalar(ss []string, v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		ss = append(ss, v)
+	case json.Number:
+		ss = append(ss, v.String())
+	case bool:
+		ss = append(ss, strconv.FormatBool(v))
+	}
+	return ss
+}
+
+// string returns the first scalar value selected by the path from the
+// given decoded JSON value. If there is no such value then an empty
+// string is returned.
+func (p *jsonPath) string(v interface{}) string {
+	ss := p.strings(v)
+	if len(ss) == 0 {
+		return ""
+	}
+	return ss[0]
+}
+
+func (sel pathSelector) selectValues(v interface{}) []interface{} {
+	switch {
+	case sel.wildcard:
+		a, _ := v.([]interface{})
+		return a
+	case sel.isIndex:
+		a, _ := v.([]interface{})
+		if sel.index >= len(a) {
+			return nil
+		}
+		return a[sel.index : sel.index+1]
+	default:
+		m, _ := v.(map[string]interface{})
+		if v, ok := m[sel.key]; ok {
+			return []interface{}{v}
+		}
+		return nil
+	}
+}
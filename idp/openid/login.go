@@ -0,0 +1,142 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/idp/idputil/secret"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// groupsKey is the key in the ProviderInfo of an identity used to
+// hold the groups reported by the identity provider.
+const groupsKey = "groups"
+
+// loginSessions manages the encrypted session cookies that hold the
+// state of logins that are in progress.
+type loginSessions struct {
+	cookieName string
+	codec      *secret.Codec
+}
+
+// newLoginSessions creates a loginSessions for the identity provider
+// with the given name that encrypts its cookies with the given key.
+func newLoginSessions(name string, key *bakery.KeyPair) loginSessions {
+	return loginSessions{
+		cookieName: "idp-login-" + name,
+		codec:      secret.NewCodec(key),
+	}
+}
+
+// newSession stores the state data for this login session in an
+// encrypted session cookie.
+func (s loginSessions) newSession(ctx context.Context, w http.ResponseWriter, dischargeID string) error {
+	sessionCookie := sessionCookie{
+		WaitID:  dischargeID,
+		Expires: time.Now().Add(15 * time.Minute),
+	}
+	value, err := s.codec.Encode(sessionCookie)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:  s.cookieName,
+		Value: value,
+	})
+	return nil
+}
+
+// getSession retrieves and validats the current session cookie for the
+// login session and returns the associated dischargeID.
+func (s loginSessions) getSession(ctx context.Context, req *http.Request) (string, error) {
+	c, err := req.Cookie(s.cookieName)
+	if err == http.ErrNoCookie {
+		return "", errgo.Notef(err, "no login session")
+	}
+	if err != nil {
+		return "", err
+	}
+	var sessionCookie sessionCookie
+	if err = s.codec.Decode(c.Value, &sessionCookie); err != nil {
+		return "", errgo.Notef(err, "invalid session")
+	}
+	if sessionCookie.Expires.Before(time.Now()) {
+		return "", errgo.New("expired session")
+	}
+	return sessionCookie.WaitID, nil
+}
+
+// deleteSession removes the session cookie for the current login
+// session.
+func (s loginSessions) deleteSession(ctx context.Context, w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name: s.cookieName,
+	})
+}
+
+// sessionCookie contains the stored state for the login process.
+type sessionCookie struct {
+	WaitID  string    `json:"wid"`
+	Expires time.Time `json:"exp"`
+}
+
+// registrationState holds state information about a registration that is
+// in progress.
+type registrationState struct {
+	WaitID     string                 `json:"wid"`
+	ProviderID store.ProviderIdentity `json:"pid"`
+	Groups     []string               `json:"groups,omitempty"`
+}
+
+// register processes a submitted registration form, creating the new
+// user if the form is valid or displaying the form again if not.
+func register(ctx context.Context, w http.ResponseWriter, req *http.Request, s loginSessions, initParams idp.InitParams, domain string) (string, error) {
+	dischargeID, err := s.getSession(ctx, req)
+	if err != nil {
+		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "")
+	}
+	var state registrationState
+	if err := s.codec.Decode(req.Form.Get("state"), &state); err != nil {
+		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "invalid registration state")
+	}
+	if state.WaitID != dischargeID {
+		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "invalid registration state")
+	}
+	u := &store.Identity{
+		ProviderID: state.ProviderID,
+		Name:       req.Form.Get("fullname"),
+		Email:      req.Form.Get("email"),
+	}
+	if state.Groups != nil {
+		u.ProviderInfo = map[string][]string{
+			groupsKey: state.Groups,
+		}
+	}
+	err = idputil.RegisterUser(ctx, initParams.Store, req.Form.Get("username"), domain, u)
+	if err == nil {
+		s.deleteSession(ctx, w)
+		initParams.VisitCompleter.Success(ctx, w, req, dischargeID, u)
+		return dischargeID, nil
+	}
+	if errgo.Cause(err) != idputil.ErrInvalidUser {
+		return dischargeID, errgo.Mask(err)
+	}
+	return dischargeID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    req.Form.Get("state"),
+		Error:    err.Error(),
+		Username: req.Form.Get("username"),
+		Domain:   domain,
+		FullName: req.Form.Get("fullname"),
+		Email:    req.Form.Get("email"),
+	}, initParams.Template))
+}
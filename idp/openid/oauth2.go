@@ -0,0 +1,338 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func init() {
+	idp.Register("oauth2", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p OAuth2Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal oauth2 parameters")
+		}
+		if p.Name == "" {
+			return nil, errgo.Newf("name not specified")
+		}
+		if p.AuthorizeURL == "" {
+			return nil, errgo.Newf("authorize-url not specified")
+		}
+		if p.TokenURL == "" {
+			return nil, errgo.Newf("token-url not specified")
+		}
+		if p.UserInfoURL == "" {
+			return nil, errgo.Newf("userinfo-url not specified")
+		}
+		if p.ClientID == "" {
+			return nil, errgo.Newf("client-id not specified")
+		}
+		if p.ClientSecret == "" {
+			return nil, errgo.Newf("client-secret not specified")
+		}
+		idp, err := NewOAuth2IdentityProvider(p)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return idp, nil
+	})
+}
+
+// OAuth2Params holds the parameters for a generic OAuth2 identity
+// provider.
+type OAuth2Params struct {
+	// Name is the name that will be given to the identity provider.
+	Name string `yaml:"name"`
+
+	// Description is the description that will be used with the
+	// identity provider. If this is not set then Name will be used.
+	Description string `yaml:"description"`
+
+	// Domain is the domain with which all identities created by this
+	// identity provider will be tagged (not including the @ separator).
+	Domain string `yaml:"domain"`
+
+	// AuthorizeURL is the URL of the OAuth2 authorization endpoint.
+	AuthorizeURL string `yaml:"authorize-url"`
+
+	// TokenURL is the URL of the OAuth2 token endpoint.
+	TokenURL string `yaml:"token-url"`
+
+	// UserInfoURL is the URL from which the information about the
+	// authenticated user is retrieved. The response must be a JSON
+	// document.
+	UserInfoURL string `yaml:"userinfo-url"`
+
+	// Scopes contains the OAuth scopes to request.
+	Scopes []string `yaml:"scopes"`
+
+	// ClientID is the ID of the client as registered with the OAuth2
+	// provider.
+	ClientID string `yaml:"client-id"`
+
+	// ClientSecret is a client specific secret agreed with the OAuth2
+	// provider.
+	ClientSecret string `yaml:"client-secret"`
+
+	// UserInfo contains the mapping from the user information
+	// document to the fields of the identity.
+	UserInfo UserInfoMapping `yaml:"userinfo"`
+}
+
+// UserInfoMapping holds the paths to the values in a user information
+// document that are used to populate an identity. Each path is a
+// simplified JSONPath expression such as "$.login" or
+// "$.groups[*].name".
+type UserInfoMapping struct {
+	// ID holds the path to the unique, stable, identifier of the
+	// user. If this is not set then "$.id" will be used.
+	ID string `yaml:"id"`
+
+	// Username holds the path to the preferred username of the
+	// user. This is used to suggest a username when a new user
+	// registers.
+	Username string `yaml:"username"`
+
+	// Name holds the path to the display name of the user.
+	Name string `yaml:"name"`
+
+	// Email holds the path to the email address of the user.
+	Email string `yaml:"email"`
+
+	// Groups holds the path to the groups of which the user is a
+	// member. If the path selects more than one value, each value
+	// is a separate group.
+	Groups string `yaml:"groups"`
+}
+
+// NewOAuth2IdentityProvider creates a new identity provider using
+// OAuth2 with the given parameters.
+func NewOAuth2IdentityProvider(p OAuth2Params) (idp.IdentityProvider, error) {
+	if p.Description == "" {
+		p.Description = p.Name
+	}
+	if p.UserInfo.ID == "" {
+		p.UserInfo.ID = "$.id"
+	}
+	idp := &oauth2IdentityProvider{
+		params: p,
+	}
+	for _, path := range []struct {
+		p    **jsonPath
+		expr string
+	}{
+		{&idp.paths.id, p.UserInfo.ID},
+		{&idp.paths.username, p.UserInfo.Username},
+		{&idp.paths.name, p.UserInfo.Name},
+		{&idp.paths.email, p.UserInfo.Email},
+		{&idp.paths.groups, p.UserInfo.Groups},
+	} {
+		var err error
+		*path.p, err = parseJSONPath(path.expr)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return idp, nil
+}
+
+type oauth2IdentityProvider struct {
+	params     OAuth2Params
+	initParams idp.InitParams
+	config     *oauth2.Config
+	paths      struct {
+		id, username, name, email, groups *jsonPath
+	}
+	loginSessions
+}
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *oauth2IdentityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain.
+func (idp *oauth2IdentityProvider) Domain() string {
+	return idp.params.Domain
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *oauth2IdentityProvider) Description() string {
+	return idp.params.Description
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*oauth2IdentityProvider) Interactive() bool {
+	return true
+}
+
+// Init implements idp.IdentityProvider.Init.
+func (idp *oauth2IdentityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	idp.config = &oauth2.Config{
+		ClientID:     idp.params.ClientID,
+		ClientSecret: idp.params.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  idp.params.AuthorizeURL,
+			TokenURL: idp.params.TokenURL,
+		},
+		RedirectURL: idp.initParams.URLPrefix + "/callback",
+		Scopes:      idp.params.Scopes,
+	}
+	idp.loginSessions = newLoginSessions(idp.params.Name, idp.initParams.Key)
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *oauth2IdentityProvider) URL(dischargeID string) string {
+	return idputil.URL(idp.initParams.URLPrefix, "/login", dischargeID)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *oauth2IdentityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups by returning the
+// groups found in the user information document the last time the user
+// logged in.
+func (*oauth2IdentityProvider) GetGroups(_ context.Context, identity *store.Identity) ([]string, error) {
+	return identity.ProviderInfo[groupsKey], nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *oauth2IdentityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/callback":
+		if dischargeID, err := idp.callback(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, dischargeID, err)
+		}
+	case "/register":
+		if dischargeID, err := register(ctx, w, req, idp.loginSessions, idp.initParams, idp.params.Domain); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, dischargeID, err)
+		}
+	default:
+		if err := idp.login(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), err)
+		}
+	}
+}
+
+func (idp *oauth2IdentityProvider) login(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	dischargeID := idputil.DischargeID(req)
+	err := idp.newSession(ctx, w, dischargeID)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	url := idp.config.AuthCodeURL(dischargeID)
+	http.Redirect(w, req, url, http.StatusFound)
+	return nil
+}
+
+func (idp *oauth2IdentityProvider) callback(ctx context.Context, w http.ResponseWriter, req *http.Request) (string, error) {
+	dischargeID, err := idp.getSession(ctx, req)
+	if err != nil {
+		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "")
+	}
+	if dischargeID != req.Form.Get("state") {
+		return dischargeID, errgo.WithCausef(nil, params.ErrBadRequest, "invalid session")
+	}
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "exchange")
+	tok, err := idp.config.Exchange(upctx, req.Form.Get("code"))
+	upstream.End(err)
+	if err != nil {
+		return dischargeID, errgo.Mask(err)
+	}
+	info, err := idp.userInfo(ctx, tok)
+	if err != nil {
+		return dischargeID, errgo.Mask(err)
+	}
+	id := idp.paths.id.string(info)
+	if id == "" {
+		return dischargeID, errgo.Newf("no user ID found in user information")
+	}
+	user := store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.Name(), id),
+	}
+	var groups []string
+	if idp.paths.groups != nil {
+		groups = idp.paths.groups.strings(info)
+		if groups == nil {
+			groups = []string{}
+		}
+		user.ProviderInfo = map[string][]string{
+			groupsKey: groups,
+		}
+		err = idp.initParams.Store.UpdateIdentity(ctx, &user, store.Update{
+			store.ProviderInfo: store.Set,
+		})
+	}
+	if err == nil {
+		err = idp.initParams.Store.Identity(ctx, &user)
+	}
+	if err == nil {
+		idp.deleteSession(ctx, w)
+		idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, &user)
+		return "", nil
+	}
+	if errgo.Cause(err) != store.ErrNotFound {
+		return dischargeID, errgo.Mask(err)
+	}
+	state, err := idp.codec.Encode(registrationState{
+		WaitID:     dischargeID,
+		ProviderID: user.ProviderID,
+		Groups:     groups,
+	})
+	if err != nil {
+		return dischargeID, errgo.Mask(err)
+	}
+	preferredUsername := idp.paths.username.string(info)
+	if !names.IsValidUserName(preferredUsername) {
+		preferredUsername = ""
+	}
+	return dischargeID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    state,
+		Username: preferredUsername,
+		Domain:   idp.params.Domain,
+		FullName: idp.paths.name.string(info),
+		Email:    idp.paths.email.string(info),
+	}, idp.initParams.Template))
+}
+
+// userInfo retrieves the user information document using the given
+// token.
+func (idp *oauth2IdentityProvider) userInfo(ctx context.Context, tok *oauth2.Token) (interface{}, error) {
+	req, err := http.NewRequest("GET", idp.params.UserInfoURL, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := idp.config.Client(ctx, tok).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get user information")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot get user information: %s", resp.Status)
+	}
+	var info interface{}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&info); err != nil {
+		return nil, errgo.Notef(err, "cannot decode user information")
+	}
+	return info, nil
+}
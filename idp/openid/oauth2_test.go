@@ -0,0 +1,366 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/openid"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var oauth2ConfigTests = []struct {
+	about       string
+	yaml        string
+	expectError string
+}{{
+	about: "good config",
+	yaml: `
+identity-providers:
+ - type: oauth2
+   name: github
+   authorize-url: https://github.com/login/oauth/authorize
+   token-url: https://github.com/login/oauth/access_token
+   userinfo-url: https://api.github.com/user
+   client-id: client-001
+   client-secret: secret-001
+   userinfo:
+     username: login
+`,
+}, {
+	about: "no authorize-url",
+	yaml: `
+identity-providers:
+ - type: oauth2
+   name: github
+   token-url: https://github.com/login/oauth/access_token
+   userinfo-url: https://api.github.com/user
+   client-id: client-001
+   client-secret: secret-001
+`,
+	expectError: `cannot unmarshal oauth2 configuration: authorize-url not specified`,
+}, {
+	about: "no userinfo-url",
+	yaml: `
+identity-providers:
+ - type: oauth2
+   name: github
+   authorize-url: https://github.com/login/oauth/authorize
+   token-url: https://github.com/login/oauth/access_token
+   client-id: client-001
+   client-secret: secret-001
+`,
+	expectError: `cannot unmarshal oauth2 configuration: userinfo-url not specified`,
+}, {
+	about: "invalid path",
+	yaml: `
+identity-providers:
+ - type: oauth2
+   name: github
+   authorize-url: https://github.com/login/oauth/authorize
+   token-url: https://github.com/login/oauth/access_token
+   userinfo-url: https://api.github.com/user
+   client-id: client-001
+   client-secret: secret-001
+   userinfo:
+     groups: orgs[x]
+`,
+	expectError: `cannot unmarshal oauth2 configuration: invalid path "orgs\[x\]": invalid index "x"`,
+}}
+
+func TestOAuth2Config(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range oauth2ConfigTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "github")
+		})
+	}
+}
+
+const testUserInfo = `{
+	"id": 1234,
+	"login": "jbloggs",
+	"name": "Joe Bloggs",
+	"emails": [{"address": "jbloggs@example.com"}, {"address": "joe@example.com"}],
+	"orgs": [{"name": "org1"}, {"name": "org2"}],
+	"admin": true,
+	"roles": ["reader", "writer"],
+	"https://example.com/roles": ["admin"]
+}`
+
+var jsonPathTests = []struct {
+	path        string
+	expect      []string
+	expectError string
+}{{
+	path:   "$.login",
+	expect: []string{"jbloggs"},
+}, {
+	path:   "login",
+	expect: []string{"jbloggs"},
+}, {
+	path:   "$.id",
+	expect: []string{"1234"},
+}, {
+	path:   "admin",
+	expect: []string{"true"},
+}, {
+	path:   "$.emails[1].address",
+	expect: []string{"joe@example.com"},
+}, {
+	path:   "$.orgs[*].name",
+	expect: []string{"org1", "org2"},
+}, {
+	path: "$.orgs[5].name",
+}, {
+	path: "$.missing",
+}, {
+	path: "$.orgs",
+}, {
+	path:   "$.roles",
+	expect: []string{"reader", "writer"},
+}, {
+	path:   "https://example.com/roles",
+	expect: []string{"admin"},
+}, {
+	path: "https://example.com/missing",
+}, {
+	path:        "$.orgs[",
+	expectError: `invalid path "\$.orgs\[": missing \]`,
+}, {
+	path:        "$..orgs",
+	expectError: `invalid path "\$..orgs": empty member name`,
+}, {
+	path:        "$.orgs[-1]",
+	expectError: `invalid path "\$.orgs\[-1\]": invalid index "-1"`,
+}}
+
+func TestJSONPath(t *testing.T) {
+	c := qt.New(t)
+	dec := json.NewDecoder(strings.NewReader(testUserInfo))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	c.Assert(err, qt.Equals, nil)
+	for _, test := range jsonPathTests {
+		c.Run(test.path, func(c *qt.C) {
+			ss, err := openid.JSONPathStrings(test.path, v)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ss, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+type oauth2Suite struct {
+	idptest *idptest.Fixture
+	server  *httptest.Server
+}
+
+func TestOAuth2(t *testing.T) {
+	qtsuite.Run(qt.New(t), &oauth2Suite{})
+}
+
+func (s *oauth2Suite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if req.Form.Get("code") != "test-code" {
+			http.Error(w, "bad code", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "test-token", "token_type": "bearer"}`)
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, testUserInfo)
+	})
+	s.server = httptest.NewServer(mux)
+	c.Defer(s.server.Close)
+}
+
+func (s *oauth2Suite) setupIdp(c *qt.C, userInfo openid.UserInfoMapping) idp.IdentityProvider {
+	i, err := openid.NewOAuth2IdentityProvider(openid.OAuth2Params{
+		Name:         "test",
+		Domain:       "example",
+		AuthorizeURL: s.server.URL + "/authorize",
+		TokenURL:     s.server.URL + "/token",
+		UserInfoURL:  s.server.URL + "/user",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		UserInfo:     userInfo,
+	})
+	c.Assert(err, qt.Equals, nil)
+	initParams := s.idptest.InitParams(c, "https://candid.example.com/login/test")
+	initParams.Template = template.Must(template.New("register").Parse("{{.State}} {{.Username}} {{.FullName}} {{.Email}}"))
+	err = i.Init(context.Background(), initParams)
+	c.Assert(err, qt.Equals, nil)
+	return i
+}
+
+func (s *oauth2Suite) TestInteractive(c *qt.C) {
+	i := s.setupIdp(c, openid.UserInfoMapping{})
+	c.Assert(i.Interactive(), qt.Equals, true)
+	c.Assert(i.Description(), qt.Equals, "test")
+	c.Assert(i.Domain(), qt.Equals, "example")
+}
+
+func (s *oauth2Suite) TestLoginNewUser(c *qt.C) {
+	i := s.setupIdp(c, openid.UserInfoMapping{
+		Username: "$.login",
+		Name:     "$.name",
+		Email:    "$.emails[0].address",
+		Groups:   "$.orgs[*].name",
+	})
+	cookies := s.login(c, i, "1")
+	rr := s.callback(c, i, cookies, "1")
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(rr.Code, qt.Equals, http.StatusOK)
+	fields := strings.SplitN(rr.Body.String(), " ", 2)
+	c.Assert(fields[1], qt.Equals, "jbloggs Joe Bloggs jbloggs@example.com")
+
+	s.register(c, i, cookies, url.Values{
+		"state":    {fields[0]},
+		"username": {"jbloggs"},
+		"fullname": {"Joe Bloggs"},
+		"email":    {"jbloggs@example.com"},
+	})
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	identity := s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1234"),
+		Username:   "jbloggs@example",
+		Name:       "Joe Bloggs",
+		Email:      "jbloggs@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"org1", "org2"},
+		},
+	})
+	groups, err := i.GetGroups(s.idptest.Ctx, identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"org1", "org2"})
+}
+
+func (s *oauth2Suite) TestLoginExistingUser(c *qt.C) {
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"oldgroup"},
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	i := s.setupIdp(c, openid.UserInfoMapping{
+		Groups: "$.orgs[*].name",
+	})
+	cookies := s.login(c, i, "1")
+	s.callback(c, i, cookies, "1")
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"org1", "org2"},
+		},
+	})
+}
+
+func (s *oauth2Suite) TestCallbackInvalidState(c *qt.C) {
+	i := s.setupIdp(c, openid.UserInfoMapping{})
+	cookies := s.login(c, i, "1")
+	s.callback(c, i, cookies, "2")
+	s.idptest.AssertLoginFailureMatches(c, `invalid session`)
+}
+
+func (s *oauth2Suite) TestCallbackNoSession(c *qt.C) {
+	i := s.setupIdp(c, openid.UserInfoMapping{})
+	s.callback(c, i, nil, "1")
+	s.idptest.AssertLoginFailureMatches(c, `no login session: http: named cookie not present`)
+}
+
+func (s *oauth2Suite) TestNoUserID(c *qt.C) {
+	i := s.setupIdp(c, openid.UserInfoMapping{
+		ID: "$.uuid",
+	})
+	cookies := s.login(c, i, "1")
+	s.callback(c, i, cookies, "1")
+	s.idptest.AssertLoginFailureMatches(c, `no user ID found in user information`)
+}
+
+// login starts a login attempt and returns the session cookies that
+// were set.
+func (s *oauth2Suite) login(c *qt.C, i idp.IdentityProvider, dischargeID string) []*http.Cookie {
+	req, err := http.NewRequest("GET", "/login?id="+dischargeID, nil)
+	c.Assert(err, qt.Equals, nil)
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusFound)
+	u, err := url.Parse(rr.Header().Get("Location"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(u.Path, qt.Equals, "/authorize")
+	c.Assert(u.Query().Get("state"), qt.Equals, dischargeID)
+	c.Assert(u.Query().Get("redirect_uri"), qt.Equals, "https://candid.example.com/login/test/callback")
+	return rr.Result().Cookies()
+}
+
+func (s *oauth2Suite) callback(c *qt.C, i idp.IdentityProvider, cookies []*http.Cookie, state string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/callback?code=test-code&state="+state, nil)
+	c.Assert(err, qt.Equals, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr
+}
+
+func (s *oauth2Suite) register(c *qt.C, i idp.IdentityProvider, cookies []*http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr
+}
@@ -1,15 +1,16 @@
 // Copyright 2017 Canonical Ltd.
 // Licensed under the AGPLv3, see LICENCE file for details.
 
-// Package openid provides identity providers that use OpenID to
-// determine the identity.
+// Package openid provides identity providers that use OpenID Connect
+// or OAuth 2.0 to determine the identity.
 package openid
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/coreos/go-oidc"
 	"golang.org/x/oauth2"
@@ -20,7 +21,7 @@ import (
 
 	"github.com/CanonicalLtd/candid/idp"
 	"github.com/CanonicalLtd/candid/idp/idputil"
-	"github.com/CanonicalLtd/candid/idp/idputil/secret"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -70,6 +71,42 @@ type OpenIDConnectParams struct {
 
 	// ClientSecret is a client specific secret agreed with the issuer.
 	ClientSecret string `yaml:"client-secret"`
+
+	// Claims contains the mapping from the claims returned by the
+	// issuer to the fields of the identity.
+	Claims ClaimMapping `yaml:"claims"`
+
+	// UserInfoGroups specifies that the groups claim should be read
+	// from the issuer's userinfo endpoint, rather than the ID token,
+	// each time the user logs in.
+	UserInfoGroups bool `yaml:"userinfo-groups"`
+}
+
+// ClaimMapping holds the claims that are used to populate an identity.
+// Each claim is specified either as a simple claim name such as
+// "preferred_username", or as a path into the claims such as
+// "realm_access.roles". A claim name that contains "." or "[", such as
+// "https://example.com/groups", is used directly if the claims contain
+// a top-level claim with exactly that name.
+type ClaimMapping struct {
+	// Username holds the claim containing the preferred username of
+	// the user. This is used to suggest a username when a new user
+	// registers. If this is not set then "preferred_username" will
+	// be used.
+	Username string `yaml:"username"`
+
+	// Name holds the claim containing the display name of the user.
+	// If this is not set then "name" will be used.
+	Name string `yaml:"name"`
+
+	// Email holds the claim containing the email address of the
+	// user. If this is not set then "email" will be used.
+	Email string `yaml:"email"`
+
+	// Groups holds the claim containing the groups of which the user
+	// is a member. If this is not set then no groups will be
+	// recorded for the user.
+	Groups string `yaml:"groups"`
 }
 
 // NewOpenIDConnectIdentityProvider creates a new identity provider using
@@ -81,6 +118,15 @@ func NewOpenIDConnectIdentityProvider(params OpenIDConnectParams) idp.IdentityPr
 	if len(params.Scopes) == 0 {
 		params.Scopes = []string{oidc.ScopeOpenID}
 	}
+	if params.Claims.Username == "" {
+		params.Claims.Username = "preferred_username"
+	}
+	if params.Claims.Name == "" {
+		params.Claims.Name = "name"
+	}
+	if params.Claims.Email == "" {
+		params.Claims.Email = "email"
+	}
 	return &openidConnectIdentityProvider{
 		params: params,
 	}
@@ -91,7 +137,10 @@ type openidConnectIdentityProvider struct {
 	initParams idp.InitParams
 	provider   *oidc.Provider
 	config     *oauth2.Config
-	codec      *secret.Codec
+	claims     struct {
+		username, name, email, groups *jsonPath
+	}
+	loginSessions
 }
 
 // Name implements idp.IdentityProvider.Name.
@@ -118,6 +167,21 @@ func (*openidConnectIdentityProvider) Interactive() bool {
 // the issuer and set up the identity provider.
 func (idp *openidConnectIdentityProvider) Init(ctx context.Context, params idp.InitParams) error {
 	idp.initParams = params
+	for _, claim := range []struct {
+		p    **jsonPath
+		expr string
+	}{
+		{&idp.claims.username, idp.params.Claims.Username},
+		{&idp.claims.name, idp.params.Claims.Name},
+		{&idp.claims.email, idp.params.Claims.Email},
+		{&idp.claims.groups, idp.params.Claims.Groups},
+	} {
+		var err error
+		*claim.p, err = parseJSONPath(claim.expr)
+		if err != nil {
+			return errgo.Notef(err, "invalid claim")
+		}
+	}
 	var err error
 	idp.provider, err = oidc.NewProvider(ctx, idp.params.Issuer)
 	if err != nil {
@@ -130,7 +194,7 @@ func (idp *openidConnectIdentityProvider) Init(ctx context.Context, params idp.I
 		RedirectURL:  idp.initParams.URLPrefix + "/callback",
 		Scopes:       idp.params.Scopes,
 	}
-	idp.codec = secret.NewCodec(idp.initParams.Key)
+	idp.loginSessions = newLoginSessions(idp.params.Name, idp.initParams.Key)
 	return nil
 }
 
@@ -143,9 +207,10 @@ func (idp *openidConnectIdentityProvider) URL(dischargeID string) string {
 func (idp *openidConnectIdentityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
 }
 
-//  GetGroups implements idp.IdentityProvider.GetGroups.
-func (*openidConnectIdentityProvider) GetGroups(context.Context, *store.Identity) ([]string, error) {
-	return nil, nil
+// GetGroups implements idp.IdentityProvider.GetGroups by returning the
+// groups found in the groups claim the last time the user logged in.
+func (*openidConnectIdentityProvider) GetGroups(_ context.Context, identity *store.Identity) ([]string, error) {
+	return identity.ProviderInfo[groupsKey], nil
 }
 
 // Handle implements idp.IdentityProvider.Handle.
@@ -185,7 +250,9 @@ func (idp *openidConnectIdentityProvider) callback(ctx context.Context, w http.R
 	if dischargeID != req.Form.Get("state") {
 		return dischargeID, errgo.WithCausef(nil, params.ErrBadRequest, "invalid session")
 	}
-	tok, err := idp.config.Exchange(ctx, req.Form.Get("code"))
+	upctx, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "exchange")
+	tok, err := idp.config.Exchange(upctx, req.Form.Get("code"))
+	upstream.End(err)
 	if err != nil {
 		return dischargeID, errgo.Mask(err)
 	}
@@ -201,10 +268,29 @@ func (idp *openidConnectIdentityProvider) callback(ctx context.Context, w http.R
 	if err != nil {
 		return dischargeID, errgo.Mask(err)
 	}
+	claims, err := decodeClaims(id.Claims)
+	if err != nil {
+		return dischargeID, errgo.Mask(err)
+	}
 	user := store.Identity{
 		ProviderID: store.MakeProviderIdentity(idp.Name(), fmt.Sprintf("%s:%s", id.Issuer, id.Subject)),
 	}
-	err = idp.initParams.Store.Identity(ctx, &user)
+	var groups []string
+	if idp.claims.groups != nil {
+		groups, err = idp.groups(ctx, tok, claims)
+		if err != nil {
+			return dischargeID, errgo.Mask(err)
+		}
+		user.ProviderInfo = map[string][]string{
+			groupsKey: groups,
+		}
+		err = idp.initParams.Store.UpdateIdentity(ctx, &user, store.Update{
+			store.ProviderInfo: store.Set,
+		})
+	}
+	if err == nil {
+		err = idp.initParams.Store.Identity(ctx, &user)
+	}
 	if err == nil {
 		idp.deleteSession(ctx, w)
 		idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, &user)
@@ -214,163 +300,66 @@ func (idp *openidConnectIdentityProvider) callback(ctx context.Context, w http.R
 	if errgo.Cause(err) != store.ErrNotFound {
 		return dischargeID, errgo.Mask(err)
 	}
-	var claims claims
-	if err := id.Claims(&claims); err != nil {
-		return dischargeID, errgo.Mask(err)
-	}
 	state, err := idp.codec.Encode(registrationState{
 		WaitID:     dischargeID,
 		ProviderID: user.ProviderID,
+		Groups:     groups,
 	})
-	preferredUsername := ""
-	if names.IsValidUserName(claims.PreferredUsername) {
-		preferredUsername = claims.PreferredUsername
-	}
-	return dischargeID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
-		State:    state,
-		Username: preferredUsername,
-		Domain:   idp.params.Domain,
-		FullName: claims.FullName,
-		Email:    claims.Email,
-	}, idp.initParams.Template))
-}
-
-func (idp *openidConnectIdentityProvider) register(ctx context.Context, w http.ResponseWriter, req *http.Request) (string, error) {
-	dischargeID, err := idp.getSession(ctx, req)
 	if err != nil {
-		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "")
-	}
-	var state registrationState
-	if err := idp.codec.Decode(req.Form.Get("state"), &state); err != nil {
-		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "invalid registration state")
-	}
-	if state.WaitID != dischargeID {
-		return dischargeID, errgo.WithCausef(err, params.ErrBadRequest, "invalid registration state")
-	}
-	u := &store.Identity{
-		ProviderID: state.ProviderID,
-		Name:       req.Form.Get("fullname"),
-		Email:      req.Form.Get("email"),
-	}
-	err = idp.registerUser(ctx, req.Form.Get("username"), u)
-	if err == nil {
-		idp.deleteSession(ctx, w)
-		idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, u)
-		return dischargeID, nil
-	}
-	if errgo.Cause(err) != errInvalidUser {
 		return dischargeID, errgo.Mask(err)
 	}
+	preferredUsername := idp.claims.username.string(claims)
+	if !names.IsValidUserName(preferredUsername) {
+		preferredUsername = ""
+	}
 	return dischargeID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
-		State:    req.Form.Get("state"),
-		Error:    err.Error(),
-		Username: req.Form.Get("username"),
+		State:    state,
+		Username: preferredUsername,
 		Domain:   idp.params.Domain,
-		FullName: req.Form.Get("fullname"),
-		Email:    req.Form.Get("email"),
+		FullName: idp.claims.name.string(claims),
+		Email:    idp.claims.email.string(claims),
 	}, idp.initParams.Template))
 }
 
-var errInvalidUser = errgo.New("invalid user")
-
-func (idp *openidConnectIdentityProvider) registerUser(ctx context.Context, username string, u *store.Identity) error {
-	if !names.IsValidUserName(username) {
-		return errgo.WithCausef(nil, errInvalidUser, "invalid user name. The username must contain only A-Z, a-z, 0-9, '.', '-', & '+', and must start and end with a letter or number.")
-	}
-	if idputil.ReservedUsernames[username] {
-		return errgo.WithCausef(nil, errInvalidUser, "username %s is not allowed, please choose another.", username)
-	}
-	u.Username = joinDomain(username, idp.params.Domain)
-	err := idp.initParams.Store.UpdateIdentity(ctx, u, store.Update{
-		store.Username: store.Set,
-		store.Name:     store.Set,
-		store.Email:    store.Set,
-	})
-	if err == nil {
-		return nil
-	}
-	if errgo.Cause(err) != store.ErrDuplicateUsername {
-		return errgo.Mask(err)
-	}
-	return errgo.WithCausef(nil, errInvalidUser, "Username already taken, please pick a different one.")
-}
-
-// newSession stores the state data for this login session in an
-// encrypted session cookie.
-func (idp *openidConnectIdentityProvider) newSession(ctx context.Context, w http.ResponseWriter, dischargeID string) error {
-	sessionCookie := sessionCookie{
-		WaitID:  dischargeID,
-		Expires: time.Now().Add(15 * time.Minute),
-	}
-	value, err := idp.codec.Encode(sessionCookie)
-	if err != nil {
-		return errgo.Mask(err)
-	}
-	http.SetCookie(w, &http.Cookie{
-		Name:  idp.sessionCookieName(),
-		Value: value,
-	})
-	return nil
-}
-
-// getSession retrieves and validats the current session cookie for the
-// login session and returns the associated dischargeID.
-func (idp *openidConnectIdentityProvider) getSession(ctx context.Context, req *http.Request) (string, error) {
-	c, err := req.Cookie(idp.sessionCookieName())
-	if err == http.ErrNoCookie {
-		return "", errgo.Notef(err, "no login session")
-	}
-	if err != nil {
-		return "", err
-	}
-	var sessionCookie sessionCookie
-	if err = idp.codec.Decode(c.Value, &sessionCookie); err != nil {
-		return "", errgo.Notef(err, "invalid session")
+// groups determines the groups of which the user is a member. If the
+// identity provider is configured to use the userinfo endpoint then the
+// groups claim is read from there, otherwise it is taken from the given
+// ID token claims.
+func (idp *openidConnectIdentityProvider) groups(ctx context.Context, tok *oauth2.Token, claims interface{}) ([]string, error) {
+	if idp.params.UserInfoGroups {
+		ui, err := idp.provider.UserInfo(ctx, idp.config.TokenSource(ctx, tok))
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot get user information")
+		}
+		claims, err = decodeClaims(ui.Claims)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
 	}
-	if sessionCookie.Expires.Before(time.Now()) {
-		return "", errgo.New("expired session")
+	groups := idp.claims.groups.strings(claims)
+	if groups == nil {
+		groups = []string{}
 	}
-	return sessionCookie.WaitID, nil
+	return groups, nil
 }
 
-// deleteSession removes the session cookie for the current login
-// session.
-func (idp *openidConnectIdentityProvider) deleteSession(ctx context.Context, w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name: idp.sessionCookieName(),
-	})
-}
-
-func (idp *openidConnectIdentityProvider) sessionCookieName() string {
-	return "idp-login-" + idp.params.Name
-}
-
-// sessionCookie contains the stored state for the OpenID login process.
-type sessionCookie struct {
-	WaitID  string    `json:"wid"`
-	Expires time.Time `json:"exp"`
-}
-
-// claims contains the set of claims possibly returned in the OpenID
-// token.
-type claims struct {
-	FullName          string `json:"name"`
-	Email             string `json:"email"`
-	PreferredUsername string `json:"preferred_username"`
-}
-
-// joinDomain creates a new params.Username with the given name and
-// (optional) domain.
-func joinDomain(name, domain string) string {
-	if domain == "" {
-		return name
-	}
-	return fmt.Sprintf("%s@%s", name, domain)
+func (idp *openidConnectIdentityProvider) register(ctx context.Context, w http.ResponseWriter, req *http.Request) (string, error) {
+	return register(ctx, w, req, idp.loginSessions, idp.initParams, idp.params.Domain)
 }
 
-// registrationState holds state information about a registration that is
-// in progress.
-type registrationState struct {
-	WaitID     string                 `json:"wid"`
-	ProviderID store.ProviderIdentity `json:"pid"`
+// decodeClaims decodes the claims returned by the given claims
+// function into a generic JSON value that can be queried using a
+// jsonPath.
+func decodeClaims(f func(interface{}) error) (interface{}, error) {
+	var raw json.RawMessage
+	if err := f(&raw); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var claims interface{}
+	if err := dec.Decode(&claims); err != nil {
+		return nil, errgo.Notef(err, "cannot decode claims")
+	}
+	return claims, nil
 }
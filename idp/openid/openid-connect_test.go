@@ -0,0 +1,348 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openid_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/openid"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type openidConnectSuite struct {
+	idptest *idptest.Fixture
+	issuer  *testIssuer
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	qtsuite.Run(qt.New(t), &openidConnectSuite{})
+}
+
+func (s *openidConnectSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	s.issuer = newTestIssuer(c)
+	s.issuer.claims = map[string]interface{}{
+		"sub":                "1234",
+		"preferred_username": "jbloggs",
+		"name":               "Joe Bloggs",
+		"email":              "jbloggs@example.com",
+		"groups":             []string{"group1", "group2"},
+		"realm_access": map[string]interface{}{
+			"roles": []string{"role1"},
+		},
+	}
+	s.issuer.userInfo = map[string]interface{}{
+		"sub":    "1234",
+		"groups": []string{"group3"},
+	}
+}
+
+func (s *openidConnectSuite) setupIdp(c *qt.C, p openid.OpenIDConnectParams) idp.IdentityProvider {
+	p.Name = "test"
+	p.Domain = "example"
+	p.Issuer = s.issuer.URL
+	p.ClientID = "client-id"
+	p.ClientSecret = "client-secret"
+	i := openid.NewOpenIDConnectIdentityProvider(p)
+	initParams := s.idptest.InitParams(c, "https://candid.example.com/login/test")
+	initParams.Template = template.Must(template.New("register").Parse("{{.State}} {{.Username}} {{.FullName}} {{.Email}}"))
+	err := i.Init(context.Background(), initParams)
+	c.Assert(err, qt.Equals, nil)
+	return i
+}
+
+func (s *openidConnectSuite) TestInvalidClaim(c *qt.C) {
+	i := openid.NewOpenIDConnectIdentityProvider(openid.OpenIDConnectParams{
+		Name:   "test",
+		Issuer: s.issuer.URL,
+		Claims: openid.ClaimMapping{
+			Groups: "groups[",
+		},
+	})
+	err := i.Init(context.Background(), s.idptest.InitParams(c, "https://candid.example.com/login/test"))
+	c.Assert(err, qt.ErrorMatches, `invalid claim: invalid path "groups\[": missing \]`)
+}
+
+func (s *openidConnectSuite) TestLoginNewUserDefaultClaims(c *qt.C) {
+	i := s.setupIdp(c, openid.OpenIDConnectParams{})
+	rr := s.callback(c, i, s.login(c, i, "1"), "1")
+	s.idptest.AssertLoginNotComplete(c)
+	fields := strings.SplitN(rr.Body.String(), " ", 2)
+	c.Assert(fields[1], qt.Equals, "jbloggs Joe Bloggs jbloggs@example.com")
+}
+
+func (s *openidConnectSuite) TestLoginNewUserMappedClaims(c *qt.C) {
+	s.issuer.claims["nickname"] = "joeb"
+	s.issuer.claims["display_name"] = "Joe B"
+	i := s.setupIdp(c, openid.OpenIDConnectParams{
+		Claims: openid.ClaimMapping{
+			Username: "nickname",
+			Name:     "display_name",
+			Groups:   "realm_access.roles",
+		},
+	})
+	cookies := s.login(c, i, "1")
+	rr := s.callback(c, i, cookies, "1")
+	s.idptest.AssertLoginNotComplete(c)
+	fields := strings.SplitN(rr.Body.String(), " ", 2)
+	c.Assert(fields[1], qt.Equals, "joeb Joe B jbloggs@example.com")
+
+	s.register(c, i, cookies, url.Values{
+		"state":    {fields[0]},
+		"username": {"joeb"},
+		"fullname": {"Joe B"},
+		"email":    {"jbloggs@example.com"},
+	})
+	s.idptest.AssertLoginSuccess(c, "joeb@example")
+	identity := s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "joeb@example",
+		Name:       "Joe B",
+		Email:      "jbloggs@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"role1"},
+		},
+	})
+	groups, err := i.GetGroups(s.idptest.Ctx, identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"role1"})
+}
+
+func (s *openidConnectSuite) TestLoginExistingUserUpdatesGroups(c *qt.C) {
+	s.createUser(c, "oldgroup")
+	i := s.setupIdp(c, openid.OpenIDConnectParams{
+		Claims: openid.ClaimMapping{
+			Groups: "groups",
+		},
+	})
+	s.callback(c, i, s.login(c, i, "1"), "1")
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	})
+}
+
+func (s *openidConnectSuite) TestLoginNamespacedGroupsClaim(c *qt.C) {
+	s.issuer.claims["https://example.com/groups"] = []string{"group4"}
+	s.createUser(c, "oldgroup")
+	i := s.setupIdp(c, openid.OpenIDConnectParams{
+		Claims: openid.ClaimMapping{
+			Groups: "https://example.com/groups",
+		},
+	})
+	s.callback(c, i, s.login(c, i, "1"), "1")
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"group4"},
+		},
+	})
+}
+
+func (s *openidConnectSuite) TestLoginExistingUserWithoutGroupsClaim(c *qt.C) {
+	s.createUser(c, "oldgroup")
+	i := s.setupIdp(c, openid.OpenIDConnectParams{})
+	s.callback(c, i, s.login(c, i, "1"), "1")
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"oldgroup"},
+		},
+	})
+}
+
+func (s *openidConnectSuite) TestLoginUserInfoGroups(c *qt.C) {
+	s.createUser(c, "oldgroup")
+	i := s.setupIdp(c, openid.OpenIDConnectParams{
+		Claims: openid.ClaimMapping{
+			Groups: "groups",
+		},
+		UserInfoGroups: true,
+	})
+	s.callback(c, i, s.login(c, i, "1"), "1")
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": {"group3"},
+		},
+	})
+}
+
+func (s *openidConnectSuite) createUser(c *qt.C, groups ...string) {
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", s.issuer.URL+":1234"),
+		Username:   "jbloggs@example",
+		ProviderInfo: map[string][]string{
+			"groups": groups,
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *openidConnectSuite) login(c *qt.C, i idp.IdentityProvider, dischargeID string) []*http.Cookie {
+	req, err := http.NewRequest("GET", "/login?id="+dischargeID, nil)
+	c.Assert(err, qt.Equals, nil)
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusFound)
+	return rr.Result().Cookies()
+}
+
+func (s *openidConnectSuite) callback(c *qt.C, i idp.IdentityProvider, cookies []*http.Cookie, state string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/callback?code=test-code&state="+state, nil)
+	c.Assert(err, qt.Equals, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr
+}
+
+func (s *openidConnectSuite) register(c *qt.C, i idp.IdentityProvider, cookies []*http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr
+}
+
+// testIssuer is a minimal OpenID Connect issuer that issues ID tokens
+// containing claims for any authorization code.
+type testIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	claims   map[string]interface{}
+	userInfo map[string]interface{}
+}
+
+func newTestIssuer(c *qt.C) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, qt.Equals, nil)
+	iss := &testIssuer{
+		key: key,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.serveConfiguration)
+	mux.HandleFunc("/keys", iss.serveKeys)
+	mux.HandleFunc("/token", iss.serveToken)
+	mux.HandleFunc("/userinfo", iss.serveUserInfo)
+	iss.Server = httptest.NewServer(mux)
+	c.Defer(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) serveConfiguration(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                 iss.URL,
+		"authorization_endpoint": iss.URL + "/auth",
+		"token_endpoint":         iss.URL + "/token",
+		"jwks_uri":               iss.URL + "/keys",
+		"userinfo_endpoint":      iss.URL + "/userinfo",
+	})
+}
+
+func (iss *testIssuer) serveKeys(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       &iss.key.PublicKey,
+			KeyID:     "test-key",
+			Algorithm: "RS256",
+			Use:       "sig",
+		}},
+	})
+}
+
+func (iss *testIssuer) serveToken(w http.ResponseWriter, req *http.Request) {
+	claims := map[string]interface{}{
+		"iss": iss.URL,
+		"aud": "client-id",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range iss.claims {
+		claims[k] = v
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key: &jose.JSONWebKey{
+			Key:   iss.key,
+			KeyID: "test-key",
+		},
+	}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, err := jws.CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": "test-token",
+		"token_type":   "bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (iss *testIssuer) serveUserInfo(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, iss.userInfo)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
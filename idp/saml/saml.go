@@ -0,0 +1,537 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package saml is an identity provider that acts as a SAML 2.0 service
+// provider and authenticates users with a SAML identity provider.
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	saml2 "github.com/russellhaering/gosaml2"
+	samltypes "github.com/russellhaering/gosaml2/types"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/idp/idputil/secret"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func init() {
+	idp.Register("saml", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal saml parameters")
+		}
+		if p.Name == "" {
+			return nil, errgo.Newf("name not specified")
+		}
+		if p.Metadata == "" && p.MetadataURL == "" {
+			return nil, errgo.Newf("metadata or metadata-url must be specified")
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+// groupsKey is the key in the ProviderInfo of an identity used to hold
+// the groups asserted by the SAML identity provider.
+const groupsKey = "groups"
+
+// requestTimeout is the length of time that an authentication request
+// sent to the SAML identity provider remains valid.
+const requestTimeout = 15 * time.Minute
+
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	Name string `yaml:"name"`
+
+	// Description is the description that will be used with the
+	// identity provider. If this is not set then Name will be used.
+	Description string `yaml:"description"`
+
+	// Domain is the domain with which all identities created by this
+	// identity provider will be tagged (not including the @ separator).
+	Domain string `yaml:"domain"`
+
+	// Metadata contains the XML metadata document describing the
+	// SAML identity provider.
+	Metadata string `yaml:"metadata"`
+
+	// MetadataURL contains a URL from which the metadata document
+	// describing the SAML identity provider can be retrieved. This
+	// is only used if Metadata is not set.
+	MetadataURL string `yaml:"metadata-url"`
+
+	// EntityID contains the entity ID with which candid identifies
+	// itself to the SAML identity provider. If this is not set then
+	// the URL of the service provider metadata will be used.
+	EntityID string `yaml:"entity-id"`
+
+	// NameIDFormat contains the format of the NameID to request from
+	// the SAML identity provider. If this is not set then a
+	// persistent NameID will be requested.
+	NameIDFormat string `yaml:"name-id-format"`
+
+	// Attributes contains the names of the SAML attributes that are
+	// used to populate the identities created by this identity
+	// provider.
+	Attributes Attributes `yaml:"attributes"`
+}
+
+// Attributes holds the names of the SAML attributes that are mapped
+// to identity fields. Any attribute that is not set will not be used.
+type Attributes struct {
+	// Username holds the name of the attribute containing the
+	// preferred username of the user. If no valid username is
+	// found then the user will be asked to choose one.
+	Username string `yaml:"username"`
+
+	// Name holds the name of the attribute containing the user's
+	// display name.
+	Name string `yaml:"name"`
+
+	// Email holds the name of the attribute containing the user's
+	// email address.
+	Email string `yaml:"email"`
+
+	// Groups holds the name of the attribute containing the groups
+	// of which the user is a member.
+	Groups string `yaml:"groups"`
+}
+
+// NewIdentityProvider creates a new SAML identity provider.
+func NewIdentityProvider(p Params) idp.IdentityProvider {
+	if p.Description == "" {
+		p.Description = p.Name
+	}
+	if p.NameIDFormat == "" {
+		p.NameIDFormat = saml2.NameIdFormatPersistent
+	}
+	return &identityProvider{
+		params: p,
+	}
+}
+
+type identityProvider struct {
+	params     Params
+	initParams idp.InitParams
+	sp         *saml2.SAMLServiceProvider
+	codec      *secret.Codec
+}
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *identityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain.
+func (idp *identityProvider) Domain() string {
+	return idp.params.Domain
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *identityProvider) Description() string {
+	return idp.params.Description
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*identityProvider) Interactive() bool {
+	return true
+}
+
+// Init implements idp.IdentityProvider.Init by reading the metadata of
+// the SAML identity provider and setting up the service provider.
+func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	metadata := []byte(idp.params.Metadata)
+	if len(metadata) == 0 {
+		var err error
+		metadata, err = fetchMetadata(ctx, idp.params.MetadataURL)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	var ed samltypes.EntityDescriptor
+	if err := xml.Unmarshal(metadata, &ed); err != nil {
+		return errgo.Notef(err, "cannot parse SAML metadata")
+	}
+	if ed.IDPSSODescriptor == nil {
+		return errgo.Newf("SAML metadata does not describe an identity provider")
+	}
+	ssoURL := ""
+	for _, sso := range ed.IDPSSODescriptor.SingleSignOnServices {
+		if sso.Binding == saml2.BindingHttpRedirect {
+			ssoURL = sso.Location
+			break
+		}
+	}
+	if ssoURL == "" {
+		return errgo.Newf("SAML metadata does not contain an HTTP-Redirect single sign-on service")
+	}
+	certs, err := signingCertificates(ed.IDPSSODescriptor)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	entityID := idp.params.EntityID
+	if entityID == "" {
+		entityID = idp.initParams.URLPrefix + "/metadata"
+	}
+	idp.sp = &saml2.SAMLServiceProvider{
+		IdentityProviderSSOURL:      ssoURL,
+		IdentityProviderIssuer:      ed.EntityID,
+		ServiceProviderIssuer:       entityID,
+		AssertionConsumerServiceURL: idp.initParams.URLPrefix + "/acs",
+		AudienceURI:                 entityID,
+		IDPCertificateStore:         &dsig.MemoryX509CertificateStore{Roots: certs},
+		NameIdFormat:                idp.params.NameIDFormat,
+		AllowMissingAttributes:      true,
+	}
+	idp.codec = secret.NewCodec(idp.initParams.Key)
+	return nil
+}
+
+// fetchMetadata retrieves the metadata document from the given URL.
+func fetchMetadata(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve SAML metadata")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot retrieve SAML metadata: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve SAML metadata")
+	}
+	return data, nil
+}
+
+// signingCertificates extracts the certificates the identity provider
+// uses to sign assertions from the given descriptor.
+func signingCertificates(d *samltypes.IDPSSODescriptor) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, kd := range d.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, xc := range kd.KeyInfo.X509Data.X509Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(xc.Data), ""))
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot decode SAML signing certificate")
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot parse SAML signing certificate")
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errgo.Newf("SAML metadata does not contain any signing certificates")
+	}
+	return certs, nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *identityProvider) URL(dischargeID string) string {
+	return idputil.URL(idp.initParams.URLPrefix, "/login", dischargeID)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *identityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups by returning the
+// groups that were asserted by the SAML identity provider the last
+// time the user logged in.
+func (*identityProvider) GetGroups(_ context.Context, identity *store.Identity) ([]string, error) {
+	return identity.ProviderInfo[groupsKey], nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/metadata":
+		if err := idp.metadata(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case "/acs":
+		if dischargeID, err := idp.acs(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, dischargeID, err)
+		}
+	case "/register":
+		if dischargeID, err := idp.register(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, dischargeID, err)
+		}
+	default:
+		if err := idp.login(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), err)
+		}
+	}
+}
+
+// metadata writes the SAML metadata describing candid as a service
+// provider.
+func (idp *identityProvider) metadata(w http.ResponseWriter) error {
+	ed := samltypes.EntityDescriptor{
+		ValidUntil: time.Now().UTC().Add(7 * 24 * time.Hour),
+		EntityID:   idp.sp.ServiceProviderIssuer,
+		SPSSODescriptor: &samltypes.SPSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: saml2.SAMLProtocolNamespace,
+			NameIDFormats:              []string{idp.params.NameIDFormat},
+			AssertionConsumerServices: []samltypes.IndexedEndpoint{{
+				Binding:  saml2.BindingHttpPost,
+				Location: idp.sp.AssertionConsumerServiceURL,
+				Index:    1,
+			}},
+		},
+	}
+	data, err := xml.MarshalIndent(ed, "", "\t")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+	return nil
+}
+
+// login starts a login attempt by redirecting the user to the SAML
+// identity provider with a new authentication request.
+func (idp *identityProvider) login(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	dischargeID := idputil.DischargeID(req)
+	doc, err := idp.sp.BuildAuthRequestDocument()
+	if err != nil {
+		return errgo.Notef(err, "cannot create SAML authentication request")
+	}
+	requestID := doc.Root().SelectAttrValue("ID", "")
+	state, err := json.Marshal(requestState{
+		WaitID: dischargeID,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := idp.initParams.KeyValueStore.Set(ctx, requestKey(requestID), state, time.Now().Add(requestTimeout)); err != nil {
+		return errgo.Mask(err)
+	}
+	url, err := idp.sp.BuildAuthURLRedirect("", doc)
+	if err != nil {
+		return errgo.Notef(err, "cannot create SAML authentication request")
+	}
+	http.Redirect(w, req, url, http.StatusFound)
+	return nil
+}
+
+// acs implements the assertion consumer service that receives the
+// response from the SAML identity provider.
+func (idp *identityProvider) acs(ctx context.Context, w http.ResponseWriter, req *http.Request) (string, error) {
+	info, err := idp.sp.RetrieveAssertionInfo(req.Form.Get("SAMLResponse"))
+	if err != nil {
+		return "", errgo.WithCausef(err, params.ErrBadRequest, "invalid SAML response")
+	}
+	if info.WarningInfo.InvalidTime {
+		return "", errgo.WithCausef(nil, params.ErrBadRequest, "SAML assertion is not currently valid")
+	}
+	if info.WarningInfo.NotInAudience {
+		return "", errgo.WithCausef(nil, params.ErrBadRequest, "SAML assertion is not intended for this service")
+	}
+	dischargeID, err := idp.completeRequest(ctx, inResponseTo(info))
+	if err != nil {
+		return "", errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	attrs := idp.params.Attributes
+	user := store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.Name(), info.NameID),
+	}
+	update := store.Update{}
+	if attrs.Name != "" {
+		user.Name = info.Values.Get(attrs.Name)
+		update[store.Name] = store.Set
+	}
+	if attrs.Email != "" {
+		user.Email = info.Values.Get(attrs.Email)
+		update[store.Email] = store.Set
+	}
+	if attrs.Groups != "" {
+		user.ProviderInfo = map[string][]string{
+			groupsKey: attributeValues(info.Values, attrs.Groups),
+		}
+		update[store.ProviderInfo] = store.Set
+	}
+	err = idp.initParams.Store.UpdateIdentity(ctx, &user, update)
+	if err == nil {
+		if err := idp.initParams.Store.Identity(ctx, &user); err != nil {
+			return dischargeID, errgo.Mask(err)
+		}
+		idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, &user)
+		return dischargeID, nil
+	}
+	if errgo.Cause(err) != store.ErrNotFound {
+		return dischargeID, errgo.Mask(err)
+	}
+
+	// This is a new user, if the identity provider has supplied a
+	// suitable username then use it, otherwise ask the user to
+	// choose one.
+	var username string
+	if attrs.Username != "" {
+		username = info.Values.Get(attrs.Username)
+	}
+	if username != "" {
+		err := idputil.RegisterUser(ctx, idp.initParams.Store, username, idp.params.Domain, &user)
+		if err == nil {
+			idp.initParams.VisitCompleter.Success(ctx, w, req, dischargeID, &user)
+			return dischargeID, nil
+		}
+		if errgo.Cause(err) != idputil.ErrInvalidUser {
+			return dischargeID, errgo.Mask(err)
+		}
+		if !names.IsValidUserName(username) {
+			username = ""
+		}
+	}
+	state, err := idp.codec.Encode(registrationState{
+		WaitID:     dischargeID,
+		ProviderID: user.ProviderID,
+		Groups:     user.ProviderInfo[groupsKey],
+		Expires:    time.Now().Add(requestTimeout),
+	})
+	if err != nil {
+		return dischargeID, errgo.Mask(err)
+	}
+	return dischargeID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    state,
+		Username: username,
+		Domain:   idp.params.Domain,
+		FullName: user.Name,
+		Email:    user.Email,
+	}, idp.initParams.Template))
+}
+
+// completeRequest marks the authentication request with the given ID as
+// complete and returns the discharge ID associated with it. Each
+// authentication request may only be completed once.
+func (idp *identityProvider) completeRequest(ctx context.Context, requestID string) (string, error) {
+	if requestID == "" {
+		return "", errgo.WithCausef(nil, params.ErrBadRequest, "unsolicited SAML response")
+	}
+	var state requestState
+	err := idp.initParams.KeyValueStore.Update(ctx, requestKey(requestID), time.Now().Add(requestTimeout), func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unknown SAML request %q", requestID)
+		}
+		if err := json.Unmarshal(old, &state); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if state.Complete {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "SAML request %q already completed", requestID)
+		}
+		state.Complete = true
+		return json.Marshal(state)
+	})
+	if err != nil {
+		return "", errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return state.WaitID, nil
+}
+
+func (idp *identityProvider) register(ctx context.Context, w http.ResponseWriter, req *http.Request) (string, error) {
+	var state registrationState
+	if err := idp.codec.Decode(req.Form.Get("state"), &state); err != nil {
+		return "", errgo.WithCausef(err, params.ErrBadRequest, "invalid registration state")
+	}
+	if state.Expires.Before(time.Now()) {
+		return state.WaitID, errgo.WithCausef(nil, params.ErrBadRequest, "registration expired")
+	}
+	u := &store.Identity{
+		ProviderID: state.ProviderID,
+		Name:       req.Form.Get("fullname"),
+		Email:      req.Form.Get("email"),
+	}
+	if state.Groups != nil {
+		u.ProviderInfo = map[string][]string{
+			groupsKey: state.Groups,
+		}
+	}
+	err := idputil.RegisterUser(ctx, idp.initParams.Store, req.Form.Get("username"), idp.params.Domain, u)
+	if err == nil {
+		idp.initParams.VisitCompleter.Success(ctx, w, req, state.WaitID, u)
+		return state.WaitID, nil
+	}
+	if errgo.Cause(err) != idputil.ErrInvalidUser {
+		return state.WaitID, errgo.Mask(err)
+	}
+	return state.WaitID, errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    req.Form.Get("state"),
+		Error:    err.Error(),
+		Username: req.Form.Get("username"),
+		Domain:   idp.params.Domain,
+		FullName: req.Form.Get("fullname"),
+		Email:    req.Form.Get("email"),
+	}, idp.initParams.Template))
+}
+
+// inResponseTo determines the ID of the authentication request that
+// the given assertion is responding to.
+func inResponseTo(info *saml2.AssertionInfo) string {
+	for _, a := range info.Assertions {
+		if a.Subject == nil || a.Subject.SubjectConfirmation == nil || a.Subject.SubjectConfirmation.SubjectConfirmationData == nil {
+			continue
+		}
+		if id := a.Subject.SubjectConfirmation.SubjectConfirmationData.InResponseTo; id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// attributeValues returns all the values of the attribute with the
+// given name.
+func attributeValues(vals saml2.Values, name string) []string {
+	var values []string
+	for _, v := range vals[name].Values {
+		values = append(values, v.Value)
+	}
+	return values
+}
+
+// requestKey determines the key used to store the state of the
+// authentication request with the given ID.
+func requestKey(requestID string) string {
+	return "request#" + requestID
+}
+
+// requestState holds the state stored for an authentication request
+// sent to the SAML identity provider.
+type requestState struct {
+	WaitID   string `json:"wid"`
+	Complete bool   `json:"complete,omitempty"`
+}
+
+// registrationState holds state information about a registration that is
+// in progress.
+type registrationState struct {
+	WaitID     string                 `json:"wid"`
+	ProviderID store.ProviderIdentity `json:"pid"`
+	Groups     []string               `json:"groups,omitempty"`
+	Expires    time.Time              `json:"exp"`
+}
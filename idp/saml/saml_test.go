@@ -0,0 +1,354 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package saml_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/saml"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	idpEntityID = "https://idp.example.com/metadata"
+	idpSSOURL   = "https://idp.example.com/sso"
+	urlPrefix   = "https://candid.example.com/login/saml"
+)
+
+var configTests = []struct {
+	about       string
+	yaml        string
+	expectError string
+}{{
+	about: "good config",
+	yaml: `
+identity-providers:
+ - type: saml
+   name: saml
+   metadata-url: https://idp.example.com/metadata
+`,
+}, {
+	about: "no name",
+	yaml: `
+identity-providers:
+ - type: saml
+   metadata-url: https://idp.example.com/metadata
+`,
+	expectError: `cannot unmarshal saml configuration: name not specified`,
+}, {
+	about: "no metadata",
+	yaml: `
+identity-providers:
+ - type: saml
+   name: saml
+`,
+	expectError: `cannot unmarshal saml configuration: metadata or metadata-url must be specified`,
+}}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range configTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "saml")
+		})
+	}
+}
+
+type samlSuite struct {
+	idptest  *idptest.Fixture
+	keyStore dsig.X509KeyStore
+	metadata string
+}
+
+func TestSAML(t *testing.T) {
+	qtsuite.Run(qt.New(t), &samlSuite{})
+}
+
+func (s *samlSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	s.keyStore = dsig.RandomKeyStoreForTest()
+	_, cert, err := s.keyStore.GetKeyPair()
+	c.Assert(err, qt.Equals, nil)
+	s.metadata = fmt.Sprintf(metadataTemplate, idpEntityID, base64.StdEncoding.EncodeToString(cert), idpSSOURL)
+}
+
+func (s *samlSuite) setupIdp(c *qt.C, params saml.Params) idp.IdentityProvider {
+	if params.Name == "" {
+		params.Name = "saml"
+	}
+	params.Metadata = s.metadata
+	i := saml.NewIdentityProvider(params)
+	initParams := s.idptest.InitParams(c, urlPrefix)
+	initParams.Template = template.Must(template.New("register").Parse("register {{.Username}} {{.Email}}"))
+	err := i.Init(context.Background(), initParams)
+	c.Assert(err, qt.Equals, nil)
+	return i
+}
+
+func (s *samlSuite) TestInteractive(c *qt.C) {
+	i := saml.NewIdentityProvider(saml.Params{Name: "saml"})
+	c.Assert(i.Interactive(), qt.Equals, true)
+	c.Assert(i.Description(), qt.Equals, "saml")
+}
+
+func (s *samlSuite) TestURL(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{})
+	c.Assert(i.URL("1"), qt.Equals, urlPrefix+"/login?id=1")
+}
+
+func (s *samlSuite) TestInitBadMetadata(c *qt.C) {
+	i := saml.NewIdentityProvider(saml.Params{
+		Name:     "saml",
+		Metadata: "<EntityDescriptor/>",
+	})
+	err := i.Init(context.Background(), s.idptest.InitParams(c, urlPrefix))
+	c.Assert(err, qt.ErrorMatches, `cannot parse SAML metadata: .*`)
+}
+
+func (s *samlSuite) TestMetadata(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{})
+	req, err := http.NewRequest("GET", "/metadata", nil)
+	c.Assert(err, qt.Equals, nil)
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusOK)
+	c.Assert(strings.Contains(rr.Body.String(), `entityID="`+urlPrefix+`/metadata"`), qt.Equals, true)
+	c.Assert(strings.Contains(rr.Body.String(), `Location="`+urlPrefix+`/acs"`), qt.Equals, true)
+}
+
+func (s *samlSuite) TestLogin(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{
+		Domain: "example",
+		Attributes: saml.Attributes{
+			Username: "uid",
+			Name:     "displayName",
+			Email:    "mail",
+			Groups:   "memberOf",
+		},
+	})
+	requestID := s.startLogin(c, i, "1")
+	s.postResponse(c, i, s.response(c, requestID, "user-1234"))
+	s.idptest.AssertLoginSuccess(c, "jbloggs@example")
+	identity := s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", "user-1234"),
+		Username:   "jbloggs@example",
+		Name:       "Joe Bloggs",
+		Email:      "jbloggs@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	})
+	groups, err := i.GetGroups(s.idptest.Ctx, identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"group1", "group2"})
+}
+
+func (s *samlSuite) TestLoginExistingUser(c *qt.C) {
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", "user-1234"),
+		Username:   "existing",
+		Name:       "Old Name",
+	}, store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	i := s.setupIdp(c, saml.Params{
+		Attributes: saml.Attributes{
+			Name: "displayName",
+		},
+	})
+	requestID := s.startLogin(c, i, "1")
+	s.postResponse(c, i, s.response(c, requestID, "user-1234"))
+	s.idptest.AssertLoginSuccess(c, "existing")
+	s.idptest.Store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", "user-1234"),
+		Username:   "existing",
+		Name:       "Joe Bloggs",
+	})
+}
+
+func (s *samlSuite) TestLoginRegistration(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{
+		Attributes: saml.Attributes{
+			Email: "mail",
+		},
+	})
+	requestID := s.startLogin(c, i, "1")
+	rr := s.postResponse(c, i, s.response(c, requestID, "user-1234"))
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(rr.Code, qt.Equals, http.StatusOK)
+	c.Assert(rr.Body.String(), qt.Equals, "register  jbloggs@example.com")
+}
+
+func (s *samlSuite) TestReplayedResponse(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{
+		Attributes: saml.Attributes{
+			Username: "uid",
+		},
+	})
+	requestID := s.startLogin(c, i, "1")
+	resp := s.response(c, requestID, "user-1234")
+	s.postResponse(c, i, resp)
+	s.idptest.AssertLoginSuccess(c, "jbloggs")
+
+	s.idptest = idptest.NewFixture(c, s.idptest.Store)
+	i = s.setupIdp(c, saml.Params{})
+	s.postResponse(c, i, resp)
+	s.idptest.AssertLoginFailureMatches(c, `SAML request ".*" already completed`)
+}
+
+func (s *samlSuite) TestUnsolicitedResponse(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{})
+	s.postResponse(c, i, s.response(c, "", "user-1234"))
+	s.idptest.AssertLoginFailureMatches(c, `unsolicited SAML response`)
+}
+
+func (s *samlSuite) TestUnknownRequest(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{})
+	s.postResponse(c, i, s.response(c, "_unknown", "user-1234"))
+	s.idptest.AssertLoginFailureMatches(c, `unknown SAML request "_unknown"`)
+}
+
+func (s *samlSuite) TestUnsignedResponse(c *qt.C) {
+	i := s.setupIdp(c, saml.Params{})
+	requestID := s.startLogin(c, i, "1")
+	s.postResponse(c, i, s.unsignedResponse(c, requestID, "user-1234"))
+	s.idptest.AssertLoginFailureMatches(c, `invalid SAML response: .*`)
+}
+
+// startLogin starts a login with the given identity provider and returns
+// the ID of the authentication request sent to the SAML identity
+// provider.
+func (s *samlSuite) startLogin(c *qt.C, i idp.IdentityProvider, dischargeID string) string {
+	req, err := http.NewRequest("GET", "/login?id="+dischargeID, nil)
+	c.Assert(err, qt.Equals, nil)
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusFound)
+	u, err := url.Parse(rr.Header().Get("Location"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(u.Scheme+"://"+u.Host+u.Path, qt.Equals, idpSSOURL)
+	data, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	c.Assert(err, qt.Equals, nil)
+	data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	c.Assert(err, qt.Equals, nil)
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(data)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(doc.Root().SelectAttrValue("AssertionConsumerServiceURL", ""), qt.Equals, urlPrefix+"/acs")
+	return doc.Root().SelectAttrValue("ID", "")
+}
+
+func (s *samlSuite) postResponse(c *qt.C, i idp.IdentityProvider, resp string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/acs", strings.NewReader(url.Values{
+		"SAMLResponse": {resp},
+	}.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	return rr
+}
+
+// response creates an encoded SAML response, with a signed assertion,
+// in response to the given request ID for the given subject.
+func (s *samlSuite) response(c *qt.C, requestID, subject string) string {
+	assertion := makeAssertion(c, requestID, subject)
+	ctx := dsig.NewDefaultSigningContext(s.keyStore)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := ctx.SignEnveloped(assertion)
+	c.Assert(err, qt.Equals, nil)
+	return makeResponse(c, requestID, signed)
+}
+
+// unsignedResponse creates an encoded SAML response without any
+// signatures.
+func (s *samlSuite) unsignedResponse(c *qt.C, requestID, subject string) string {
+	return makeResponse(c, requestID, makeAssertion(c, requestID, subject))
+}
+
+func makeAssertion(c *qt.C, requestID, subject string) *etree.Element {
+	now := time.Now().UTC()
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(fmt.Sprintf(assertionTemplate,
+		now.Format(time.RFC3339),
+		idpEntityID,
+		subject,
+		requestID,
+		now.Add(5*time.Minute).Format(time.RFC3339),
+		urlPrefix+"/acs",
+		now.Add(-time.Minute).Format(time.RFC3339),
+		now.Add(5*time.Minute).Format(time.RFC3339),
+		urlPrefix+"/metadata",
+	))
+	c.Assert(err, qt.Equals, nil)
+	return doc.Root()
+}
+
+func makeResponse(c *qt.C, requestID string, assertion *etree.Element) string {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(fmt.Sprintf(responseTemplate,
+		requestID,
+		urlPrefix+"/acs",
+		time.Now().UTC().Format(time.RFC3339),
+		idpEntityID,
+	))
+	c.Assert(err, qt.Equals, nil)
+	doc.Root().AddChild(assertion)
+	data, err := doc.WriteToBytes()
+	c.Assert(err, qt.Equals, nil)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+const metadataTemplate = `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+	<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+		<md:KeyDescriptor use="signing">
+			<ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+				<ds:X509Data>
+					<ds:X509Certificate>%s</ds:X509Certificate>
+				</ds:X509Data>
+			</ds:KeyInfo>
+		</md:KeyDescriptor>
+		<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%s"/>
+	</md:IDPSSODescriptor>
+</md:EntityDescriptor>
+`
+
+const responseTemplate = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" Version="2.0" InResponseTo="%s" Destination="%s" IssueInstant="%s"><saml:Issuer>%s</saml:Issuer><samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status></samlp:Response>`
+
+const assertionTemplate = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion" Version="2.0" IssueInstant="%s"><saml:Issuer>%s</saml:Issuer><saml:Subject><saml:NameID>%s</saml:NameID><saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData InResponseTo="%s" NotOnOrAfter="%s" Recipient="%s"/></saml:SubjectConfirmation></saml:Subject><saml:Conditions NotBefore="%s" NotOnOrAfter="%s"><saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction></saml:Conditions><saml:AttributeStatement><saml:Attribute Name="uid"><saml:AttributeValue>jbloggs</saml:AttributeValue></saml:Attribute><saml:Attribute Name="displayName"><saml:AttributeValue>Joe Bloggs</saml:AttributeValue></saml:Attribute><saml:Attribute Name="mail"><saml:AttributeValue>jbloggs@example.com</saml:AttributeValue></saml:Attribute><saml:Attribute Name="memberOf"><saml:AttributeValue>group1</saml:AttributeValue><saml:AttributeValue>group2</saml:AttributeValue></saml:Attribute></saml:AttributeStatement></saml:Assertion>`
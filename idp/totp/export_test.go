@@ -0,0 +1,34 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp
+
+import (
+	"time"
+)
+
+var (
+	HOTP            = hotp
+	MaxAttempts     = maxAttempts
+	MaxUserAttempts = maxUserAttempts
+)
+
+// Code returns the TOTP code for the given base32 encoded secret at
+// time t.
+func Code(secret string, t time.Time) (string, error) {
+	b, err := secretEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(b, counter(t)), nil
+}
+
+// Validate checks the given code against the given secret at time t.
+func Validate(secret []byte, code string, t time.Time, last uint64) (uint64, bool) {
+	return validate(secret, code, t, last)
+}
+
+// KeyURI returns the otpauth URI for the given parameters.
+func KeyURI(issuer, account string, secret []byte) string {
+	return keyURI(issuer, account, secret)
+}
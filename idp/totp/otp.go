@@ -0,0 +1,99 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	// period is the length of each time step.
+	period = 30 * time.Second
+
+	// digits is the number of digits in each generated code.
+	digits = 6
+
+	// skew is the number of time steps either side of the current
+	// one for which a code is accepted, to allow for clock drift.
+	skew = 1
+
+	// secretLen is the length of generated secrets, in bytes.
+	secretLen = 20
+)
+
+// secretEncoding is the encoding used when showing secrets to users.
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newSecret generates a new random TOTP secret.
+func newSecret() ([]byte, error) {
+	secret := make([]byte, secretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errgo.Notef(err, "cannot generate secret")
+	}
+	return secret, nil
+}
+
+// hotp calculates the HOTP value (see RFC 4226) for the given secret
+// and counter.
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, v%1000000)
+}
+
+// counter returns the TOTP time step (see RFC 6238) containing t.
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(period/time.Second))
+}
+
+// validate checks whether the given code is valid for secret at time
+// t. Codes for time steps at or before last are not accepted, which
+// prevents any code from being used more than once. If the code is
+// valid then the time step that it was generated for is returned.
+func validate(secret []byte, code string, t time.Time, last uint64) (uint64, bool) {
+	now := counter(t)
+	start := uint64(0)
+	if now > skew {
+		start = now - skew
+	}
+	for c := start; c <= now+skew; c++ {
+		if c <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, c)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// keyURI creates a URI in the format understood by authenticator
+// applications that contains the given secret.
+func keyURI(issuer, account string, secret []byte) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+	}
+	u.RawQuery = url.Values{
+		"secret": {secretEncoding.EncodeToString(secret)},
+		"issuer": {issuer},
+	}.Encode()
+	return u.String()
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/idp/totp"
+)
+
+// rfcSecret is the secret used in the test vectors in RFC 4226 and
+// RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	c := qt.New(t)
+	// Test values from RFC 4226 appendix D.
+	expect := []string{
+		"755224",
+		"287082",
+		"359152",
+		"969429",
+		"338314",
+		"254676",
+		"287922",
+		"162583",
+		"399871",
+		"520489",
+	}
+	for i, code := range expect {
+		c.Check(totp.HOTP(rfcSecret, uint64(i)), qt.Equals, code, qt.Commentf("counter %d", i))
+	}
+}
+
+var validateTests = []struct {
+	about         string
+	time          int64
+	code          string
+	last          uint64
+	expectCounter uint64
+	expectOK      bool
+}{{
+	about:         "current code",
+	time:          59,
+	code:          "287082",
+	expectCounter: 1,
+	expectOK:      true,
+}, {
+	about:         "previous code",
+	time:          89,
+	code:          "287082",
+	expectCounter: 1,
+	expectOK:      true,
+}, {
+	about:         "next code",
+	time:          29,
+	code:          "287082",
+	expectCounter: 1,
+	expectOK:      true,
+}, {
+	about: "code too old",
+	time:  119,
+	code:  "287082",
+}, {
+	about: "code already used",
+	time:  59,
+	code:  "287082",
+	last:  1,
+}, {
+	about: "wrong code",
+	time:  59,
+	code:  "123456",
+}, {
+	// Test value from RFC 6238 appendix B, truncated to 6 digits.
+	about:         "rfc6238 test vector",
+	time:          1111111109,
+	code:          "081804",
+	expectCounter: 37037036,
+	expectOK:      true,
+}}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range validateTests {
+		c.Run(test.about, func(c *qt.C) {
+			counter, ok := totp.Validate(rfcSecret, test.code, time.Unix(test.time, 0), test.last)
+			c.Assert(ok, qt.Equals, test.expectOK)
+			c.Assert(counter, qt.Equals, test.expectCounter)
+		})
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(totp.KeyURI("Candid", "bob@example", rfcSecret), qt.Equals, "otpauth://totp/Candid:bob@example?issuer=Candid&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+}
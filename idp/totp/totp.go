@@ -0,0 +1,476 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package totp is an identity provider that adds a time-based one-time
+// password (TOTP) second factor to another interactive identity
+// provider.
+package totp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/idp/idputil/secret"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// loginTimeout is the time a user has to enter a verification
+	// code after logging in with the wrapped identity provider.
+	loginTimeout = 10 * time.Minute
+
+	// maxAttempts is the maximum number of incorrect verification
+	// codes that may be entered for a single login.
+	maxAttempts = 5
+
+	// maxUserAttempts is the maximum number of incorrect verification
+	// codes that may be entered for a user, across all their logins,
+	// before verification is refused for userAttemptsPeriod.
+	maxUserAttempts = 10
+
+	// userAttemptsPeriod is the time after the last incorrect
+	// verification code for a user after which their count of
+	// incorrect codes is forgotten.
+	userAttemptsPeriod = time.Hour
+
+	// credentialPrefix is the prefix of the key-value store keys
+	// that hold users' TOTP credentials.
+	credentialPrefix = "totp#"
+
+	// attemptsPrefix is the prefix of the key-value store keys that
+	// count failed verification attempts for a login.
+	attemptsPrefix = "totp-attempts#"
+
+	// userAttemptsPrefix is the prefix of the key-value store keys
+	// that count failed verification attempts for a user.
+	userAttemptsPrefix = "totp-user-attempts#"
+)
+
+func init() {
+	idp.Register("totp", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal totp parameters")
+		}
+		if p.IdentityProvider.IdentityProvider == nil {
+			return nil, errgo.Newf("identity-provider not specified")
+		}
+		if !p.IdentityProvider.Interactive() {
+			return nil, errgo.Newf("identity provider %q is not interactive", p.IdentityProvider.Name())
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+// Params holds the parameters for a totp identity provider.
+type Params struct {
+	// Issuer is the name that authenticator applications will show
+	// for the account. If this is not set then "Candid" will be
+	// used.
+	Issuer string `yaml:"issuer"`
+
+	// IdentityProvider is the identity provider that is used to
+	// perform the initial login. It must be interactive.
+	IdentityProvider idp.Config `yaml:"identity-provider"`
+}
+
+// NewIdentityProvider creates a new identity provider that requires a
+// TOTP verification code after a successful login with the identity
+// provider in the given parameters. The returned identity provider
+// takes its name, domain and description from the wrapped identity
+// provider.
+func NewIdentityProvider(p Params) idp.IdentityProvider {
+	if p.Issuer == "" {
+		p.Issuer = "Candid"
+	}
+	remover, _ := p.IdentityProvider.IdentityProvider.(idp.IdentityDataRemover)
+	return &identityProvider{
+		IdentityProvider: p.IdentityProvider.IdentityProvider,
+		params:           p,
+		remover:          remover,
+	}
+}
+
+type identityProvider struct {
+	idp.IdentityProvider
+	params     Params
+	initParams idp.InitParams
+	codec      *secret.Codec
+
+	// remover holds the wrapped identity provider if it keeps
+	// data about its identities, or nil otherwise.
+	remover idp.IdentityDataRemover
+}
+
+// Init implements idp.IdentityProvider.Init by initializing the wrapped
+// identity provider such that successful logins are diverted to
+// TOTP verification.
+func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	idp.codec = secret.NewCodec(params.Key)
+	params.VisitCompleter = &visitCompleter{
+		VisitCompleter: idp.initParams.VisitCompleter,
+		idp:            idp,
+	}
+	return errgo.Mask(idp.IdentityProvider.Init(ctx, params))
+}
+
+// RemoveIdentityData implements idp.IdentityDataRemover by removing the
+// TOTP credential and attempt count for the given identity, along with
+// any data held by the wrapped identity provider.
+func (idp *identityProvider) RemoveIdentityData(ctx context.Context, id *store.Identity) error {
+	// The key-value store cannot delete keys, so the values are
+	// replaced with ones that are equivalent to no value and that
+	// expire immediately.
+	now := time.Now()
+	if err := idp.initParams.KeyValueStore.Set(ctx, credentialPrefix+string(id.ProviderID), nil, now); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := idp.initParams.KeyValueStore.Set(ctx, userAttemptsPrefix+string(id.ProviderID), []byte("0"), now); err != nil {
+		return errgo.Mask(err)
+	}
+	if idp.remover == nil {
+		return nil
+	}
+	return errgo.Mask(idp.remover.RemoveIdentityData(ctx, id))
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/totp" {
+		idp.IdentityProvider.Handle(ctx, w, req)
+		return
+	}
+	var ls loginState
+	if err := idp.codec.Decode(req.Form.Get("state"), &ls); err != nil {
+		idp.initParams.VisitCompleter.Failure(ctx, w, req, "", errgo.WithCausef(err, params.ErrBadRequest, "invalid login state"))
+		return
+	}
+	if err := idp.verify(ctx, w, req, &ls); err != nil {
+		idp.failure(ctx, w, req, &ls, err)
+	}
+}
+
+// A loginState holds the state of a login that is waiting for TOTP
+// verification.
+type loginState struct {
+	// ID uniquely identifies the login attempt.
+	ID string
+
+	// DischargeID holds the discharge ID passed to
+	// VisitCompleter.Success, if Redirect is false.
+	DischargeID string
+
+	// Redirect holds whether the login is a redirect-based login.
+	Redirect bool
+
+	// ReturnTo and State hold the parameters passed to
+	// VisitCompleter.RedirectSuccess, if Redirect is true.
+	ReturnTo string
+	State    string
+
+	// ProviderID holds the provider ID of the identity that has
+	// logged in.
+	ProviderID store.ProviderIdentity
+
+	// Username holds the username of the identity that has logged
+	// in.
+	Username string
+
+	// Secret holds a newly generated secret if the user is
+	// enrolling.
+	Secret []byte `json:",omitempty"`
+
+	// Expires holds the time after which the login is no longer
+	// valid.
+	Expires time.Time
+}
+
+// credential holds the TOTP credential for a user.
+type credential struct {
+	// Secret holds the shared secret.
+	Secret []byte
+
+	// Counter holds the time step of the last code that was
+	// accepted.
+	Counter uint64
+}
+
+// formParams holds the parameters passed to the totp template.
+type formParams struct {
+	// Action holds the URL to which the form should be posted.
+	Action string
+
+	// State holds the encoded login state.
+	State string
+
+	// Enrol holds whether the user is enrolling a new credential.
+	Enrol bool
+
+	// Secret holds the base32 encoded secret when enrolling.
+	Secret string
+
+	// KeyURI holds an otpauth URI containing the secret when
+	// enrolling.
+	KeyURI template.URL
+
+	// Error holds an error message if a verification code was not
+	// accepted.
+	Error string
+}
+
+// start starts TOTP verification for a user that has successfully
+// logged in with the wrapped identity provider.
+func (idp *identityProvider) start(ctx context.Context, w http.ResponseWriter, req *http.Request, ls *loginState, id *store.Identity) error {
+	ls.ProviderID = id.ProviderID
+	ls.Username = id.Username
+	if ls.Username == "" {
+		ls.Username = string(id.ProviderID)
+	}
+	ls.Expires = time.Now().Add(loginTimeout)
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return errgo.Mask(err)
+	}
+	ls.ID = hex.EncodeToString(buf[:])
+	cred, err := idp.initParams.KeyValueStore.Get(ctx, credentialPrefix+string(ls.ProviderID))
+	if err == nil && len(cred) == 0 {
+		// The credential has been removed.
+		err = simplekv.ErrNotFound
+	}
+	switch errgo.Cause(err) {
+	case nil:
+	case simplekv.ErrNotFound:
+		ls.Secret, err = newSecret()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	default:
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(idp.form(ctx, w, ls, ""))
+}
+
+// form writes the verification form for the given login state.
+func (idp *identityProvider) form(ctx context.Context, w http.ResponseWriter, ls *loginState, errMsg string) error {
+	state, err := idp.codec.Encode(ls)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	fp := formParams{
+		Action: idp.initParams.URLPrefix + "/totp",
+		State:  state,
+		Error:  errMsg,
+	}
+	if ls.Secret != nil {
+		fp.Enrol = true
+		fp.Secret = secretEncoding.EncodeToString(ls.Secret)
+		fp.KeyURI = template.URL(keyURI(idp.params.Issuer, ls.Username, ls.Secret))
+	}
+	t := idp.initParams.Template.Lookup("totp")
+	if t == nil {
+		return errgo.New("totp template not found")
+	}
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if err := t.Execute(w, fp); err != nil {
+		return errgo.Notef(err, "cannot process totp template")
+	}
+	return nil
+}
+
+var (
+	errInvalidCode       = errgo.New("invalid verification code")
+	errTooManyAttempts   = errgo.WithCausef(nil, params.ErrUnauthorized, "too many failed verification attempts")
+	errAttemptsExhausted = errgo.New("attempts exhausted")
+)
+
+// verify checks the verification code sent in the given request and
+// completes the login if it is correct.
+func (idp *identityProvider) verify(ctx context.Context, w http.ResponseWriter, req *http.Request, ls *loginState) error {
+	if time.Now().After(ls.Expires) {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "login expired")
+	}
+	last, err := idp.countAttempt(ctx, ls)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+	}
+	code := req.Form.Get("code")
+	if ls.Secret != nil {
+		err = idp.enrol(ctx, ls, code)
+	} else {
+		err = idp.check(ctx, ls, code)
+	}
+	if errgo.Cause(err) == errInvalidCode {
+		if last {
+			return errTooManyAttempts
+		}
+		return errgo.Mask(idp.form(ctx, w, ls, err.Error()))
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := idp.resetUserAttempts(ctx, ls); err != nil {
+		return errgo.Mask(err)
+	}
+	id := store.Identity{
+		ProviderID: ls.ProviderID,
+	}
+	if err := idp.initParams.Store.Identity(ctx, &id); err != nil {
+		return errgo.Mask(err)
+	}
+	ctx = idputil.ContextWithMFA(ctx)
+	if ls.Redirect {
+		idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, &id)
+	} else {
+		idp.initParams.VisitCompleter.Success(ctx, w, req, ls.DischargeID, &id)
+	}
+	return nil
+}
+
+// enrol stores the new secret in the given login state as the user's
+// credential if the given code is valid for it.
+func (idp *identityProvider) enrol(ctx context.Context, ls *loginState, code string) error {
+	c, ok := validate(ls.Secret, code, time.Now(), 0)
+	if !ok {
+		return errInvalidCode
+	}
+	return idp.initParams.KeyValueStore.Update(ctx, credentialPrefix+string(ls.ProviderID), time.Time{}, func(old []byte) ([]byte, error) {
+		if len(old) != 0 {
+			return nil, errgo.Newf("user %q already enrolled", ls.Username)
+		}
+		return idp.encodeCredential(credential{
+			Secret:  ls.Secret,
+			Counter: c,
+		})
+	})
+}
+
+// check checks that the given code is valid for the user's stored
+// credential.
+func (idp *identityProvider) check(ctx context.Context, ls *loginState, code string) error {
+	return idp.initParams.KeyValueStore.Update(ctx, credentialPrefix+string(ls.ProviderID), time.Time{}, func(old []byte) ([]byte, error) {
+		if len(old) == 0 {
+			return nil, errgo.Newf("user %q not enrolled", ls.Username)
+		}
+		var cred credential
+		if err := idp.codec.Decode(string(old), &cred); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		c, ok := validate(cred.Secret, code, time.Now(), cred.Counter)
+		if !ok {
+			return nil, errInvalidCode
+		}
+		cred.Counter = c
+		return idp.encodeCredential(cred)
+	})
+}
+
+func (idp *identityProvider) encodeCredential(cred credential) ([]byte, error) {
+	s, err := idp.codec.Encode(cred)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return []byte(s), nil
+}
+
+// countAttempt records an attempt to verify a code for the given
+// login, both for the login and for the user. This is done before the
+// code is checked so that concurrent attempts cannot exceed the limits.
+// If there have already been too many failed attempts an error with a
+// cause of params.ErrUnauthorized is returned. Otherwise countAttempt
+// returns whether this is the last attempt allowed for the login.
+func (idp *identityProvider) countAttempt(ctx context.Context, ls *loginState) (last bool, _ error) {
+	n, err := idp.incrementAttempts(ctx, attemptsPrefix+ls.ID, maxAttempts, ls.Expires)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+	}
+	if _, err := idp.incrementAttempts(ctx, userAttemptsPrefix+string(ls.ProviderID), maxUserAttempts, time.Now().Add(userAttemptsPeriod)); err != nil {
+		return false, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+	}
+	return n >= maxAttempts, nil
+}
+
+// incrementAttempts increments the count of attempts held in the
+// given key and returns the new count. If the count has already reached
+// max then it is not incremented and an error with a cause of
+// params.ErrUnauthorized is returned.
+func (idp *identityProvider) incrementAttempts(ctx context.Context, key string, max int, expire time.Time) (int, error) {
+	var n int
+	err := idp.initParams.KeyValueStore.Update(ctx, key, expire, func(old []byte) ([]byte, error) {
+		n = 0
+		if old != nil {
+			var err error
+			if n, err = strconv.Atoi(string(old)); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		if n >= max {
+			return nil, errAttemptsExhausted
+		}
+		n++
+		return []byte(strconv.Itoa(n)), nil
+	})
+	if errgo.Cause(err) == errAttemptsExhausted {
+		return n, errTooManyAttempts
+	}
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return n, nil
+}
+
+// resetUserAttempts clears the count of attempts for the user in the
+// given login after a code has been accepted.
+func (idp *identityProvider) resetUserAttempts(ctx context.Context, ls *loginState) error {
+	return errgo.Mask(idp.initParams.KeyValueStore.Set(ctx, userAttemptsPrefix+string(ls.ProviderID), []byte("0"), time.Now().Add(userAttemptsPeriod)))
+}
+
+// failure fails the given login with the given error.
+func (idp *identityProvider) failure(ctx context.Context, w http.ResponseWriter, req *http.Request, ls *loginState, err error) {
+	if ls.Redirect {
+		idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+	} else {
+		idp.initParams.VisitCompleter.Failure(ctx, w, req, ls.DischargeID, err)
+	}
+}
+
+// visitCompleter is the idp.VisitCompleter given to the wrapped
+// identity provider. Successful logins are diverted to TOTP
+// verification, failures are passed straight through.
+type visitCompleter struct {
+	idp.VisitCompleter
+	idp *identityProvider
+}
+
+// Success implements idp.VisitCompleter.Success.
+func (c *visitCompleter) Success(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, id *store.Identity) {
+	ls := loginState{
+		DischargeID: dischargeID,
+	}
+	if err := c.idp.start(ctx, w, req, &ls, id); err != nil {
+		c.Failure(ctx, w, req, dischargeID, err)
+	}
+}
+
+// RedirectSuccess implements idp.VisitCompleter.RedirectSuccess.
+func (c *visitCompleter) RedirectSuccess(ctx context.Context, w http.ResponseWriter, req *http.Request, returnTo, state string, id *store.Identity) {
+	ls := loginState{
+		Redirect: true,
+		ReturnTo: returnTo,
+		State:    state,
+	}
+	if err := c.idp.start(ctx, w, req, &ls, id); err != nil {
+		c.RedirectFailure(ctx, w, req, returnTo, state, err)
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp_test
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp"
+	_ "github.com/CanonicalLtd/candid/idp/agent"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/static"
+	"github.com/CanonicalLtd/candid/idp/totp"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var configTests = []struct {
+	about       string
+	yaml        string
+	expectError string
+}{{
+	about: "good config",
+	yaml: `
+identity-providers:
+ - type: totp
+   issuer: Example
+   identity-provider:
+     type: static
+     name: test
+     domain: example
+`,
+}, {
+	about: "no identity provider",
+	yaml: `
+identity-providers:
+ - type: totp
+`,
+	expectError: `cannot unmarshal totp configuration: identity-provider not specified`,
+}, {
+	about: "non-interactive identity provider",
+	yaml: `
+identity-providers:
+ - type: totp
+   identity-provider:
+     type: agent
+`,
+	expectError: `cannot unmarshal totp configuration: identity provider "agent" is not interactive`,
+}, {
+	about: "invalid identity provider",
+	yaml: `
+identity-providers:
+ - type: totp
+   identity-provider:
+     type: nonexistent
+`,
+	expectError: `cannot unmarshal totp configuration: cannot unmarshal totp parameters: unrecognised identity provider type "nonexistent"`,
+}}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range configTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "test")
+			c.Assert(conf.IdentityProviders[0].Domain(), qt.Equals, "example")
+			c.Assert(conf.IdentityProviders[0].Interactive(), qt.Equals, true)
+		})
+	}
+}
+
+type totpSuite struct {
+	store   *candidtest.Store
+	idptest *idptest.Fixture
+}
+
+func TestTOTP(t *testing.T) {
+	qtsuite.Run(qt.New(t), &totpSuite{})
+}
+
+func (s *totpSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.idptest = idptest.NewFixture(c, s.store)
+}
+
+func (s *totpSuite) setupIdp(c *qt.C, f *idptest.Fixture) idp.IdentityProvider {
+	i := totp.NewIdentityProvider(totp.Params{
+		IdentityProvider: idp.Config{
+			IdentityProvider: static.NewIdentityProvider(static.Params{
+				Name:   "test",
+				Domain: "example",
+				Users: map[string]static.UserInfo{
+					"bob": {
+						Password: "pass",
+						Name:     "Bob Robertson",
+					},
+				},
+			}),
+		},
+	})
+	initParams := f.InitParams(c, "https://candid.example.com/login/test")
+	// Use the same key for every fixture, as a real server would, so
+	// that stored credentials can be decrypted.
+	initParams.Key = s.idptest.Oven.Key()
+	initParams.Template = template.Must(template.New("totp").Parse("{{.Action}}|{{.State}}|{{.Secret}}|{{.Error}}"))
+	err := i.Init(context.Background(), initParams)
+	c.Assert(err, qt.Equals, nil)
+	return i
+}
+
+func (s *totpSuite) TestEnrolAndVerify(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	form := s.login(c, i)
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(form.action, qt.Equals, "https://candid.example.com/login/test/totp")
+	c.Assert(form.secret, qt.Not(qt.Equals), "")
+
+	code, err := totp.Code(form.secret, time.Now())
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form.state, code)
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+	s.idptest.AssertLoginMFA(c)
+
+	// Log in again with a new fixture; the user is already enrolled
+	// so no secret is shown, and the code that has already been used
+	// is not accepted again.
+	f := idptest.NewFixture(c, s.store)
+	i = s.setupIdp(c, f)
+	form2 := s.login(c, i)
+	f.AssertLoginNotComplete(c)
+	c.Assert(form2.secret, qt.Equals, "")
+	form3 := s.verify(c, i, form2.state, code)
+	f.AssertLoginNotComplete(c)
+	c.Assert(form3.err, qt.Equals, "invalid verification code")
+
+	code, err = totp.Code(form.secret, time.Now().Add(30*time.Second))
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form3.state, code)
+	f.AssertLoginSuccess(c, "bob@example")
+}
+
+func (s *totpSuite) TestEnrolInvalidCode(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	form := s.login(c, i)
+	form2 := s.verify(c, i, form.state, "000000x")
+	s.idptest.AssertLoginNotComplete(c)
+	c.Assert(form2.err, qt.Equals, "invalid verification code")
+	c.Assert(form2.secret, qt.Equals, form.secret)
+}
+
+func (s *totpSuite) TestTooManyAttempts(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	form := s.login(c, i)
+	for n := 1; n < totp.MaxAttempts; n++ {
+		form = s.verify(c, i, form.state, "bad")
+		s.idptest.AssertLoginNotComplete(c)
+	}
+	s.verify(c, i, form.state, "bad")
+	s.idptest.AssertLoginFailureMatches(c, `too many failed verification attempts`)
+}
+
+func (s *totpSuite) TestCorrectCodeAfterTooManyAttempts(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	form := s.login(c, i)
+	for n := 1; n < totp.MaxAttempts; n++ {
+		s.verify(c, i, form.state, "bad")
+		s.idptest.AssertLoginNotComplete(c)
+	}
+	s.verify(c, i, form.state, "bad")
+	s.idptest.AssertLoginFailureMatches(c, `too many failed verification attempts`)
+
+	// Reusing the login state with a correct code must not log
+	// the user in.
+	f := idptest.NewFixture(c, s.store)
+	i = s.setupIdp(c, f)
+	code, err := totp.Code(form.secret, time.Now())
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form.state, code)
+	f.AssertLoginFailureMatches(c, `too many failed verification attempts`)
+}
+
+func (s *totpSuite) TestTooManyUserAttempts(c *qt.C) {
+	// Restarting the login does not give the user more attempts
+	// than maxUserAttempts.
+	for n := 0; n < totp.MaxUserAttempts; n += totp.MaxAttempts {
+		f := idptest.NewFixture(c, s.store)
+		i := s.setupIdp(c, f)
+		form := s.login(c, i)
+		for m := 0; m < totp.MaxAttempts; m++ {
+			form = s.verify(c, i, form.state, "bad")
+		}
+		f.AssertLoginFailureMatches(c, `too many failed verification attempts`)
+	}
+	f := idptest.NewFixture(c, s.store)
+	i := s.setupIdp(c, f)
+	form := s.login(c, i)
+	f.AssertLoginNotComplete(c)
+	code, err := totp.Code(form.secret, time.Now())
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form.state, code)
+	f.AssertLoginFailureMatches(c, `too many failed verification attempts`)
+}
+
+func (s *totpSuite) TestRemoveIdentityData(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	form := s.login(c, i)
+	code, err := totp.Code(form.secret, time.Now())
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form.state, code)
+	s.idptest.AssertLoginSuccess(c, "bob@example")
+
+	id := store.Identity{Username: "bob@example"}
+	err = s.store.Store.Identity(context.Background(), &id)
+	c.Assert(err, qt.Equals, nil)
+	err = i.(idp.IdentityDataRemover).RemoveIdentityData(context.Background(), &id)
+	c.Assert(err, qt.Equals, nil)
+
+	// The credential has been removed, so the user must enrol
+	// again.
+	f := idptest.NewFixture(c, s.store)
+	i = s.setupIdp(c, f)
+	form = s.login(c, i)
+	c.Assert(form.secret, qt.Not(qt.Equals), "")
+	code, err = totp.Code(form.secret, time.Now())
+	c.Assert(err, qt.Equals, nil)
+	s.verify(c, i, form.state, code)
+	f.AssertLoginSuccess(c, "bob@example")
+}
+
+func (s *totpSuite) TestInvalidState(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	s.verify(c, i, "bad-state", "123456")
+	s.idptest.AssertLoginFailureMatches(c, `invalid login state: .*`)
+}
+
+func (s *totpSuite) TestInnerLoginFailure(c *qt.C) {
+	i := s.setupIdp(c, s.idptest)
+	s.do(c, i, "/login", url.Values{
+		"username": {"bob"},
+		"password": {"wrong"},
+	})
+	s.idptest.AssertLoginFailureMatches(c, `authentication failed for user "bob"`)
+}
+
+type totpForm struct {
+	action string
+	state  string
+	secret string
+	err    string
+}
+
+// login logs in to the wrapped identity provider and returns the
+// TOTP form that is displayed.
+func (s *totpSuite) login(c *qt.C, i idp.IdentityProvider) totpForm {
+	return s.do(c, i, "/login", url.Values{
+		"id":       {"1"},
+		"username": {"bob"},
+		"password": {"pass"},
+	})
+}
+
+// verify posts the given code with the given state.
+func (s *totpSuite) verify(c *qt.C, i idp.IdentityProvider, state, code string) totpForm {
+	return s.do(c, i, "/totp", url.Values{
+		"state": {state},
+		"code":  {code},
+	})
+}
+
+func (s *totpSuite) do(c *qt.C, i idp.IdentityProvider, path string, form url.Values) totpForm {
+	req, err := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	i.Handle(context.Background(), rr, req)
+	parts := strings.Split(rr.Body.String(), "|")
+	if len(parts) != 4 {
+		return totpForm{}
+	}
+	return totpForm{
+		action: parts[0],
+		state:  parts[1],
+		secret: parts[2],
+		err:    parts[3],
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/CanonicalLtd/candid/idp/idputil"
 	"github.com/CanonicalLtd/candid/idp/idputil/secret"
 	"github.com/CanonicalLtd/candid/idp/usso/internal/kvnoncestore"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -234,11 +235,13 @@ func (idp *identityProvider) callback(ctx context.Context, w http.ResponseWriter
 
 // GetGroups implements idp.IdentityProvider.GetGroups by fetching group
 // information from launchpad.
-func (idp *identityProvider) GetGroups(_ context.Context, id *store.Identity) ([]string, error) {
+func (idp *identityProvider) GetGroups(ctx context.Context, id *store.Identity) ([]string, error) {
 	_, ussoID := id.ProviderID.Split()
 	groups0, err := idp.groupCache.Get(ussoID, func() (interface{}, error) {
 		t := time.Now()
+		_, upstream := monitoring.StartIDPRequest(ctx, idp.Name(), "launchpad-groups")
 		groups, err := idp.getLaunchpadGroupsNoCache(ussoID)
+		upstream.End(err)
 		idp.groupMonitor.Observe(float64(time.Since(t)) / float64(time.Microsecond))
 		return groups, err
 	})
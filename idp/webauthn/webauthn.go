@@ -0,0 +1,626 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package webauthn is an identity provider that authenticates users
+// with WebAuthn (FIDO2) credentials, such as security keys, that have
+// been registered against their existing identities.
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	gowebauthn "github.com/duo-labs/webauthn/webauthn"
+	"github.com/juju/simplekv"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// credentialsKey is the ProviderInfo key under which WebAuthn
+	// credentials are stored in an identity.
+	credentialsKey = "webauthn-credentials"
+
+	// sessionTimeout is the length of time for which a registration
+	// or login ceremony remains valid.
+	sessionTimeout = 5 * time.Minute
+)
+
+func init() {
+	idp.Register("webauthn", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal webauthn parameters")
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+// Params holds the parameters for a webauthn identity provider.
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	// If this is not set then "webauthn" will be used.
+	Name string `yaml:"name"`
+
+	// Description is the description that will be used with the
+	// identity provider. If this is not set then "Security Key" will
+	// be used.
+	Description string `yaml:"description"`
+
+	// RelyingPartyID is the WebAuthn relying party ID. If this is not
+	// set then the host name of the candid server will be used.
+	// Changing the relying party ID will invalidate all registered
+	// credentials.
+	RelyingPartyID string `yaml:"relying-party-id"`
+
+	// RelyingPartyName is the name of the relying party that will be
+	// shown to users by their browsers. If this is not set then
+	// "Candid" will be used.
+	RelyingPartyName string `yaml:"relying-party-name"`
+}
+
+// NewIdentityProvider creates a new webauthn identity provider.
+func NewIdentityProvider(p Params) idp.IdentityProvider {
+	if p.Name == "" {
+		p.Name = "webauthn"
+	}
+	if p.Description == "" {
+		p.Description = "Security Key"
+	}
+	if p.RelyingPartyName == "" {
+		p.RelyingPartyName = "Candid"
+	}
+	return &identityProvider{
+		params: p,
+	}
+}
+
+type identityProvider struct {
+	params     Params
+	initParams idp.InitParams
+	webauthn   *gowebauthn.WebAuthn
+}
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *identityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain. The webauthn identity
+// provider does not create identities so it has no domain.
+func (idp *identityProvider) Domain() string {
+	return ""
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *identityProvider) Description() string {
+	return idp.params.Description
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*identityProvider) Interactive() bool {
+	return true
+}
+
+// Init implements idp.IdentityProvider.Init.
+func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	u, err := url.Parse(params.URLPrefix)
+	if err != nil {
+		return errgo.Notef(err, "cannot parse URL prefix")
+	}
+	rpID := idp.params.RelyingPartyID
+	if rpID == "" {
+		rpID = u.Hostname()
+	}
+	idp.webauthn, err = gowebauthn.New(&gowebauthn.Config{
+		RPDisplayName: idp.params.RelyingPartyName,
+		RPID:          rpID,
+		RPOrigin:      u.Scheme + "://" + u.Host,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *identityProvider) URL(dischargeID string) string {
+	return idputil.URL(idp.initParams.URLPrefix, "/login", dischargeID)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *identityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups. The webauthn
+// identity provider does not store any groups.
+func (*identityProvider) GetGroups(context.Context, *store.Identity) ([]string, error) {
+	return nil, nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/login":
+		if err := idp.handleLogin(ctx, w, req); err != nil {
+			idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), err)
+		}
+	case "/login/begin":
+		resp, err := idp.beginLogin(ctx, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		httprequest.WriteJSON(w, http.StatusOK, resp)
+	default:
+		writeError(w, errgo.WithCausef(nil, params.ErrNotFound, "path %q not found", req.URL.Path))
+	}
+}
+
+// loginParams holds the parameters passed to the webauthn-login
+// template.
+type loginParams struct {
+	// Action holds the URL to which the completed login form should
+	// be posted.
+	Action string
+
+	// BeginURL holds the URL used to start the login ceremony.
+	BeginURL string
+}
+
+func (idp *identityProvider) handleLogin(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	default:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	case "GET":
+		t := idp.initParams.Template.Lookup("webauthn-login")
+		if t == nil {
+			return errgo.New("webauthn-login template not found")
+		}
+		w.Header().Set("Content-Type", "text/html;charset=utf-8")
+		if err := t.Execute(w, loginParams{
+			Action:   idp.URL(idputil.DischargeID(req)),
+			BeginURL: idp.initParams.URLPrefix + "/login/begin",
+		}); err != nil {
+			return errgo.Notef(err, "cannot process webauthn-login template")
+		}
+		return nil
+	case "POST":
+		id, err := idp.finishLogin(ctx, req.Form.Get("session"), []byte(req.Form.Get("credential")))
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrUnauthorized))
+		}
+		idp.initParams.VisitCompleter.Success(ctx, w, req, idputil.DischargeID(req), id)
+		return nil
+	}
+}
+
+// beginLoginRequest holds the body of a request to begin a login
+// ceremony.
+type beginLoginRequest struct {
+	Username string `json:"username"`
+}
+
+// beginLoginResponse holds the response to a request to begin a login
+// ceremony.
+type beginLoginResponse struct {
+	// SessionID holds the ID of the login session, this must be
+	// posted along with the credential to complete the login.
+	SessionID string `json:"session_id"`
+
+	// Options holds the options to pass to
+	// navigator.credentials.get.
+	Options *protocol.CredentialAssertion `json:"options"`
+}
+
+func (idp *identityProvider) beginLogin(ctx context.Context, req *http.Request) (*beginLoginResponse, error) {
+	if req.Method != "POST" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	}
+	var blr beginLoginRequest
+	if err := json.NewDecoder(req.Body).Decode(&blr); err != nil {
+		return nil, errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal request")
+	}
+	id := store.Identity{
+		Username: blr.Username,
+	}
+	err := idp.initParams.Store.Identity(ctx, &id)
+	if err != nil && errgo.Cause(err) != store.ErrNotFound {
+		return nil, errgo.Mask(err)
+	}
+	u, err := newUser(&id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(u.credentials) == 0 {
+		// Don't distinguish between users that don't exist and
+		// users without credentials.
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "no credentials registered for user %q", blr.Username)
+	}
+	assertion, session, err := idp.webauthn.BeginLogin(u)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sessionID, err := idp.newSession(ctx, "login", &sessionState{
+		Session:    *session,
+		ProviderID: id.ProviderID,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &beginLoginResponse{
+		SessionID: sessionID,
+		Options:   assertion,
+	}, nil
+}
+
+func (idp *identityProvider) finishLogin(ctx context.Context, sessionID string, credential []byte) (*store.Identity, error) {
+	st, err := idp.useSession(ctx, "login", sessionID)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	id := store.Identity{
+		ProviderID: st.ProviderID,
+	}
+	if err := idp.initParams.Store.Identity(ctx, &id); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	u, err := newUser(&id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid credential: %s", protocolError(err))
+	}
+	cred, err := idp.webauthn.ValidateLogin(u, st.Session, parsed)
+	if err != nil {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "login failed: %s", protocolError(err))
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "login failed: credential may have been cloned")
+	}
+	for i := range u.credentials {
+		if bytes.Equal(u.credentials[i].ID, cred.ID) {
+			u.credentials[i].SignCount = cred.Authenticator.SignCount
+		}
+	}
+	if err := idp.setCredentials(ctx, &id, u.credentials); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &id, nil
+}
+
+// WebAuthnCredentials returns the WebAuthn credentials registered for
+// the given identity.
+func (idp *identityProvider) WebAuthnCredentials(ctx context.Context, id *store.Identity) ([]candidparams.WebAuthnCredential, error) {
+	creds, err := credentials(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	pcreds := make([]candidparams.WebAuthnCredential, len(creds))
+	for i, cred := range creds {
+		pcreds[i] = cred.params()
+	}
+	return pcreds, nil
+}
+
+// StartWebAuthnRegistration starts the registration of a new WebAuthn
+// credential with the given name for the given identity.
+func (idp *identityProvider) StartWebAuthnRegistration(ctx context.Context, id *store.Identity, name string) (*candidparams.StartWebAuthnRegistrationResponse, error) {
+	u, err := newUser(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	exclusions := make([]protocol.CredentialDescriptor, len(u.credentials))
+	for i, cred := range u.credentials {
+		exclusions[i] = protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: cred.ID,
+		}
+	}
+	creation, session, err := idp.webauthn.BeginRegistration(u, gowebauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	options, err := json.Marshal(creation)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sessionID, err := idp.newSession(ctx, "registration", &sessionState{
+		Session:    *session,
+		ProviderID: id.ProviderID,
+		Name:       name,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &candidparams.StartWebAuthnRegistrationResponse{
+		SessionID: sessionID,
+		Options:   options,
+	}, nil
+}
+
+// CompleteWebAuthnRegistration completes the registration of a WebAuthn
+// credential for the given identity using the given JSON encoded
+// PublicKeyCredential.
+func (idp *identityProvider) CompleteWebAuthnRegistration(ctx context.Context, id *store.Identity, sessionID string, credential []byte) (*candidparams.WebAuthnCredential, error) {
+	st, err := idp.useSession(ctx, "registration", sessionID)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if st.ProviderID != id.ProviderID {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "registration session is for a different user")
+	}
+	u, err := newUser(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid credential: %s", protocolError(err))
+	}
+	cred, err := idp.webauthn.CreateCredential(u, st.Session, parsed)
+	if err != nil {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "cannot register credential: %s", protocolError(err))
+	}
+	c := storedCredential{
+		ID:              cred.ID,
+		Name:            st.Name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Created:         time.Now().UTC().Round(time.Millisecond),
+	}
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: id.ProviderID,
+		ProviderInfo: map[string][]string{
+			credentialsKey: {string(buf)},
+		},
+	}, store.Update{
+		store.ProviderInfo: store.Push,
+	}); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	pc := c.params()
+	return &pc, nil
+}
+
+// RemoveWebAuthnCredential removes the WebAuthn credential with the
+// given ID from the given identity. If there is no such credential
+// then an error with a cause of params.ErrNotFound is returned.
+func (idp *identityProvider) RemoveWebAuthnCredential(ctx context.Context, id *store.Identity, credentialID string) error {
+	for _, v := range id.ProviderInfo[credentialsKey] {
+		var cred storedCredential
+		if err := json.Unmarshal([]byte(v), &cred); err != nil {
+			return errgo.Notef(err, "cannot unmarshal credential")
+		}
+		if cred.params().ID != credentialID {
+			continue
+		}
+		return errgo.Mask(idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+			ProviderID: id.ProviderID,
+			ProviderInfo: map[string][]string{
+				credentialsKey: {v},
+			},
+		}, store.Update{
+			store.ProviderInfo: store.Pull,
+		}))
+	}
+	return errgo.WithCausef(nil, params.ErrNotFound, "credential %q not found", credentialID)
+}
+
+// setCredentials replaces the credentials stored for the given
+// identity.
+func (idp *identityProvider) setCredentials(ctx context.Context, id *store.Identity, creds []storedCredential) error {
+	vs := make([]string, len(creds))
+	for i, cred := range creds {
+		buf, err := json.Marshal(cred)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		vs[i] = string(buf)
+	}
+	return errgo.Mask(idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: id.ProviderID,
+		ProviderInfo: map[string][]string{
+			credentialsKey: vs,
+		},
+	}, store.Update{
+		store.ProviderInfo: store.Set,
+	}))
+}
+
+// sessionState holds the state of a registration or login ceremony
+// in the key-value store.
+type sessionState struct {
+	Session    gowebauthn.SessionData
+	ProviderID store.ProviderIdentity
+	Name       string `json:",omitempty"`
+	Used       bool   `json:",omitempty"`
+}
+
+// newSession stores the given state for a new ceremony of the given
+// kind and returns its ID.
+func (idp *identityProvider) newSession(ctx context.Context, kind string, st *sessionState) (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", errgo.Mask(err)
+	}
+	sessionID := hex.EncodeToString(buf[:])
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if err := idp.initParams.KeyValueStore.Set(ctx, kind+"#"+sessionID, data, time.Now().Add(sessionTimeout)); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return sessionID, nil
+}
+
+// useSession retrieves the state for the ceremony of the given kind
+// with the given ID and marks it as used so that it cannot be
+// completed more than once.
+func (idp *identityProvider) useSession(ctx context.Context, kind, sessionID string) (*sessionState, error) {
+	var st sessionState
+	err := idp.initParams.KeyValueStore.Update(ctx, kind+"#"+sessionID, time.Now().Add(sessionTimeout), func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%s session not found", kind)
+		}
+		st = sessionState{}
+		if err := json.Unmarshal(old, &st); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if st.Used {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%s session already used", kind)
+		}
+		st.Used = true
+		return json.Marshal(st)
+	})
+	if errgo.Cause(err) == simplekv.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%s session not found", kind)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return &st, nil
+}
+
+// storedCredential is the form in which a WebAuthn credential is
+// stored in an identity's ProviderInfo.
+type storedCredential struct {
+	ID              []byte    `json:"id"`
+	Name            string    `json:"name,omitempty"`
+	PublicKey       []byte    `json:"public-key"`
+	AttestationType string    `json:"attestation-type,omitempty"`
+	AAGUID          []byte    `json:"aaguid,omitempty"`
+	SignCount       uint32    `json:"sign-count"`
+	Created         time.Time `json:"created"`
+}
+
+func (c storedCredential) params() candidparams.WebAuthnCredential {
+	return candidparams.WebAuthnCredential{
+		ID:      base64.RawURLEncoding.EncodeToString(c.ID),
+		Name:    c.Name,
+		Created: c.Created,
+	}
+}
+
+// credentials returns the WebAuthn credentials stored in the given
+// identity.
+func credentials(id *store.Identity) ([]storedCredential, error) {
+	vs := id.ProviderInfo[credentialsKey]
+	creds := make([]storedCredential, len(vs))
+	for i, v := range vs {
+		if err := json.Unmarshal([]byte(v), &creds[i]); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal credential")
+		}
+	}
+	return creds, nil
+}
+
+// user implements the webauthn.User interface for an identity.
+type user struct {
+	identity    *store.Identity
+	credentials []storedCredential
+}
+
+func newUser(id *store.Identity) (*user, error) {
+	creds, err := credentials(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &user{
+		identity:    id,
+		credentials: creds,
+	}, nil
+}
+
+// WebAuthnID implements webauthn.User.WebAuthnID.
+func (u *user) WebAuthnID() []byte {
+	return []byte(u.identity.ID)
+}
+
+// WebAuthnName implements webauthn.User.WebAuthnName.
+func (u *user) WebAuthnName() string {
+	return u.identity.Username
+}
+
+// WebAuthnDisplayName implements webauthn.User.WebAuthnDisplayName.
+func (u *user) WebAuthnDisplayName() string {
+	if u.identity.Name != "" {
+		return u.identity.Name
+	}
+	return u.identity.Username
+}
+
+// WebAuthnIcon implements webauthn.User.WebAuthnIcon.
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials implements webauthn.User.WebAuthnCredentials.
+func (u *user) WebAuthnCredentials() []gowebauthn.Credential {
+	creds := make([]gowebauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		creds[i] = gowebauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator: gowebauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return creds
+}
+
+// protocolError returns a description of an error returned from the
+// WebAuthn protocol implementation.
+func protocolError(err error) string {
+	if perr, ok := err.(*protocol.Error); ok && perr.DevInfo != "" {
+		return perr.Details + ": " + strings.Join(strings.Fields(perr.DevInfo), " ")
+	}
+	return err.Error()
+}
+
+// writeError writes the given error to w as a JSON error response.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code, _ := errgo.Cause(err).(params.ErrorCode)
+	switch code {
+	case params.ErrBadRequest:
+		status = http.StatusBadRequest
+	case params.ErrNotFound:
+		status = http.StatusNotFound
+	case params.ErrUnauthorized:
+		status = http.StatusUnauthorized
+	}
+	httprequest.WriteJSON(w, status, &params.Error{
+		Code:    code,
+		Message: err.Error(),
+	})
+}
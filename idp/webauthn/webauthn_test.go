@@ -0,0 +1,313 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package webauthn_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/config"
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idptest"
+	"github.com/CanonicalLtd/candid/idp/webauthn"
+	"github.com/CanonicalLtd/candid/idp/webauthn/webauthntest"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var configTests = []struct {
+	about       string
+	yaml        string
+	expectName  string
+	expectError string
+}{{
+	about: "default config",
+	yaml: `
+identity-providers:
+ - type: webauthn
+`,
+	expectName: "webauthn",
+}, {
+	about: "named",
+	yaml: `
+identity-providers:
+ - type: webauthn
+   name: keys
+   relying-party-id: example.com
+`,
+	expectName: "keys",
+}, {
+	about: "invalid parameters",
+	yaml: `
+identity-providers:
+ - type: webauthn
+   name: [1]
+`,
+	expectError: `(?s)cannot unmarshal webauthn configuration: cannot unmarshal webauthn parameters: .*`,
+}}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range configTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, test.expectName)
+			c.Assert(conf.IdentityProviders[0].Domain(), qt.Equals, "")
+			c.Assert(conf.IdentityProviders[0].Interactive(), qt.Equals, true)
+		})
+	}
+}
+
+// registrar holds the methods used to manage WebAuthn credentials.
+type registrar interface {
+	WebAuthnCredentials(ctx context.Context, id *store.Identity) ([]candidparams.WebAuthnCredential, error)
+	StartWebAuthnRegistration(ctx context.Context, id *store.Identity, name string) (*candidparams.StartWebAuthnRegistrationResponse, error)
+	CompleteWebAuthnRegistration(ctx context.Context, id *store.Identity, sessionID string, credential []byte) (*candidparams.WebAuthnCredential, error)
+	RemoveWebAuthnCredential(ctx context.Context, id *store.Identity, credentialID string) error
+}
+
+type webauthnSuite struct {
+	idptest *idptest.Fixture
+	idp     idp.IdentityProvider
+	reg     registrar
+	auth    *webauthntest.Authenticator
+}
+
+func TestWebAuthn(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webauthnSuite{})
+}
+
+func (s *webauthnSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	s.idp = webauthn.NewIdentityProvider(webauthn.Params{})
+	initParams := s.idptest.InitParams(c, "https://candid.example.com/login/webauthn")
+	initParams.Template = template.Must(template.New("webauthn-login").Parse("{{.Action}}|{{.BeginURL}}"))
+	err := s.idp.Init(s.idptest.Ctx, initParams)
+	c.Assert(err, qt.Equals, nil)
+	s.reg = s.idp.(registrar)
+	s.auth, err = webauthntest.NewAuthenticator("https://candid.example.com")
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Name:       "Bob Robertson",
+	}, store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *webauthnSuite) TestLoginForm(c *qt.C) {
+	req, err := http.NewRequest("GET", "/login?id=1", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	s.idp.Handle(s.idptest.Ctx, rr, req)
+	c.Assert(rr.Code, qt.Equals, http.StatusOK)
+	c.Assert(rr.Body.String(), qt.Equals, "https://candid.example.com/login/webauthn/login?id=1|https://candid.example.com/login/webauthn/login/begin")
+}
+
+func (s *webauthnSuite) TestRegisterAndLogin(c *qt.C) {
+	cred := s.register(c, "my key")
+	c.Assert(cred.Name, qt.Equals, "my key")
+
+	creds, err := s.reg.WebAuthnCredentials(s.idptest.Ctx, s.identity(c))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(creds, qt.DeepEquals, []candidparams.WebAuthnCredential{*cred})
+
+	sessionID, opts := s.beginLogin(c, "bob")
+	resp, err := s.auth.Get(opts)
+	c.Assert(err, qt.Equals, nil)
+	s.finishLogin(c, sessionID, resp)
+	s.idptest.AssertLoginSuccess(c, "bob")
+}
+
+func (s *webauthnSuite) TestLoginSessionReused(c *qt.C) {
+	s.register(c, "my key")
+	sessionID, opts := s.beginLogin(c, "bob")
+	resp, err := s.auth.Get(opts)
+	c.Assert(err, qt.Equals, nil)
+	s.finishLogin(c, sessionID, resp)
+	s.idptest.AssertLoginSuccess(c, "bob")
+
+	f := idptest.NewFixture(c, s.idptest.Store)
+	s.setCompleter(c, f)
+	s.finishLogin(c, sessionID, resp)
+	f.AssertLoginFailureMatches(c, `login session already used`)
+}
+
+func (s *webauthnSuite) TestLoginReplayedSignature(c *qt.C) {
+	s.register(c, "my key")
+	sessionID, opts := s.beginLogin(c, "bob")
+	resp, err := s.auth.Get(opts)
+	c.Assert(err, qt.Equals, nil)
+	s.finishLogin(c, sessionID, resp)
+	s.idptest.AssertLoginSuccess(c, "bob")
+
+	// A signature made for a different challenge must not be
+	// accepted.
+	sessionID, _ = s.beginLogin(c, "bob")
+	f := idptest.NewFixture(c, s.idptest.Store)
+	s.setCompleter(c, f)
+	s.finishLogin(c, sessionID, resp)
+	f.AssertLoginFailureMatches(c, `login failed: .*`)
+}
+
+func (s *webauthnSuite) TestLoginUnknownSession(c *qt.C) {
+	s.finishLogin(c, "nonexistent", []byte("{}"))
+	s.idptest.AssertLoginFailureMatches(c, `login session not found`)
+}
+
+func (s *webauthnSuite) TestBeginLoginNoCredentials(c *qt.C) {
+	rr := s.doBeginLogin(c, "bob")
+	c.Assert(rr.Code, qt.Equals, http.StatusNotFound)
+	c.Assert(rr.Body.String(), qt.Matches, `.*no credentials registered for user \\"bob\\".*`)
+
+	rr = s.doBeginLogin(c, "nobody")
+	c.Assert(rr.Code, qt.Equals, http.StatusNotFound)
+	c.Assert(rr.Body.String(), qt.Matches, `.*no credentials registered for user \\"nobody\\".*`)
+}
+
+func (s *webauthnSuite) TestRegistrationSessionReused(c *qt.C) {
+	id := s.identity(c)
+	resp, err := s.reg.StartWebAuthnRegistration(s.idptest.Ctx, id, "my key")
+	c.Assert(err, qt.Equals, nil)
+	cred, err := s.auth.Create(resp.Options)
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.reg.CompleteWebAuthnRegistration(s.idptest.Ctx, id, resp.SessionID, cred)
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.reg.CompleteWebAuthnRegistration(s.idptest.Ctx, id, resp.SessionID, cred)
+	c.Assert(err, qt.ErrorMatches, `registration session already used`)
+}
+
+func (s *webauthnSuite) TestRegistrationDifferentUser(c *qt.C) {
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	alice := store.Identity{Username: "alice"}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &alice)
+	c.Assert(err, qt.Equals, nil)
+
+	resp, err := s.reg.StartWebAuthnRegistration(s.idptest.Ctx, s.identity(c), "my key")
+	c.Assert(err, qt.Equals, nil)
+	cred, err := s.auth.Create(resp.Options)
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.reg.CompleteWebAuthnRegistration(s.idptest.Ctx, &alice, resp.SessionID, cred)
+	c.Assert(err, qt.ErrorMatches, `registration session is for a different user`)
+}
+
+func (s *webauthnSuite) TestRegistrationInvalidCredential(c *qt.C) {
+	id := s.identity(c)
+	resp, err := s.reg.StartWebAuthnRegistration(s.idptest.Ctx, id, "my key")
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.reg.CompleteWebAuthnRegistration(s.idptest.Ctx, id, resp.SessionID, []byte("{}"))
+	c.Assert(err, qt.ErrorMatches, `invalid credential: .*`)
+}
+
+func (s *webauthnSuite) TestRemoveCredential(c *qt.C) {
+	cred := s.register(c, "my key")
+	err := s.reg.RemoveWebAuthnCredential(s.idptest.Ctx, s.identity(c), cred.ID)
+	c.Assert(err, qt.Equals, nil)
+	creds, err := s.reg.WebAuthnCredentials(s.idptest.Ctx, s.identity(c))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(creds, qt.HasLen, 0)
+
+	err = s.reg.RemoveWebAuthnCredential(s.idptest.Ctx, s.identity(c), cred.ID)
+	c.Assert(err, qt.ErrorMatches, `credential ".*" not found`)
+
+	// The removed credential can no longer be used to log in.
+	rr := s.doBeginLogin(c, "bob")
+	c.Assert(rr.Code, qt.Equals, http.StatusNotFound)
+}
+
+// identity returns the current stored identity for bob.
+func (s *webauthnSuite) identity(c *qt.C) *store.Identity {
+	id := store.Identity{Username: "bob"}
+	err := s.idptest.Store.Store.Identity(s.idptest.Ctx, &id)
+	c.Assert(err, qt.Equals, nil)
+	return &id
+}
+
+// register registers the suite's authenticator for bob.
+func (s *webauthnSuite) register(c *qt.C, name string) *candidparams.WebAuthnCredential {
+	resp, err := s.reg.StartWebAuthnRegistration(s.idptest.Ctx, s.identity(c), name)
+	c.Assert(err, qt.Equals, nil)
+	cred, err := s.auth.Create(resp.Options)
+	c.Assert(err, qt.Equals, nil)
+	pcred, err := s.reg.CompleteWebAuthnRegistration(s.idptest.Ctx, s.identity(c), resp.SessionID, cred)
+	c.Assert(err, qt.Equals, nil)
+	return pcred
+}
+
+func (s *webauthnSuite) doBeginLogin(c *qt.C, username string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(map[string]string{"username": username})
+	c.Assert(err, qt.Equals, nil)
+	req, err := http.NewRequest("POST", "/login/begin", bytes.NewReader(body))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	s.idp.Handle(s.idptest.Ctx, rr, req)
+	return rr
+}
+
+// beginLogin starts a login ceremony for the given user and returns
+// the session ID and the options to pass to the authenticator.
+func (s *webauthnSuite) beginLogin(c *qt.C, username string) (string, []byte) {
+	rr := s.doBeginLogin(c, username)
+	c.Assert(rr.Code, qt.Equals, http.StatusOK, qt.Commentf("%s", rr.Body))
+	var resp struct {
+		SessionID string          `json:"session_id"`
+		Options   json.RawMessage `json:"options"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	c.Assert(err, qt.Equals, nil)
+	return resp.SessionID, resp.Options
+}
+
+func (s *webauthnSuite) finishLogin(c *qt.C, sessionID string, credential []byte) {
+	form := url.Values{
+		"id":         {"1"},
+		"session":    {sessionID},
+		"credential": {string(credential)},
+	}
+	req, err := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	rr := httptest.NewRecorder()
+	s.idp.Handle(s.idptest.Ctx, rr, req)
+}
+
+// setCompleter re-initializes the identity provider so that it
+// reports to the given fixture.
+func (s *webauthnSuite) setCompleter(c *qt.C, f *idptest.Fixture) {
+	initParams := f.InitParams(c, "https://candid.example.com/login/webauthn")
+	err := s.idp.Init(f.Ctx, initParams)
+	c.Assert(err, qt.Equals, nil)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package webauthntest provides a virtual WebAuthn authenticator for
+// use in tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"gopkg.in/errgo.v1"
+)
+
+// Authenticator is a virtual WebAuthn authenticator holding a single
+// ES256 credential.
+type Authenticator struct {
+	// Origin holds the origin that the authenticator's "browser"
+	// reports in the client data.
+	Origin string
+
+	// CredentialID holds the ID of the authenticator's credential.
+	CredentialID []byte
+
+	// Counter holds the signature counter.
+	Counter uint32
+
+	key *ecdsa.PrivateKey
+}
+
+// NewAuthenticator creates a new Authenticator that reports the given
+// origin.
+func NewAuthenticator(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &Authenticator{
+		Origin:       origin,
+		CredentialID: id,
+		key:          key,
+	}, nil
+}
+
+// options holds the parts of the options passed to
+// navigator.credentials.create and navigator.credentials.get that the
+// Authenticator uses.
+type options struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+// Create creates the JSON encoded PublicKeyCredential that a browser
+// would return from navigator.credentials.create when called with the
+// given JSON encoded options.
+func (a *Authenticator) Create(opts []byte) ([]byte, error) {
+	var o options
+	if err := json.Unmarshal(opts, &o); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	clientData, err := a.clientData("webauthn.create", o.PublicKey.Challenge)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: padded(a.key.PublicKey.X.Bytes()),
+		-3: padded(a.key.PublicKey.Y.Bytes()),
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	authData := a.authData(o.PublicKey.RP.ID, 0x41)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(a.CredentialID)))
+	authData = append(authData, l[:]...)
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, coseKey...)
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return a.credential(map[string]string{
+		"attestationObject": encode(attestationObject),
+		"clientDataJSON":    encode(clientData),
+	})
+}
+
+// Get creates the JSON encoded PublicKeyCredential that a browser
+// would return from navigator.credentials.get when called with the
+// given JSON encoded options.
+func (a *Authenticator) Get(opts []byte) ([]byte, error) {
+	var o options
+	if err := json.Unmarshal(opts, &o); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	clientData, err := a.clientData("webauthn.get", o.PublicKey.Challenge)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	a.Counter++
+	authData := a.authData(o.PublicKey.RPID, 0x01)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return a.credential(map[string]string{
+		"authenticatorData": encode(authData),
+		"clientDataJSON":    encode(clientData),
+		"signature":         encode(sig),
+	})
+}
+
+func (a *Authenticator) clientData(typ, challenge string) ([]byte, error) {
+	// The challenge in the options may use either base64 encoding,
+	// browsers always report it using unpadded base64url.
+	b, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		b, err = base64.RawURLEncoding.DecodeString(challenge)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid challenge")
+		}
+	}
+	return json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(b),
+		"origin":    a.Origin,
+	})
+}
+
+// authData creates the fixed part of the authenticator data for the
+// given relying party ID with the given flags.
+func (a *Authenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], flags)
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], a.Counter)
+	return append(authData, counter[:]...)
+}
+
+func (a *Authenticator) credential(response map[string]string) ([]byte, error) {
+	id := encode(a.CredentialID)
+	return json.Marshal(map[string]interface{}{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": response,
+	})
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padded returns b left padded with zeros to the length of a P-256
+// coordinate.
+func padded(b []byte) []byte {
+	if len(b) >= 32 {
+		return b
+	}
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -7,9 +7,11 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
@@ -80,6 +82,9 @@ type Authorizer struct {
 	store          store.Store
 	groupResolvers map[string]groupResolver
 	aclManager     *aclstore.Manager
+
+	revokedMacaroons   simplekv.Store
+	revocationDuration time.Duration
 }
 
 // Params specifify the configuration parameters for a new Authroizer.
@@ -107,6 +112,17 @@ type Params struct {
 
 	// ACLStore is the acl store.
 	ACLManager *aclstore.Manager
+
+	// RevokedMacaroons holds the store used to record the IDs of
+	// macaroons that have been individually revoked. If this is nil
+	// then macaroons cannot be revoked individually.
+	RevokedMacaroons simplekv.Store
+
+	// RevocationDuration holds the length of time for which a revoked
+	// macaroon ID is remembered. This should be at least as long as
+	// the lifetime of any macaroon issued by the identity server. If
+	// this is zero, 28 days is used.
+	RevocationDuration time.Duration
 }
 
 // New creates a new Authorizer for authorizing identity server
@@ -122,6 +138,12 @@ func New(params Params) (*Authorizer, error) {
 		location:      params.Location,
 		store:         params.Store,
 		aclManager:    params.ACLManager,
+
+		revokedMacaroons:   params.RevokedMacaroons,
+		revocationDuration: params.RevocationDuration,
+	}
+	if a.revocationDuration == 0 {
+		a.revocationDuration = defaultRevocationDuration
 	}
 	resolvers := make(map[string]groupResolver)
 	for _, idp := range params.IdentityProviders {
@@ -179,6 +201,9 @@ func (a *Authorizer) aclForOp(ctx context.Context, op bakery.Op) (acl []string,
 		case ActionCreateParentAgent:
 			acl, err := a.aclManager.ACL(ctx, writeUserACL)
 			return acl, false, errgo.Mask(err)
+		case ActionWriteAdmin:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			return acl, false, errgo.Mask(err)
 		}
 	case kindUser:
 		if name == "" {
@@ -244,8 +269,13 @@ func (a *Authorizer) SetAdminPublicKey(ctx context.Context, pk *bakery.PublicKey
 // macaroons, is authorized to perform the given operations. It may
 // return an bakery.DischargeRequiredError when further checks are
 // required, or params.ErrUnauthorized if the user is authenticated but
-// does not have the required authorization.
+// does not have the required authorization or has been suspended.
+// Macaroons that have been revoked are ignored.
 func (a *Authorizer) Auth(ctx context.Context, mss []macaroon.Slice, ops ...bakery.Op) (*identchecker.AuthInfo, error) {
+	mss, err := a.unrevoked(ctx, mss)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
 	authInfo, err := a.checker.Auth(mss...).Allow(ctx, ops...)
 	if err != nil {
 		if errgo.Cause(err) == bakery.ErrPermissionDenied {
@@ -253,6 +283,11 @@ func (a *Authorizer) Auth(ctx context.Context, mss []macaroon.Slice, ops ...bake
 		}
 		return nil, errgo.Mask(err, isDischargeRequiredError)
 	}
+	if id, ok := authInfo.Identity.(*Identity); ok {
+		if err := id.checkNotSuspended(ctx); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+		}
+	}
 	return authInfo, nil
 }
 
@@ -348,6 +383,7 @@ func (c identityClient) DeclaredIdentity(ctx context.Context, declared map[strin
 			Username: username,
 		},
 		authorizer: c.authorizer,
+		declared:   declared,
 	}, nil
 }
 
@@ -360,6 +396,10 @@ type Identity struct {
 	id             store.Identity
 	authorizer     *Authorizer
 	resolvedGroups []string
+
+	// declared holds the attributes declared by the macaroons that
+	// authenticated the identity, if any.
+	declared map[string]string
 }
 
 // Id implements identchecker.Identity.Id.
@@ -394,9 +434,21 @@ func (id *Identity) Allow(ctx context.Context, acl []string) (bool, error) {
 
 // Groups returns all the groups associated with the user. The groups
 // include those stored in the identity server's database along with any
-// retrieved by the relevent identity provider's GetGroups method. Once
-// the set of groups has been determined it is cached in the Identity.
+// retrieved by the relevent identity provider's GetGroups method, and
+// any groups that those groups are themselves members of. Once the set
+// of groups has been determined it is cached in the Identity.
+//
+// Errors resolving the groups are logged and the groups that could be
+// determined are returned.
 func (id *Identity) Groups(ctx context.Context) ([]string, error) {
+	groups, err := id.groups(ctx, false)
+	return groups, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+}
+
+// groups implements Groups. If strict is true then an error is returned
+// if the groups cannot be completely determined, rather than returning
+// the groups that could be.
+func (id *Identity) groups(ctx context.Context, strict bool) ([]string, error) {
 	if id.resolvedGroups != nil {
 		return id.resolvedGroups, nil
 	}
@@ -404,15 +456,28 @@ func (id *Identity) Groups(ctx context.Context) ([]string, error) {
 		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
 	groups := id.id.Groups
+	resolved := false
 	if gr := id.authorizer.groupResolvers[id.id.ProviderID.Provider()]; gr != nil {
 		var err error
 		groups, err = gr.resolveGroups(ctx, &id.id)
 		if err != nil {
+			if strict {
+				return nil, errgo.Notef(err, "cannot resolve groups")
+			}
 			logger.Warningf("error resolving groups: %s", err)
 		} else {
-			id.resolvedGroups = groups
+			resolved = true
 		}
 	}
+	groups, err := ExpandGroups(ctx, id.authorizer.store, groups)
+	if err != nil {
+		if strict {
+			return nil, errgo.Notef(err, "cannot resolve nested groups")
+		}
+		logger.Warningf("error resolving nested groups: %s", err)
+	} else if resolved {
+		id.resolvedGroups = groups
+	}
 	return groups, nil
 }
 
@@ -426,6 +491,25 @@ func (id *Identity) StoreIdentity(ctx context.Context) (*store.Identity, error)
 	return &id.id, nil
 }
 
+// checkNotSuspended returns an error with a params.ErrUnauthorized
+// cause if the identity has been suspended. Identities that are not in
+// the store cannot have been suspended.
+func (id *Identity) checkNotSuspended(ctx context.Context) error {
+	if id.id.Username == AdminUsername {
+		return nil
+	}
+	if err := id.lookup(ctx); err != nil {
+		if errgo.Cause(err) == params.ErrNotFound {
+			return nil
+		}
+		return errgo.Mask(err)
+	}
+	if id.id.Suspended {
+		return errgo.WithCausef(nil, params.ErrUnauthorized, "user %q is suspended", id.id.Username)
+	}
+	return nil
+}
+
 func (id *Identity) lookup(ctx context.Context) error {
 	if id.id.ID != "" {
 		return nil
@@ -540,6 +624,12 @@ func (r candidGroupResolver) resolveGroups(ctx context.Context, identity *store.
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	// The owner is also a member of any group that contains one of
+	// its groups.
+	ownerGroups, err = ExpandGroups(ctx, r.store, ownerGroups)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
 	allowedGroups := make([]string, 0, len(identity.Groups))
 	for _, g1 := range identity.Groups {
 		for _, g2 := range ownerGroups {
@@ -552,6 +642,42 @@ func (r candidGroupResolver) resolveGroups(ctx context.Context, identity *store.
 	return allowedGroups, nil
 }
 
+// ExpandGroups returns the given groups along with all the groups
+// that contain any of them, directly or through other groups, as
+// recorded in the Groups field of the groups held in the given store.
+// The given groups are returned first, in their original order,
+// followed by the containing groups in the order they were found. Each
+// group is only visited once, so cycles in the group hierarchy are
+// safe. If an error is returned then the groups found so far are
+// still returned.
+func ExpandGroups(ctx context.Context, st store.Store, groups []string) ([]string, error) {
+	seen := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		seen[g] = true
+	}
+	// Copy the groups so that the given slice is never modified.
+	expanded := append([]string(nil), groups...)
+	for i := 0; i < len(expanded); i++ {
+		group := store.Group{
+			Name: expanded[i],
+		}
+		if err := st.Group(ctx, &group); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				continue
+			}
+			return expanded, errgo.Mask(err)
+		}
+		for _, g := range group.Groups {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+			expanded = append(expanded, g)
+		}
+	}
+	return expanded, nil
+}
+
 type idpGroupResolver struct {
 	idp idp.IdentityProvider
 }
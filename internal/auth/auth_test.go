@@ -67,6 +67,8 @@ func (s *authSuite) Init(c *qt.C) {
 	ctx, close := s.store.Store.Context(context.Background())
 	c.Defer(close)
 	s.context = ctx
+	revokedMacaroons, err := s.store.ProviderDataStore.KeyValueStore(ctx, "_revoked_macaroons")
+	c.Assert(err, qt.Equals, nil)
 	s.authorizer, err = auth.New(auth.Params{
 		AdminPassword:    "password",
 		Location:         identityLocation,
@@ -78,7 +80,8 @@ func (s *authSuite) Init(c *qt.C) {
 				GetGroups: s.getGroups,
 			}),
 		},
-		ACLManager: aclManager,
+		ACLManager:       aclManager,
+		RevokedMacaroons: revokedMacaroons,
 	})
 	c.Assert(err, qt.Equals, nil)
 	s.adminAgentKey, err = bakery.GenerateKey()
@@ -235,6 +238,9 @@ var aclForOpTests = []struct {
 }, {
 	op:     auth.GlobalOp("createAgent"),
 	expect: []string{identchecker.Everyone},
+}, {
+	op:     auth.GlobalOp("writeAdmin"),
+	expect: []string{auth.AdminUsername},
 }, {
 	op: op("global-foo", "login"),
 }, {
@@ -388,6 +394,69 @@ func (s *authSuite) TestIdentityAllow(c *qt.C) {
 	}
 }
 
+func (s *authSuite) createGroup(c *qt.C, name string, groups ...string) {
+	err := s.store.Store.CreateGroup(s.context, &store.Group{
+		Name:   name,
+		Groups: groups,
+	})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *authSuite) TestNestedGroups(c *qt.C) {
+	s.createGroup(c, "team-a", "engineering")
+	s.createGroup(c, "engineering", "staff")
+	// Create a cycle to check that it is handled.
+	s.createGroup(c, "staff", "team-a")
+	s.createGroup(c, "sales", "staff")
+	id := s.createIdentity(c, "testuser", nil, "team-a", "other")
+	groups, err := id.Groups(s.context)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"other", "team-a", "engineering", "staff"})
+
+	ok, err := id.Allow(s.context, []string{"staff"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+	ok, err = id.Allow(s.context, []string{"sales"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+}
+
+func (s *authSuite) TestNestedGroupsAgent(c *qt.C) {
+	s.createGroup(c, "team-a", "engineering")
+	s.createIdentity(c, "bob", nil, "team-a")
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent"),
+		Username:   "agent@candid",
+		Groups:     []string{"engineering", "sales"},
+		Owner:      store.MakeProviderIdentity("test", "bob"),
+	}, store.Update{
+		store.Username: store.Set,
+		store.Groups:   store.Set,
+		store.Owner:    store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	id, err := s.authorizer.Identity(s.context, "agent@candid")
+	c.Assert(err, qt.Equals, nil)
+	groups, err := id.Groups(s.context)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"engineering"})
+}
+
+func (s *authSuite) TestExpandGroups(c *qt.C) {
+	s.createGroup(c, "a", "b", "c")
+	s.createGroup(c, "b", "d")
+	s.createGroup(c, "d", "a", "e")
+	groups := []string{"a", "x"}
+	expanded, err := auth.ExpandGroups(s.context, s.store.Store, groups)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(expanded, qt.DeepEquals, []string{"a", "x", "b", "c", "d", "e"})
+	c.Assert(groups, qt.DeepEquals, []string{"a", "x"})
+
+	expanded, err = auth.ExpandGroups(s.context, s.store.Store, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(expanded, qt.IsNil)
+}
+
 func (s *authSuite) TestAuthorizeMacaroonRequired(c *qt.C) {
 	authInfo, err := s.authorizer.Auth(s.context, nil, identchecker.LoginOp)
 	c.Assert(err, qt.ErrorMatches, `macaroon discharge required: authentication required`)
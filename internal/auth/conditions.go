@@ -0,0 +1,204 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// idpAttr is the declared attribute holding the name of the
+	// identity provider that the user logged in with.
+	idpAttr = "idp"
+
+	// mfaAttr is the declared attribute recording whether the user
+	// completed multi-factor authentication when logging in.
+	mfaAttr = "mfa"
+)
+
+// AuthenticationCaveats returns caveats declaring how a user logged in,
+// which are used when checking the authenticated-via and mfa-verified
+// conditions. They must be added to every macaroon that the server
+// mints for LoginOp.
+//
+// Both attributes are always declared, because the holder of a
+// macaroon can add declared caveats of their own. A macaroon holding
+// conflicting declarations is not accepted, and a macaroon without the
+// server's declarations never satisfies those conditions.
+func AuthenticationCaveats(idp string, mfa bool) []checkers.Caveat {
+	mfaValue := "false"
+	if mfa {
+		mfaValue = "true"
+	}
+	return []checkers.Caveat{
+		checkers.DeclaredCaveat(idpAttr, idp),
+		checkers.DeclaredCaveat(mfaAttr, mfaValue),
+	}
+}
+
+// ProviderAuthenticationCaveats returns the authentication caveats for
+// a macaroon minted for the given identity without the user logging
+// in, for example a token requested by an administrator. The user is
+// declared to have authenticated via the identity provider that holds
+// the identity, without multi-factor authentication.
+func ProviderAuthenticationCaveats(id *store.Identity) []checkers.Caveat {
+	idp := ""
+	if strings.Contains(string(id.ProviderID), ":") {
+		idp = id.ProviderID.Provider()
+	}
+	return AuthenticationCaveats(idp, false)
+}
+
+// AuthenticationCaveats returns the authentication caveats declaring
+// the same authentication as the macaroons that authenticated the
+// identity. An identity that was not authenticated by such macaroons
+// is declared to have been authenticated by no identity provider and
+// without multi-factor authentication.
+func (id *Identity) AuthenticationCaveats() []checkers.Caveat {
+	return AuthenticationCaveats(id.declared[idpAttr], id.declared[mfaAttr] == "true")
+}
+
+// An IdentityCheck checks whether an authenticated identity satisfies
+// a third-party caveat condition. If it does not, an error with a cause
+// of bakery.ErrPermissionDenied is returned.
+type IdentityCheck func(ctx context.Context, id identchecker.Identity) error
+
+// identityConditions holds the functions that implement the
+// conditions that are checked against the attributes of an identity.
+// Each function reports whether the identity satisfies the condition
+// with the given arguments.
+var identityConditions = map[string]func(ctx context.Context, id *Identity, args []string) (bool, error){
+	"is-not-member-of":  isNotMemberOf,
+	"has-email-domain":  hasEmailDomain,
+	"is-user":           isUser,
+	"authenticated-via": authenticatedVia,
+	"mfa-verified":      mfaVerified,
+}
+
+// IdentityCondition returns the check for the given third-party caveat
+// condition with the given argument. If the condition is not one that
+// is checked against the identity then a nil check is returned. If the
+// argument is not valid for the condition then an error with a cause
+// of params.ErrBadRequest is returned.
+func IdentityCondition(cond, arg string) (IdentityCheck, error) {
+	f := identityConditions[cond]
+	if f == nil {
+		return nil, nil
+	}
+	args := strings.Fields(arg)
+	switch {
+	case cond == "mfa-verified" && len(args) > 0:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unexpected argument to %s condition", cond)
+	case cond != "mfa-verified" && len(args) == 0:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%s condition requires an argument", cond)
+	}
+	return func(ctx context.Context, id identchecker.Identity) error {
+		aid, ok := id.(*Identity)
+		if !ok {
+			return errgo.WithCausef(nil, bakery.ErrPermissionDenied, "")
+		}
+		ok, err := f(ctx, aid, args)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return errgo.WithCausef(nil, bakery.ErrPermissionDenied, "")
+		}
+		return nil
+	}, nil
+}
+
+// isNotMemberOf implements the is-not-member-of condition, which is
+// satisfied when the identity is neither a member of, nor named by, any
+// of the given groups. As this is an exclusion, the condition is not
+// satisfied unless all the identity's groups can be determined.
+func isNotMemberOf(ctx context.Context, id *Identity, groups []string) (bool, error) {
+	if ok, isTrivial := trivialAllow(id.id.Username, groups); isTrivial {
+		return !ok, nil
+	}
+	idGroups, err := id.groups(ctx, true)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot determine groups")
+	}
+	for _, g := range groups {
+		for _, idg := range idGroups {
+			if g == idg {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// hasEmailDomain implements the has-email-domain condition, which is
+// satisfied when the identity has an email address in any of the given
+// domains.
+func hasEmailDomain(ctx context.Context, id *Identity, domains []string) (bool, error) {
+	if err := id.lookup(ctx); err != nil {
+		if errgo.Cause(err) == params.ErrNotFound {
+			return false, nil
+		}
+		return false, errgo.Mask(err)
+	}
+	i := strings.LastIndex(id.id.Email, "@")
+	if i == -1 {
+		return false, nil
+	}
+	for _, d := range domains {
+		if strings.EqualFold(id.id.Email[i+1:], d) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isUser implements the is-user condition, which is satisfied when the
+// identity has any of the given usernames.
+func isUser(_ context.Context, id *Identity, usernames []string) (bool, error) {
+	for _, u := range usernames {
+		if id.Id() == u {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authenticatedVia implements the authenticated-via condition, which is
+// satisfied when the user logged in with any of the given identity
+// providers.
+func authenticatedVia(_ context.Context, id *Identity, idps []string) (bool, error) {
+	idp := id.LoginIDP()
+	if idp == "" {
+		return false, nil
+	}
+	for _, name := range idps {
+		if idp == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LoginIDP returns the name of the identity provider that the user
+// logged in with, as declared by the server in the macaroons that
+// authenticated the identity. If no identity provider was declared then
+// an empty string is returned.
+func (id *Identity) LoginIDP() string {
+	return id.declared[idpAttr]
+}
+
+// mfaVerified implements the mfa-verified condition, which is satisfied
+// when the user completed multi-factor authentication when logging in.
+func mfaVerified(_ context.Context, id *Identity, _ []string) (bool, error) {
+	return id.declared[mfaAttr] == "true", nil
+}
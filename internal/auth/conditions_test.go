@@ -0,0 +1,217 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth_test
+
+import (
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var identityConditionTests = []struct {
+	about     string
+	condition string
+	arg       string
+	// macaroon holds the kind of macaroon to authenticate with:
+	// "mfa" for a login through ldap with multi-factor
+	// authentication, "test" for a plain login through test,
+	// and "legacy" for a macaroon without any authentication
+	// caveats.
+	macaroon     string
+	expectDenied bool
+}{{
+	about:     "is-not-member-of other group",
+	condition: "is-not-member-of",
+	arg:       "g2 g3",
+	macaroon:  "test",
+}, {
+	about:        "is-not-member-of member group",
+	condition:    "is-not-member-of",
+	arg:          "g2 g1",
+	macaroon:     "test",
+	expectDenied: true,
+}, {
+	about:        "is-not-member-of username",
+	condition:    "is-not-member-of",
+	arg:          "bob",
+	macaroon:     "test",
+	expectDenied: true,
+}, {
+	about:     "has-email-domain matching domain",
+	condition: "has-email-domain",
+	arg:       "example.org EXAMPLE.com",
+	macaroon:  "test",
+}, {
+	about:        "has-email-domain other domain",
+	condition:    "has-email-domain",
+	arg:          "example.org",
+	macaroon:     "test",
+	expectDenied: true,
+}, {
+	about:     "is-user matching user",
+	condition: "is-user",
+	arg:       "alice bob",
+	macaroon:  "test",
+}, {
+	about:        "is-user other user",
+	condition:    "is-user",
+	arg:          "alice",
+	macaroon:     "test",
+	expectDenied: true,
+}, {
+	about:     "authenticated-via declared provider",
+	condition: "authenticated-via",
+	arg:       "ldap",
+	macaroon:  "mfa",
+}, {
+	about:        "authenticated-via other provider",
+	condition:    "authenticated-via",
+	arg:          "test",
+	macaroon:     "mfa",
+	expectDenied: true,
+}, {
+	about:        "authenticated-via without declared provider",
+	condition:    "authenticated-via",
+	arg:          "test",
+	macaroon:     "legacy",
+	expectDenied: true,
+}, {
+	about:     "mfa-verified with mfa",
+	condition: "mfa-verified",
+	macaroon:  "mfa",
+}, {
+	about:        "mfa-verified without mfa",
+	condition:    "mfa-verified",
+	macaroon:     "test",
+	expectDenied: true,
+}, {
+	about:        "mfa-verified without declared mfa",
+	condition:    "mfa-verified",
+	macaroon:     "legacy",
+	expectDenied: true,
+}}
+
+func (s *authSuite) TestIdentityCondition(c *qt.C) {
+	s.createIdentity(c, "bob", nil, "g1")
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		Username: "bob",
+		Email:    "bob@example.com",
+	}, store.Update{
+		store.Email: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	macaroons := map[string]*bakery.Macaroon{
+		"mfa":    s.loginMacaroon(c, "bob", "ldap", true),
+		"test":   s.loginMacaroon(c, "bob", "test", false),
+		"legacy": s.identityMacaroon(c, "bob"),
+	}
+
+	for _, test := range identityConditionTests {
+		c.Run(test.about, func(c *qt.C) {
+			check, err := auth.IdentityCondition(test.condition, test.arg)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(check, qt.Not(qt.IsNil))
+			authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{macaroons[test.macaroon].M()}}, identchecker.LoginOp)
+			c.Assert(err, qt.Equals, nil)
+			err = check(s.context, authInfo.Identity)
+			if test.expectDenied {
+				c.Assert(err, qt.ErrorMatches, `permission denied`)
+				c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrPermissionDenied)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+		})
+	}
+}
+
+func (s *authSuite) TestForgedAuthenticationDeclaration(c *qt.C) {
+	s.createIdentity(c, "bob", nil)
+	m := s.loginMacaroon(c, "bob", "test", false)
+	err := m.M().AddFirstPartyCaveat([]byte("declared mfa true"))
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	_, ok := errgo.Cause(err).(*bakery.DischargeRequiredError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error %v", err))
+}
+
+func (s *authSuite) TestIsNotMemberOfGroupsError(c *qt.C) {
+	s.createIdentity(c, "bob", nil, "g1")
+	s.providerGroupsError = errgo.New("provider unavailable")
+	m := s.identityMacaroon(c, "bob")
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+
+	// The identity is not in g2 as far as can be determined, but
+	// an exclusion cannot be satisfied unless all the groups are
+	// known.
+	check, err := auth.IdentityCondition("is-not-member-of", "g2")
+	c.Assert(err, qt.Equals, nil)
+	err = check(s.context, authInfo.Identity)
+	c.Assert(err, qt.ErrorMatches, `cannot determine groups: cannot resolve groups: provider unavailable`)
+
+	// Other checks still use the groups that could be determined.
+	groups, err := authInfo.Identity.(*auth.Identity).Groups(s.context)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"g1"})
+}
+
+func (s *authSuite) TestProviderAuthenticationCaveats(c *qt.C) {
+	id := s.createIdentity(c, "bob", nil)
+	sid, err := id.StoreIdentity(s.context)
+	c.Assert(err, qt.Equals, nil)
+	m, err := s.oven.NewMacaroon(s.context, bakery.LatestVersion, append([]checkers.Caveat{
+		candidclient.UserDeclaration("bob"),
+	}, auth.ProviderAuthenticationCaveats(sid)...), identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.(*auth.Identity).LoginIDP(), qt.Equals, "test")
+	check, err := auth.IdentityCondition("mfa-verified", "")
+	c.Assert(err, qt.Equals, nil)
+	err = check(s.context, authInfo.Identity)
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrPermissionDenied)
+
+	// The declarations cannot be overridden by the holder.
+	err = m.M().AddFirstPartyCaveat([]byte("declared mfa true"))
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	_, ok := errgo.Cause(err).(*bakery.DischargeRequiredError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error %v", err))
+}
+
+func (s *authSuite) TestIdentityConditionNotRecognized(c *qt.C) {
+	check, err := auth.IdentityCondition("is-member-of", "g1")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(check, qt.IsNil)
+}
+
+func (s *authSuite) TestIdentityConditionMissingArgument(c *qt.C) {
+	_, err := auth.IdentityCondition("is-user", " ")
+	c.Assert(err, qt.ErrorMatches, `is-user condition requires an argument`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *authSuite) TestIdentityConditionUnexpectedArgument(c *qt.C) {
+	_, err := auth.IdentityCondition("mfa-verified", "yes")
+	c.Assert(err, qt.ErrorMatches, `unexpected argument to mfa-verified condition`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *authSuite) loginMacaroon(c *qt.C, username, idp string, mfa bool) *bakery.Macaroon {
+	caveats := append([]checkers.Caveat{
+		candidclient.UserDeclaration(username),
+	}, auth.AuthenticationCaveats(idp, mfa)...)
+	m, err := s.oven.NewMacaroon(s.context, bakery.LatestVersion, caveats, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
@@ -5,7 +5,9 @@ package httpauth
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
@@ -14,6 +16,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	macaroon "gopkg.in/macaroon.v2"
 
 	"github.com/CanonicalLtd/candid/internal/auth"
 )
@@ -38,12 +41,23 @@ func New(o *bakery.Oven, a *auth.Authorizer) *Authorizer {
 // perform the given operations. It may return an httpbakery error when
 // further checks are required, or params.ErrUnauthorized if the user is
 // authenticated but does not have the required authorization.
+//
+// As well as macaroons in cookies and headers, macaroons may be
+// presented as a token in an "Authorization: Bearer" header.
 func (a *Authorizer) Auth(ctx context.Context, req *http.Request, ops ...bakery.Op) (*identchecker.AuthInfo, error) {
 	ctx = httpbakery.ContextWithRequest(ctx, req)
 	if username, password, ok := req.BasicAuth(); ok {
 		ctx = auth.ContextWithUserCredentials(ctx, username, password)
 	}
-	authInfo, err := a.authorizer.Auth(ctx, httpbakery.RequestMacaroons(req), ops...)
+	mss := httpbakery.RequestMacaroons(req)
+	if token, ok := bearerToken(req); ok {
+		ms, err := decodeBearerToken(token)
+		if err != nil {
+			return nil, errgo.WithCausef(err, params.ErrUnauthorized, "invalid bearer token")
+		}
+		mss = append(mss, ms)
+	}
+	authInfo, err := a.authorizer.Auth(ctx, mss, ops...)
 	if err == nil {
 		return authInfo, nil
 	}
@@ -68,3 +82,32 @@ func (a *Authorizer) Auth(ctx context.Context, req *http.Request, ops ...bakery.
 		CookieNameSuffix: "candid",
 	})
 }
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+// in the given request, if there is one.
+func bearerToken(req *http.Request) (string, bool) {
+	h := req.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// decodeBearerToken decodes a bearer token. Bearer tokens are
+// base64-encoded JSON macaroon slices, the same format that is used for
+// macaroons in cookies and the Macaroons header.
+func decodeBearerToken(token string) (macaroon.Slice, error) {
+	data, err := macaroon.Base64Decode([]byte(token))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var ms macaroon.Slice
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(ms) == 0 {
+		return nil, errgo.New("no macaroons")
+	}
+	return ms, nil
+}
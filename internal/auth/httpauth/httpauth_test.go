@@ -6,17 +6,22 @@ package httpauth_test
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
 	"github.com/juju/aclstore/v2"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	macaroon "gopkg.in/macaroon.v2"
 
 	"github.com/CanonicalLtd/candid/internal/auth"
 	"github.com/CanonicalLtd/candid/internal/auth/httpauth"
@@ -140,6 +145,48 @@ func (s *authSuite) TestAuthorizeMacaroonRequired(c *qt.C) {
 	c.Assert(derr.Info.Macaroon, qt.Not(qt.IsNil))
 }
 
+func (s *authSuite) TestAuthorizeWithBearerToken(c *qt.C) {
+	authorizer, err := auth.New(auth.Params{
+		Location:         identityLocation,
+		Store:            s.store.Store,
+		MacaroonVerifier: s.oven,
+		ACLManager:       s.aclManager,
+	})
+	c.Assert(err, qt.Equals, nil)
+	httpAuthorizer := httpauth.New(s.oven, authorizer)
+	m, err := s.oven.NewMacaroon(
+		context.Background(),
+		bakery.LatestVersion,
+		[]checkers.Caveat{
+			candidclient.UserDeclaration("bob"),
+			checkers.TimeBeforeCaveat(time.Now().Add(time.Hour)),
+		},
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.Equals, nil)
+	buf, err := json.Marshal(macaroon.Slice{m.M()})
+	c.Assert(err, qt.Equals, nil)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+base64.RawURLEncoding.EncodeToString(buf))
+	authInfo, err := httpAuthorizer.Auth(context.Background(), req, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.Id(), qt.Equals, "bob")
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "bearer "+base64.StdEncoding.EncodeToString(buf))
+	authInfo, err = httpAuthorizer.Auth(context.Background(), req, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.Id(), qt.Equals, "bob")
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	authInfo, err = httpAuthorizer.Auth(context.Background(), req, identchecker.LoginOp)
+	c.Assert(err, qt.ErrorMatches, `invalid bearer token: .*`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrUnauthorized)
+	c.Assert(authInfo, qt.IsNil)
+}
+
 func b64str(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
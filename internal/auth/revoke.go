@@ -0,0 +1,115 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// issuedAttr is the declared attribute holding the time at which a
+// macaroon was issued.
+const issuedAttr = "issued"
+
+// defaultRevocationDuration holds the length of time for which a
+// revoked macaroon ID is remembered if no duration is specified in
+// Params.
+const defaultRevocationDuration = 28 * 24 * time.Hour
+
+// IssuedCaveat returns a caveat declaring that a macaroon was issued
+// at the given time. It should be added to every macaroon that
+// declares a username so that the macaroon is rejected once the
+// sessions of that user have been revoked (see store.Identity.NotBefore).
+// Macaroons without this caveat are treated as having been issued at
+// the zero time.
+func IssuedCaveat(t time.Time) checkers.Caveat {
+	return checkers.DeclaredCaveat(issuedAttr, t.UTC().Format(time.RFC3339Nano))
+}
+
+// MacaroonID returns the string form of the given macaroon ID as
+// accepted by RevokeMacaroon.
+func MacaroonID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// RevokeMacaroon adds the macaroon with the given ID, as returned by
+// MacaroonID, to the revocation list. Any macaroon slice whose primary
+// macaroon has that ID will no longer be accepted.
+func (a *Authorizer) RevokeMacaroon(ctx context.Context, id string) error {
+	if a.revokedMacaroons == nil {
+		return errgo.Newf("macaroon revocation not available")
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(id); err != nil {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid macaroon id %q", id)
+	}
+	now := time.Now()
+	err := a.revokedMacaroons.Set(ctx, id, []byte(now.UTC().Format(time.RFC3339)), now.Add(a.revocationDuration))
+	return errgo.Mask(err)
+}
+
+// unrevoked returns those macaroon slices in mss that have not been
+// revoked.
+func (a *Authorizer) unrevoked(ctx context.Context, mss []macaroon.Slice) ([]macaroon.Slice, error) {
+	var valid []macaroon.Slice
+	for _, ms := range mss {
+		revoked, err := a.isRevoked(ctx, ms)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if revoked {
+			logger.Debugf("ignoring revoked macaroon %s", MacaroonID(ms[0].Id()))
+			continue
+		}
+		valid = append(valid, ms)
+	}
+	return valid, nil
+}
+
+// isRevoked reports whether the given macaroon slice has been revoked,
+// either individually or because the sessions of the user that it
+// declares have been revoked since it was issued.
+func (a *Authorizer) isRevoked(ctx context.Context, ms macaroon.Slice) (bool, error) {
+	if len(ms) == 0 {
+		return false, nil
+	}
+	if a.revokedMacaroons != nil {
+		_, err := a.revokedMacaroons.Get(ctx, MacaroonID(ms[0].Id()))
+		if err == nil {
+			return true, nil
+		}
+		if errgo.Cause(err) != simplekv.ErrNotFound {
+			return false, errgo.Mask(err)
+		}
+	}
+	declared := checkers.InferDeclared(Namespace, ms)
+	username := declared["username"]
+	if username == "" {
+		return false, nil
+	}
+	id := store.Identity{
+		Username: username,
+	}
+	if err := a.store.Identity(ctx, &id); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return false, nil
+		}
+		return false, errgo.Mask(err)
+	}
+	if id.NotBefore.IsZero() {
+		return false, nil
+	}
+	// A missing or malformed issue time leaves issued as the zero
+	// time, so the macaroon is treated as revoked.
+	issued, _ := time.Parse(time.RFC3339Nano, declared[issuedAttr])
+	return issued.Before(id.NotBefore), nil
+}
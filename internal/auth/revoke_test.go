@@ -0,0 +1,109 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth_test
+
+import (
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func (s *authSuite) issuedMacaroon(c *qt.C, username string, issued time.Time) *bakery.Macaroon {
+	m, err := s.oven.NewMacaroon(
+		s.context,
+		bakery.LatestVersion,
+		[]checkers.Caveat{
+			candidclient.UserDeclaration(username),
+			auth.IssuedCaveat(issued),
+		},
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
+
+func (s *authSuite) TestRevokeSessions(c *qt.C) {
+	s.createIdentity(c, "bob", nil)
+	now := time.Now()
+	before := s.issuedMacaroon(c, "bob", now.Add(-time.Minute))
+	after := s.issuedMacaroon(c, "bob", now.Add(time.Minute))
+	legacy := s.identityMacaroon(c, "bob")
+
+	for _, m := range []*bakery.Macaroon{before, after, legacy} {
+		_, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+		c.Assert(err, qt.Equals, nil)
+	}
+
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		Username:  "bob",
+		NotBefore: now,
+	}, store.Update{
+		store.NotBefore: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// Macaroons issued before the sessions were revoked, or
+	// without an issue time, are ignored so the user has to log
+	// in again.
+	for _, m := range []*bakery.Macaroon{before, legacy} {
+		_, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+		_, ok := errgo.Cause(err).(*bakery.DischargeRequiredError)
+		c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error %v", err))
+	}
+
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{after.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.Id(), qt.Equals, "bob")
+}
+
+func (s *authSuite) TestRevokeSessionsOtherUser(c *qt.C) {
+	s.createIdentity(c, "bob", nil)
+	s.createIdentity(c, "alice", nil)
+	m := s.issuedMacaroon(c, "alice", time.Now().Add(-time.Minute))
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		Username:  "bob",
+		NotBefore: time.Now(),
+	}, store.Update{
+		store.NotBefore: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.Id(), qt.Equals, "alice")
+}
+
+func (s *authSuite) TestRevokeMacaroon(c *qt.C) {
+	s.createIdentity(c, "bob", nil)
+	m1 := s.issuedMacaroon(c, "bob", time.Now())
+	m2 := s.issuedMacaroon(c, "bob", time.Now())
+
+	err := s.authorizer.RevokeMacaroon(s.context, auth.MacaroonID(m1.M().Id()))
+	c.Assert(err, qt.Equals, nil)
+
+	_, err = s.authorizer.Auth(s.context, []macaroon.Slice{{m1.M()}}, identchecker.LoginOp)
+	_, ok := errgo.Cause(err).(*bakery.DischargeRequiredError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error %v", err))
+
+	// Other macaroons are unaffected, even when presented
+	// alongside the revoked one.
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m1.M()}, {m2.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(authInfo.Identity.Id(), qt.Equals, "bob")
+}
+
+func (s *authSuite) TestRevokeMacaroonInvalidID(c *qt.C) {
+	err := s.authorizer.RevokeMacaroon(s.context, "not base64!")
+	c.Assert(err, qt.ErrorMatches, `invalid macaroon id "not base64!"`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
@@ -48,6 +48,12 @@ func NewDischargeCreator(server *Server) *DischargeCreator {
 	}
 }
 
+// PublicKey returns the public key of the service, which is used to
+// identify it to the identity server.
+func (s *DischargeCreator) PublicKey() *bakery.PublicKey {
+	return &s.bakeryKey.Public
+}
+
 // AssertDischarge checks that a macaroon can be discharged with
 // interaction using the specified visitor.
 func (s *DischargeCreator) AssertDischarge(c *qt.C, i httpbakery.Interactor) {
@@ -17,6 +17,7 @@ import (
 	"github.com/CanonicalLtd/candid/meeting"
 	"github.com/CanonicalLtd/candid/store"
 	"github.com/CanonicalLtd/candid/store/memstore"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 // Store implements a test fixture that contains memory-based
@@ -27,6 +28,8 @@ type Store struct {
 	MeetingStore       meeting.Store
 	BakeryRootKeyStore bakery.RootKeyStore
 	ACLStore           aclstore.ACLStore
+	AuditStore         store.AuditStore
+	WebhookQueue       webhook.Queue
 }
 
 // NewStore returns a new Store that uses in-memory storage.
@@ -37,6 +40,8 @@ func NewStore() *Store {
 		MeetingStore:       memstore.NewMeetingStore(),
 		BakeryRootKeyStore: bakery.NewMemRootKeyStore(),
 		ACLStore:           aclstore.NewACLStore(memsimplekv.NewStore()),
+		AuditStore:         memstore.NewAuditStore(),
+		WebhookQueue:       memstore.NewWebhookQueue(),
 	}
 }
 
@@ -49,6 +54,8 @@ func (s *Store) ServerParams() identity.ServerParams {
 		MeetingStore:      s.MeetingStore,
 		RootKeyStore:      s.BakeryRootKeyStore,
 		ACLStore:          s.ACLStore,
+		AuditStore:        s.AuditStore,
+		WebhookQueue:      s.WebhookQueue,
 	}
 }
 
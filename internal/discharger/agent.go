@@ -83,15 +83,25 @@ func (h *handler) AgentLogin(p httprequest.Params, req *agentLoginRequest) (*age
 // agentMacaroon creates a new macaroon containing a local third-party
 // caveat addressed to the specified agent.
 func (h *handler) agentMacaroon(ctx context.Context, vers bakery.Version, op bakery.Op, user string, key *bakery.PublicKey) (*bakery.Macaroon, error) {
+	// Agent logins are attributed to the provider that holds the
+	// identity. If there is no such identity then the macaroon
+	// cannot be used anyway, as the public key check will fail.
+	id := store.Identity{
+		Username: user,
+	}
+	if err := h.params.Store.Identity(ctx, &id); err != nil && errgo.Cause(err) != store.ErrNotFound {
+		return nil, errgo.Mask(err)
+	}
 	m, err := h.params.Oven.NewMacaroon(
 		ctx,
 		vers,
-		[]checkers.Caveat{
+		append([]checkers.Caveat{
 			checkers.TimeBeforeCaveat(time.Now().Add(agentLoginMacaroonDuration)),
 			candidclient.UserDeclaration(user),
+			auth.IssuedCaveat(time.Now()),
 			bakery.LocalThirdPartyCaveat(key, vers),
 			auth.UserHasPublicKeyCaveat(params.Username(user), key),
-		},
+		}, auth.ProviderAuthenticationCaveats(&id)...),
 		op,
 	)
 	return m, errgo.Mask(err)
@@ -18,6 +18,7 @@ import (
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	"gopkg.in/macaroon-bakery.v2/httpbakery/agent"
@@ -56,6 +57,11 @@ func (s *agentSuite) TestHTTPBakeryAgentDischarge(c *qt.C) {
 	c.Assert(err, qt.Equals, nil)
 	_, err = s.dischargeCreator.Bakery.Checker.Auth(ms).Allow(context.Background(), identchecker.LoginOp)
 	c.Assert(err, qt.Equals, nil)
+
+	// Agent logins are attributed to the provider holding the agent.
+	declared := checkers.InferDeclared(nil, ms)
+	c.Assert(declared["idp"], qt.Equals, "idm")
+	c.Assert(declared["mfa"], qt.Equals, "false")
 }
 
 func (s *agentSuite) TestGetAgentDischargeNoCookie(c *qt.C) {
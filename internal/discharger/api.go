@@ -58,9 +58,8 @@ func NewAPIHandler(params identity.HandlerParams) ([]httprequest.Handler, error)
 		place:                 place,
 		reqAuth:               reqAuth,
 	}))
-	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+	d := newKeyringDischarger(params.Keyring, httpbakery.DischargerParams{
 		CheckerP:        checker,
-		Key:             params.Key,
 		ErrorToResponse: identity.ReqServer.ErrorMapper,
 	})
 	for _, h := range d.Handlers() {
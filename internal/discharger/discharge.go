@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/trace"
 	"gopkg.in/CanonicalLtd/candidclient.v1"
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
@@ -21,6 +22,7 @@ import (
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	"gopkg.in/macaroon-bakery.v2/httpbakery/agent"
 	"gopkg.in/macaroon.v2"
@@ -28,6 +30,10 @@ import (
 	"github.com/CanonicalLtd/candid/internal/auth"
 	"github.com/CanonicalLtd/candid/internal/auth/httpauth"
 	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+	"github.com/CanonicalLtd/candid/internal/tracing"
+	"github.com/CanonicalLtd/candid/lifetime"
+	"github.com/CanonicalLtd/candid/policy"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -56,7 +62,17 @@ func (c *thirdPartyCaveatChecker) CheckThirdPartyCaveat(ctx context.Context, p h
 // This is implemented as a separate method so that it can be called from
 // WaitLegacy without nesting the trace context.
 func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+	ctx, span := tracing.Start(ctx, "discharge")
+	caveats, err := c.checkCaveat(ctx, p)
+	cond := dischargeCondition(p.Caveat.Condition, err)
+	span.SetAttributes(attribute.String("candid.condition", cond))
+	tracing.End(span, err)
+	monitoring.DischargeCompleted(cond, errorCode(err))
+	return caveats, err
+}
 
+// checkCaveat implements checkThirdPartyCaveat.
+func (c *thirdPartyCaveatChecker) checkCaveat(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
 	domain := ""
 	if c, err := p.Request.Cookie("domain"); err == nil && names.IsValidUserDomain(c.Value) {
 		domain = c.Value
@@ -71,6 +87,7 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		forceLegacy = true
 	}
 	var op bakery.Op
+	var identityCheck auth.IdentityCheck
 	switch cond {
 	case "is-authenticated-user":
 		op = auth.GlobalOp(auth.ActionDischarge)
@@ -88,7 +105,14 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 	case "is-member-of":
 		op = auth.GroupsDischargeOp(strings.Fields(args))
 	default:
-		return nil, checkers.ErrCaveatNotRecognized
+		identityCheck, err = auth.IdentityCondition(cond, args)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		if identityCheck == nil {
+			return nil, checkers.ErrCaveatNotRecognized
+		}
+		op = auth.GlobalOp(auth.ActionDischarge)
 	}
 
 	var mss []macaroon.Slice
@@ -112,6 +136,13 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 	}
 
 	authInfo, err := c.params.Authorizer.Auth(ctx, mss, op)
+	if err == nil && identityCheck != nil {
+		err = identityCheck(ctx, authInfo.Identity)
+	}
+	var policyCaveats []checkers.Caveat
+	if err == nil {
+		policyCaveats, err = c.evaluatePolicy(ctx, p, cond, authInfo.Identity)
+	}
 	if _, ok := errgo.Cause(err).(*bakery.DischargeRequiredError); ok {
 		return nil, c.interactionRequiredError(ctx, interactionRequiredParams{
 			why:         err,
@@ -127,14 +158,25 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		})
 	}
 	if err != nil {
-		// TODO return appropriate error code when permission denied.
-		return nil, errgo.Mask(err)
+		identity.Audit(ctx, c.params.AuditStore, store.AuditEntry{
+			Type:     store.AuditDischargeFailure,
+			Username: p.Request.Form.Get("discharge-for-user"),
+			Caveat:   string(p.Caveat.Condition),
+			Detail:   err.Error(),
+		})
+		return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized), errgo.Is(policy.ErrDenied))
 	}
 	logger.Debugf("authorization for %#v succeeded", authInfo.Identity)
+	identity.Audit(ctx, c.params.AuditStore, store.AuditEntry{
+		Type:     store.AuditDischarge,
+		Username: authInfo.Identity.Id(),
+		Caveat:   string(p.Caveat.Condition),
+	})
 	c.updateDischargeTime(ctx, authInfo.Identity.Id())
-	if cond == "is-member-of" {
-		return nil, nil
+	if cond == "is-member-of" || identityCheck != nil {
+		return policyCaveats, nil
 	}
+	lt := identityLifetimes(ctx, c.params.Lifetimes, authInfo.Identity)
 	if p.Token != nil && len(mss) > 0 {
 		// As well as discharging the original third party caveat, also
 		// set the discharge token macaroon as a cookie
@@ -144,10 +186,55 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 			return nil, errgo.Mask(err)
 		}
 	}
-	return []checkers.Caveat{
+	caveats := []checkers.Caveat{
 		candidclient.UserDeclaration(authInfo.Identity.Id()),
-		checkers.TimeBeforeCaveat(time.Now().Add(24 * time.Hour)),
-	}, nil
+		auth.IssuedCaveat(time.Now()),
+		checkers.TimeBeforeCaveat(time.Now().Add(lt.Discharge)),
+	}
+	// The discharge also authenticates the user to the identity
+	// server itself, so it must declare how the user logged in.
+	if aid, ok := authInfo.Identity.(*auth.Identity); ok {
+		caveats = append(caveats, aid.AuthenticationCaveats()...)
+	}
+	return append(caveats, policyCaveats...), nil
+}
+
+// evaluatePolicy evaluates the configured policy against a request to
+// discharge a caveat with the given condition for the given identity.
+// It returns any additional caveats that the policy requires be added
+// to the discharge macaroon. If the policy denies the discharge then an
+// error with a cause of policy.ErrDenied is returned.
+func (c *thirdPartyCaveatChecker) evaluatePolicy(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams, cond string, id identchecker.Identity) ([]checkers.Caveat, error) {
+	if c.params.Policy == nil {
+		return nil, nil
+	}
+	req := policy.Request{
+		Username:  id.Id(),
+		Origin:    p.Request.Header.Get("Origin"),
+		Condition: cond,
+		Time:      time.Now(),
+	}
+	// Version 1 caveats do not record the key of the service that
+	// added them.
+	if p.Caveat.FirstPartyPublicKey != (bakery.PublicKey{}) {
+		req.Service = p.Caveat.FirstPartyPublicKey.String()
+	}
+	if aid, ok := id.(*auth.Identity); ok {
+		sid, err := aid.StoreIdentity(ctx)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		req.Email = sid.Email
+		if req.Groups, err = aid.Groups(ctx); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		req.IDP = aid.LoginIDP()
+	}
+	caveats, err := c.params.Policy.Evaluate(&req)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(policy.ErrDenied))
+	}
+	return caveats, nil
 }
 
 func macaroonsFromDischargeToken(ctx context.Context, token *httpbakery.DischargeToken) (macaroon.Slice, error) {
@@ -277,3 +364,67 @@ func (h *handler) DischargeToken(p httprequest.Params, req *dischargeTokenReques
 	}
 	return &dischargeTokenResponse{DischargeToken: dt}, nil
 }
+
+// identityLifetimes returns the macaroon lifetimes that apply to the
+// given identity, which depend on the identity provider that
+// authenticated it and the groups that it is a member of.
+func identityLifetimes(ctx context.Context, lt *lifetime.Config, id identchecker.Identity) lifetime.Durations {
+	aid, ok := id.(*auth.Identity)
+	if !ok || lt == nil || len(lt.IdentityProviders)+len(lt.Groups) == 0 {
+		return lt.For("", nil)
+	}
+	sid, err := aid.StoreIdentity(ctx)
+	if err != nil {
+		if errgo.Cause(err) != params.ErrNotFound {
+			logger.Warningf("cannot determine lifetimes for %q: %s", id.Id(), err)
+		}
+		return lt.For("", nil)
+	}
+	var groups []string
+	if len(lt.Groups) > 0 {
+		// Only resolve the groups when they might make a
+		// difference, as doing so can require a request to the
+		// identity provider.
+		groups, err = aid.Groups(ctx)
+		if err != nil {
+			logger.Warningf("cannot determine groups for %q: %s", id.Id(), err)
+		}
+	}
+	return lt.For(sid.ProviderID.Provider(), groups)
+}
+
+// dischargeCondition returns the name of the given caveat condition for
+// use in the discharge metrics. Conditions that were not recognized are
+// reported as "unrecognized" so that the number of reported conditions
+// remains bounded. The legacy "<" prefix is removed so that old and new
+// clients are counted under the same condition.
+func dischargeCondition(condition []byte, err error) string {
+	cond, _, perr := checkers.ParseCaveat(string(condition))
+	if perr != nil || errgo.Cause(err) == checkers.ErrCaveatNotRecognized {
+		return "unrecognized"
+	}
+	return strings.TrimPrefix(cond, "<")
+}
+
+// errorCode returns the error code associated with the given error for
+// use in the discharge metrics, or "" if err is nil.
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch cause := errgo.Cause(err).(type) {
+	case *httpbakery.Error:
+		return string(cause.Code)
+	case interface {
+		ErrorCode() params.ErrorCode
+	}:
+		return string(cause.ErrorCode())
+	}
+	switch errgo.Cause(err) {
+	case checkers.ErrCaveatNotRecognized:
+		return "caveat not recognized"
+	case policy.ErrDenied:
+		return "denied by policy"
+	}
+	return "internal error"
+}
@@ -500,6 +500,200 @@ func (s *dischargeSuite) TestDischargeMemberOf(c *qt.C) {
 	}
 }
 
+var dischargeIdentityConditionTests = []struct {
+	about       string
+	condition   string
+	expectError string
+}{{
+	about:     "is-not-member-of",
+	condition: "is-not-member-of test1 test3",
+}, {
+	about:       "is-not-member-of - member",
+	condition:   "is-not-member-of test1 test2",
+	expectError: `cannot get discharge from ".*": Post http.*: permission denied`,
+}, {
+	about:     "has-email-domain",
+	condition: "has-email-domain example.com",
+}, {
+	about:       "has-email-domain - other domain",
+	condition:   "has-email-domain example.org",
+	expectError: `cannot get discharge from ".*": Post http.*: permission denied`,
+}, {
+	about:     "is-user",
+	condition: "is-user test-user other-user",
+}, {
+	about:       "is-user - other user",
+	condition:   "is-user other-user",
+	expectError: `cannot get discharge from ".*": Post http.*: permission denied`,
+}, {
+	about:     "authenticated-via",
+	condition: "authenticated-via test",
+}, {
+	about:       "authenticated-via - other identity provider",
+	condition:   "authenticated-via ldap",
+	expectError: `cannot get discharge from ".*": Post http.*: permission denied`,
+}, {
+	about:       "mfa-verified - no mfa",
+	condition:   "mfa-verified",
+	expectError: `cannot get discharge from ".*": Post http.*: permission denied`,
+}, {
+	about:       "missing argument",
+	condition:   "is-user",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: is-user condition requires an argument`,
+}}
+
+func (s *dischargeSuite) TestDischargeIdentityConditions(c *qt.C) {
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "test:test-user",
+			Email:      "test-user@example.com",
+			IDPGroups:  []string{"test2"},
+		},
+	})
+	ctx := context.Background()
+	for i, test := range dischargeIdentityConditionTests {
+		c.Logf("%d. %q", i, test.about)
+		m := s.dischargeCreator.NewMacaroon(c, test.condition, groupOp)
+		ms, err := client.DischargeAll(ctx, m)
+		if test.expectError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, qt.Equals, nil)
+		s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+	}
+}
+
+func (s *dischargeSuite) TestDischargeDeclaresAuthentication(c *qt.C) {
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "test:test-user",
+		},
+	})
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.Equals, nil)
+
+	// The discharge also authenticates the user to candid itself,
+	// so it must declare how the user logged in.
+	declared := checkers.InferDeclared(nil, ms)
+	c.Assert(declared["idp"], qt.Equals, "test")
+	c.Assert(declared["mfa"], qt.Equals, "false")
+}
+
+func (s *dischargeSuite) TestDischargeMemberOfNestedGroup(c *qt.C) {
+	ctx := context.Background()
+	for _, g := range []store.Group{{
+		Name:   "test2",
+		Groups: []string{"engineering"},
+	}, {
+		Name:   "engineering",
+		Groups: []string{"staff", "test2"},
+	}} {
+		err := s.store.Store.CreateGroup(ctx, &g)
+		c.Assert(err, qt.Equals, nil)
+	}
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "http://example.com/test-user",
+			IDPGroups:  []string{"test2"},
+		},
+	})
+	m := s.dischargeCreator.NewMacaroon(c, "is-member-of staff", groupOp)
+	ms, err := client.DischargeAll(ctx, m)
+	c.Assert(err, qt.Equals, nil)
+	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+
+	m = s.dischargeCreator.NewMacaroon(c, "is-member-of sales", groupOp)
+	_, err = client.DischargeAll(ctx, m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: permission denied`)
+}
+
+func (s *dischargeSuite) TestDischargeAudited(c *qt.C) {
+	ctx := context.Background()
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "http://example.com/test-user",
+			IDPGroups:  []string{"staff"},
+		},
+	})
+	m := s.dischargeCreator.NewMacaroon(c, "is-member-of staff", groupOp)
+	_, err := client.DischargeAll(ctx, m)
+	c.Assert(err, qt.Equals, nil)
+	m = s.dischargeCreator.NewMacaroon(c, "is-member-of sales", groupOp)
+	_, err = client.DischargeAll(ctx, m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: permission denied`)
+
+	entries, err := s.store.AuditStore.FindAuditEntries(ctx, store.AuditFilter{})
+	c.Assert(err, qt.Equals, nil)
+	for i := range entries {
+		entries[i].ID = ""
+		entries[i].Time = time.Time{}
+	}
+	c.Assert(entries, qt.DeepEquals, []store.AuditEntry{{
+		Type:   store.AuditDischargeFailure,
+		Caveat: "is-member-of sales",
+		Detail: "permission denied",
+	}, {
+		Type:     store.AuditLogin,
+		Username: "test-user",
+		IDP:      "test",
+	}, {
+		Type:     store.AuditDischarge,
+		Username: "test-user",
+		Caveat:   "is-member-of staff",
+	}, {
+		Type:     store.AuditLogin,
+		Username: "test-user",
+		IDP:      "test",
+	}})
+}
+
+func (s *dischargeSuite) TestDischargeSuspendedUser(c *qt.C) {
+	ctx := context.Background()
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "http://example.com/test-user",
+		},
+	})
+	m := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+	_, err := client.DischargeAll(ctx, m)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.store.Store.UpdateIdentity(ctx, &store.Identity{
+		Username:  "test-user",
+		Suspended: true,
+	}, store.Update{
+		store.Suspended: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	m = s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+	_, err = client.DischargeAll(ctx, m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": third party refused discharge: cannot discharge: user "test-user" is suspended`)
+}
+
+func (s *dischargeSuite) TestLoginFailureAudited(c *qt.C) {
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username: "test-user",
+		},
+	})
+	m := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+	_, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	entries, err := s.store.AuditStore.FindAuditEntries(context.Background(), store.AuditFilter{})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditLoginFailure)
+	c.Assert(entries[0].IDP, qt.Equals, "test")
+	c.Assert(entries[0].Detail, qt.Not(qt.Equals), "")
+}
+
 func (s *dischargeSuite) TestDischargeXMemberOfX(c *qt.C) {
 	// if the user is X member of no group, we must still
 	// discharge is-member-of X.
@@ -776,3 +970,48 @@ func (v *valueSavingOpenWebBrowser) OpenWebBrowser(u *url.URL) error {
 	v.url = u
 	return v.openWebBrowser(u)
 }
+
+var dischargeConditionTests = []struct {
+	about     string
+	condition string
+	err       error
+	expect    string
+}{{
+	about:     "success",
+	condition: "is-authenticated-user",
+	expect:    "is-authenticated-user",
+}, {
+	about:     "with argument",
+	condition: "is-member-of group1 group2",
+	err:       errgo.New("permission denied"),
+	expect:    "is-member-of",
+}, {
+	about:     "legacy prefix",
+	condition: "<is-authenticated-user",
+	expect:    "is-authenticated-user",
+}, {
+	about:     "legacy prefix with argument",
+	condition: "<is-member-of group1",
+	expect:    "is-member-of",
+}, {
+	about:     "not recognized",
+	condition: "something-else",
+	err:       errgo.WithCausef(nil, checkers.ErrCaveatNotRecognized, "caveat not recognized"),
+	expect:    "unrecognized",
+}, {
+	about:     "invalid caveat",
+	condition: "",
+	err:       errgo.New("cannot parse caveat"),
+	expect:    "unrecognized",
+}}
+
+func TestDischargeCondition(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	for _, test := range dischargeConditionTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(discharger.DischargeCondition([]byte(test.condition), test.err), qt.Equals, test.expect)
+		})
+	}
+}
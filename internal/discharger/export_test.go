@@ -5,13 +5,20 @@ package discharger
 
 import (
 	"github.com/juju/simplekv"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
 
 	"github.com/CanonicalLtd/candid/idp"
 	"github.com/CanonicalLtd/candid/internal/discharger/internal"
 	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/keyring"
 )
 
-var NewIDPHandler = newIDPHandler
+var (
+	NewIDPHandler      = newIDPHandler
+	DischargeCondition = dischargeCondition
+)
 
 type LoginInfo loginInfo
 
@@ -23,3 +30,27 @@ func NewVisitCompleter(params identity.HandlerParams, store simplekv.Store) idp.
 		place:                 &place{params.MeetingPlace},
 	}
 }
+
+type KeyringDischarger struct {
+	d *keyringDischarger
+}
+
+func NewKeyringDischarger(kr *keyring.Keyring, p httpbakery.DischargerParams) KeyringDischarger {
+	return KeyringDischarger{newKeyringDischarger(kr, p)}
+}
+
+func (d KeyringDischarger) Handlers() []httprequest.Handler {
+	return d.d.Handlers()
+}
+
+// CachedKeys returns the public keys for which the discharger holds
+// handlers.
+func (d KeyringDischarger) CachedKeys() map[bakery.PublicKey]bool {
+	d.d.mu.Lock()
+	defer d.d.mu.Unlock()
+	keys := make(map[bakery.PublicKey]bool)
+	for pk := range d.d.handlers {
+		keys[pk] = true
+	}
+	return keys
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/trace"
 	candidclient "gopkg.in/CanonicalLtd/candidclient.v1"
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
@@ -22,19 +23,14 @@ import (
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 
 	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/idputil"
+	"github.com/CanonicalLtd/candid/internal/auth"
 	"github.com/CanonicalLtd/candid/internal/discharger/internal"
 	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+	"github.com/CanonicalLtd/candid/internal/tracing"
 	"github.com/CanonicalLtd/candid/store"
-)
-
-const (
-	// identityMacaroonDuration is the length of time for which an
-	// identity macaroon is valid.
-	identityMacaroonDuration = 28 * 24 * time.Hour
-
-	// dischargeTokenDuration is the length of time for which a
-	// discharge token is valid.
-	dischargeTokenDuration = identityMacaroonDuration
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 func initIDPs(ctx context.Context, params identity.HandlerParams, dt *dischargeTokenCreator, vc *visitCompleter) error {
@@ -43,8 +39,16 @@ func initIDPs(ctx context.Context, params identity.HandlerParams, dt *dischargeT
 		if err != nil {
 			return errgo.Mask(err)
 		}
+		st := params.Store
+		if params.Webhooks != nil {
+			st = idpStore{
+				Store:    params.Store,
+				idp:      ip.Name(),
+				webhooks: params.Webhooks,
+			}
+		}
 		if err := ip.Init(ctx, idp.InitParams{
-			Store:                 params.Store,
+			Store:                 st,
 			KeyValueStore:         kvStore,
 			Oven:                  params.Oven,
 			Key:                   params.Key,
@@ -59,15 +63,54 @@ func initIDPs(ctx context.Context, params identity.HandlerParams, dt *dischargeT
 	return nil
 }
 
+// idpStore wraps the store given to an identity provider so that an
+// IdentityCreated event is sent when the identity provider adds a new
+// identity.
+type idpStore struct {
+	store.Store
+	idp      string
+	webhooks *webhook.Dispatcher
+}
+
+// UpdateIdentity implements store.Store.UpdateIdentity.
+func (s idpStore) UpdateIdentity(ctx context.Context, id *store.Identity, update store.Update) error {
+	if update[store.Username] != store.Set || id.ProviderID == "" {
+		return errgo.Mask(s.Store.UpdateIdentity(ctx, id, update), errgo.Any)
+	}
+	existing := store.Identity{
+		ProviderID: id.ProviderID,
+	}
+	err := s.Store.Identity(ctx, &existing)
+	if err != nil && errgo.Cause(err) != store.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	created := err != nil
+	if err := s.Store.UpdateIdentity(ctx, id, update); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if created {
+		s.webhooks.Notify(ctx, webhook.Event{
+			Type:     webhook.IdentityCreated,
+			Username: id.Username,
+			IDP:      s.idp,
+		})
+	}
+	return nil
+}
+
 func newIDPHandler(params identity.HandlerParams, idp idp.IdentityProvider) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		t := trace.New("identity.internal.v1.idp", idp.Name())
 		defer t.Finish()
 		ctx := trace.NewContext(context.Background(), t)
+		ctx = tracing.ContextWithSpanFrom(ctx, req.Context())
+		ctx, span := tracing.Start(ctx, "idp.handle", attribute.String("candid.idp", idp.Name()))
+		defer span.End()
 		ctx, close := params.Store.Context(ctx)
 		defer close()
 		ctx, close = params.MeetingStore.Context(ctx)
 		defer close()
+		ctx = contextWithIDPName(ctx, idp.Name())
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/login/"+idp.Name())
 		req.ParseForm()
 		idp.Handle(ctx, w, req)
@@ -79,13 +122,32 @@ type dischargeTokenCreator struct {
 }
 
 func (d *dischargeTokenCreator) DischargeToken(ctx context.Context, id *store.Identity) (*httpbakery.DischargeToken, error) {
+	aid, err := d.params.Authorizer.Identity(ctx, id.Username)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	idpName := idpNameFromContext(ctx)
+	if idpName == "" {
+		// Logins that are not handled by an identity provider,
+		// such as agent logins, are attributed to the provider
+		// that holds the identity.
+		sid, err := aid.StoreIdentity(ctx)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		idpName = sid.ProviderID.Provider()
+	}
+	lt := identityLifetimes(ctx, d.params.Lifetimes, aid)
+	caveats := []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(lt.DischargeToken)),
+		candidclient.UserDeclaration(id.Username),
+		auth.IssuedCaveat(time.Now()),
+	}
+	caveats = append(caveats, auth.AuthenticationCaveats(idpName, idputil.MFAFromContext(ctx))...)
 	m, err := d.params.Oven.NewMacaroon(
 		ctx,
 		bakery.LatestVersion,
-		[]checkers.Caveat{
-			checkers.TimeBeforeCaveat(time.Now().Add(dischargeTokenDuration)),
-			candidclient.UserDeclaration(id.Username),
-		},
+		caveats,
 		identchecker.LoginOp,
 	)
 	if err != nil {
@@ -95,12 +157,38 @@ func (d *dischargeTokenCreator) DischargeToken(ctx context.Context, id *store.Id
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	firstLogin := false
+	if d.params.Webhooks != nil {
+		prev := store.Identity{
+			ID:         id.ID,
+			ProviderID: id.ProviderID,
+			Username:   id.Username,
+		}
+		if err := d.params.Store.Identity(ctx, &prev); err != nil {
+			logger.Errorf("cannot retrieve last login time: %s", err)
+		} else {
+			firstLogin = prev.LastLogin.IsZero()
+		}
+	}
 	id.LastLogin = time.Now()
 	if err := d.params.Store.UpdateIdentity(ctx, id, store.Update{
 		store.LastLogin: store.Set,
 	}); err != nil {
 		logger.Errorf("cannot update last login time: %s", err)
 	}
+	if firstLogin {
+		d.params.Webhooks.Notify(ctx, webhook.Event{
+			Type:     webhook.FirstLogin,
+			Username: id.Username,
+			IDP:      idpNameFromContext(ctx),
+		})
+	}
+	identity.Audit(ctx, d.params.AuditStore, store.AuditEntry{
+		Type:     store.AuditLogin,
+		Username: id.Username,
+		IDP:      idpNameFromContext(ctx),
+	})
+	monitoring.LoginCompleted(idpNameFromContext(ctx), true)
 	return &httpbakery.DischargeToken{
 		Kind:  "macaroon",
 		Value: v,
@@ -141,6 +229,7 @@ func (c *visitCompleter) Success(ctx context.Context, w http.ResponseWriter, req
 
 // Failure implements idp.VisitCompleter.Failure.
 func (c *visitCompleter) Failure(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, err error) {
+	auditLoginFailure(ctx, c.params, err)
 	_, bakeryErr := httpbakery.ErrorToResponse(ctx, err)
 	if dischargeID != "" {
 		c.place.Done(ctx, dischargeID, &loginInfo{
@@ -176,6 +265,7 @@ func (c *visitCompleter) RedirectSuccess(ctx context.Context, w http.ResponseWri
 
 // RedirectFailure implements idp.VisitCompleter.RedirectFailure.
 func (c *visitCompleter) RedirectFailure(ctx context.Context, w http.ResponseWriter, req *http.Request, returnTo, state string, err error) {
+	auditLoginFailure(ctx, c.params, err)
 	v := url.Values{
 		"error": {err.Error()},
 	}
@@ -191,6 +281,33 @@ func (c *visitCompleter) RedirectFailure(ctx context.Context, w http.ResponseWri
 	identity.WriteError(ctx, w, err)
 }
 
+// auditLoginFailure records a failed login attempt through the
+// identity provider handling the current request in the audit log and
+// the login metrics.
+func auditLoginFailure(ctx context.Context, params identity.HandlerParams, err error) {
+	identity.Audit(ctx, params.AuditStore, store.AuditEntry{
+		Type:   store.AuditLoginFailure,
+		IDP:    idpNameFromContext(ctx),
+		Detail: err.Error(),
+	})
+	monitoring.LoginCompleted(idpNameFromContext(ctx), false)
+}
+
+type idpNameKey struct{}
+
+// contextWithIDPName returns a context that records that the request
+// is being handled by the identity provider with the given name.
+func contextWithIDPName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, idpNameKey{}, name)
+}
+
+// idpNameFromContext returns the name of the identity provider
+// recorded with contextWithIDPName, or "" if there is none.
+func idpNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(idpNameKey{}).(string)
+	return name
+}
+
 // redirect writes a redirect response addressed the the given returnTo
 // address with the given query parameters. If an error is returned it
 // will be because the returnTo address is invalid and therefore it will
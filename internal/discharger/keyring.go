@@ -0,0 +1,114 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/keyring"
+)
+
+// A keyringDischarger serves the endpoints of an httpbakery.Discharger
+// using the keys in a keyring. Discharge requests are handled using
+// the key that the caveat is addressed to, while the public key
+// endpoints return the current key.
+type keyringDischarger struct {
+	keyring *keyring.Keyring
+	params  httpbakery.DischargerParams
+
+	mu       sync.Mutex
+	handlers map[bakery.PublicKey]map[string]httprequest.Handler
+}
+
+func newKeyringDischarger(kr *keyring.Keyring, p httpbakery.DischargerParams) *keyringDischarger {
+	return &keyringDischarger{
+		keyring:  kr,
+		params:   p,
+		handlers: make(map[bakery.PublicKey]map[string]httprequest.Handler),
+	}
+}
+
+// Handlers returns the handlers for the discharger endpoints.
+func (d *keyringDischarger) Handlers() []httprequest.Handler {
+	var handlers []httprequest.Handler
+	for _, h := range d.dischargerHandlers(d.keyring.Current()) {
+		route := h.Method + " " + h.Path
+		discharge := h.Path == "/discharge"
+		handlers = append(handlers, httprequest.Handler{
+			Method: h.Method,
+			Path:   h.Path,
+			Handle: func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+				key := d.keyring.Current()
+				if discharge {
+					key = d.keyring.KeyForCaveat(requestCaveat(req))
+				}
+				d.dischargerHandlers(key)[route].Handle(w, req, p)
+			},
+		})
+	}
+	return handlers
+}
+
+// dischargerHandlers returns the handlers of an httpbakery.Discharger
+// using the given key, indexed by method and path. Handlers for keys
+// that have been removed from the keyring are discarded.
+func (d *keyringDischarger) dischargerHandlers(key *bakery.KeyPair) map[string]httprequest.Handler {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune()
+	if handlers := d.handlers[key.Public]; handlers != nil {
+		return handlers
+	}
+	p := d.params
+	p.Key = key
+	handlers := make(map[string]httprequest.Handler)
+	for _, h := range httpbakery.NewDischarger(p).Handlers() {
+		handlers[h.Method+" "+h.Path] = h
+	}
+	d.handlers[key.Public] = handlers
+	return handlers
+}
+
+// prune removes the cached handlers for any key that is no longer held
+// in the keyring. It must be called with d.mu held.
+func (d *keyringDischarger) prune() {
+	if len(d.handlers) == 0 {
+		return
+	}
+	keys := d.keyring.Keys()
+	held := make(map[bakery.PublicKey]bool, len(keys))
+	for _, key := range keys {
+		held[key.Public] = true
+	}
+	for pk := range d.handlers {
+		if !held[pk] {
+			delete(d.handlers, pk)
+		}
+	}
+}
+
+// requestCaveat returns the encrypted third-party caveat held in the
+// given discharge request, or nil if it cannot be found.
+func requestCaveat(req *http.Request) []byte {
+	if err := req.ParseForm(); err != nil {
+		return nil
+	}
+	for _, name := range []string{"caveat64", "id64"} {
+		if v := req.Form.Get(name); v != "" {
+			caveat, err := macaroon.Base64Decode([]byte(v))
+			if err != nil {
+				return nil
+			}
+			return caveat
+		}
+	}
+	return []byte(req.Form.Get("id"))
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/test"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/keyring"
+)
+
+func TestKeyRotation(t *testing.T) {
+	qtsuite.Run(qt.New(t), &keyRotationSuite{})
+}
+
+type keyRotationSuite struct {
+	clock            *testclock.Clock
+	srv              *candidtest.Server
+	dischargeCreator *candidtest.DischargeCreator
+}
+
+func (s *keyRotationSuite) Init(c *qt.C) {
+	s.clock = testclock.NewClock(time.Now())
+	c.Patch(&keyring.Clock, s.clock)
+	st := candidtest.NewStore()
+	sp := st.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		test.NewIdentityProvider(test.Params{Name: "test"}),
+	}
+	sp.KeyRotationPeriod = 24 * time.Hour
+	sp.KeyGracePeriod = 12 * time.Hour
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	s.dischargeCreator = candidtest.NewDischargeCreator(s.srv)
+	s.waitRefresh(c)
+}
+
+// waitRefresh waits until the server is waiting to refresh its keys.
+func (s *keyRotationSuite) waitRefresh(c *qt.C) {
+	select {
+	case <-s.clock.Alarms():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for keyring")
+	}
+}
+
+// newMacaroon returns a macaroon with a third-party caveat addressed
+// to the key that the server currently publishes.
+func (s *keyRotationSuite) newMacaroon(c *qt.C) *bakery.Macaroon {
+	locator := httpbakery.NewThirdPartyLocator(nil, nil)
+	locator.AllowInsecure()
+	key, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	b := identchecker.NewBakery(identchecker.BakeryParams{
+		Locator: locator,
+		Key:     key,
+	})
+	m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  s.srv.URL,
+		Condition: "is-authenticated-user",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
+
+func (s *keyRotationSuite) TestRotation(c *qt.C) {
+	client := s.srv.Client(testInteractor("alice", nil))
+	info, err := httpbakery.ThirdPartyInfoForLocation(context.Background(), nil, s.srv.URL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(info.PublicKey, qt.Equals, s.srv.Key.Public)
+	m0 := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+
+	s.clock.Advance(24 * time.Hour)
+	s.waitRefresh(c)
+
+	// The new key is published.
+	info, err = httpbakery.ThirdPartyInfoForLocation(context.Background(), nil, s.srv.URL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(info.PublicKey, qt.Not(qt.Equals), s.srv.Key.Public)
+
+	// Caveats addressed to either key can be discharged.
+	_, err = client.DischargeAll(context.Background(), s.newMacaroon(c))
+	c.Assert(err, qt.Equals, nil)
+	_, err = client.DischargeAll(context.Background(), m0)
+	c.Assert(err, qt.Equals, nil)
+
+	s.clock.Advance(12 * time.Hour)
+	s.waitRefresh(c)
+
+	// Once the grace period has expired, caveats addressed to the
+	// old key can no longer be discharged.
+	_, err = client.DischargeAll(context.Background(), m0)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": third party refused discharge: cannot discharge: discharger cannot decode caveat id: public key mismatch`)
+	_, err = client.DischargeAll(context.Background(), s.newMacaroon(c))
+	c.Assert(err, qt.Equals, nil)
+}
+
+func TestKeyringDischargerDiscardsRetiredKeys(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(time.Now())
+	c.Patch(&keyring.Clock, clock)
+	key0, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	kr, err := keyring.New(context.Background(), keyring.Params{
+		Store:          memsimplekv.NewStore(),
+		Key:            key0,
+		RotationPeriod: 24 * time.Hour,
+		GracePeriod:    12 * time.Hour,
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer kr.Close()
+	waitKeyring(c, clock)
+
+	d := discharger.NewKeyringDischarger(kr, httpbakery.DischargerParams{})
+	var publicKey httprequest.Handler
+	for _, h := range d.Handlers() {
+		if h.Method == "GET" && h.Path == "/publickey" {
+			publicKey = h
+		}
+	}
+	getPublicKey := func() bakery.PublicKey {
+		req, err := http.NewRequest("GET", "/publickey", nil)
+		c.Assert(err, qt.Equals, nil)
+		rr := httptest.NewRecorder()
+		publicKey.Handle(rr, req, nil)
+		c.Assert(rr.Code, qt.Equals, http.StatusOK)
+		var resp struct {
+			PublicKey bakery.PublicKey
+		}
+		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		c.Assert(err, qt.Equals, nil)
+		return resp.PublicKey
+	}
+	c.Assert(getPublicKey(), qt.Equals, key0.Public)
+	c.Assert(d.CachedKeys(), qt.DeepEquals, map[bakery.PublicKey]bool{key0.Public: true})
+
+	// Handlers for the old key are retained during its grace period.
+	clock.Advance(24 * time.Hour)
+	waitKeyring(c, clock)
+	key1 := kr.Current()
+	c.Assert(getPublicKey(), qt.Equals, key1.Public)
+	c.Assert(d.CachedKeys(), qt.DeepEquals, map[bakery.PublicKey]bool{
+		key0.Public: true,
+		key1.Public: true,
+	})
+
+	// Once the old key has been retired its handlers are discarded.
+	clock.Advance(12 * time.Hour)
+	waitKeyring(c, clock)
+	c.Assert(getPublicKey(), qt.Equals, key1.Public)
+	c.Assert(d.CachedKeys(), qt.DeepEquals, map[bakery.PublicKey]bool{key1.Public: true})
+}
+
+// waitKeyring waits until a keyring using the given clock is waiting to
+// refresh its keys.
+func waitKeyring(c *qt.C, clock *testclock.Clock) {
+	select {
+	case <-clock.Alarms():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for keyring")
+	}
+}
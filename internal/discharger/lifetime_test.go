@@ -0,0 +1,123 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/test"
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/lifetime"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestLifetimes(t *testing.T) {
+	qtsuite.Run(qt.New(t), &lifetimeSuite{})
+}
+
+type lifetimeSuite struct {
+	srv              *candidtest.Server
+	dischargeCreator *candidtest.DischargeCreator
+}
+
+func (s *lifetimeSuite) Init(c *qt.C) {
+	st := candidtest.NewStore()
+	sp := st.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		test.NewIdentityProvider(test.Params{Name: "test"}),
+	}
+	sp.Lifetimes = &lifetime.Config{
+		Durations: lifetime.Durations{
+			Discharge: 12 * time.Hour,
+		},
+		IdentityProviders: map[string]lifetime.Durations{
+			"test": {
+				DischargeToken: 48 * time.Hour,
+			},
+		},
+		Groups: map[string]lifetime.Durations{
+			"admins": {
+				Discharge: time.Hour,
+			},
+		},
+	}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	s.dischargeCreator = candidtest.NewDischargeCreator(s.srv)
+}
+
+var lifetimeTests = []struct {
+	about                string
+	username             params.Username
+	groups               []string
+	expectDischarge      time.Duration
+	expectDischargeToken time.Duration
+}{{
+	about:                "identity provider lifetimes",
+	username:             "alice",
+	expectDischarge:      12 * time.Hour,
+	expectDischargeToken: 48 * time.Hour,
+}, {
+	about:                "group lifetimes",
+	username:             "bob",
+	groups:               []string{"admins"},
+	expectDischarge:      time.Hour,
+	expectDischargeToken: 48 * time.Hour,
+}}
+
+func (s *lifetimeSuite) TestLifetimes(c *qt.C) {
+	for _, test := range lifetimeTests {
+		c.Run(test.about, func(c *qt.C) {
+			client := s.srv.Client(testInteractor(test.username, test.groups))
+			m := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+			ms, err := client.DischargeAll(context.Background(), m)
+			c.Assert(err, qt.Equals, nil)
+			assertExpiresIn(c, ms[1:], test.expectDischarge)
+
+			u, err := url.Parse(s.srv.URL)
+			c.Assert(err, qt.Equals, nil)
+			mss := httpbakery.MacaroonsForURL(client.Client.Jar, u)
+			c.Assert(mss, qt.HasLen, 1)
+			assertExpiresIn(c, mss[0], test.expectDischargeToken)
+		})
+	}
+}
+
+// testInteractor returns an interactor that logs in to the test
+// identity provider as the given user with the given groups.
+func testInteractor(username params.Username, groups []string) httpbakery.Interactor {
+	return test.Interactor{
+		User: &params.User{
+			Username:   username,
+			ExternalID: string(store.MakeProviderIdentity("test", string(username))),
+			IDPGroups:  groups,
+		},
+	}
+}
+
+// assertExpiresIn asserts that the given macaroons expire
+// approximately the given duration from now.
+func assertExpiresIn(c *qt.C, ms macaroon.Slice, d time.Duration) {
+	t, ok := checkers.MacaroonsExpiryTime(auth.Namespace, ms)
+	c.Assert(ok, qt.Equals, true)
+	remaining := time.Until(t)
+	c.Assert(remaining <= d, qt.Equals, true, qt.Commentf("remaining %v; want %v", remaining, d))
+	c.Assert(remaining > d-time.Minute, qt.Equals, true, qt.Commentf("remaining %v; want %v", remaining, d))
+}
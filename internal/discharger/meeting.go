@@ -10,6 +10,7 @@ import (
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 
+	"github.com/CanonicalLtd/candid/internal/tracing"
 	"github.com/CanonicalLtd/candid/meeting"
 )
 
@@ -52,7 +53,9 @@ func (p *place) Done(ctx context.Context, id string, info *loginInfo) error {
 }
 
 func (p *place) Wait(ctx context.Context, id string) (*dischargeRequestInfo, *loginInfo, error) {
+	ctx, span := tracing.Start(ctx, "rendezvous.wait")
 	reqData, loginData, err := p.place.Wait(ctx, id)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, nil, errgo.Notef(err, "cannot wait")
 	}
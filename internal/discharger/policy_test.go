@@ -0,0 +1,168 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/test"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/policy"
+)
+
+func TestPolicy(t *testing.T) {
+	qtsuite.Run(qt.New(t), &policySuite{})
+}
+
+type policySuite struct {
+	srv   *candidtest.Server
+	prod  *candidtest.DischargeCreator
+	other *candidtest.DischargeCreator
+}
+
+const testPolicy = `
+services:
+  prod: %s
+rules:
+- name: contractors-prod
+  match:
+    groups: [contractors]
+    services: [prod]
+  deny: true
+  message: contractors cannot use production services
+- match:
+    groups: [staff]
+  caveats:
+  - declared department engineering
+  expire-after: 10m
+`
+
+func (s *policySuite) Init(c *qt.C) {
+	st := candidtest.NewStore()
+	sp := st.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		test.NewIdentityProvider(test.Params{Name: "test"}),
+	}
+	// The policy refers to the public key of the service, which is
+	// not known until after the server has been created.
+	sp.Policy = new(policy.Policy)
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	s.prod = candidtest.NewDischargeCreator(s.srv)
+	s.other = candidtest.NewDischargeCreator(s.srv)
+	p, err := policy.Parse([]byte(fmt.Sprintf(testPolicy, s.prod.PublicKey())))
+	c.Assert(err, qt.Equals, nil)
+	*sp.Policy = *p
+}
+
+func (s *policySuite) TestDenied(c *qt.C) {
+	denied := dischargeCount(c, "is-authenticated-user", "denied by policy")
+	client := s.srv.Client(testInteractor("alice", []string{"contractors"}))
+	statuses := &statusRecorder{RoundTripper: http.DefaultTransport}
+	client.Client.Transport = statuses
+	_, err := s.prod.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post .*: cannot discharge: contractors cannot use production services`)
+	c.Assert(statuses.discharge, qt.Equals, http.StatusForbidden)
+	c.Assert(dischargeCount(c, "is-authenticated-user", "denied by policy"), qt.Equals, denied+1)
+
+	// The policy only applies to the production service.
+	ms, err := s.other.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.Equals, nil)
+	s.other.AssertMacaroon(c, ms, identchecker.LoginOp, "alice")
+}
+
+var policyCaveatsTests = []struct {
+	about     string
+	condition string
+}{{
+	about:     "is-authenticated-user",
+	condition: "is-authenticated-user",
+}, {
+	about:     "is-member-of",
+	condition: "is-member-of staff",
+}, {
+	about:     "identity condition",
+	condition: "is-user bob",
+}}
+
+func (s *policySuite) TestCaveats(c *qt.C) {
+	client := s.srv.Client(testInteractor("bob", []string{"staff"}))
+	for _, test := range policyCaveatsTests {
+		c.Run(test.about, func(c *qt.C) {
+			m := s.prod.NewMacaroon(c, test.condition, identchecker.LoginOp)
+			ms, err := client.DischargeAll(context.Background(), m)
+			c.Assert(err, qt.Equals, nil)
+			assertExpiresIn(c, ms[1:], 10*time.Minute)
+			found := false
+			for _, cav := range ms[1].Caveats() {
+				found = found || string(cav.Id) == "declared department engineering"
+			}
+			c.Assert(found, qt.Equals, true)
+		})
+	}
+}
+
+func (s *policySuite) TestNoMatchingRules(c *qt.C) {
+	client := s.srv.Client(testInteractor("carol", nil))
+	ms, err := s.prod.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.Equals, nil)
+	assertExpiresIn(c, ms[1:], 24*time.Hour)
+}
+
+// statusRecorder is an http.RoundTripper that records the status of the
+// most recent response from the discharge endpoint.
+type statusRecorder struct {
+	http.RoundTripper
+	discharge int
+}
+
+func (r *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.RoundTripper.RoundTrip(req)
+	if err == nil && req.URL.Path == "/discharge" {
+		r.discharge = resp.StatusCode
+	}
+	return resp, err
+}
+
+// dischargeCount returns the number of failed discharges with the given
+// condition and error code that have been recorded in the discharge
+// metrics.
+func dischargeCount(c *qt.C, condition, errorCode string) float64 {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	c.Assert(err, qt.Equals, nil)
+	want := map[string]string{
+		"condition":  condition,
+		"result":     "failure",
+		"error_code": errorCode,
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "candid_discharger_discharges_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if reflect.DeepEqual(labels, want) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
@@ -96,10 +96,15 @@ func (h *handler) WaitLegacy(p httprequest.Params, req *waitRequest) (*waitRespo
 	// (because they might be creating the token in response to a callback
 	// from an external identity provider, for example).
 
+	caveat := reqInfo.Caveat
+	if caveat == nil {
+		// The caveat is encoded in the id.
+		caveat = reqInfo.CaveatId
+	}
 	m, err := bakery.Discharge(p.Context, bakery.DischargeParams{
 		Id:     reqInfo.CaveatId,
 		Caveat: reqInfo.Caveat,
-		Key:    h.params.Key,
+		Key:    h.params.Keyring.KeyForCaveat(caveat),
 		Checker: bakery.ThirdPartyCaveatCheckerFunc(func(ctx context.Context, ci *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
 			return h.params.checker.checkThirdPartyCaveat(ctx, httpbakery.ThirdPartyCaveatCheckerParams{
 				Caveat:   ci,
@@ -0,0 +1,102 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/test"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webhookSuite{})
+}
+
+type webhookSuite struct {
+	srv              *candidtest.Server
+	dischargeCreator *candidtest.DischargeCreator
+	events           chan webhook.Event
+}
+
+func (s *webhookSuite) Init(c *qt.C) {
+	s.events = make(chan webhook.Event, 20)
+	hooksrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev webhook.Event
+		err := json.NewDecoder(req.Body).Decode(&ev)
+		c.Check(err, qt.Equals, nil)
+		s.events <- ev
+	}))
+	c.Defer(hooksrv.Close)
+
+	st := candidtest.NewStore()
+	sp := st.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		test.NewIdentityProvider(test.Params{Name: "test"}),
+	}
+	sp.Webhooks = []webhook.Hook{{
+		URL: hooksrv.URL,
+	}}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	s.dischargeCreator = candidtest.NewDischargeCreator(s.srv)
+}
+
+func (s *webhookSuite) TestNewIdentityFirstLogin(c *qt.C) {
+	client := s.srv.Client(test.Interactor{
+		User: &params.User{
+			Username:   "test-user",
+			ExternalID: "http://example.com/test-user",
+		},
+	})
+	m := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+	_, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.Equals, nil)
+
+	var events []webhook.Event
+	for len(events) < 2 {
+		select {
+		case ev := <-s.events:
+			ev.ID = ""
+			ev.Time = time.Time{}
+			events = append(events, ev)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for webhook events")
+		}
+	}
+	c.Assert(events, qt.DeepEquals, []webhook.Event{{
+		Type:     webhook.IdentityCreated,
+		Username: "test-user",
+		IDP:      "test",
+	}, {
+		Type:     webhook.FirstLogin,
+		Username: "test-user",
+		IDP:      "test",
+	}})
+
+	// A second login does not produce any more events.
+	m = s.dischargeCreator.NewMacaroon(c, "is-authenticated-user", identchecker.LoginOp)
+	_, err = client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.Equals, nil)
+	select {
+	case ev := <-s.events:
+		c.Fatalf("unexpected event %#v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
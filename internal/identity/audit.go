@@ -0,0 +1,84 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package identity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// Audit records the given entry in the audit log held in the given
+// store. If the store is nil then nothing is recorded. Failures to
+// record the entry are logged but otherwise ignored so that the
+// operation being audited is not affected.
+func Audit(ctx context.Context, st store.AuditStore, entry store.AuditEntry) {
+	if st == nil {
+		return
+	}
+	if err := st.AddAuditEntry(ctx, &entry); err != nil {
+		logger.Errorf("cannot record %s audit entry: %s", entry.Type, err)
+	}
+}
+
+// aclActorKey is the context key used to record the user that
+// authenticated to the ACL handler.
+type aclActorKey struct{}
+
+// maxACLRequestBodySize holds the maximum size of the body of a request
+// that changes an ACL. The body is read before the request is
+// authenticated, so it must be limited.
+const maxACLRequestBodySize = 1024 * 1024
+
+// auditACLHandler wraps the given ACL handler so that successful
+// changes to ACLs are recorded in the audit log.
+func auditACLHandler(h http.Handler, st store.AuditStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxACLRequestBodySize))
+		if err != nil {
+			WriteError(req.Context(), w, errgo.WithCausef(err, params.ErrBadRequest, "cannot read request body"))
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var actor string
+		req = req.WithContext(context.WithValue(req.Context(), aclActorKey{}, &actor))
+		sw := &statusResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		h.ServeHTTP(sw, req)
+		if sw.status != http.StatusOK {
+			return
+		}
+		Audit(req.Context(), st, store.AuditEntry{
+			Type:   store.AuditACLChange,
+			Actor:  actor,
+			Detail: fmt.Sprintf("%s %s %s", req.Method, req.URL.Path, bytes.TrimSpace(body)),
+		})
+	})
+}
+
+// statusResponseWriter is an http.ResponseWriter that records the
+// status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -11,6 +11,8 @@ import (
 	"gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/policy"
 )
 
 // ErrLoginRequired is returned by the /debug/* endpoints when OpenID
@@ -65,8 +67,10 @@ func errorResponseBody(err error) *apiError {
 	cause := errgo.Cause(err)
 	if coder, ok := cause.(errorCoder); ok {
 		errResp.Code = coder.ErrorCode()
-	} else if errgo.Cause(err) == httprequest.ErrUnmarshal {
+	} else if cause == httprequest.ErrUnmarshal {
 		errResp.Code = params.ErrBadRequest
+	} else if cause == policy.ErrDenied {
+		errResp.Code = params.ErrForbidden
 	}
 	return &apiError{
 		originalError: cause,
@@ -9,10 +9,12 @@ import (
 	"html/template"
 	"net/http"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
 	"github.com/juju/utils/debugstatus"
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,9 +27,14 @@ import (
 	"github.com/CanonicalLtd/candid/idp"
 	"github.com/CanonicalLtd/candid/internal/auth"
 	"github.com/CanonicalLtd/candid/internal/auth/httpauth"
+	"github.com/CanonicalLtd/candid/internal/keyring"
 	"github.com/CanonicalLtd/candid/internal/monitoring"
+	"github.com/CanonicalLtd/candid/internal/tracing"
+	"github.com/CanonicalLtd/candid/lifetime"
 	"github.com/CanonicalLtd/candid/meeting"
+	"github.com/CanonicalLtd/candid/policy"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 var logger = loggo.GetLogger("candid.internal.identity")
@@ -42,20 +49,43 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	if len(versions) == 0 {
 		return nil, errgo.Newf("identity server must serve at least one version of the API")
 	}
+	if sp.Store != nil {
+		sp.Store = monitoring.InstrumentStore(sp.Store)
+	}
 
 	// Create the bakery parts.
+	var keyStore simplekv.Store
+	if sp.ProviderDataStore != nil {
+		var err error
+		keyStore, err = sp.ProviderDataStore.KeyValueStore(context.Background(), "_keys")
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
 	if sp.Key == nil {
 		var err error
-		sp.Key, err = bakery.GenerateKey()
+		if keyStore != nil {
+			sp.Key, err = keyring.ServerKey(context.Background(), keyStore)
+		} else {
+			sp.Key, err = bakery.GenerateKey()
+		}
 		if err != nil {
 			return nil, errgo.Notef(err, "cannot generate key")
 		}
 	}
-	locator := bakery.NewThirdPartyStore()
-	locator.AddInfo(sp.Location, bakery.ThirdPartyInfo{
-		PublicKey: sp.Key.Public,
-		Version:   bakery.LatestVersion,
+	kr, err := keyring.New(context.Background(), keyring.Params{
+		Store:          keyStore,
+		Key:            sp.Key,
+		RotationPeriod: sp.KeyRotationPeriod,
+		GracePeriod:    sp.KeyGracePeriod,
 	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	locator := selfLocator{
+		location: sp.Location,
+		keyring:  kr,
+	}
 	var rksf func([]bakery.Op) bakery.RootKeyStore
 	if sp.RootKeyStore != nil {
 		rksf = func([]bakery.Op) bakery.RootKeyStore {
@@ -74,17 +104,29 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 		InitialAdminUsers: []string{auth.AdminUsername},
 	})
 	if err != nil {
+		kr.Close()
 		return nil, errgo.Mask(err)
 	}
+	var revokedMacaroons simplekv.Store
+	if sp.ProviderDataStore != nil {
+		revokedMacaroons, err = sp.ProviderDataStore.KeyValueStore(context.Background(), "_revoked_macaroons")
+		if err != nil {
+			kr.Close()
+			return nil, errgo.Mask(err)
+		}
+	}
 	auth, err := auth.New(auth.Params{
-		AdminPassword:     sp.AdminPassword,
-		Location:          sp.Location,
-		MacaroonVerifier:  oven,
-		Store:             sp.Store,
-		IdentityProviders: sp.IdentityProviders,
-		ACLManager:        aclManager,
+		AdminPassword:      sp.AdminPassword,
+		Location:           sp.Location,
+		MacaroonVerifier:   oven,
+		Store:              sp.Store,
+		IdentityProviders:  sp.IdentityProviders,
+		ACLManager:         aclManager,
+		RevokedMacaroons:   revokedMacaroons,
+		RevocationDuration: sp.Lifetimes.Max(),
 	})
 	if err != nil {
+		kr.Close()
 		return nil, errgo.Mask(err)
 	}
 
@@ -97,11 +139,16 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 				WriteError(ctx, w, err)
 				return nil, errgo.Mask(err)
 			}
+			if actor, ok := req.Context().Value(aclActorKey{}).(*string); ok {
+				*actor = ai.Identity.Id()
+			}
 			return ai.Identity.(aclstore.Identity), nil
 		},
 	})
+	aclHandler = auditACLHandler(aclHandler, sp.AuditStore)
 
 	if err := auth.SetAdminPublicKey(context.Background(), sp.AdminAgentPublicKey); err != nil {
+		kr.Close()
 		return nil, errgo.Mask(err)
 	}
 
@@ -112,17 +159,31 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 		WaitTimeout: sp.RendezvousTimeout,
 	})
 	if err != nil {
+		kr.Close()
 		return nil, errgo.Notef(err, "cannot create meeting place")
 	}
 
-	storeCollector := monitoring.StoreCollector{Store: sp.Store}
-	prometheus.Register(storeCollector)
+	var webhooks *webhook.Dispatcher
+	if len(sp.Webhooks) > 0 {
+		if sp.WebhookQueue == nil {
+			place.Close()
+			kr.Close()
+			return nil, errgo.Newf("webhooks configured without a webhook queue")
+		}
+		webhooks = webhook.NewDispatcher(webhook.Params{
+			Queue: sp.WebhookQueue,
+			Hooks: sp.Webhooks,
+		})
+	}
+
+	registerStoreMetrics(sp.Store)
 
 	// Create the HTTP server.
 	srv := &Server{
-		router:         httprouter.New(),
-		meetingPlace:   place,
-		storeCollector: storeCollector,
+		router:       httprouter.New(),
+		meetingPlace: place,
+		webhooks:     webhooks,
+		keyring:      kr,
 	}
 	// Disable the automatic rerouting in order to maintain
 	// compatibility. It might be worthwhile relaxing this in the
@@ -144,6 +205,8 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 			Oven:         oven,
 			Authorizer:   auth,
 			MeetingPlace: place,
+			Webhooks:     webhooks,
+			Keyring:      kr,
 		})
 		if err != nil {
 			return nil, errgo.Notef(err, "cannot create API %s", name)
@@ -152,14 +215,17 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 			srv.router.Handle(h.Method, h.Path, h.Handle)
 		}
 	}
+	srv.handler = tracing.Handler(srv.router)
 	return srv, nil
 }
 
 // Server serves the identity endpoints.
 type Server struct {
-	router         *httprouter.Router
-	meetingPlace   *meeting.Place
-	storeCollector monitoring.StoreCollector
+	router       *httprouter.Router
+	handler      http.Handler
+	meetingPlace *meeting.Place
+	webhooks     *webhook.Dispatcher
+	keyring      *keyring.Keyring
 }
 
 // ServeHTTP implements http.Handler.
@@ -176,14 +242,63 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Bakery-Protocol-Version, Macaroons, X-Requested-With, Content-Type")
 	w.Header().Set("Access-Control-Cache-Max-Age", "600")
-	srv.router.ServeHTTP(w, req)
+	srv.handler.ServeHTTP(w, req)
+}
+
+// Drain stops the work the server does in the background, delivering
+// webhooks and rotating keys, while it continues to serve requests. It
+// is used when the server has been replaced by a newly configured one
+// but must keep serving any rendezvous that it holds. Close must still
+// be called once the server is no longer required.
+func (s *Server) Drain() {
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	s.keyring.Close()
 }
 
 // Close  closes any resources held by this Handler.
 func (s *Server) Close() {
 	logger.Debugf("Closing Server")
 	s.meetingPlace.Close()
-	prometheus.Unregister(s.storeCollector)
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	s.keyring.Close()
+	unregisterStoreMetrics()
+}
+
+// storeMetrics holds the store collector that is registered on behalf
+// of all the servers that are running. The collector remains
+// registered until every server has been closed, so that replacing a
+// server with a newly configured one does not interrupt the metrics.
+var storeMetrics struct {
+	mu        sync.Mutex
+	servers   int
+	collector monitoring.StoreCollector
+}
+
+// registerStoreMetrics registers a collector for metrics about the
+// given store, unless one is already registered for another server.
+func registerStoreMetrics(st store.Store) {
+	storeMetrics.mu.Lock()
+	defer storeMetrics.mu.Unlock()
+	if storeMetrics.servers == 0 {
+		storeMetrics.collector = monitoring.StoreCollector{Store: st}
+		prometheus.Register(storeMetrics.collector)
+	}
+	storeMetrics.servers++
+}
+
+// unregisterStoreMetrics unregisters the store collector once the last
+// running server has been closed.
+func unregisterStoreMetrics() {
+	storeMetrics.mu.Lock()
+	defer storeMetrics.mu.Unlock()
+	storeMetrics.servers--
+	if storeMetrics.servers == 0 {
+		prometheus.Unregister(storeMetrics.collector)
+	}
 }
 
 // ServerParams contains configuration parameters for a server.
@@ -207,9 +322,23 @@ type ServerParams struct {
 	// AdminPassword holds the password for admin login.
 	AdminPassword string
 
-	// Key holds the keypair to use with the bakery service.
+	// Key holds the keypair to use with the bakery service. If this
+	// is nil then a key pair is generated and, if ProviderDataStore
+	// is set, stored so that it is used by every server.
 	Key *bakery.KeyPair
 
+	// KeyRotationPeriod holds the interval at which the key used to
+	// decrypt third-party caveats is replaced by a newly generated
+	// key. If this is zero, or ProviderDataStore is not set, then
+	// Key is always used.
+	KeyRotationPeriod time.Duration
+
+	// KeyGracePeriod holds the length of time after the key used to
+	// decrypt third-party caveats has been replaced for which the
+	// old key can still be used. If this is zero then
+	// KeyRotationPeriod is used.
+	KeyGracePeriod time.Duration
+
 	// Location holds a URL representing the externally accessible
 	// base URL of the service, without a trailing slash.
 	Location string
@@ -249,6 +378,29 @@ type ServerParams struct {
 
 	// ACLStore holds the ACLStore for the identity server.
 	ACLStore aclstore.ACLStore
+
+	// AuditStore holds the store that is used to record the audit
+	// log. If this is nil then no audit log will be recorded.
+	AuditStore store.AuditStore
+
+	// Webhooks holds the endpoints that are sent notifications of
+	// identity lifecycle events.
+	Webhooks []webhook.Hook
+
+	// WebhookQueue holds the queue used to store webhook deliveries
+	// until they have been completed. It must be set if Webhooks
+	// is not empty.
+	WebhookQueue webhook.Queue
+
+	// Lifetimes holds the lifetimes of the macaroons issued by the
+	// identity server. If this is nil then the default lifetimes are
+	// used.
+	Lifetimes *lifetime.Config
+
+	// Policy holds the policy that is evaluated when discharging
+	// third-party caveats. If this is nil then no policy is
+	// applied.
+	Policy *policy.Policy
 }
 
 type HandlerParams struct {
@@ -265,6 +417,33 @@ type HandlerParams struct {
 	// MeetingPlace contains the meeting place that should be used by
 	// handlers to complete rendezvous.
 	MeetingPlace *meeting.Place
+
+	// Webhooks contains the dispatcher that should be used by
+	// handlers to send webhook notifications. It may be nil, in
+	// which case no notifications will be sent.
+	Webhooks *webhook.Dispatcher
+
+	// Keyring contains the keys that should be used by handlers to
+	// decrypt third-party caveats.
+	Keyring *keyring.Keyring
+}
+
+// selfLocator is a bakery.ThirdPartyLocator that locates the identity
+// server itself using the current key in its keyring.
+type selfLocator struct {
+	location string
+	keyring  *keyring.Keyring
+}
+
+// ThirdPartyInfo implements bakery.ThirdPartyLocator.ThirdPartyInfo.
+func (l selfLocator) ThirdPartyInfo(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
+	if loc != l.location {
+		return bakery.ThirdPartyInfo{}, bakery.ErrNotFound
+	}
+	return bakery.ThirdPartyInfo{
+		PublicKey: l.keyring.Current().Public,
+		Version:   bakery.LatestVersion,
+	}, nil
 }
 
 // notFound is the handler that is called when a handler cannot be found
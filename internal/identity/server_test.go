@@ -13,7 +13,9 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
@@ -34,6 +36,7 @@ import (
 	"github.com/CanonicalLtd/candid/internal/identity"
 	"github.com/CanonicalLtd/candid/internal/v1"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 func TestServer(t *testing.T) {
@@ -125,6 +128,56 @@ func (s *serverSuite) TestNewServerWithVersions(c *qt.C) {
 	assertServesVersion(c, h, "version3")
 }
 
+func (s *serverSuite) TestDrainStopsWebhookDelivery(c *qt.C) {
+	hooksrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Errorf("unexpected webhook delivery")
+	}))
+	defer hooksrv.Close()
+
+	var webhooks *webhook.Dispatcher
+	h, err := identity.New(identity.ServerParams{
+		Store:        s.store.Store,
+		MeetingStore: s.store.MeetingStore,
+		ACLStore:     s.store.ACLStore,
+		WebhookQueue: s.store.WebhookQueue,
+		Webhooks: []webhook.Hook{{
+			URL:    hooksrv.URL,
+			Secret: "secret",
+		}},
+	}, map[string]identity.NewAPIHandlerFunc{
+		"version1": func(p identity.HandlerParams) ([]httprequest.Handler, error) {
+			webhooks = p.Webhooks
+			return []httprequest.Handler{{
+				Method: "GET",
+				Path:   "/version1/*path",
+				Handle: func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+					httprequest.WriteJSON(w, http.StatusOK, versionResponse{
+						Version: "version1",
+						Path:    req.URL.Path,
+					})
+				},
+			}}, nil
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer h.Close()
+	h.Drain()
+
+	// A drained server still serves requests, but events raised
+	// by them are left in the queue for other servers to deliver.
+	assertServesVersion(c, h, "version1")
+	webhooks.Notify(context.Background(), webhook.Event{
+		Type:     webhook.IdentityCreated,
+		Username: "bob",
+	})
+	time.Sleep(100 * time.Millisecond)
+	now := time.Now()
+	deliveries, err := s.store.WebhookQueue.Claim(context.Background(), now, now.Add(time.Minute), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(deliveries, qt.HasLen, 1)
+	c.Assert(deliveries[0].URL, qt.Equals, hooksrv.URL)
+}
+
 func (s *serverSuite) TestServerHasAccessControlAllowHeaders(c *qt.C) {
 	impl := map[string]identity.NewAPIHandlerFunc{
 		"/a": func(identity.HandlerParams) ([]httprequest.Handler, error) {
@@ -271,6 +324,10 @@ func assertDoesNotServeVersion(c *qt.C, h http.Handler, vers string) {
 	c.Assert(rec.Code, qt.Equals, http.StatusNotFound)
 }
 
+func TestFullServer(t *testing.T) {
+	qtsuite.Run(qt.New(t), &fullServerSuite{})
+}
+
 type fullServerSuite struct {
 	store *candidtest.Store
 	srv   *candidtest.Server
@@ -338,6 +395,45 @@ func (s *fullServerSuite) TestACL(c *qt.C) {
 	c.Assert(acl, qt.DeepEquals, []string{"test-2"})
 }
 
+func (s *fullServerSuite) TestACLChangeAudited(c *qt.C) {
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: s.srv.URL + "/acl",
+		Doer:    s.srv.AdminClient(),
+	})
+	_, err := client.Get(context.Background(), "read-user")
+	c.Assert(err, qt.Equals, nil)
+	err = client.Add(context.Background(), "read-user", []string{"test-1"})
+	c.Assert(err, qt.Equals, nil)
+	err = client.Add(context.Background(), "no-such-acl", []string{"test-1"})
+	c.Assert(err, qt.ErrorMatches, `.*ACL not found`)
+
+	entries, err := s.store.AuditStore.FindAuditEntries(context.Background(), store.AuditFilter{})
+	c.Assert(err, qt.Equals, nil)
+	var aclEntries []store.AuditEntry
+	for _, e := range entries {
+		if e.Type == store.AuditACLChange {
+			aclEntries = append(aclEntries, e)
+		}
+	}
+	c.Assert(aclEntries, qt.HasLen, 1)
+	c.Assert(aclEntries[0].Actor, qt.Equals, auth.AdminUsername)
+	c.Assert(aclEntries[0].Detail, qt.Equals, `POST /acl/read-user {"add":["test-1"]}`)
+}
+
+func (s *fullServerSuite) TestACLChangeBodyTooLarge(c *qt.C) {
+	body := strings.NewReader(`{"add":["` + strings.Repeat("a", 2*1024*1024) + `"]}`)
+	resp, err := http.Post(s.srv.URL+"/acl/read-user", "application/json", body)
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	var perr params.Error
+	err = json.Unmarshal(buf, &perr)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(perr.Message, qt.Matches, `cannot read request body: .*too large`)
+}
+
 func (s *fullServerSuite) TestACLMACARAQResponse(c *qt.C) {
 	resp, err := http.Get(s.srv.URL + "/acl/read-user")
 	c.Assert(err, qt.Equals, nil)
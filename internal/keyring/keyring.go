@@ -0,0 +1,336 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package keyring manages the key pairs that the identity server uses
+// to decrypt the third-party caveats addressed to it, including their
+// persistence and periodic rotation.
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/tomb.v2"
+)
+
+var logger = loggo.GetLogger("candid.internal.keyring")
+
+// Clock holds the clock used by the keyring. It may be replaced in
+// tests.
+var Clock clock.Clock = clock.WallClock
+
+const (
+	// serverKey holds the key in the key-value store under which a
+	// generated server key pair is stored.
+	serverKey = "server-key"
+
+	// ringKey holds the key in the key-value store under which the
+	// rotated discharge key pairs are stored.
+	ringKey = "discharge-keys"
+
+	// refreshInterval holds the interval at which the keyring
+	// checks whether the keys need rotating, or have been rotated
+	// by another server.
+	refreshInterval = time.Minute
+
+	// publicKeyPrefixLen holds the number of bytes of the public key
+	// of the third party that are included in version 2 and later
+	// caveat ids.
+	publicKeyPrefixLen = 4
+)
+
+// ServerKey returns the key pair held in the given store. If the store
+// does not hold a key pair then a new one is generated and stored, so
+// that every server sharing the store uses the same key.
+func ServerKey(ctx context.Context, st simplekv.Store) (*bakery.KeyPair, error) {
+	key, err := getServerKey(ctx, st)
+	if errgo.Cause(err) != simplekv.ErrNotFound {
+		return key, errgo.Mask(err)
+	}
+	key, err = bakery.GenerateKey()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot generate key")
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	err = simplekv.SetKeyOnce(ctx, st, serverKey, data, time.Time{})
+	if errgo.Cause(err) == simplekv.ErrDuplicateKey {
+		// Another server stored its key first.
+		key, err = getServerKey(ctx, st)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return key, nil
+}
+
+func getServerKey(ctx context.Context, st simplekv.Store) (*bakery.KeyPair, error) {
+	data, err := st.Get(ctx, serverKey)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(simplekv.ErrNotFound))
+	}
+	var key bakery.KeyPair
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal server key")
+	}
+	return &key, nil
+}
+
+// Params holds the parameters for a new Keyring.
+type Params struct {
+	// Store holds the store in which rotated keys are persisted.
+	// Keys are only rotated if this is set.
+	Store simplekv.Store
+
+	// Key holds the initial key pair of the keyring.
+	Key *bakery.KeyPair
+
+	// RotationPeriod holds the length of time for which a key is
+	// the current key before it is replaced by a newly generated
+	// key. If this is zero then keys are not rotated.
+	RotationPeriod time.Duration
+
+	// GracePeriod holds the length of time after a key has been
+	// replaced for which caveats encrypted with it will still be
+	// decrypted. If this is zero then RotationPeriod is used.
+	GracePeriod time.Duration
+}
+
+// A Keyring holds the key pairs used to decrypt third-party caveats.
+// The most recently generated key pair is the current key, which is
+// published to services adding third-party caveats. Previous keys are
+// retained until their grace period has expired.
+type Keyring struct {
+	p        Params
+	rotating bool
+	tomb     tomb.Tomb
+
+	mu   sync.Mutex
+	keys []key
+}
+
+// key holds a key pair in the keyring and the time it became the
+// current key.
+type key struct {
+	KeyPair *bakery.KeyPair `json:"key"`
+	Created time.Time       `json:"created"`
+}
+
+// New returns a new Keyring. If keys are to be rotated then a
+// goroutine is started to rotate them, which is stopped by Close.
+func New(ctx context.Context, p Params) (*Keyring, error) {
+	k := &Keyring{
+		p: p,
+		keys: []key{{
+			KeyPair: p.Key,
+			Created: Clock.Now(),
+		}},
+	}
+	if p.Store == nil || p.RotationPeriod == 0 {
+		return k, nil
+	}
+	if k.p.GracePeriod == 0 {
+		k.p.GracePeriod = p.RotationPeriod
+	}
+	if err := k.refresh(ctx); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	k.rotating = true
+	k.tomb.Go(k.run)
+	return k, nil
+}
+
+// Close stops any key rotation.
+func (k *Keyring) Close() {
+	if !k.rotating {
+		return
+	}
+	k.tomb.Kill(nil)
+	k.tomb.Wait()
+}
+
+// Current returns the current key pair.
+func (k *Keyring) Current() *bakery.KeyPair {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keys[0].KeyPair
+}
+
+// Keys returns all the key pairs currently held in the keyring, starting
+// with the current key pair.
+func (k *Keyring) Keys() []*bakery.KeyPair {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys := make([]*bakery.KeyPair, len(k.keys))
+	for i, key := range k.keys {
+		keys[i] = key.KeyPair
+	}
+	return keys
+}
+
+// KeyForCaveat returns the key pair that the given encrypted
+// third-party caveat is addressed to. If the caveat is not addressed to
+// any key in the keyring then the current key pair is returned.
+func (k *Keyring) KeyForCaveat(caveat []byte) *bakery.KeyPair {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	pk := caveatPublicKey(caveat)
+	for _, key := range k.keys {
+		if len(pk) > 0 && bytes.HasPrefix(key.KeyPair.Public.Key[:], pk) {
+			return key.KeyPair
+		}
+	}
+	return k.keys[0].KeyPair
+}
+
+// caveatPublicKey returns as much of the public key of the third party
+// as is recorded in the given encrypted caveat.
+func caveatPublicKey(caveat []byte) []byte {
+	if len(caveat) == 0 {
+		return nil
+	}
+	switch caveat[0] {
+	case byte(bakery.Version2), byte(bakery.Version3):
+		if len(caveat) < 1+publicKeyPrefixLen {
+			return nil
+		}
+		return caveat[1 : 1+publicKeyPrefixLen]
+	case 'e':
+		// Version 1 caveats are base64-encoded JSON objects.
+		data, err := base64.StdEncoding.DecodeString(string(caveat))
+		if err != nil {
+			return nil
+		}
+		var v struct {
+			ThirdPartyPublicKey *bakery.PublicKey
+		}
+		if err := json.Unmarshal(data, &v); err != nil || v.ThirdPartyPublicKey == nil {
+			return nil
+		}
+		return v.ThirdPartyPublicKey.Key[:]
+	}
+	return nil
+}
+
+func (k *Keyring) run() error {
+	for {
+		select {
+		case <-Clock.After(refreshInterval):
+		case <-k.tomb.Dying():
+			return nil
+		}
+		if err := k.refresh(context.Background()); err != nil {
+			logger.Errorf("cannot refresh keys: %s", err)
+		}
+	}
+}
+
+// refresh reads the keys from the store, rotating the current key and
+// removing keys that are past their grace period if necessary.
+func (k *Keyring) refresh(ctx context.Context) error {
+	ctx, close := k.p.Store.Context(ctx)
+	defer close()
+	now := Clock.Now()
+	keys, err := k.read(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if k.needsUpdate(keys, now) {
+		err := k.p.Store.Update(ctx, ringKey, time.Time{}, func(old []byte) ([]byte, error) {
+			keys = nil
+			if old != nil {
+				if err := json.Unmarshal(old, &keys); err != nil {
+					return nil, errgo.Notef(err, "cannot unmarshal keys")
+				}
+			}
+			if !k.needsUpdate(keys, now) {
+				// Another server has already updated the keys.
+				return old, nil
+			}
+			newKeys, err := k.update(keys, now)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			keys = newKeys
+			return json.Marshal(keys)
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !bytes.Equal(k.keys[0].KeyPair.Public.Key[:], keys[0].KeyPair.Public.Key[:]) {
+		logger.Infof("current key is now %s", keys[0].KeyPair.Public)
+	}
+	k.keys = keys
+	return nil
+}
+
+// read reads the keys held in the store.
+func (k *Keyring) read(ctx context.Context) ([]key, error) {
+	data, err := k.p.Store.Get(ctx, ringKey)
+	if errgo.Cause(err) == simplekv.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var keys []key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal keys")
+	}
+	return keys, nil
+}
+
+// needsUpdate reports whether the given keys need to be updated at the
+// given time.
+func (k *Keyring) needsUpdate(keys []key, now time.Time) bool {
+	if len(keys) == 0 || !now.Before(keys[0].Created.Add(k.p.RotationPeriod)) {
+		return true
+	}
+	return len(keys) > 1 && !now.Before(keys[len(keys)-2].Created.Add(k.p.GracePeriod))
+}
+
+// update returns the keys that should be held at the given time. If
+// there are no keys then the keyring is started with the initial key
+// pair, if the current key has expired a new key pair is generated,
+// and any keys past their grace period are removed.
+func (k *Keyring) update(keys []key, now time.Time) ([]key, error) {
+	if len(keys) == 0 {
+		keys = []key{{
+			KeyPair: k.p.Key,
+			Created: now,
+		}}
+	}
+	if !now.Before(keys[0].Created.Add(k.p.RotationPeriod)) {
+		kp, err := bakery.GenerateKey()
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot generate key")
+		}
+		keys = append([]key{{
+			KeyPair: kp,
+			Created: now,
+		}}, keys...)
+	}
+	// A key is retired once the key that replaced it has been
+	// current for the grace period.
+	for i := 1; i < len(keys); i++ {
+		if !now.Before(keys[i-1].Created.Add(k.p.GracePeriod)) {
+			keys = keys[:i]
+			break
+		}
+	}
+	return keys, nil
+}
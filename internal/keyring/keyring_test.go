@@ -0,0 +1,163 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package keyring_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+
+	"github.com/CanonicalLtd/candid/internal/keyring"
+)
+
+var epoch = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// waitIdle waits until the keyring is waiting to refresh its keys.
+func waitIdle(c *qt.C, clock *testclock.Clock) {
+	select {
+	case <-clock.Alarms():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for keyring")
+	}
+}
+
+// advance advances the given clock by the given duration and waits
+// for the keyring to refresh its keys.
+func advance(c *qt.C, clock *testclock.Clock, d time.Duration) {
+	clock.Advance(d)
+	waitIdle(c, clock)
+}
+
+// newKey returns a newly generated key pair.
+func newKey(c *qt.C) *bakery.KeyPair {
+	key, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	return key
+}
+
+// encryptedCaveat returns a third-party caveat encrypted with the
+// given public key using the given version.
+func encryptedCaveat(c *qt.C, key *bakery.PublicKey, version bakery.Version) []byte {
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo("candid", bakery.ThirdPartyInfo{
+		PublicKey: *key,
+		Version:   version,
+	})
+	m, err := bakery.NewMacaroon([]byte("root key"), []byte("id"), "", version, nil)
+	c.Assert(err, qt.Equals, nil)
+	err = m.AddCaveat(context.Background(), checkers.Caveat{
+		Location:  "candid",
+		Condition: "is-authenticated-user",
+	}, newKey(c), locator)
+	c.Assert(err, qt.Equals, nil)
+	return m.M().Caveats()[0].Id
+}
+
+func TestStaticKey(t *testing.T) {
+	c := qt.New(t)
+	key := newKey(c)
+	kr, err := keyring.New(context.Background(), keyring.Params{
+		Key: key,
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer kr.Close()
+	c.Assert(kr.Current(), qt.Equals, key)
+	c.Assert(kr.Keys(), qt.DeepEquals, []*bakery.KeyPair{key})
+	c.Assert(kr.KeyForCaveat(encryptedCaveat(c, &key.Public, bakery.Version2)), qt.Equals, key)
+	c.Assert(kr.KeyForCaveat(encryptedCaveat(c, &newKey(c).Public, bakery.Version2)), qt.Equals, key)
+}
+
+func TestServerKey(t *testing.T) {
+	c := qt.New(t)
+	st := memsimplekv.NewStore()
+	key1, err := keyring.ServerKey(context.Background(), st)
+	c.Assert(err, qt.Equals, nil)
+	key2, err := keyring.ServerKey(context.Background(), st)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(key2, qt.DeepEquals, key1)
+
+	key3, err := keyring.ServerKey(context.Background(), memsimplekv.NewStore())
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(key3.Public, qt.Not(qt.Equals), key1.Public)
+}
+
+var versions = []bakery.Version{bakery.Version1, bakery.Version2}
+
+func TestRotation(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&keyring.Clock, clock)
+	key0 := newKey(c)
+	kr, err := keyring.New(context.Background(), keyring.Params{
+		Store:          memsimplekv.NewStore(),
+		Key:            key0,
+		RotationPeriod: 24 * time.Hour,
+		GracePeriod:    12 * time.Hour,
+	})
+	c.Assert(err, qt.Equals, nil)
+	defer kr.Close()
+	waitIdle(c, clock)
+	c.Assert(kr.Current(), qt.DeepEquals, key0)
+
+	advance(c, clock, 23*time.Hour)
+	c.Assert(kr.Current(), qt.DeepEquals, key0)
+
+	advance(c, clock, time.Hour)
+	key1 := kr.Current()
+	c.Assert(key1.Public, qt.Not(qt.Equals), key0.Public)
+	c.Assert(kr.Keys(), qt.DeepEquals, []*bakery.KeyPair{key1, key0})
+	for _, v := range versions {
+		c.Assert(kr.KeyForCaveat(encryptedCaveat(c, &key0.Public, v)), qt.DeepEquals, key0)
+		c.Assert(kr.KeyForCaveat(encryptedCaveat(c, &key1.Public, v)), qt.DeepEquals, key1)
+	}
+
+	// Once the grace period has expired the old key is no longer
+	// used.
+	advance(c, clock, 12*time.Hour)
+	c.Assert(kr.Current(), qt.DeepEquals, key1)
+	c.Assert(kr.Keys(), qt.DeepEquals, []*bakery.KeyPair{key1})
+	for _, v := range versions {
+		c.Assert(kr.KeyForCaveat(encryptedCaveat(c, &key0.Public, v)), qt.DeepEquals, key1)
+	}
+}
+
+func TestRotationSharedStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&keyring.Clock, clock)
+	key0 := newKey(c)
+	p := keyring.Params{
+		Store:          memsimplekv.NewStore(),
+		Key:            key0,
+		RotationPeriod: 24 * time.Hour,
+	}
+	kr1, err := keyring.New(context.Background(), p)
+	c.Assert(err, qt.Equals, nil)
+	defer kr1.Close()
+
+	// A server started with a different initial key uses the keys
+	// already in the store.
+	p.Key = newKey(c)
+	kr2, err := keyring.New(context.Background(), p)
+	c.Assert(err, qt.Equals, nil)
+	defer kr2.Close()
+	c.Assert(kr2.Current(), qt.DeepEquals, kr1.Current())
+
+	// Both servers agree on the rotated key.
+	waitIdle(c, clock)
+	waitIdle(c, clock)
+	clock.Advance(24 * time.Hour)
+	waitIdle(c, clock)
+	waitIdle(c, clock)
+	c.Assert(kr1.Current().Public, qt.Not(qt.Equals), key0.Public)
+	c.Assert(kr2.Current(), qt.DeepEquals, kr1.Current())
+}
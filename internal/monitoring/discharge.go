@@ -0,0 +1,49 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dischargeCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "candid",
+		Subsystem: "discharger",
+		Name:      "discharges_total",
+		Help:      "The number of third-party caveat discharge attempts.",
+	}, []string{"condition", "result", "error_code"})
+
+	loginCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "candid",
+		Subsystem: "login",
+		Name:      "logins_total",
+		Help:      "The number of login attempts through each identity provider.",
+	}, []string{"idp", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(dischargeCount)
+	prometheus.MustRegister(loginCount)
+}
+
+// DischargeCompleted records the result of an attempt to discharge a
+// third-party caveat with the given condition. The errorCode should be
+// empty if the discharge succeeded.
+func DischargeCompleted(condition, errorCode string) {
+	dischargeCount.WithLabelValues(condition, result(errorCode == ""), errorCode).Inc()
+}
+
+// LoginCompleted records the result of an attempt to log in through
+// the identity provider with the given name.
+func LoginCompleted(idp string, success bool) {
+	loginCount.WithLabelValues(idp, result(success)).Inc()
+}
+
+func result(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
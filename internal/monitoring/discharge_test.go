@@ -0,0 +1,50 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+)
+
+func TestDischargeCompleted(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	success := monitoring.DischargeCount.WithLabelValues("is-authenticated-user", "success", "")
+	failure := monitoring.DischargeCount.WithLabelValues("is-authenticated-user", "failure", "interaction required")
+	nsuccess := testutil.ToFloat64(success)
+	nfailure := testutil.ToFloat64(failure)
+
+	monitoring.DischargeCompleted("is-authenticated-user", "")
+	c.Assert(testutil.ToFloat64(success), qt.Equals, nsuccess+1)
+	c.Assert(testutil.ToFloat64(failure), qt.Equals, nfailure)
+
+	monitoring.DischargeCompleted("is-authenticated-user", "interaction required")
+	monitoring.DischargeCompleted("is-authenticated-user", "interaction required")
+	c.Assert(testutil.ToFloat64(success), qt.Equals, nsuccess+1)
+	c.Assert(testutil.ToFloat64(failure), qt.Equals, nfailure+2)
+}
+
+func TestLoginCompleted(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	success := monitoring.LoginCount.WithLabelValues("test", "success")
+	failure := monitoring.LoginCount.WithLabelValues("test", "failure")
+	nsuccess := testutil.ToFloat64(success)
+	nfailure := testutil.ToFloat64(failure)
+
+	monitoring.LoginCompleted("test", true)
+	c.Assert(testutil.ToFloat64(success), qt.Equals, nsuccess+1)
+	c.Assert(testutil.ToFloat64(failure), qt.Equals, nfailure)
+
+	monitoring.LoginCompleted("test", false)
+	c.Assert(testutil.ToFloat64(success), qt.Equals, nsuccess+1)
+	c.Assert(testutil.ToFloat64(failure), qt.Equals, nfailure+1)
+}
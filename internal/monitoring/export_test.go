@@ -0,0 +1,10 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring
+
+var (
+	DischargeCount     = dischargeCount
+	LoginCount         = loginCount
+	IDPRequestDuration = idpRequestDuration
+)
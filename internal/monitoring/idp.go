@@ -0,0 +1,60 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CanonicalLtd/candid/internal/tracing"
+)
+
+var (
+	idpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "candid",
+		Subsystem: "idp",
+		Name:      "request_duration_seconds",
+		Help:      "The duration of a request from an identity provider to its upstream service.",
+	}, []string{"idp", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(idpRequestDuration)
+}
+
+// An IDPRequest records a request from an identity provider to its
+// upstream service.
+type IDPRequest struct {
+	idp       string
+	operation string
+	startTime time.Time
+	span      trace.Span
+}
+
+// StartIDPRequest starts recording a request from the named identity
+// provider to its upstream service. The operation names the type of
+// request, for example "bind" for an LDAP bind. The returned context
+// should be used when making the request, and End must be called once
+// the request has completed.
+func StartIDPRequest(ctx context.Context, idp, operation string) (context.Context, *IDPRequest) {
+	ctx, span := tracing.Start(ctx, "idp."+operation,
+		attribute.String("candid.idp", idp),
+	)
+	return ctx, &IDPRequest{
+		idp:       idp,
+		operation: operation,
+		startTime: time.Now(),
+		span:      span,
+	}
+}
+
+// End records the completion of the request with the given error.
+func (r *IDPRequest) End(err error) {
+	idpRequestDuration.WithLabelValues(r.idp, r.operation).Observe(float64(time.Since(r.startTime)) / float64(time.Second))
+	tracing.End(r.span, err)
+}
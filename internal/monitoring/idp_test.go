@@ -0,0 +1,46 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+)
+
+func TestIDPRequest(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	before := idpRequestSample(c, "test", "bind")
+
+	_, req := monitoring.StartIDPRequest(context.Background(), "test", "bind")
+	time.Sleep(10 * time.Millisecond)
+	req.End(nil)
+	_, req = monitoring.StartIDPRequest(context.Background(), "test", "bind")
+	req.End(errgo.New("bind failed"))
+
+	after := idpRequestSample(c, "test", "bind")
+	c.Assert(after.GetSampleCount(), qt.Equals, before.GetSampleCount()+2)
+	if d := after.GetSampleSum() - before.GetSampleSum(); d < 0.01 {
+		c.Fatalf("unexpected total duration %vs", d)
+	}
+}
+
+// idpRequestSample returns the current state of the IDP request
+// duration histogram with the given labels. The testutil package
+// cannot be used here as it only supports counters and gauges.
+func idpRequestSample(c *qt.C, idp, operation string) *dto.Histogram {
+	var m dto.Metric
+	err := monitoring.IDPRequestDuration.WithLabelValues(idp, operation).(prometheus.Metric).Write(&m)
+	c.Assert(err, qt.Equals, nil)
+	return m.GetHistogram()
+}
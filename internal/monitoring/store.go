@@ -5,10 +5,13 @@ package monitoring
 
 import (
 	"context"
+	"time"
 
 	"github.com/juju/loggo"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/CanonicalLtd/candid/internal/tracing"
 	"github.com/CanonicalLtd/candid/store"
 )
 
@@ -41,3 +44,147 @@ func (c StoreCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(storeIdentiesDesc, prometheus.GaugeValue, float64(count), provider)
 	}
 }
+
+var storeOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "candid",
+	Subsystem: "store",
+	Name:      "operation_duration_seconds",
+	Help:      "The duration of a store operation.",
+}, []string{"method"})
+
+func init() {
+	prometheus.MustRegister(storeOperationDuration)
+}
+
+// InstrumentStore returns a store.Store that records the duration of
+// each operation performed on the given store, and a trace span for
+// each operation.
+func InstrumentStore(st store.Store) store.Store {
+	return instrumentedStore{st}
+}
+
+type instrumentedStore struct {
+	store store.Store
+}
+
+// A storeOperation records a single operation on an instrumented store.
+type storeOperation struct {
+	method    string
+	startTime time.Time
+	span      trace.Span
+}
+
+func startStoreOperation(ctx context.Context, method string) (context.Context, storeOperation) {
+	ctx, span := tracing.Start(ctx, "store."+method)
+	return ctx, storeOperation{
+		method:    method,
+		startTime: time.Now(),
+		span:      span,
+	}
+}
+
+func (op storeOperation) end(err error) {
+	storeOperationDuration.WithLabelValues(op.method).Observe(float64(time.Since(op.startTime)) / float64(time.Second))
+	tracing.End(op.span, err)
+}
+
+// Context implements store.Store.Context.
+func (s instrumentedStore) Context(ctx context.Context) (context.Context, func()) {
+	return s.store.Context(ctx)
+}
+
+// Identity implements store.Store.Identity.
+func (s instrumentedStore) Identity(ctx context.Context, identity *store.Identity) error {
+	ctx, op := startStoreOperation(ctx, "Identity")
+	err := s.store.Identity(ctx, identity)
+	op.end(err)
+	return err
+}
+
+// FindIdentities implements store.Store.FindIdentities.
+func (s instrumentedStore) FindIdentities(ctx context.Context, ref *store.Identity, filter store.Filter, sort []store.Sort, skip, limit int) ([]store.Identity, error) {
+	ctx, op := startStoreOperation(ctx, "FindIdentities")
+	v, err := s.store.FindIdentities(ctx, ref, filter, sort, skip, limit)
+	op.end(err)
+	return v, err
+}
+
+// CountIdentities implements store.Store.CountIdentities.
+func (s instrumentedStore) CountIdentities(ctx context.Context, ref *store.Identity, filter store.Filter) (int, error) {
+	ctx, op := startStoreOperation(ctx, "CountIdentities")
+	v, err := s.store.CountIdentities(ctx, ref, filter)
+	op.end(err)
+	return v, err
+}
+
+// UpdateIdentity implements store.Store.UpdateIdentity.
+func (s instrumentedStore) UpdateIdentity(ctx context.Context, identity *store.Identity, update store.Update) error {
+	ctx, op := startStoreOperation(ctx, "UpdateIdentity")
+	err := s.store.UpdateIdentity(ctx, identity, update)
+	op.end(err)
+	return err
+}
+
+// DeleteIdentity implements store.Store.DeleteIdentity.
+func (s instrumentedStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	ctx, op := startStoreOperation(ctx, "DeleteIdentity")
+	err := s.store.DeleteIdentity(ctx, identity)
+	op.end(err)
+	return err
+}
+
+// IdentityCounts implements store.Store.IdentityCounts.
+func (s instrumentedStore) IdentityCounts(ctx context.Context) (map[string]int, error) {
+	ctx, op := startStoreOperation(ctx, "IdentityCounts")
+	v, err := s.store.IdentityCounts(ctx)
+	op.end(err)
+	return v, err
+}
+
+// IdentityGroups implements store.Store.IdentityGroups.
+func (s instrumentedStore) IdentityGroups(ctx context.Context) ([]string, error) {
+	ctx, op := startStoreOperation(ctx, "IdentityGroups")
+	v, err := s.store.IdentityGroups(ctx)
+	op.end(err)
+	return v, err
+}
+
+// Group implements store.Store.Group.
+func (s instrumentedStore) Group(ctx context.Context, group *store.Group) error {
+	ctx, op := startStoreOperation(ctx, "Group")
+	err := s.store.Group(ctx, group)
+	op.end(err)
+	return err
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s instrumentedStore) FindGroups(ctx context.Context) ([]store.Group, error) {
+	ctx, op := startStoreOperation(ctx, "FindGroups")
+	v, err := s.store.FindGroups(ctx)
+	op.end(err)
+	return v, err
+}
+
+// CreateGroup implements store.Store.CreateGroup.
+func (s instrumentedStore) CreateGroup(ctx context.Context, group *store.Group) error {
+	ctx, op := startStoreOperation(ctx, "CreateGroup")
+	err := s.store.CreateGroup(ctx, group)
+	op.end(err)
+	return err
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s instrumentedStore) UpdateGroup(ctx context.Context, group *store.Group) error {
+	ctx, op := startStoreOperation(ctx, "UpdateGroup")
+	err := s.store.UpdateGroup(ctx, group)
+	op.end(err)
+	return err
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s instrumentedStore) RemoveGroup(ctx context.Context, group *store.Group) error {
+	ctx, op := startStoreOperation(ctx, "RemoveGroup")
+	err := s.store.RemoveGroup(ctx, group)
+	op.end(err)
+	return err
+}
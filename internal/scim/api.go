@@ -0,0 +1,182 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package scim implements a SCIM 2.0 (RFC 7643 and RFC 7644)
+// provisioning endpoint on top of the identity store.
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/juju/loggo"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/CanonicalLtd/candid/internal/auth/httpauth"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/monitoring"
+)
+
+var logger = loggo.GetLogger("candid.internal.scim")
+
+// contentType is the media type of SCIM requests and responses.
+const contentType = "application/scim+json"
+
+// reqServer is the httprequest.Server used for SCIM requests. Errors
+// are written in the form specified in RFC 7644 section 3.12.
+var reqServer = httprequest.Server{
+	ErrorWriter: writeError,
+}
+
+// NewAPIHandler is an identity.NewAPIHandlerFunc.
+func NewAPIHandler(params identity.HandlerParams) ([]httprequest.Handler, error) {
+	handlers := reqServer.Handlers(new(params))
+	for i := range handlers {
+		handlers[i].Handle = acceptSCIMContent(handlers[i].Handle)
+	}
+	return handlers, nil
+}
+
+// acceptSCIMContent wraps the given handler so that request bodies with
+// the SCIM media type are accepted as JSON.
+func acceptSCIMContent(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == contentType {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		h(w, req, p)
+	}
+}
+
+// new returns a function that will generate a new instance of the SCIM
+// API handler for a request.
+func new(hParams identity.HandlerParams) func(p httprequest.Params, arg interface{}) (*handler, context.Context, error) {
+	reqAuth := httpauth.New(hParams.Oven, hParams.Authorizer)
+	return func(p httprequest.Params, arg interface{}) (*handler, context.Context, error) {
+		ctx, close := hParams.Store.Context(p.Context)
+		hnd := &handler{
+			params: hParams,
+			monReq: monitoring.NewRequest(&p),
+			close:  close,
+		}
+		op := opForRequest(arg)
+		logger.Debugf("opForRequest %#v -> %#v", arg, op)
+		if op.Entity == "" {
+			hnd.Close()
+			return nil, nil, params.ErrUnauthorized
+		}
+		if _, err := reqAuth.Auth(ctx, p.Request, op); err != nil {
+			hnd.Close()
+			return nil, nil, errgo.Mask(err, errgo.Any)
+		}
+		return hnd, ctx, nil
+	}
+}
+
+// A handler is a handler for a request to a /scim endpoint.
+type handler struct {
+	params identity.HandlerParams
+
+	monReq monitoring.Request
+	close  func()
+}
+
+// Close implements io.Closer. httprequest will automatically call this
+// once a request is complete.
+func (h *handler) Close() error {
+	if h.close != nil {
+		h.close()
+		h.close = nil
+	}
+	h.monReq.ObserveMetric()
+	return nil
+}
+
+// location returns the URL of the given SCIM resource.
+func (h *handler) location(resourceType, id string) string {
+	return h.params.Location + "/scim/v2/" + resourceType + "s/" + url.PathEscape(id)
+}
+
+// writeResponse writes v as a SCIM JSON response with the given status.
+func writeResponse(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal response")
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(data)
+	return errgo.Mask(err)
+}
+
+// writeError writes the given error as a SCIM error response. Errors
+// from the bakery are written as the bakery expects so that agent
+// clients can still acquire macaroons.
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	if _, ok := errgo.Cause(err).(*httpbakery.Error); ok {
+		identity.WriteError(ctx, w, err)
+		return
+	}
+	status, resp := errorResponse(err)
+	if status == http.StatusInternalServerError {
+		logger.Errorf("Internal Server Error: %s (%s)", err, errgo.Details(err))
+	}
+	if err := writeResponse(w, status, resp); err != nil {
+		logger.Errorf("cannot write error response: %s", err)
+	}
+}
+
+// newListResponse creates a ListResponse for a query that matched total
+// resources. The returned start and end values are the indexes of the
+// matched resources that should be included in the response.
+func newListResponse(r *listRequest, total int) (_ *ListResponse, start, end int, _ error) {
+	startIndex := 1
+	if r.StartIndex != "" {
+		n, err := strconv.Atoi(r.StartIndex)
+		if err != nil {
+			return nil, 0, 0, errgo.WithCausef(nil, errInvalidValue, "invalid startIndex %q", r.StartIndex)
+		}
+		// RFC 7644 section 3.4.2.4 specifies that values less than
+		// 1 are interpreted as 1.
+		if n > 1 {
+			startIndex = n
+		}
+	}
+	count := total
+	if r.Count != "" {
+		n, err := strconv.Atoi(r.Count)
+		if err != nil {
+			return nil, 0, 0, errgo.WithCausef(nil, errInvalidValue, "invalid count %q", r.Count)
+		}
+		// Negative values are interpreted as 0.
+		if n < count {
+			count = n
+		}
+		if count < 0 {
+			count = 0
+		}
+	}
+	start = startIndex - 1
+	if start > total {
+		start = total
+	}
+	end = start + count
+	if end > total {
+		end = total
+	}
+	return &ListResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: end - start,
+		Resources:    []interface{}{},
+	}, start, end, nil
+}
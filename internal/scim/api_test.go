@@ -0,0 +1,184 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/scim"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const adminPassword = "open sesame"
+
+func TestAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &apiSuite{})
+}
+
+type apiSuite struct {
+	store *candidtest.Store
+	srv   *candidtest.Server
+	finds *findRecorder
+}
+
+func (s *apiSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	s.finds = &findRecorder{Store: sp.Store}
+	sp.Store = s.finds
+	sp.AdminPassword = adminPassword
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"scim":       scim.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+}
+
+func (s *apiSuite) TestServiceProviderConfig(c *qt.C) {
+	var conf scim.ServiceProviderConfig
+	resp := s.srv.Get(c, "/scim/v2/ServiceProviderConfig")
+	assertResponse(c, resp, http.StatusOK, &conf)
+	c.Assert(conf.Patch.Supported, qt.Equals, true)
+	c.Assert(conf.Filter.Supported, qt.Equals, true)
+	c.Assert(conf.AuthenticationSchemes[0].Type, qt.Equals, "oauthbearertoken")
+}
+
+func (s *apiSuite) TestUnauthenticated(c *qt.C) {
+	req, err := http.NewRequest("GET", "/scim/v2/Users", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.SetBasicAuth("admin", "bad password")
+	var scimErr scim.Error
+	assertResponse(c, s.srv.Do(c, req), http.StatusUnauthorized, &scimErr)
+	c.Assert(scimErr.Status, qt.Equals, "401")
+}
+
+func (s *apiSuite) TestNotAuthorized(c *qt.C) {
+	s.srv.CreateAgent(c, "bob@candid")
+	var token candidparams.BearerTokenResponse
+	err := s.srv.AdminIdentityClient().Client.Call(s.srv.Ctx, &candidparams.BearerTokenRequest{
+		Username: "bob@candid",
+	}, &token)
+	c.Assert(err, qt.Equals, nil)
+
+	req, err := http.NewRequest("GET", "/scim/v2/Users", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	var scimErr scim.Error
+	assertResponse(c, s.srv.Do(c, req), http.StatusUnauthorized, &scimErr)
+	c.Assert(scimErr.Detail, qt.Matches, `.*permission denied.*`)
+}
+
+func (s *apiSuite) TestBearerToken(c *qt.C) {
+	var token candidparams.BearerTokenResponse
+	err := s.srv.AdminIdentityClient().Client.Call(s.srv.Ctx, &candidparams.BearerTokenRequest{
+		Username: "admin@candid",
+	}, &token)
+	c.Assert(err, qt.Equals, nil)
+	s.srv.CreateUser(c, "bob")
+
+	req, err := http.NewRequest("GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "bob"`), nil)
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	var list scim.ListResponse
+	assertResponse(c, s.srv.Do(c, req), http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 1)
+}
+
+func (s *apiSuite) TestAgentAuthentication(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	req, err := http.NewRequest("GET", s.srv.URL+"/scim/v2/Users?filter="+url.QueryEscape(`userName eq "bob"`), nil)
+	c.Assert(err, qt.Equals, nil)
+	resp, err := s.srv.AdminClient().Do(req)
+	c.Assert(err, qt.Equals, nil)
+	var list scim.ListResponse
+	assertResponse(c, resp, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 1)
+}
+
+func (s *apiSuite) TestNotFound(c *qt.C) {
+	var scimErr scim.Error
+	s.do(c, "GET", "/scim/v2/Users/1234", nil, http.StatusNotFound, &scimErr)
+	c.Assert(scimErr, qt.DeepEquals, scim.Error{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		Status:  "404",
+		Detail:  `identity "1234" not found`,
+	})
+}
+
+// do performs a SCIM request against the server, authenticating as the
+// admin user.
+func (s *apiSuite) do(c *qt.C, method, path string, body interface{}, expectStatus int, v interface{}) {
+	var r *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		c.Assert(err, qt.Equals, nil)
+		r = bytes.NewReader(data)
+	} else {
+		r = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, path, r)
+	c.Assert(err, qt.Equals, nil)
+	req.SetBasicAuth("admin", adminPassword)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	assertResponse(c, s.srv.Do(c, req), expectStatus, v)
+}
+
+// assertResponse checks that the given response has the expected status
+// and unmarshals the body into v, if it is not nil.
+func assertResponse(c *qt.C, resp *http.Response, expectStatus int, v interface{}) {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.StatusCode, qt.Equals, expectStatus, qt.Commentf("body: %s", data))
+	if v == nil {
+		return
+	}
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/scim+json")
+	err = json.Unmarshal(data, v)
+	c.Assert(err, qt.Equals, nil)
+}
+
+// findRecorder is a store.Store that records the skip and limit of
+// every call to FindIdentities.
+type findRecorder struct {
+	store.Store
+
+	mu    sync.Mutex
+	calls [][2]int
+}
+
+// FindIdentities implements store.Store.FindIdentities.
+func (r *findRecorder) FindIdentities(ctx context.Context, ref *store.Identity, filter store.Filter, sort []store.Sort, skip, limit int) ([]store.Identity, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, [2]int{skip, limit})
+	r.mu.Unlock()
+	return r.Store.FindIdentities(ctx, ref, filter, sort, skip, limit)
+}
+
+// reset returns the skip and limit of the calls made since the last
+// call to reset.
+func (r *findRecorder) reset() [][2]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
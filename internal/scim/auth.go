@@ -0,0 +1,30 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+)
+
+// opForRequest returns the operation that will be performed
+// by the API handler method which takes the given argument r.
+// See aclForOp in ../auth/auth.go for the mapping from
+// operation to ACLs.
+func opForRequest(r interface{}) bakery.Op {
+	switch r.(type) {
+	case *serviceProviderConfigRequest:
+		return auth.GlobalOp(auth.ActionLogin)
+	case *listUsersRequest, *userRequest, *listGroupsRequest, *groupRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *createUserRequest, *replaceUserRequest, *patchUserRequest, *deleteUserRequest:
+		return auth.GlobalOp(auth.ActionWriteAdmin)
+	case *createGroupRequest, *replaceGroupRequest, *patchGroupRequest, *deleteGroupRequest:
+		return auth.GlobalOp(auth.ActionWriteAdmin)
+	default:
+		logger.Infof("unknown API argument type %#v", r)
+	}
+	return bakery.Op{}
+}
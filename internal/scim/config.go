@@ -0,0 +1,35 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"net/http"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// ServiceProviderConfig serves the /scim/v2/ServiceProviderConfig
+// endpoint.
+func (h *handler) ServiceProviderConfig(p httprequest.Params, r *serviceProviderConfigRequest) error {
+	return writeResponse(p.Response, http.StatusOK, &ServiceProviderConfig{
+		Schemas: []string{serviceProviderConfigSchema},
+		Patch:   Supported{Supported: true},
+		Filter: FilterConfig{
+			Supported: true,
+		},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "Authentication with a token obtained from /v1/u/:username/bearer-token.",
+		}, {
+			Type:        "httpbasic",
+			Name:        "HTTP Basic",
+			Description: "Authentication as the admin user with the admin password.",
+		}},
+		Meta: &Meta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     h.params.Location + "/scim/v2/ServiceProviderConfig",
+		},
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"net/http"
+	"strconv"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// An errorType is the type of a SCIM error, as reported in the scimType
+// field of an error response. Values of errorType are used as error
+// causes.
+type errorType string
+
+// Error implements error.
+func (e errorType) Error() string {
+	return string(e)
+}
+
+// These are the error types defined in RFC 7644 section 3.12 that are
+// returned by this implementation.
+const (
+	errInvalidFilter errorType = "invalidFilter"
+	errInvalidSyntax errorType = "invalidSyntax"
+	errInvalidPath   errorType = "invalidPath"
+	errInvalidValue  errorType = "invalidValue"
+	errMutability    errorType = "mutability"
+	errNoTarget      errorType = "noTarget"
+	errUniqueness    errorType = "uniqueness"
+)
+
+// errorResponse determines the HTTP status and body of the response
+// for the given error.
+func errorResponse(err error) (int, *Error) {
+	resp := &Error{
+		Schemas: []string{errorSchema},
+		Detail:  err.Error(),
+	}
+	status := http.StatusInternalServerError
+	switch cause := errgo.Cause(err); cause {
+	case errUniqueness, params.ErrAlreadyExists:
+		status = http.StatusConflict
+		resp.ScimType = string(errUniqueness)
+	case errInvalidFilter, errInvalidSyntax, errInvalidPath, errInvalidValue, errMutability, errNoTarget:
+		status = http.StatusBadRequest
+		resp.ScimType = string(cause.(errorType))
+	case httprequest.ErrUnmarshal:
+		status = http.StatusBadRequest
+		resp.ScimType = string(errInvalidSyntax)
+	case params.ErrBadRequest:
+		status = http.StatusBadRequest
+	case params.ErrNotFound:
+		status = http.StatusNotFound
+	case params.ErrUnauthorized, params.ErrNoAdminCredsProvided:
+		status = http.StatusUnauthorized
+	case params.ErrForbidden:
+		status = http.StatusForbidden
+	case params.ErrMethodNotAllowed:
+		status = http.StatusMethodNotAllowed
+	}
+	resp.Status = strconv.Itoa(status)
+	return status, resp
+}
+
+// translateStoreError translates errors returned from the store into
+// the equivalent SCIM errors.
+func translateStoreError(err error) error {
+	var cause error
+	switch errgo.Cause(err) {
+	case store.ErrNotFound:
+		cause = params.ErrNotFound
+	case store.ErrDuplicateUsername, store.ErrDuplicateGroup:
+		cause = errUniqueness
+	case nil:
+		return nil
+	}
+	err1 := errgo.WithCausef(err, cause, "").(*errgo.Err)
+	err1.SetLocation(1)
+	return err1
+}
+
+// isSCIMError reports whether the given error cause should be
+// preserved when an error is masked.
+func isSCIMError(err error) bool {
+	if _, ok := err.(errorType); ok {
+		return true
+	}
+	return err == params.ErrNotFound
+}
@@ -0,0 +1,11 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+type FilterTerm = filterTerm
+
+var (
+	MatchString = matchString
+	ParseFilter = parseFilter
+)
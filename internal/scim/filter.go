@@ -0,0 +1,161 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// A filterTerm is a single attribute comparison in a SCIM filter.
+type filterTerm struct {
+	// Attr holds the attribute path, converted to lower case as
+	// attribute names are case insensitive.
+	Attr string
+
+	// Op holds the comparison to perform.
+	Op store.Comparison
+
+	// Value holds the value to compare against.
+	Value string
+}
+
+var comparisons = map[string]store.Comparison{
+	"eq": store.Equal,
+	"ne": store.NotEqual,
+	"gt": store.GreaterThan,
+	"lt": store.LessThan,
+	"ge": store.GreaterThanOrEqual,
+	"le": store.LessThanOrEqual,
+	"sw": store.Prefix,
+	"co": store.Contains,
+}
+
+// parseFilter parses a SCIM filter (see RFC 7644 section 3.4.2.2). Only
+// the subset of the filter language that can be represented with a
+// store.Filter is supported: a number of attribute comparisons with a
+// string value, joined with "and".
+func parseFilter(filter string) ([]filterTerm, error) {
+	toks, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(errInvalidFilter))
+	}
+	if len(toks) == 0 {
+		return nil, errgo.WithCausef(nil, errInvalidFilter, "empty filter")
+	}
+	var terms []filterTerm
+	for len(toks) > 0 {
+		if len(terms) > 0 {
+			if toks[0].quoted || !strings.EqualFold(toks[0].text, "and") {
+				return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported logical operator %q", toks[0].text)
+			}
+			toks = toks[1:]
+		}
+		if len(toks) < 2 || toks[0].quoted || toks[1].quoted {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid filter %q", filter)
+		}
+		op, ok := comparisons[strings.ToLower(toks[1].text)]
+		if !ok {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported operator %q", toks[1].text)
+		}
+		if len(toks) < 3 {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid filter %q", filter)
+		}
+		if !toks[2].quoted {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported value %s, only string values are supported", toks[2].text)
+		}
+		terms = append(terms, filterTerm{
+			Attr:  strings.ToLower(toks[0].text),
+			Op:    op,
+			Value: toks[2].text,
+		})
+		toks = toks[3:]
+	}
+	return terms, nil
+}
+
+// A filterToken is a token in a SCIM filter.
+type filterToken struct {
+	// text holds the text of the token. For quoted tokens this is the
+	// unquoted string.
+	text string
+
+	// quoted holds whether the token was a quoted string.
+	quoted bool
+}
+
+// tokenizeFilter splits the given filter into tokens.
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var toks []filterToken
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return toks, nil
+		}
+		if s[0] != '"' {
+			n := strings.IndexFunc(s, unicode.IsSpace)
+			if n == -1 {
+				n = len(s)
+			}
+			toks = append(toks, filterToken{text: s[:n]})
+			s = s[n:]
+			continue
+		}
+		n := quotedLen(s)
+		if n == -1 {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unterminated string in filter")
+		}
+		var v string
+		if err := json.Unmarshal([]byte(s[:n]), &v); err != nil {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid string %s in filter", s[:n])
+		}
+		toks = append(toks, filterToken{text: v, quoted: true})
+		s = s[n:]
+	}
+}
+
+// quotedLen returns the length of the JSON string at the start of s,
+// including the quotes, or -1 if the string is not terminated.
+func quotedLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// matchString reports whether the given value satisfies the comparison
+// in the given filter term. As in the store, the "sw" and "co"
+// comparisons ignore case.
+func matchString(v string, t filterTerm) bool {
+	n := strings.Compare(v, t.Value)
+	switch t.Op {
+	case store.Equal:
+		return n == 0
+	case store.NotEqual:
+		return n != 0
+	case store.GreaterThan:
+		return n > 0
+	case store.LessThan:
+		return n < 0
+	case store.GreaterThanOrEqual:
+		return n >= 0
+	case store.LessThanOrEqual:
+		return n <= 0
+	case store.Prefix:
+		return strings.HasPrefix(strings.ToLower(v), strings.ToLower(t.Value))
+	case store.Contains:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(t.Value))
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/internal/scim"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+var parseFilterTests = []struct {
+	filter      string
+	expect      []scim.FilterTerm
+	expectError string
+}{{
+	filter: `userName eq "bob"`,
+	expect: []scim.FilterTerm{{Attr: "username", Op: store.Equal, Value: "bob"}},
+}, {
+	filter: `  emails.value NE "bob@example.com" `,
+	expect: []scim.FilterTerm{{Attr: "emails.value", Op: store.NotEqual, Value: "bob@example.com"}},
+}, {
+	filter: `userName gt "a" and userName lt "c" AND displayName ge "x y" and externalId le "\"q\""`,
+	expect: []scim.FilterTerm{
+		{Attr: "username", Op: store.GreaterThan, Value: "a"},
+		{Attr: "username", Op: store.LessThan, Value: "c"},
+		{Attr: "displayname", Op: store.GreaterThanOrEqual, Value: "x y"},
+		{Attr: "externalid", Op: store.LessThanOrEqual, Value: `"q"`},
+	},
+}, {
+	filter: `userName sw "bo" and emails.value CO "example"`,
+	expect: []scim.FilterTerm{
+		{Attr: "username", Op: store.Prefix, Value: "bo"},
+		{Attr: "emails.value", Op: store.Contains, Value: "example"},
+	},
+}, {
+	filter:      ``,
+	expectError: `empty filter`,
+}, {
+	filter:      `userName ew "bob"`,
+	expectError: `unsupported operator "ew"`,
+}, {
+	filter:      `userName pr`,
+	expectError: `unsupported operator "pr"`,
+}, {
+	filter:      `userName eq "bob" or userName eq "alice"`,
+	expectError: `unsupported logical operator "or"`,
+}, {
+	filter:      `active eq true`,
+	expectError: `unsupported value true, only string values are supported`,
+}, {
+	filter:      `userName eq "bob`,
+	expectError: `unterminated string in filter`,
+}, {
+	filter:      `userName eq`,
+	expectError: `invalid filter "userName eq"`,
+}, {
+	filter:      `"userName" eq "bob"`,
+	expectError: `invalid filter "\\"userName\\" eq \\"bob\\""`,
+}}
+
+func TestParseFilter(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseFilterTests {
+		c.Run(test.filter, func(c *qt.C) {
+			terms, err := scim.ParseFilter(test.filter)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(terms, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+var matchStringTests = []struct {
+	value  string
+	term   scim.FilterTerm
+	expect bool
+}{{
+	value:  "bob",
+	term:   scim.FilterTerm{Op: store.Equal, Value: "bob"},
+	expect: true,
+}, {
+	value:  "Bob",
+	term:   scim.FilterTerm{Op: store.Equal, Value: "bob"},
+	expect: false,
+}, {
+	value:  "bob",
+	term:   scim.FilterTerm{Op: store.GreaterThan, Value: "alice"},
+	expect: true,
+}, {
+	value:  "Bobby",
+	term:   scim.FilterTerm{Op: store.Prefix, Value: "bob"},
+	expect: true,
+}, {
+	value:  "robert",
+	term:   scim.FilterTerm{Op: store.Prefix, Value: "bob"},
+	expect: false,
+}, {
+	value:  "Team-Admins",
+	term:   scim.FilterTerm{Op: store.Contains, Value: "admin"},
+	expect: true,
+}, {
+	value:  "team-users",
+	term:   scim.FilterTerm{Op: store.Contains, Value: "admin"},
+	expect: false,
+}}
+
+func TestMatchString(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range matchStringTests {
+		c.Check(scim.MatchString(test.value, test.term), qt.Equals, test.expect, qt.Commentf("%q %v %q", test.value, test.term.Op, test.term.Value))
+	}
+}
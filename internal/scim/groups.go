@@ -0,0 +1,466 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// ListGroups serves the /scim/v2/Groups endpoint. Groups are returned
+// sorted by name.
+func (h *handler) ListGroups(p httprequest.Params, r *listGroupsRequest) error {
+	var terms []filterTerm
+	if r.Filter != "" {
+		var err error
+		terms, err = parseFilter(r.Filter)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidFilter))
+		}
+		for _, t := range terms {
+			if t.Attr != "displayname" && t.Attr != "id" {
+				return errgo.WithCausef(nil, errInvalidFilter, "unsupported filter attribute %q", t.Attr)
+			}
+		}
+	}
+	allNames, err := h.groupNames(p.Context)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var names []string
+	for _, name := range allNames {
+		if matchGroup(name, terms) {
+			names = append(names, name)
+		}
+	}
+	resp, start, end, err := newListResponse(&r.listRequest, len(names))
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	excludeMembers := excludesMembers(r.ExcludedAttributes)
+	for _, name := range names[start:end] {
+		var members []store.Identity
+		if !excludeMembers {
+			if members, err = h.members(p.Context, name); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		resp.Resources = append(resp.Resources, h.groupResource(name, members, excludeMembers))
+	}
+	return writeResponse(p.Response, http.StatusOK, resp)
+}
+
+// GetGroup serves the /scim/v2/Groups/:id endpoint.
+func (h *handler) GetGroup(p httprequest.Params, r *groupRequest) error {
+	members, err := h.group(p.Context, r.ID)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return writeResponse(p.Response, http.StatusOK, h.groupResource(r.ID, members, excludesMembers(r.ExcludedAttributes)))
+}
+
+// CreateGroup serves a POST to the /scim/v2/Groups endpoint.
+func (h *handler) CreateGroup(p httprequest.Params, r *createGroupRequest) error {
+	if r.Group.DisplayName == "" {
+		return errgo.WithCausef(nil, errInvalidValue, "displayName must be specified")
+	}
+	if _, err := h.group(p.Context, r.Group.DisplayName); err == nil {
+		return errgo.WithCausef(nil, errUniqueness, "group %q already exists", r.Group.DisplayName)
+	} else if errgo.Cause(err) != params.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	members, err := h.updateGroup(p.Context, "", nil, &r.Group)
+	if err != nil {
+		return errgo.Mask(err, isSCIMError)
+	}
+	return writeResponse(p.Response, http.StatusCreated, h.groupResource(r.Group.DisplayName, members, false))
+}
+
+// ReplaceGroup serves a PUT to the /scim/v2/Groups/:id endpoint.
+func (h *handler) ReplaceGroup(p httprequest.Params, r *replaceGroupRequest) error {
+	members, err := h.group(p.Context, r.ID)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	members, err = h.updateGroup(p.Context, r.ID, members, &r.Group)
+	if err != nil {
+		return errgo.Mask(err, isSCIMError)
+	}
+	return writeResponse(p.Response, http.StatusOK, h.groupResource(r.Group.DisplayName, members, false))
+}
+
+// PatchGroup serves a PATCH to the /scim/v2/Groups/:id endpoint.
+func (h *handler) PatchGroup(p httprequest.Params, r *patchGroupRequest) error {
+	members, err := h.group(p.Context, r.ID)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	g := h.groupResource(r.ID, members, false)
+	for _, op := range r.Patch.Operations {
+		if err := patchGroup(g, op); err != nil {
+			return errgo.Mask(err, isSCIMError)
+		}
+	}
+	members, err = h.updateGroup(p.Context, r.ID, members, g)
+	if err != nil {
+		return errgo.Mask(err, isSCIMError)
+	}
+	return writeResponse(p.Response, http.StatusOK, h.groupResource(g.DisplayName, members, false))
+}
+
+// DeleteGroup serves a DELETE to the /scim/v2/Groups/:id endpoint. The
+// group is removed from all of its members.
+func (h *handler) DeleteGroup(p httprequest.Params, r *deleteGroupRequest) error {
+	members, err := h.group(p.Context, r.ID)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	for _, id := range members {
+		if err := h.updateMembership(p.Context, id.ID, r.ID, store.Pull); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if err := h.updateRegisteredGroups(p.Context, r.ID, ""); err != nil {
+		return errgo.Mask(err)
+	}
+	p.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// groupNames returns the names of all known groups, sorted by name.
+// A group is known if it has been registered in the store or if it has
+// any members.
+func (h *handler) groupNames(ctx context.Context) ([]string, error) {
+	registered, err := h.registeredGroups(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	withMembers, err := h.params.Store.IdentityGroups(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(registered, withMembers...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// members returns the members of the given group, sorted by username.
+func (h *handler) members(ctx context.Context, name string) ([]store.Identity, error) {
+	ids, err := h.params.Store.FindIdentities(ctx, &store.Identity{
+		Groups: []string{name},
+	}, store.Filter{
+		store.Groups: store.Equal,
+	}, []store.Sort{{Field: store.Username}}, 0, 0)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return ids, nil
+}
+
+// group returns the members of the given group. If the group does not
+// exist an error with a cause of params.ErrNotFound is returned.
+func (h *handler) group(ctx context.Context, name string) ([]store.Identity, error) {
+	members, err := h.members(ctx, name)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(members) > 0 {
+		return members, nil
+	}
+	err = h.params.Store.Group(ctx, &store.Group{Name: name})
+	if errgo.Cause(err) == store.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "group %q not found", name)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return nil, nil
+}
+
+// updateGroup updates the group with the given name and members so that
+// it matches the given group resource. The new members of the group are
+// returned. A new group is created if oldName is empty.
+func (h *handler) updateGroup(ctx context.Context, oldName string, oldMembers []store.Identity, g *Group) ([]store.Identity, error) {
+	if g.DisplayName == "" {
+		return nil, errgo.WithCausef(nil, errInvalidValue, "displayName must be specified")
+	}
+	renamed := g.DisplayName != oldName
+	if renamed && oldName != "" {
+		if _, err := h.group(ctx, g.DisplayName); err == nil {
+			return nil, errgo.WithCausef(nil, errUniqueness, "group %q already exists", g.DisplayName)
+		} else if errgo.Cause(err) != params.ErrNotFound {
+			return nil, errgo.Mask(err)
+		}
+	}
+	old := make(map[string]bool)
+	for _, id := range oldMembers {
+		old[id.ID] = true
+	}
+	var members []store.Identity
+	added := make(map[string]bool)
+	for _, m := range g.Members {
+		if added[m.Value] {
+			continue
+		}
+		added[m.Value] = true
+		id := store.Identity{ID: m.Value}
+		if err := h.params.Store.Identity(ctx, &id); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				return nil, errgo.WithCausef(nil, errInvalidValue, "member %q not found", m.Value)
+			}
+			return nil, errgo.Mask(err)
+		}
+		members = append(members, id)
+	}
+	for _, id := range oldMembers {
+		if renamed || !added[id.ID] {
+			if err := h.updateMembership(ctx, id.ID, oldName, store.Pull); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+	}
+	for _, id := range members {
+		if renamed || !old[id.ID] {
+			if err := h.updateMembership(ctx, id.ID, g.DisplayName, store.Push); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+	}
+	if err := h.updateRegisteredGroups(ctx, oldName, g.DisplayName); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return members, nil
+}
+
+// updateMembership adds (with store.Push) or removes (with store.Pull)
+// the given group from the identity with the given ID.
+func (h *handler) updateMembership(ctx context.Context, id, group string, op store.Operation) error {
+	if err := h.params.Store.UpdateIdentity(ctx, &store.Identity{
+		ID:     id,
+		Groups: []string{group},
+	}, store.Update{
+		store.Groups: op,
+	}); err != nil {
+		return translateStoreError(err)
+	}
+	if h.params.Webhooks == nil {
+		return nil
+	}
+	identity := store.Identity{ID: id}
+	if err := h.params.Store.Identity(ctx, &identity); err != nil {
+		return errgo.Mask(err)
+	}
+	h.params.Webhooks.Notify(ctx, webhook.Event{
+		Type:     webhook.GroupsChanged,
+		Username: identity.Username,
+		Groups:   identity.Groups,
+	})
+	return nil
+}
+
+// registeredGroups returns the names of the groups held in the store.
+// Any group that an identity is a member of also exists, storing the
+// groups created through SCIM means that groups can exist without any
+// members.
+func (h *handler) registeredGroups(ctx context.Context) ([]string, error) {
+	groups, err := h.params.Store.FindGroups(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+	return names, nil
+}
+
+// updateRegisteredGroups removes the group named remove, and adds the
+// group named add to the groups held in the store. If both names are
+// given then a stored group named remove is renamed, so that its other
+// details are retained. Either name may be empty.
+func (h *handler) updateRegisteredGroups(ctx context.Context, remove, add string) error {
+	if remove == add {
+		remove = ""
+	}
+	if remove != "" {
+		g := store.Group{Name: remove}
+		err := h.params.Store.Group(ctx, &g)
+		switch {
+		case errgo.Cause(err) == store.ErrNotFound:
+		case err != nil:
+			return errgo.Mask(err)
+		case add != "":
+			g.Name = add
+			return translateStoreError(h.params.Store.UpdateGroup(ctx, &g))
+		default:
+			return translateStoreError(h.params.Store.RemoveGroup(ctx, &g))
+		}
+	}
+	if add == "" {
+		return nil
+	}
+	err := h.params.Store.CreateGroup(ctx, &store.Group{Name: add})
+	if errgo.Cause(err) == store.ErrDuplicateGroup {
+		return nil
+	}
+	return translateStoreError(err)
+}
+
+// groupResource creates the SCIM representation of the given group.
+func (h *handler) groupResource(name string, members []store.Identity, excludeMembers bool) *Group {
+	g := &Group{
+		Schemas:     []string{groupSchema},
+		ID:          name,
+		DisplayName: name,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Location:     h.location("Group", name),
+		},
+	}
+	if excludeMembers {
+		return g
+	}
+	for _, id := range members {
+		g.Members = append(g.Members, Reference{
+			Value:   id.ID,
+			Ref:     h.location("User", id.ID),
+			Display: id.Username,
+		})
+	}
+	return g
+}
+
+// patchGroup applies the given PATCH operation to the given group.
+func patchGroup(g *Group, op PatchOperation) error {
+	path := strings.ToLower(strings.TrimPrefix(op.Path, groupSchema+":"))
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if path != "" {
+			return errgo.Mask(setGroupAttr(g, path, op.Value, strings.EqualFold(op.Op, "add")), isSCIMError)
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errgo.WithCausef(nil, errInvalidValue, "invalid value for %s operation", op.Op)
+		}
+		for k, v := range attrs {
+			attr := strings.ToLower(strings.TrimPrefix(k, groupSchema+":"))
+			if err := setGroupAttr(g, attr, v, strings.EqualFold(op.Op, "add")); err != nil {
+				return errgo.Mask(err, isSCIMError)
+			}
+		}
+		return nil
+	case "remove":
+		return errgo.Mask(removeGroupMembers(g, strings.TrimPrefix(op.Path, groupSchema+":"), op.Value), isSCIMError)
+	default:
+		return errgo.WithCausef(nil, errInvalidSyntax, "unsupported operation %q", op.Op)
+	}
+}
+
+// setGroupAttr sets the given attribute of a group to the JSON encoded
+// value v. If add is true then members are added to the existing
+// members, rather than replacing them.
+func setGroupAttr(g *Group, attr string, v json.RawMessage, add bool) error {
+	switch attr {
+	case "displayname":
+		if err := json.Unmarshal(v, &g.DisplayName); err != nil {
+			return errgo.WithCausef(nil, errInvalidValue, "invalid value for %q", attr)
+		}
+	case "members":
+		var members []Reference
+		if err := json.Unmarshal(v, &members); err != nil {
+			return errgo.WithCausef(nil, errInvalidValue, "invalid value for %q", attr)
+		}
+		if !add {
+			g.Members = nil
+		}
+		g.Members = append(g.Members, members...)
+	case "id", "schemas", "meta":
+		// These attributes are read only, ignore any attempt to
+		// change them.
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported attribute %q", attr)
+	}
+	return nil
+}
+
+// removeGroupMembers removes members from a group. The path may either
+// be "members", in which case the members listed in the JSON encoded
+// value v are removed, or all members if there is no value, or a
+// filter of the form members[value eq "id"].
+func removeGroupMembers(g *Group, path string, v json.RawMessage) error {
+	remove := make(map[string]bool)
+	lpath := strings.ToLower(path)
+	switch {
+	case lpath == "members":
+		if len(v) == 0 {
+			g.Members = nil
+			return nil
+		}
+		var members []Reference
+		if err := json.Unmarshal(v, &members); err != nil {
+			return errgo.WithCausef(nil, errInvalidValue, "invalid value for %q", path)
+		}
+		for _, m := range members {
+			remove[m.Value] = true
+		}
+	case strings.HasPrefix(lpath, "members[") && strings.HasSuffix(lpath, "]"):
+		terms, err := parseFilter(path[len("members[") : len(path)-1])
+		if err != nil || len(terms) != 1 || terms[0].Attr != "value" || terms[0].Op != store.Equal {
+			return errgo.WithCausef(nil, errInvalidPath, "unsupported path %q", path)
+		}
+		remove[terms[0].Value] = true
+	case lpath == "":
+		return errgo.WithCausef(nil, errNoTarget, "remove operation must specify a path")
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported attribute %q", path)
+	}
+	members := g.Members[:0]
+	for _, m := range g.Members {
+		if !remove[m.Value] {
+			members = append(members, m)
+		}
+	}
+	g.Members = members
+	return nil
+}
+
+// matchGroup reports whether the group with the given name matches all
+// the given filter terms. Groups are identified by their name so the id
+// and displayName attributes are equivalent.
+func matchGroup(name string, terms []filterTerm) bool {
+	for _, t := range terms {
+		if !matchString(name, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// excludesMembers reports whether the given excludedAttributes
+// parameter excludes the members attribute.
+func excludesMembers(excludedAttributes string) bool {
+	for _, attr := range strings.Split(excludedAttributes, ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/internal/scim"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func (s *apiSuite) TestCreateGroup(c *qt.C) {
+	bob := s.userID(c, "bob")
+	alice := s.userID(c, "alice")
+
+	var g scim.Group
+	s.do(c, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{"urn:ietf:params:scim:schemas:core:2.0:Group"},
+		"displayName": "engineering",
+		"members": []map[string]string{
+			{"value": bob},
+			{"value": alice},
+		},
+	}, http.StatusCreated, &g)
+	c.Assert(g, qt.DeepEquals, scim.Group{
+		Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:Group"},
+		ID:          "engineering",
+		DisplayName: "engineering",
+		Members: []scim.Reference{{
+			Value:   bob,
+			Ref:     s.srv.URL + "/scim/v2/Users/" + bob,
+			Display: "bob",
+		}, {
+			Value:   alice,
+			Ref:     s.srv.URL + "/scim/v2/Users/" + alice,
+			Display: "alice",
+		}},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Location:     s.srv.URL + "/scim/v2/Groups/engineering",
+		},
+	})
+	c.Assert(s.userGroups(c, "bob"), qt.DeepEquals, []string{"engineering"})
+	c.Assert(s.userGroups(c, "alice"), qt.DeepEquals, []string{"engineering"})
+
+	var scimErr scim.Error
+	s.do(c, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "engineering",
+	}, http.StatusConflict, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "uniqueness")
+
+	s.do(c, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "sales",
+		"members":     []map[string]string{{"value": "1234"}},
+	}, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidValue")
+	c.Assert(scimErr.Detail, qt.Equals, `member "1234" not found`)
+}
+
+func (s *apiSuite) TestEmptyGroup(c *qt.C) {
+	var g scim.Group
+	s.do(c, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "empty",
+	}, http.StatusCreated, &g)
+	c.Assert(g.Members, qt.HasLen, 0)
+
+	g = scim.Group{}
+	s.do(c, "GET", "/scim/v2/Groups/empty", nil, http.StatusOK, &g)
+	c.Assert(g.DisplayName, qt.Equals, "empty")
+}
+
+func (s *apiSuite) TestListGroups(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1", "g2")
+	s.srv.CreateUser(c, "alice", "g2", "g3")
+
+	var list scim.ListResponse
+	s.do(c, "GET", "/scim/v2/Groups", nil, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 3)
+	groups := groupResources(c, list)
+	c.Assert(groups, qt.HasLen, 3)
+	c.Assert(groups[0].DisplayName, qt.Equals, "g1")
+	c.Assert(groups[1].DisplayName, qt.Equals, "g2")
+	c.Assert(groups[1].Members, qt.HasLen, 2)
+	c.Assert(groups[2].DisplayName, qt.Equals, "g3")
+
+	list = scim.ListResponse{}
+	s.finds.reset()
+	s.do(c, "GET", "/scim/v2/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "g2"`), nil, http.StatusOK, &list)
+	groups = groupResources(c, list)
+	c.Assert(groups, qt.HasLen, 1)
+	c.Assert(groups[0].DisplayName, qt.Equals, "g2")
+	c.Assert(groups[0].Members, qt.HasLen, 0)
+	// No identities are read when members are excluded.
+	c.Assert(s.finds.reset(), qt.HasLen, 0)
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Groups?filter="+url.QueryEscape(`displayName sw "G" and id co "3"`), nil, http.StatusOK, &list)
+	groups = groupResources(c, list)
+	c.Assert(groups, qt.HasLen, 1)
+	c.Assert(groups[0].DisplayName, qt.Equals, "g3")
+
+	var scimErr scim.Error
+	s.do(c, "GET", "/scim/v2/Groups?filter="+url.QueryEscape(`members.value eq "1"`), nil, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidFilter")
+}
+
+func (s *apiSuite) TestPatchGroupMembers(c *qt.C) {
+	bob := s.userID(c, "bob")
+	alice := s.userID(c, "alice")
+	s.do(c, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "g1",
+	}, http.StatusCreated, nil)
+
+	var g scim.Group
+	s.do(c, "PATCH", "/scim/v2/Groups/g1", patchOp(
+		scim.PatchOperation{Op: "Add", Path: "members", Value: jsonValue(c, []map[string]string{{"value": bob}})},
+		scim.PatchOperation{Op: "add", Path: "members", Value: jsonValue(c, []map[string]string{{"value": alice}})},
+	), http.StatusOK, &g)
+	c.Assert(g.Members, qt.HasLen, 2)
+	c.Assert(s.userGroups(c, "bob"), qt.DeepEquals, []string{"g1"})
+	c.Assert(s.userGroups(c, "alice"), qt.DeepEquals, []string{"g1"})
+
+	g = scim.Group{}
+	s.do(c, "PATCH", "/scim/v2/Groups/g1", patchOp(
+		scim.PatchOperation{Op: "remove", Path: `members[value eq "` + bob + `"]`},
+	), http.StatusOK, &g)
+	c.Assert(g.Members, qt.HasLen, 1)
+	c.Assert(g.Members[0].Value, qt.Equals, alice)
+	c.Assert(s.userGroups(c, "bob"), qt.HasLen, 0)
+	c.Assert(s.userGroups(c, "alice"), qt.DeepEquals, []string{"g1"})
+
+	g = scim.Group{}
+	s.do(c, "PATCH", "/scim/v2/Groups/g1", patchOp(
+		scim.PatchOperation{Op: "replace", Path: "members", Value: jsonValue(c, []map[string]string{{"value": bob}})},
+	), http.StatusOK, &g)
+	c.Assert(g.Members, qt.HasLen, 1)
+	c.Assert(g.Members[0].Value, qt.Equals, bob)
+	c.Assert(s.userGroups(c, "bob"), qt.DeepEquals, []string{"g1"})
+	c.Assert(s.userGroups(c, "alice"), qt.HasLen, 0)
+
+	g = scim.Group{}
+	s.do(c, "PATCH", "/scim/v2/Groups/g1", patchOp(
+		scim.PatchOperation{Op: "remove", Path: "members", Value: jsonValue(c, []map[string]string{{"value": bob}})},
+	), http.StatusOK, &g)
+	c.Assert(g.Members, qt.HasLen, 0)
+	c.Assert(s.userGroups(c, "bob"), qt.HasLen, 0)
+
+	// The group still exists with no members.
+	s.do(c, "GET", "/scim/v2/Groups/g1", nil, http.StatusOK, nil)
+}
+
+func (s *apiSuite) TestRenameGroup(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1", "other")
+	s.do(c, "PATCH", "/scim/v2/Groups/g1", patchOp(
+		scim.PatchOperation{Op: "replace", Value: jsonValue(c, map[string]string{"displayName": "g2"})},
+	), http.StatusOK, nil)
+	c.Assert(s.userGroups(c, "bob"), qt.DeepEquals, []string{"other", "g2"})
+	s.do(c, "GET", "/scim/v2/Groups/g1", nil, http.StatusNotFound, nil)
+
+	var scimErr scim.Error
+	s.do(c, "PUT", "/scim/v2/Groups/g2", map[string]interface{}{
+		"displayName": "other",
+	}, http.StatusConflict, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "uniqueness")
+}
+
+func (s *apiSuite) TestDeleteGroup(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1", "g2")
+	s.srv.CreateUser(c, "alice", "g1")
+	s.do(c, "DELETE", "/scim/v2/Groups/g1", nil, http.StatusNoContent, nil)
+	c.Assert(s.userGroups(c, "bob"), qt.DeepEquals, []string{"g2"})
+	c.Assert(s.userGroups(c, "alice"), qt.HasLen, 0)
+	s.do(c, "GET", "/scim/v2/Groups/g1", nil, http.StatusNotFound, nil)
+	s.do(c, "DELETE", "/scim/v2/Groups/g1", nil, http.StatusNotFound, nil)
+}
+
+func (s *apiSuite) userID(c *qt.C, username string) string {
+	s.srv.CreateUser(c, username)
+	identity := store.Identity{Username: username}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+	return identity.ID
+}
+
+func (s *apiSuite) userGroups(c *qt.C, username string) []string {
+	identity := store.Identity{Username: username}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+	return identity.Groups
+}
+
+func groupResources(c *qt.C, list scim.ListResponse) []scim.Group {
+	var groups []scim.Group
+	for _, r := range list.Resources {
+		var g scim.Group
+		err := json.Unmarshal(jsonValue(c, r), &g)
+		c.Assert(err, qt.Equals, nil)
+		groups = append(groups, g)
+	}
+	return groups
+}
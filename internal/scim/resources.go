@@ -0,0 +1,232 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"encoding/json"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// Schema URNs defined in RFC 7643 and RFC 7644.
+const (
+	userSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	serviceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	listResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	patchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	errorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Meta holds the common resource metadata.
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// Name holds the components of a user's name. Only the formatted name
+// is stored.
+type Name struct {
+	Formatted string `json:"formatted,omitempty"`
+}
+
+// Email holds an email address of a user.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference holds a reference from one resource to another, as used in
+// the groups attribute of a user and the members attribute of a group.
+type Reference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// User is a SCIM user resource.
+type User struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *Name       `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []Email     `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Groups      []Reference `json:"groups,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// Group is a SCIM group resource.
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// ListResponse is the response to a query.
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// PatchOp is the body of a PATCH request.
+type PatchOp struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single operation in a PATCH request.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error is the body of a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Supported holds whether an optional feature is supported.
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// FilterConfig describes the supported filtering.
+type FilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// BulkConfig describes the supported bulk operations.
+type BulkConfig struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// AuthenticationScheme describes a supported authentication scheme.
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ServiceProviderConfig describes the SCIM features supported by the
+// server.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkConfig             `json:"bulk"`
+	Filter                FilterConfig           `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  *Meta                  `json:"meta,omitempty"`
+}
+
+// serviceProviderConfigRequest is a request for the service provider
+// configuration.
+type serviceProviderConfigRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/ServiceProviderConfig"`
+}
+
+// listRequest holds the query parameters common to listing users and
+// groups.
+type listRequest struct {
+	Filter             string `httprequest:"filter,form"`
+	StartIndex         string `httprequest:"startIndex,form"`
+	Count              string `httprequest:"count,form"`
+	ExcludedAttributes string `httprequest:"excludedAttributes,form"`
+}
+
+// listUsersRequest is a request to query the users.
+type listUsersRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Users"`
+	listRequest
+}
+
+// userRequest is a request for a single user.
+type userRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// createUserRequest is a request to create a user.
+type createUserRequest struct {
+	httprequest.Route `httprequest:"POST /scim/v2/Users"`
+	User              User `httprequest:",body"`
+}
+
+// replaceUserRequest is a request to replace the attributes of a user.
+type replaceUserRequest struct {
+	httprequest.Route `httprequest:"PUT /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+	User              User   `httprequest:",body"`
+}
+
+// patchUserRequest is a request to modify the attributes of a user.
+type patchUserRequest struct {
+	httprequest.Route `httprequest:"PATCH /scim/v2/Users/:id"`
+	ID                string  `httprequest:"id,path"`
+	Patch             PatchOp `httprequest:",body"`
+}
+
+// deleteUserRequest is a request to delete a user.
+type deleteUserRequest struct {
+	httprequest.Route `httprequest:"DELETE /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// listGroupsRequest is a request to query the groups.
+type listGroupsRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Groups"`
+	listRequest
+}
+
+// groupRequest is a request for a single group.
+type groupRequest struct {
+	httprequest.Route  `httprequest:"GET /scim/v2/Groups/:id"`
+	ID                 string `httprequest:"id,path"`
+	ExcludedAttributes string `httprequest:"excludedAttributes,form"`
+}
+
+// createGroupRequest is a request to create a group.
+type createGroupRequest struct {
+	httprequest.Route `httprequest:"POST /scim/v2/Groups"`
+	Group             Group `httprequest:",body"`
+}
+
+// replaceGroupRequest is a request to replace the attributes of a
+// group.
+type replaceGroupRequest struct {
+	httprequest.Route `httprequest:"PUT /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+	Group             Group  `httprequest:",body"`
+}
+
+// patchGroupRequest is a request to modify the attributes of a group.
+type patchGroupRequest struct {
+	httprequest.Route `httprequest:"PATCH /scim/v2/Groups/:id"`
+	ID                string  `httprequest:"id,path"`
+	Patch             PatchOp `httprequest:",body"`
+}
+
+// deleteGroupRequest is a request to delete a group.
+type deleteGroupRequest struct {
+	httprequest.Route `httprequest:"DELETE /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+}
@@ -0,0 +1,447 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// ListUsers serves the /scim/v2/Users endpoint. Users are returned
+// sorted by username.
+func (h *handler) ListUsers(p httprequest.Params, r *listUsersRequest) error {
+	var ref store.Identity
+	var filter store.Filter
+	if r.Filter != "" {
+		terms, err := parseFilter(r.Filter)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidFilter))
+		}
+		if err := userFilter(&ref, &filter, terms); err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidFilter))
+		}
+	}
+	total, err := h.params.Store.CountIdentities(p.Context, &ref, filter)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp, start, end, err := newListResponse(&r.listRequest, total)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	if end == start {
+		// A limit of zero would return every match.
+		return writeResponse(p.Response, http.StatusOK, resp)
+	}
+	ids, err := h.params.Store.FindIdentities(p.Context, &ref, filter, []store.Sort{{Field: store.Username}}, start, end-start)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range ids {
+		resp.Resources = append(resp.Resources, h.userResource(&ids[i]))
+	}
+	resp.ItemsPerPage = len(resp.Resources)
+	return writeResponse(p.Response, http.StatusOK, resp)
+}
+
+// GetUser serves the /scim/v2/Users/:id endpoint.
+func (h *handler) GetUser(p httprequest.Params, r *userRequest) error {
+	id := store.Identity{ID: r.ID}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return translateStoreError(err)
+	}
+	return writeResponse(p.Response, http.StatusOK, h.userResource(&id))
+}
+
+// CreateUser serves a POST to the /scim/v2/Users endpoint. If an
+// externalId is given it is used as the provider ID of the new
+// identity, so that when the user subsequently logs in through the
+// identity provider named in the externalId the identity will be
+// matched. Otherwise the provider ID will be "scim:" followed by the
+// username.
+func (h *handler) CreateUser(p httprequest.Params, r *createUserRequest) error {
+	if err := checkUser(&r.User); err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	externalID := r.User.ExternalID
+	if externalID == "" {
+		externalID = r.User.UserName
+	}
+	id := store.Identity{
+		ProviderID: providerID(externalID),
+	}
+	err := h.params.Store.Identity(p.Context, &id)
+	if err == nil {
+		return errgo.WithCausef(nil, errUniqueness, "user with externalId %q already exists", externalID)
+	}
+	if errgo.Cause(err) != store.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	id = store.Identity{
+		ProviderID: providerID(externalID),
+		Username:   r.User.UserName,
+		Name:       displayName(&r.User),
+		Email:      primaryEmail(&r.User),
+	}
+	update := store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+		store.Email:    store.Set,
+	}
+	if !active(&r.User) {
+		id.Suspended = true
+		update[store.Suspended] = store.Set
+	}
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, update); err != nil {
+		return translateStoreError(err)
+	}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return errgo.Mask(err)
+	}
+	h.params.Webhooks.Notify(p.Context, webhook.Event{
+		Type:     webhook.IdentityCreated,
+		Username: id.Username,
+		IDP:      id.ProviderID.Provider(),
+	})
+	return writeResponse(p.Response, http.StatusCreated, h.userResource(&id))
+}
+
+// ReplaceUser serves a PUT to the /scim/v2/Users/:id endpoint.
+func (h *handler) ReplaceUser(p httprequest.Params, r *replaceUserRequest) error {
+	id := store.Identity{ID: r.ID}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return translateStoreError(err)
+	}
+	if err := h.updateUser(p.Context, &id, &r.User); err != nil {
+		return errgo.Mask(err, isSCIMError)
+	}
+	return writeResponse(p.Response, http.StatusOK, h.userResource(&id))
+}
+
+// PatchUser serves a PATCH to the /scim/v2/Users/:id endpoint.
+func (h *handler) PatchUser(p httprequest.Params, r *patchUserRequest) error {
+	id := store.Identity{ID: r.ID}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return translateStoreError(err)
+	}
+	u := h.userResource(&id)
+	for _, op := range r.Patch.Operations {
+		if err := patchUser(u, op); err != nil {
+			return errgo.Mask(err, isSCIMError)
+		}
+	}
+	if err := h.updateUser(p.Context, &id, u); err != nil {
+		return errgo.Mask(err, isSCIMError)
+	}
+	return writeResponse(p.Response, http.StatusOK, h.userResource(&id))
+}
+
+// DeleteUser serves a DELETE to the /scim/v2/Users/:id endpoint. Users
+// are not removed from the store, they are deactivated.
+func (h *handler) DeleteUser(p httprequest.Params, r *deleteUserRequest) error {
+	id := store.Identity{ID: r.ID}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return translateStoreError(err)
+	}
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, deactivateUpdate(&id)); err != nil {
+		return translateStoreError(err)
+	}
+	p.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// updateUser updates the given identity so that it matches the given
+// user resource. On success the identity will be updated to hold the
+// new values.
+func (h *handler) updateUser(ctx context.Context, id *store.Identity, u *User) error {
+	if err := checkUser(u); err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	if u.ExternalID != "" && providerID(u.ExternalID) != id.ProviderID {
+		return errgo.WithCausef(nil, errMutability, "externalId cannot be changed")
+	}
+	id1 := store.Identity{
+		ID:       id.ID,
+		Username: u.UserName,
+		Name:     displayName(u),
+		Email:    primaryEmail(u),
+	}
+	var update store.Update
+	update[store.Username] = store.Set
+	update[store.Name] = setOrClear(id1.Name)
+	update[store.Email] = setOrClear(id1.Email)
+	switch {
+	case active(u) && id.Suspended:
+		update[store.Suspended] = store.Clear
+	case !active(u) && !id.Suspended:
+		update = mergeUpdate(update, deactivateUpdate(&id1))
+	}
+	if err := h.params.Store.UpdateIdentity(ctx, &id1, update); err != nil {
+		return translateStoreError(err)
+	}
+	*id = store.Identity{ID: id.ID}
+	return errgo.Mask(h.params.Store.Identity(ctx, id))
+}
+
+// deactivateUpdate prepares the given identity for an update that will
+// suspend it. Deactivating a user also removes it from all groups.
+func deactivateUpdate(id *store.Identity) store.Update {
+	id.Suspended = true
+	id.Groups = nil
+	var update store.Update
+	update[store.Suspended] = store.Set
+	update[store.Groups] = store.Clear
+	return update
+}
+
+// mergeUpdate returns an update that performs all the operations in u1
+// and u2.
+func mergeUpdate(u1, u2 store.Update) store.Update {
+	for f, op := range u2 {
+		if op != store.NoUpdate {
+			u1[f] = op
+		}
+	}
+	return u1
+}
+
+func setOrClear(v string) store.Operation {
+	if v == "" {
+		return store.Clear
+	}
+	return store.Set
+}
+
+// userResource creates the SCIM representation of the given identity.
+func (h *handler) userResource(id *store.Identity) *User {
+	active := !id.Suspended
+	u := &User{
+		Schemas:     []string{userSchema},
+		ID:          id.ID,
+		ExternalID:  externalID(id.ProviderID),
+		UserName:    id.Username,
+		DisplayName: id.Name,
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     h.location("User", id.ID),
+		},
+	}
+	if id.Name != "" {
+		u.Name = &Name{Formatted: id.Name}
+	}
+	if id.Email != "" {
+		u.Emails = []Email{{Value: id.Email, Primary: true}}
+	}
+	for _, g := range id.Groups {
+		u.Groups = append(u.Groups, Reference{
+			Value:   g,
+			Ref:     h.location("Group", g),
+			Display: g,
+		})
+	}
+	return u
+}
+
+// checkUser checks that the given user resource can be stored.
+func checkUser(u *User) error {
+	if u.UserName == "" {
+		return errgo.WithCausef(nil, errInvalidValue, "userName must be specified")
+	}
+	var username params.Username
+	if err := username.UnmarshalText([]byte(u.UserName)); err != nil {
+		return errgo.WithCausef(nil, errInvalidValue, "%s", err)
+	}
+	return nil
+}
+
+// userFilter updates the given reference identity and filter so that
+// store.FindIdentities will return the users that match the given
+// filter terms.
+func userFilter(ref *store.Identity, filter *store.Filter, terms []filterTerm) error {
+	for _, t := range terms {
+		var f store.Field
+		switch t.Attr {
+		case "username":
+			f = store.Username
+			ref.Username = t.Value
+		case "externalid":
+			f = store.ProviderID
+			ref.ProviderID = providerID(t.Value)
+			if t.Op == store.Contains {
+				// The value may match any part of the
+				// provider ID, so it cannot be qualified
+				// with the scim provider.
+				ref.ProviderID = store.ProviderIdentity(t.Value)
+			}
+		case "displayname", "name.formatted":
+			f = store.Name
+			ref.Name = t.Value
+		case "emails", "emails.value":
+			f = store.Email
+			ref.Email = t.Value
+		default:
+			return errgo.WithCausef(nil, errInvalidFilter, "unsupported filter attribute %q", t.Attr)
+		}
+		if filter[f] != store.NoComparison {
+			return errgo.WithCausef(nil, errInvalidFilter, "attribute %q used more than once in filter", t.Attr)
+		}
+		filter[f] = t.Op
+	}
+	return nil
+}
+
+// patchUser applies the given PATCH operation to the given user.
+func patchUser(u *User, op PatchOperation) error {
+	path := strings.ToLower(strings.TrimPrefix(op.Path, userSchema+":"))
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if path != "" {
+			return errgo.Mask(setUserAttr(u, path, op.Value), isSCIMError)
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errgo.WithCausef(nil, errInvalidValue, "invalid value for %s operation", op.Op)
+		}
+		for k, v := range attrs {
+			if err := setUserAttr(u, strings.ToLower(strings.TrimPrefix(k, userSchema+":")), v); err != nil {
+				return errgo.Mask(err, isSCIMError)
+			}
+		}
+		return nil
+	case "remove":
+		return errgo.Mask(removeUserAttr(u, path), isSCIMError)
+	default:
+		return errgo.WithCausef(nil, errInvalidSyntax, "unsupported operation %q", op.Op)
+	}
+}
+
+// setUserAttr sets the given attribute of a user to the JSON encoded
+// value v.
+func setUserAttr(u *User, attr string, v json.RawMessage) error {
+	var err error
+	switch {
+	case attr == "username":
+		err = json.Unmarshal(v, &u.UserName)
+	case attr == "externalid":
+		err = json.Unmarshal(v, &u.ExternalID)
+	case attr == "displayname", attr == "name.formatted":
+		var s string
+		err = json.Unmarshal(v, &s)
+		u.DisplayName = s
+		u.Name = &Name{Formatted: s}
+	case attr == "name":
+		var n Name
+		err = json.Unmarshal(v, &n)
+		u.DisplayName = n.Formatted
+		u.Name = &n
+	case attr == "emails":
+		u.Emails = nil
+		err = json.Unmarshal(v, &u.Emails)
+	case attr == "emails.value", strings.HasPrefix(attr, "emails[") && strings.HasSuffix(attr, "].value"):
+		var s string
+		err = json.Unmarshal(v, &s)
+		u.Emails = []Email{{Value: s, Primary: true}}
+	case attr == "active":
+		var b bool
+		b, err = unmarshalBool(v)
+		u.Active = &b
+	case attr == "id", attr == "schemas", attr == "meta", attr == "groups":
+		// These attributes are read only, ignore any attempt to
+		// change them.
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported attribute %q", attr)
+	}
+	if err != nil {
+		return errgo.WithCausef(nil, errInvalidValue, "invalid value for %q", attr)
+	}
+	return nil
+}
+
+// removeUserAttr removes the given attribute from a user.
+func removeUserAttr(u *User, attr string) error {
+	switch {
+	case attr == "":
+		return errgo.WithCausef(nil, errNoTarget, "remove operation must specify a path")
+	case attr == "displayname", attr == "name", attr == "name.formatted":
+		u.DisplayName = ""
+		u.Name = nil
+	case attr == "emails", strings.HasPrefix(attr, "emails["):
+		u.Emails = nil
+	case attr == "username", attr == "externalid", attr == "active":
+		return errgo.WithCausef(nil, errMutability, "cannot remove %q", attr)
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported attribute %q", attr)
+	}
+	return nil
+}
+
+// unmarshalBool unmarshals a boolean value. Some clients send boolean
+// values as strings, so those are accepted too.
+func unmarshalBool(v json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return false, errgo.Mask(err)
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+// providerID returns the provider ID of an identity with the given SCIM
+// externalId. An externalId that contains a colon is taken to be a
+// complete provider ID, any other value is in the "scim" provider.
+func providerID(externalID string) store.ProviderIdentity {
+	if strings.Contains(externalID, ":") {
+		return store.ProviderIdentity(externalID)
+	}
+	return store.MakeProviderIdentity("scim", externalID)
+}
+
+// externalID returns the SCIM externalId for the given provider ID, it
+// is the inverse of providerID.
+func externalID(pid store.ProviderIdentity) string {
+	if provider, id := pid.Split(); provider == "scim" && !strings.Contains(id, ":") {
+		return id
+	}
+	return string(pid)
+}
+
+func displayName(u *User) string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != nil {
+		return u.Name.Formatted
+	}
+	return ""
+}
+
+func primaryEmail(u *User) string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+func active(u *User) bool {
+	return u.Active == nil || *u.Active
+}
@@ -0,0 +1,307 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/internal/scim"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func (s *apiSuite) TestCreateUser(c *qt.C) {
+	var u scim.User
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName": "bob",
+		"name": map[string]string{
+			"formatted": "Bob Dobbs",
+		},
+		"emails": []map[string]interface{}{{
+			"value": "bob@example.com",
+			"type":  "work",
+		}},
+	}, http.StatusCreated, &u)
+	c.Assert(u.ID, qt.Not(qt.Equals), "")
+	active := true
+	c.Assert(u, qt.DeepEquals, scim.User{
+		Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		ID:          u.ID,
+		ExternalID:  "bob",
+		UserName:    "bob",
+		Name:        &scim.Name{Formatted: "Bob Dobbs"},
+		DisplayName: "Bob Dobbs",
+		Emails:      []scim.Email{{Value: "bob@example.com", Primary: true}},
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Location:     s.srv.URL + "/scim/v2/Users/" + u.ID,
+		},
+	})
+
+	identity := store.Identity{Username: "bob"}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.ProviderID, qt.Equals, store.ProviderIdentity("scim:bob"))
+	c.Assert(identity.Name, qt.Equals, "Bob Dobbs")
+	c.Assert(identity.Email, qt.Equals, "bob@example.com")
+
+	var u2 scim.User
+	s.do(c, "GET", "/scim/v2/Users/"+u.ID, nil, http.StatusOK, &u2)
+	c.Assert(u2, qt.DeepEquals, u)
+}
+
+func (s *apiSuite) TestCreateUserWithProviderExternalID(c *qt.C) {
+	var u scim.User
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName":   "bob",
+		"externalId": "ldap:uid=bob",
+	}, http.StatusCreated, &u)
+	c.Assert(u.ExternalID, qt.Equals, "ldap:uid=bob")
+
+	identity := store.Identity{ID: u.ID}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.ProviderID, qt.Equals, store.ProviderIdentity("ldap:uid=bob"))
+}
+
+func (s *apiSuite) TestCreateUserConflict(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	var scimErr scim.Error
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName":   "bob",
+		"externalId": "other",
+	}, http.StatusConflict, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "uniqueness")
+
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName":   "bob2",
+		"externalId": "test:bob",
+	}, http.StatusConflict, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "uniqueness")
+	c.Assert(scimErr.Detail, qt.Equals, `user with externalId "test:bob" already exists`)
+}
+
+func (s *apiSuite) TestCreateUserInvalid(c *qt.C) {
+	var scimErr scim.Error
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"displayName": "Bob",
+	}, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidValue")
+	c.Assert(scimErr.Detail, qt.Equals, "userName must be specified")
+
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName": "bob smith",
+	}, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidValue")
+	c.Assert(scimErr.Detail, qt.Equals, `illegal username "bob smith"`)
+}
+
+func (s *apiSuite) TestListUsers(c *qt.C) {
+	for _, name := range []string{"dave", "alice", "carol", "bob"} {
+		s.srv.CreateUser(c, name)
+	}
+	// Note that the admin user is also created when the first
+	// request is authenticated.
+	var list scim.ListResponse
+	s.do(c, "GET", "/scim/v2/Users", nil, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 5)
+	c.Assert(list.StartIndex, qt.Equals, 1)
+	c.Assert(list.ItemsPerPage, qt.Equals, 5)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"admin@candid", "alice", "bob", "carol", "dave"})
+
+	list = scim.ListResponse{}
+	s.finds.reset()
+	s.do(c, "GET", "/scim/v2/Users?startIndex=3&count=2", nil, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 5)
+	c.Assert(list.StartIndex, qt.Equals, 3)
+	c.Assert(list.ItemsPerPage, qt.Equals, 2)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"bob", "carol"})
+	// Only the requested page is read from the store.
+	c.Assert(s.finds.reset(), qt.DeepEquals, [][2]int{{2, 2}})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?count=0", nil, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 5)
+	c.Assert(list.ItemsPerPage, qt.Equals, 0)
+	c.Assert(list.Resources, qt.HasLen, 0)
+	c.Assert(s.finds.reset(), qt.HasLen, 0)
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?startIndex=10", nil, http.StatusOK, &list)
+	c.Assert(list.TotalResults, qt.Equals, 5)
+	c.Assert(list.ItemsPerPage, qt.Equals, 0)
+	c.Assert(list.Resources, qt.HasLen, 0)
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName gt "bob"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"carol", "dave"})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`externalId eq "test:carol"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"carol"})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName sw "B"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"bob"})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName co "a"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"admin@candid", "alice", "carol", "dave"})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`externalId sw "test:c"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"carol"})
+
+	list = scim.ListResponse{}
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`externalId co "ali"`), nil, http.StatusOK, &list)
+	c.Assert(userNames(c, list), qt.DeepEquals, []string{"alice"})
+}
+
+func (s *apiSuite) TestListUsersInvalidFilter(c *qt.C) {
+	var scimErr scim.Error
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName ew "b"`), nil, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidFilter")
+	c.Assert(scimErr.Detail, qt.Equals, `unsupported operator "ew"`)
+
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`title eq "boss"`), nil, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidFilter")
+	c.Assert(scimErr.Detail, qt.Equals, `unsupported filter attribute "title"`)
+
+	s.do(c, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName ne "a" and userName ne "b"`), nil, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidFilter")
+	c.Assert(scimErr.Detail, qt.Equals, `attribute "username" used more than once in filter`)
+
+	s.do(c, "GET", "/scim/v2/Users?count=many", nil, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidValue")
+}
+
+func (s *apiSuite) TestReplaceUser(c *qt.C) {
+	var u scim.User
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName":    "bob",
+		"displayName": "Bob",
+		"emails":      []map[string]interface{}{{"value": "bob@example.com"}},
+	}, http.StatusCreated, &u)
+
+	id := u.ID
+	u = scim.User{}
+	s.do(c, "PUT", "/scim/v2/Users/"+id, map[string]interface{}{
+		"userName":    "robert",
+		"displayName": "Robert",
+	}, http.StatusOK, &u)
+	c.Assert(u.UserName, qt.Equals, "robert")
+	c.Assert(u.DisplayName, qt.Equals, "Robert")
+	c.Assert(u.Emails, qt.HasLen, 0)
+
+	var scimErr scim.Error
+	s.do(c, "PUT", "/scim/v2/Users/"+id, map[string]interface{}{
+		"userName":   "robert",
+		"externalId": "other",
+	}, http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "mutability")
+}
+
+func (s *apiSuite) TestPatchUser(c *qt.C) {
+	var u scim.User
+	s.do(c, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName":    "bob",
+		"displayName": "Bob",
+	}, http.StatusCreated, &u)
+
+	s.do(c, "PATCH", "/scim/v2/Users/"+u.ID, patchOp(
+		scim.PatchOperation{Op: "Replace", Path: `emails[type eq "work"].value`, Value: jsonValue(c, "bob@example.com")},
+		scim.PatchOperation{Op: "replace", Path: "name.formatted", Value: jsonValue(c, "Bob Dobbs")},
+	), http.StatusOK, &u)
+	c.Assert(u.Emails, qt.DeepEquals, []scim.Email{{Value: "bob@example.com", Primary: true}})
+	c.Assert(u.DisplayName, qt.Equals, "Bob Dobbs")
+
+	id := u.ID
+	u = scim.User{}
+	s.do(c, "PATCH", "/scim/v2/Users/"+id, patchOp(
+		scim.PatchOperation{Op: "add", Value: jsonValue(c, map[string]interface{}{
+			"displayName": "J. R. Dobbs",
+		})},
+		scim.PatchOperation{Op: "remove", Path: "emails"},
+	), http.StatusOK, &u)
+	c.Assert(u.DisplayName, qt.Equals, "J. R. Dobbs")
+	c.Assert(u.Emails, qt.HasLen, 0)
+
+	var scimErr scim.Error
+	s.do(c, "PATCH", "/scim/v2/Users/"+id, patchOp(
+		scim.PatchOperation{Op: "replace", Path: "title", Value: jsonValue(c, "boss")},
+	), http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidPath")
+
+	s.do(c, "PATCH", "/scim/v2/Users/"+id, patchOp(
+		scim.PatchOperation{Op: "move", Path: "displayName"},
+	), http.StatusBadRequest, &scimErr)
+	c.Assert(scimErr.ScimType, qt.Equals, "invalidSyntax")
+}
+
+func (s *apiSuite) TestDeactivateUser(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1", "g2")
+	identity := store.Identity{Username: "bob"}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+
+	// Some clients send boolean values as strings.
+	var u scim.User
+	s.do(c, "PATCH", "/scim/v2/Users/"+identity.ID, patchOp(
+		scim.PatchOperation{Op: "Replace", Path: "active", Value: jsonValue(c, "False")},
+	), http.StatusOK, &u)
+	c.Assert(*u.Active, qt.Equals, false)
+	c.Assert(u.Groups, qt.HasLen, 0)
+
+	u = scim.User{}
+	s.do(c, "PATCH", "/scim/v2/Users/"+identity.ID, patchOp(
+		scim.PatchOperation{Op: "replace", Path: "active", Value: jsonValue(c, true)},
+	), http.StatusOK, &u)
+	c.Assert(*u.Active, qt.Equals, true)
+	c.Assert(u.Groups, qt.HasLen, 0)
+}
+
+func (s *apiSuite) TestDeleteUser(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1")
+	identity := store.Identity{Username: "bob"}
+	err := s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.Equals, nil)
+
+	s.do(c, "DELETE", "/scim/v2/Users/"+identity.ID, nil, http.StatusNoContent, nil)
+
+	var u scim.User
+	s.do(c, "GET", "/scim/v2/Users/"+identity.ID, nil, http.StatusOK, &u)
+	c.Assert(*u.Active, qt.Equals, false)
+	c.Assert(u.Groups, qt.HasLen, 0)
+
+	s.do(c, "DELETE", "/scim/v2/Users/1234", nil, http.StatusNotFound, nil)
+}
+
+func userNames(c *qt.C, list scim.ListResponse) []string {
+	var names []string
+	for _, r := range list.Resources {
+		var u scim.User
+		err := json.Unmarshal(jsonValue(c, r), &u)
+		c.Assert(err, qt.Equals, nil)
+		names = append(names, u.UserName)
+	}
+	return names
+}
+
+func patchOp(ops ...scim.PatchOperation) scim.PatchOp {
+	return scim.PatchOp{
+		Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: ops,
+	}
+}
+
+func jsonValue(c *qt.C, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	c.Assert(err, qt.Equals, nil)
+	return data
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package tracing provides OpenTelemetry tracing for the identity
+// server. Spans are only exported once Setup has been called, until
+// then all spans are discarded.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/errgo.v1"
+)
+
+// instrumentationName holds the name of the tracer used for all spans
+// created by the identity server.
+const instrumentationName = "github.com/CanonicalLtd/candid"
+
+// propagator holds the propagator used to read trace context from
+// incoming requests.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Params holds the parameters for exporting spans.
+type Params struct {
+	// Endpoint holds the host and port of the OTLP/HTTP collector
+	// to which spans are exported.
+	Endpoint string
+
+	// Insecure holds whether plain HTTP, rather than HTTPS, is used
+	// to connect to the collector.
+	Insecure bool
+
+	// ServiceName holds the service name reported with each span.
+	// If this is empty then "candid" is used.
+	ServiceName string
+
+	// SampleRatio holds the fraction of traces started by the
+	// identity server that are sampled. Traces started by the
+	// caller of the identity server are sampled according to the
+	// caller's decision. If this is zero then all traces are
+	// sampled.
+	SampleRatio float64
+}
+
+// Setup configures spans to be exported to the OTLP collector
+// specified in the given parameters. The returned function must be
+// called to flush any remaining spans before the process exits.
+func Setup(ctx context.Context, p Params) (shutdown func(context.Context) error, err error) {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(p.Endpoint),
+	}
+	if p.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot create trace exporter")
+	}
+	if p.ServiceName == "" {
+		p.ServiceName = "candid"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if p.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(p.SampleRatio)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", p.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp.Shutdown, nil
+}
+
+// Start starts a new span with the given name as a child of any span
+// in the given context. The span must be ended, usually with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the given span, recording the given error if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ContextWithSpanFrom returns a copy of ctx that holds the span held in
+// from. It is used when a handler deliberately does not use the request
+// context, but its spans should still be part of the request's trace.
+func ContextWithSpanFrom(ctx, from context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, trace.SpanFromContext(from))
+}
+
+// Handler returns an http.Handler that serves each request with h in a
+// new server span. The span continues any W3C trace context sent with
+// the request.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, "HTTP "+req.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", req.Method),
+				attribute.String("http.target", req.URL.Path),
+			),
+		)
+		defer span.End()
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/internal/tracing"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestHandler(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	sr := recordSpans(c)
+
+	h := tracing.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := tracing.Start(req.Context(), "child")
+		tracing.End(span, errgo.New("test error"))
+	}))
+	req := httptest.NewRequest("GET", "/discharge", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	c.Assert(spans, qt.HasLen, 2)
+	child, server := spans[0], spans[1]
+
+	c.Check(server.Name(), qt.Equals, "HTTP GET")
+	c.Check(server.SpanKind(), qt.Equals, trace.SpanKindServer)
+	c.Check(server.SpanContext().TraceID().String(), qt.Equals, traceID)
+	c.Check(server.Parent().SpanID().String(), qt.Equals, spanID)
+	c.Check(server.Parent().IsRemote(), qt.Equals, true)
+
+	c.Check(child.Name(), qt.Equals, "child")
+	c.Check(child.SpanContext().TraceID().String(), qt.Equals, traceID)
+	c.Check(child.Parent().SpanID(), qt.Equals, server.SpanContext().SpanID())
+	c.Check(child.Status().Code, qt.Equals, codes.Error)
+	c.Check(child.Status().Description, qt.Equals, "test error")
+}
+
+func TestHandlerWithoutTraceContext(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	sr := recordSpans(c)
+
+	h := tracing.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/wait", nil))
+
+	spans := sr.Ended()
+	c.Assert(spans, qt.HasLen, 1)
+	c.Check(spans[0].Name(), qt.Equals, "HTTP POST")
+	c.Check(spans[0].Parent().IsValid(), qt.Equals, false)
+}
+
+func TestContextWithSpanFrom(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	sr := recordSpans(c)
+
+	reqCtx, parent := tracing.Start(context.Background(), "parent")
+	type key struct{}
+	ctx := tracing.ContextWithSpanFrom(context.WithValue(context.Background(), key{}, "value"), reqCtx)
+	c.Check(ctx.Value(key{}), qt.Equals, "value")
+	_, span := tracing.Start(ctx, "child")
+	tracing.End(span, nil)
+	parent.End()
+
+	spans := sr.Ended()
+	c.Assert(spans, qt.HasLen, 2)
+	c.Check(spans[0].Parent().SpanID(), qt.Equals, spans[1].SpanContext().SpanID())
+	c.Check(spans[0].Status().Code, qt.Equals, codes.Unset)
+}
+
+// recordSpans installs a tracer provider that records all spans for
+// the duration of the test.
+func recordSpans(c *qt.C) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	c.Defer(func() {
+		otel.SetTracerProvider(old)
+	})
+	return sr
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/internal/identity"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// defaultAuditLimit holds the number of audit entries returned
+	// when no limit is requested.
+	defaultAuditLimit = 100
+
+	// maxAuditLimit holds the maximum number of audit entries
+	// returned by a single request.
+	maxAuditLimit = 1000
+)
+
+// Audit returns the entries in the audit log that match the given
+// request, newest first. Older entries can be retrieved by making
+// another request with Until set to the time of the last entry
+// returned.
+func (h *handler) Audit(p httprequest.Params, r *candidparams.AuditRequest) ([]candidparams.AuditEntry, error) {
+	pentries := []candidparams.AuditEntry{}
+	if h.params.AuditStore == nil {
+		return pentries, nil
+	}
+	limit := r.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	entries, err := h.params.AuditStore.FindAuditEntries(p.Context, store.AuditFilter{
+		Username: string(r.User),
+		Since:    r.Since,
+		Until:    r.Until,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, e := range entries {
+		pentries = append(pentries, candidparams.AuditEntry{
+			ID:       e.ID,
+			Time:     e.Time,
+			Type:     string(e.Type),
+			Username: e.Username,
+			Actor:    e.Actor,
+			IDP:      e.IDP,
+			Caveat:   e.Caveat,
+			Detail:   e.Detail,
+		})
+	}
+	return pentries, nil
+}
+
+// audit records the given entry in the audit log. The actor of the
+// entry is set to the authenticated user making the request.
+func (h *handler) audit(ctx context.Context, entry store.AuditEntry) {
+	if id := identityFromContext(ctx); id != nil {
+		entry.Actor = id.Id()
+	}
+	identity.Audit(ctx, h.params.AuditStore, entry)
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestAuditAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &auditSuite{})
+}
+
+type auditSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *auditSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *auditSuite) TestAdministrativeChangesAudited(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "bob",
+		Groups: params.ModifyGroups{
+			Add: []string{"g1", "g2"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+		Username: "bob",
+		Groups: params.Groups{
+			Groups: []string{"g3"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = s.adminClient.PutSSHKeys(s.srv.Ctx, &params.PutSSHKeysRequest{
+		Username: "bob",
+		Body: params.PutSSHKeysBody{
+			SSHKeys: []string{"ssh-rsa key1", "ssh-rsa key2"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = s.adminClient.DeleteSSHKeys(s.srv.Ctx, &params.DeleteSSHKeysRequest{
+		Username: "bob",
+		Body: params.DeleteSSHKeysBody{
+			SSHKeys: []string{"ssh-rsa key1"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	key, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	resp, err := s.adminClient.CreateAgent(s.srv.Ctx, &params.CreateAgentRequest{
+		CreateAgentBody: params.CreateAgentBody{
+			PublicKeys: []*bakery.PublicKey{&key.Public},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	var entries []candidparams.AuditEntry
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User: auth.AdminUsername,
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	var changes []candidparams.AuditEntry
+	for _, e := range entries {
+		if e.Type == string(store.AuditDischarge) {
+			continue
+		}
+		c.Assert(e.ID, qt.Not(qt.Equals), "")
+		c.Assert(e.Time.IsZero(), qt.Equals, false)
+		e.ID = ""
+		e.Time = time.Time{}
+		changes = append(changes, e)
+	}
+	c.Assert(changes, qt.DeepEquals, []candidparams.AuditEntry{{
+		Type:     "agent-create",
+		Username: string(resp.Username),
+		Actor:    auth.AdminUsername,
+	}, {
+		Type:     "ssh-keys-change",
+		Username: "bob",
+		Actor:    auth.AdminUsername,
+		Detail:   "removed 1 key(s)",
+	}, {
+		Type:     "ssh-keys-change",
+		Username: "bob",
+		Actor:    auth.AdminUsername,
+		Detail:   "added 2 key(s)",
+	}, {
+		Type:     "groups-change",
+		Username: "bob",
+		Actor:    auth.AdminUsername,
+		Detail:   "set: g3",
+	}, {
+		Type:     "groups-change",
+		Username: "bob",
+		Actor:    auth.AdminUsername,
+		Detail:   "add: g1 g2",
+	}})
+}
+
+func (s *auditSuite) TestAuditFilters(c *qt.C) {
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []store.AuditEntry{{
+		Time:     t0,
+		Type:     store.AuditLogin,
+		Username: "bob",
+		IDP:      "test",
+	}, {
+		Time:     t0.Add(time.Hour),
+		Type:     store.AuditLogin,
+		Username: "alice",
+		IDP:      "test",
+	}, {
+		Time:     t0.Add(2 * time.Hour),
+		Type:     store.AuditDischarge,
+		Username: "bob",
+		Caveat:   "is-authenticated-user",
+	}} {
+		err := s.store.AuditStore.AddAuditEntry(s.srv.Ctx, &e)
+		c.Assert(err, qt.Equals, nil, qt.Commentf("entry %d", i))
+	}
+
+	var entries []candidparams.AuditEntry
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User:  "bob",
+		Until: t0.Add(24 * time.Hour),
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(entries[0].Type, qt.Equals, "discharge")
+	c.Assert(entries[0].Caveat, qt.Equals, "is-authenticated-user")
+	c.Assert(entries[1].Type, qt.Equals, "login")
+	c.Assert(entries[1].IDP, qt.Equals, "test")
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		Since: t0.Add(time.Hour),
+		Until: t0.Add(2 * time.Hour),
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Username, qt.Equals, "alice")
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User: "nobody",
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.DeepEquals, []candidparams.AuditEntry{})
+}
+
+func (s *auditSuite) TestAuditLimit(c *qt.C) {
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1500; i++ {
+		err := s.store.AuditStore.AddAuditEntry(s.srv.Ctx, &store.AuditEntry{
+			Time:     t0.Add(time.Duration(i) * time.Second),
+			Type:     store.AuditLogin,
+			Username: "bob",
+			IDP:      "test",
+		})
+		c.Assert(err, qt.Equals, nil)
+	}
+
+	var entries []candidparams.AuditEntry
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User: "bob",
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 100)
+	c.Assert(entries[0].Time.UTC(), qt.DeepEquals, t0.Add(1499*time.Second))
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User:  "bob",
+		Limit: 5000,
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1000)
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{
+		User:  "bob",
+		Until: entries[len(entries)-1].Time,
+		Limit: 1000,
+	}, &entries)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 500)
+	c.Assert(entries[len(entries)-1].Time.UTC(), qt.DeepEquals, t0)
+}
+
+func (s *auditSuite) TestAuditUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	var entries []candidparams.AuditEntry
+	err := client.Client.Call(s.srv.Ctx, &candidparams.AuditRequest{}, &entries)
+	c.Assert(err, qt.ErrorMatches, `Get .*/v1/audit: permission denied`)
+}
@@ -9,6 +9,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 
 	"github.com/CanonicalLtd/candid/internal/auth"
+	candidparams "github.com/CanonicalLtd/candid/params"
 )
 
 // opForRequest returns the operation that will be performed
@@ -66,6 +67,45 @@ func opForRequest(r interface{}) bakery.Op {
 		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
 	case *params.DischargeTokenForUserRequest:
 		return auth.GlobalOp(auth.ActionDischargeFor)
+	case *candidparams.WebAuthnCredentialsRequest:
+		return auth.UserOp(r.Username, auth.ActionReadAdmin)
+	case *candidparams.StartWebAuthnRegistrationRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.CompleteWebAuthnRegistrationRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.DeleteWebAuthnCredentialRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.SetPasswordRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.ResetPasswordRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.BearerTokenRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.UserStatusRequest:
+		return auth.UserOp(r.Username, auth.ActionReadAdmin)
+	case *candidparams.SetUserStatusRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.DeleteUserRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.RevokeSessionsRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *candidparams.RevokeMacaroonsRequest:
+		return auth.GlobalOp(auth.ActionWriteAdmin)
+	case *candidparams.GroupsRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *candidparams.CreateGroupRequest:
+		return auth.GlobalOp(auth.ActionWriteAdmin)
+	case *candidparams.GroupRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *candidparams.GroupMembersRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *candidparams.AuditRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *candidparams.SearchUsersRequest:
+		if r.Owner != "" {
+			return auth.UserOp(r.Owner, auth.ActionRead)
+		}
+		return auth.GlobalOp(auth.ActionRead)
 	default:
 		logger.Infof("unknown API argument type %#v", r)
 	}
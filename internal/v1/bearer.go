@@ -0,0 +1,69 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+// defaultBearerTokenLifetime is the lifetime of a bearer token when no
+// expiry time is requested.
+const defaultBearerTokenLifetime = 30 * 24 * time.Hour
+
+// BearerToken returns a token identifying the user that can be sent in
+// an HTTP "Authorization: Bearer" header. The token is an encoded
+// macaroon slice so it is checked in exactly the same way as any other
+// macaroon presented to the identity server.
+func (h *handler) BearerToken(p httprequest.Params, r *candidparams.BearerTokenRequest) (*candidparams.BearerTokenResponse, error) {
+	expires := r.Body.Expires
+	if expires.IsZero() {
+		expires = time.Now().Add(defaultBearerTokenLifetime)
+	}
+	if !expires.After(time.Now()) {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "expiry time is in the past")
+	}
+	id, err := h.params.Authorizer.Identity(p.Context, string(r.Username))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	sid, err := id.StoreIdentity(p.Context)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	m, err := h.params.Oven.NewMacaroon(
+		p.Context,
+		bakery.LatestVersion,
+		append([]checkers.Caveat{
+			candidclient.UserDeclaration(id.Id()),
+			auth.IssuedCaveat(time.Now()),
+			checkers.TimeBeforeCaveat(expires),
+		}, auth.ProviderAuthenticationCaveats(sid)...),
+		identchecker.LoginOp,
+	)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot mint macaroon")
+	}
+	buf, err := json.Marshal(macaroon.Slice{m.M()})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot marshal macaroon")
+	}
+	return &candidparams.BearerTokenResponse{
+		Token:   base64.RawURLEncoding.EncodeToString(buf),
+		Expires: expires.UTC(),
+	}, nil
+}
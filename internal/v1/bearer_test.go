@@ -0,0 +1,102 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+func TestBearerTokenAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &bearerTokenSuite{})
+}
+
+type bearerTokenSuite struct {
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *bearerTokenSuite) Init(c *qt.C) {
+	s.srv = candidtest.NewMemServer(c, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *bearerTokenSuite) TestBearerToken(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	var token candidparams.BearerTokenResponse
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.BearerTokenRequest{
+		Username: "bob",
+	}, &token)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(token.Expires.After(time.Now().Add(29*24*time.Hour)), qt.Equals, true)
+
+	// The token declares how the user authenticated, so that the
+	// holder cannot add their own declaration.
+	data, err := macaroon.Base64Decode([]byte(token.Token))
+	c.Assert(err, qt.Equals, nil)
+	var ms macaroon.Slice
+	err = json.Unmarshal(data, &ms)
+	c.Assert(err, qt.Equals, nil)
+	declared := checkers.InferDeclared(nil, ms)
+	c.Assert(declared["idp"], qt.Equals, "test")
+	c.Assert(declared["mfa"], qt.Equals, "false")
+
+	req, err := http.NewRequest("GET", "/v1/whoami", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	resp := s.srv.Do(c, req)
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK, qt.Commentf("body: %s", data))
+	var whoami params.WhoAmIResponse
+	err = json.Unmarshal(data, &whoami)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(whoami.User, qt.Equals, "bob")
+}
+
+func (s *bearerTokenSuite) TestBearerTokenExpires(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	expires := time.Now().Add(time.Hour).Round(time.Second).UTC()
+	var token candidparams.BearerTokenResponse
+	req := &candidparams.BearerTokenRequest{
+		Username: "bob",
+	}
+	req.Body.Expires = expires
+	err := s.adminClient.Client.Call(s.srv.Ctx, req, &token)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(token.Expires.Equal(expires), qt.Equals, true)
+
+	req.Body.Expires = time.Now().Add(-time.Hour)
+	err = s.adminClient.Client.Call(s.srv.Ctx, req, &token)
+	c.Assert(err, qt.ErrorMatches, `Post .*: expiry time is in the past`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *bearerTokenSuite) TestBearerTokenUserNotFound(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.BearerTokenRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/internal/auth"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// DeleteUser deletes the given user along with any agents that it
+// owns. If anonymisation is requested the user's record is instead
+// stripped of all personal information and suspended, which
+// prevents the same external identity from logging in again. In both
+// cases any data held about the user by its identity provider is
+// removed.
+func (h *handler) DeleteUser(p httprequest.Params, r *candidparams.DeleteUserRequest) error {
+	if string(r.Username) == auth.AdminUsername {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot delete the admin user")
+	}
+	id := store.Identity{
+		Username: string(r.Username),
+	}
+	detail := "deleted"
+	if r.Anonymise {
+		if err := h.anonymiseIdentity(p.Context, &id); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		detail = "anonymised"
+	} else if err := h.deleteIdentity(p.Context, &id); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditIdentityDelete,
+		Username: string(r.Username),
+		Detail:   detail,
+	})
+	h.params.Webhooks.Notify(p.Context, webhook.Event{
+		Type:     webhook.IdentityDeleted,
+		Username: string(r.Username),
+	})
+	return nil
+}
+
+// deleteIdentity removes the given identity, and any data held about it
+// by its identity provider, from the store.
+func (h *handler) deleteIdentity(ctx context.Context, id *store.Identity) error {
+	if err := h.params.Store.Identity(ctx, id); err != nil {
+		return translateStoreError(err)
+	}
+	if err := h.removeProviderData(ctx, id); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := h.params.Store.DeleteIdentity(ctx, &store.Identity{ID: id.ID}); err != nil {
+		return translateStoreError(err)
+	}
+	return nil
+}
+
+// anonymiseIdentity removes all personal information from the given
+// identity, gives it a placeholder username and suspends it. Any
+// identities owned by it, and any data held about it by its identity
+// provider, are deleted.
+//
+// The provider ID is kept, even though it may identify the person (for
+// example an LDAP DN or OpenID URL), because it is what identity
+// providers use to find the identity when the same external identity
+// logs in. Replacing it, even with a hash, would allow that person to
+// log in again as a new user.
+func (h *handler) anonymiseIdentity(ctx context.Context, id *store.Identity) error {
+	if err := h.params.Store.Identity(ctx, id); err != nil {
+		return translateStoreError(err)
+	}
+	if err := h.removeProviderData(ctx, id); err != nil {
+		return errgo.Mask(err)
+	}
+	owned, err := h.params.Store.FindIdentities(ctx, &store.Identity{
+		Owner: id.ProviderID,
+	}, store.Filter{store.Owner: store.Equal}, nil, 0, 0)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range owned {
+		if err := h.params.Store.DeleteIdentity(ctx, &store.Identity{ID: owned[i].ID}); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				// Already removed as the descendant of
+				// another owned identity.
+				continue
+			}
+			return errgo.Mask(err)
+		}
+	}
+	// Map fields are updated per key, so clearing them requires
+	// every existing key to be named.
+	providerInfo := make(map[string][]string)
+	for k := range id.ProviderInfo {
+		providerInfo[k] = nil
+	}
+	extraInfo := make(map[string][]string)
+	for k := range id.ExtraInfo {
+		extraInfo[k] = nil
+	}
+	anon := store.Identity{
+		ID:           id.ID,
+		Username:     "deleted-" + id.ID,
+		ProviderInfo: providerInfo,
+		ExtraInfo:    extraInfo,
+		Suspended:    true,
+	}
+	if err := h.params.Store.UpdateIdentity(ctx, &anon, store.Update{
+		store.Username:     store.Set,
+		store.Name:         store.Clear,
+		store.Email:        store.Clear,
+		store.Groups:       store.Clear,
+		store.PublicKeys:   store.Clear,
+		store.ProviderInfo: store.Clear,
+		store.ExtraInfo:    store.Clear,
+		store.Owner:        store.Clear,
+		store.Suspended:    store.Set,
+	}); err != nil {
+		return translateStoreError(err)
+	}
+	return nil
+}
+
+// removeProviderData removes any data held about the given identity by
+// the identity provider that created it.
+func (h *handler) removeProviderData(ctx context.Context, id *store.Identity) error {
+	if !strings.Contains(string(id.ProviderID), ":") {
+		return nil
+	}
+	name := id.ProviderID.Provider()
+	for _, ip := range h.params.IdentityProviders {
+		if ip.Name() != name {
+			continue
+		}
+		r, ok := ip.(idp.IdentityDataRemover)
+		if !ok {
+			return nil
+		}
+		if err := r.RemoveIdentityData(ctx, id); err != nil {
+			return errgo.Notef(err, "cannot remove identity provider data")
+		}
+		return nil
+	}
+	return nil
+}
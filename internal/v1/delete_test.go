@@ -0,0 +1,180 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/test"
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestDeleteUserAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &deleteUserSuite{})
+}
+
+type deleteUserSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+	idp         *removerIdentityProvider
+}
+
+func (s *deleteUserSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.idp = &removerIdentityProvider{
+		IdentityProvider: test.NewIdentityProvider(test.Params{Name: "test"}),
+	}
+	sp := s.store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{s.idp}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+// addUserWithAgent adds the user bob, who owns the agent
+// bob-agent@candid.
+func (s *deleteUserSuite) addUserWithAgent(c *qt.C) {
+	err := s.store.Store.UpdateIdentity(s.srv.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Name:       "Bob Robertson",
+		Email:      "bob@example.com",
+		Groups:     []string{"g1"},
+		ExtraInfo: map[string][]string{
+			"k1": {"v1"},
+		},
+	}, store.Update{
+		store.Username:  store.Set,
+		store.Name:      store.Set,
+		store.Email:     store.Set,
+		store.Groups:    store.Set,
+		store.ExtraInfo: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = s.store.Store.UpdateIdentity(s.srv.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "bob-agent"),
+		Username:   "bob-agent@candid",
+		Owner:      store.MakeProviderIdentity("test", "bob"),
+	}, store.Update{
+		store.Username: store.Set,
+		store.Owner:    store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *deleteUserSuite) TestDeleteUser(c *qt.C) {
+	s.addUserWithAgent(c)
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	for _, username := range []string{"bob", "bob-agent@candid"} {
+		err := s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: username})
+		c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound, qt.Commentf("%s", username))
+	}
+	entries, err := s.store.AuditStore.FindAuditEntries(s.srv.Ctx, store.AuditFilter{
+		Username: "bob",
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditIdentityDelete)
+	c.Assert(entries[0].Actor, qt.Equals, auth.AdminUsername)
+	c.Assert(entries[0].Detail, qt.Equals, "deleted")
+	c.Assert(s.idp.removed, qt.DeepEquals, []store.ProviderIdentity{"test:bob"})
+}
+
+func (s *deleteUserSuite) TestAnonymiseUser(c *qt.C) {
+	s.addUserWithAgent(c)
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username:  "bob",
+		Anonymise: true,
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: "bob"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	err = s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: "bob-agent@candid"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	id := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+	}
+	err = s.store.Store.Identity(s.srv.Ctx, &id)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id.Username, qt.Equals, "deleted-"+id.ID)
+	c.Assert(id.Name, qt.Equals, "")
+	c.Assert(id.Email, qt.Equals, "")
+	c.Assert(id.Groups, qt.HasLen, 0)
+	c.Assert(id.ExtraInfo, qt.HasLen, 0)
+	c.Assert(id.Suspended, qt.Equals, true)
+
+	entries, err := s.store.AuditStore.FindAuditEntries(s.srv.Ctx, store.AuditFilter{
+		Username: "bob",
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Detail, qt.Equals, "anonymised")
+	c.Assert(s.idp.removed, qt.DeepEquals, []store.ProviderIdentity{"test:bob"})
+}
+
+func (s *deleteUserSuite) TestDeleteUserNotFound(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Delete .*/v1/u/bob: user bob not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username:  "bob",
+		Anonymise: true,
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Delete .*/v1/u/bob\?anonymise=true: user bob not found`)
+}
+
+func (s *deleteUserSuite) TestCannotDeleteAdmin(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username: auth.AdminUsername,
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Delete .*: cannot delete the admin user`)
+}
+
+func (s *deleteUserSuite) TestDeleteUserRequiresAdmin(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.Client.Call(s.srv.Ctx, &candidparams.DeleteUserRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Delete .*: permission denied`)
+}
+
+// removerIdentityProvider is an identity provider that records the
+// identities whose data it has been asked to remove.
+type removerIdentityProvider struct {
+	idp.IdentityProvider
+	removed []store.ProviderIdentity
+}
+
+// RemoveIdentityData implements idp.IdentityDataRemover.
+func (p *removerIdentityProvider) RemoveIdentityData(_ context.Context, id *store.Identity) error {
+	p.removed = append(p.removed, id.ProviderID)
+	return nil
+}
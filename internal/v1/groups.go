@@ -0,0 +1,141 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// Groups returns all the groups stored in the identity server.
+func (h *handler) Groups(p httprequest.Params, r *candidparams.GroupsRequest) ([]candidparams.Group, error) {
+	groups, err := h.params.Store.FindGroups(p.Context)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	pgroups := make([]candidparams.Group, len(groups))
+	for i := range groups {
+		g, err := h.groupFromStore(p.Context, &groups[i])
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		pgroups[i] = *g
+	}
+	return pgroups, nil
+}
+
+// CreateGroup creates a new group.
+func (h *handler) CreateGroup(p httprequest.Params, r *candidparams.CreateGroupRequest) error {
+	if err := checkGroupName(r.Body.Name); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	for _, g := range r.Body.Groups {
+		if err := checkGroupName(g); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+	}
+	group := store.Group{
+		Name:        r.Body.Name,
+		Description: r.Body.Description,
+		Groups:      r.Body.Groups,
+	}
+	if r.Body.Owner != "" {
+		owner := store.Identity{
+			Username: string(r.Body.Owner),
+		}
+		if err := h.params.Store.Identity(p.Context, &owner); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				return errgo.WithCausef(nil, params.ErrBadRequest, "owner %q not found", r.Body.Owner)
+			}
+			return errgo.Mask(err)
+		}
+		group.Owner = owner.ProviderID
+	}
+	return translateStoreError(h.params.Store.CreateGroup(p.Context, &group))
+}
+
+// Group returns the details of the requested group.
+func (h *handler) Group(p httprequest.Params, r *candidparams.GroupRequest) (*candidparams.Group, error) {
+	group := store.Group{
+		Name: r.Name,
+	}
+	if err := h.params.Store.Group(p.Context, &group); err != nil {
+		return nil, translateStoreError(err)
+	}
+	return h.groupFromStore(p.Context, &group)
+}
+
+// GroupMembers returns the usernames of the identities that are
+// members of the requested group.
+func (h *handler) GroupMembers(p httprequest.Params, r *candidparams.GroupMembersRequest) ([]string, error) {
+	if err := h.params.Store.Group(p.Context, &store.Group{Name: r.Name}); err != nil {
+		return nil, translateStoreError(err)
+	}
+	identities, err := h.params.Store.FindIdentities(
+		p.Context,
+		&store.Identity{
+			Groups: []string{r.Name},
+		},
+		store.Filter{
+			store.Groups: store.Equal,
+		},
+		[]store.Sort{{Field: store.Username}},
+		0,
+		0,
+	)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	members := make([]string, len(identities))
+	for i, id := range identities {
+		members[i] = id.Username
+	}
+	return members, nil
+}
+
+// groupFromStore converts the given stored group to its API
+// representation.
+func (h *handler) groupFromStore(ctx context.Context, g *store.Group) (*candidparams.Group, error) {
+	group := &candidparams.Group{
+		Name:        g.Name,
+		Description: g.Description,
+		Groups:      g.Groups,
+	}
+	if g.Owner != "" {
+		owner := store.Identity{
+			ProviderID: g.Owner,
+		}
+		err := h.params.Store.Identity(ctx, &owner)
+		switch errgo.Cause(err) {
+		case nil:
+			group.Owner = params.Username(owner.Username)
+		case store.ErrNotFound:
+			logger.Warningf("owner %q of group %q not found", g.Owner, g.Name)
+		default:
+			return nil, errgo.Mask(err)
+		}
+	}
+	return group, nil
+}
+
+// checkGroupName checks that the given name is valid for a group. As
+// groups are listed in space-separated caveat conditions, a group name
+// may not contain white space.
+func checkGroupName(name string) error {
+	if name == "" {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "group name not specified")
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) != -1 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid group name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+func TestGroupsAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &groupsSuite{})
+}
+
+type groupsSuite struct {
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *groupsSuite) Init(c *qt.C) {
+	s.srv = candidtest.NewMemServer(c, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *groupsSuite) TestCreateAndListGroups(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	var groups []candidparams.Group
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupsRequest{}, &groups)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []candidparams.Group{})
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.CreateGroupRequest{
+		Body: candidparams.Group{
+			Name:        "team-a",
+			Description: "Team A",
+			Owner:       "bob",
+			Groups:      []string{"engineering"},
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.CreateGroupRequest{
+		Body: candidparams.Group{
+			Name: "engineering",
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupsRequest{}, &groups)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []candidparams.Group{{
+		Name: "engineering",
+	}, {
+		Name:        "team-a",
+		Description: "Team A",
+		Owner:       "bob",
+		Groups:      []string{"engineering"},
+	}})
+
+	var group candidparams.Group
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupRequest{
+		Name: "team-a",
+	}, &group)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(group, qt.DeepEquals, groups[1])
+}
+
+func (s *groupsSuite) TestCreateGroupExists(c *qt.C) {
+	req := &candidparams.CreateGroupRequest{
+		Body: candidparams.Group{
+			Name: "team-a",
+		},
+	}
+	err := s.adminClient.Client.Call(s.srv.Ctx, req, nil)
+	c.Assert(err, qt.Equals, nil)
+	err = s.adminClient.Client.Call(s.srv.Ctx, req, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+}
+
+var createGroupErrorTests = []struct {
+	about       string
+	group       candidparams.Group
+	expectError string
+}{{
+	about:       "no name",
+	expectError: `Post .*: group name not specified`,
+}, {
+	about: "invalid name",
+	group: candidparams.Group{
+		Name: "team a",
+	},
+	expectError: `Post .*: invalid group name "team a"`,
+}, {
+	about: "invalid parent group",
+	group: candidparams.Group{
+		Name:   "team-a",
+		Groups: []string{""},
+	},
+	expectError: `Post .*: group name not specified`,
+}, {
+	about: "unknown owner",
+	group: candidparams.Group{
+		Name:  "team-a",
+		Owner: "alice",
+	},
+	expectError: `Post .*: owner "alice" not found`,
+}}
+
+func (s *groupsSuite) TestCreateGroupError(c *qt.C) {
+	for _, test := range createGroupErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.CreateGroupRequest{
+				Body: test.group,
+			}, nil)
+			c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+func (s *groupsSuite) TestGroupNotFound(c *qt.C) {
+	var group candidparams.Group
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupRequest{
+		Name: "team-a",
+	}, &group)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `Get .*: group team-a not found`)
+
+	var members []string
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupMembersRequest{
+		Name: "team-a",
+	}, &members)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *groupsSuite) TestGroupMembers(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "team-a")
+	s.srv.CreateUser(c, "alice", "team-a", "team-b")
+	s.srv.CreateUser(c, "carol", "team-b")
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.CreateGroupRequest{
+		Body: candidparams.Group{
+			Name: "team-a",
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	var members []string
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.GroupMembersRequest{
+		Name: "team-a",
+	}, &members)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, []string{"alice", "bob"})
+}
+
+func (s *groupsSuite) TestGroupsUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	err := client.Client.Call(s.srv.Ctx, &candidparams.CreateGroupRequest{
+		Body: candidparams.Group{
+			Name: "team-a",
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/groups: permission denied`)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+// A passwordProvider is an identity provider that manages the passwords
+// of its users.
+type passwordProvider interface {
+	Domain() string
+	SetPassword(ctx context.Context, username params.Username, password string) error
+	ResetPassword(ctx context.Context, username params.Username) (string, error)
+}
+
+// SetPassword sets the password of a user of a local identity
+// provider, creating the user if necessary.
+func (h *handler) SetPassword(p httprequest.Params, r *candidparams.SetPasswordRequest) error {
+	pp, err := h.passwordProvider(r.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return errgo.Mask(pp.SetPassword(p.Context, r.Username, r.Body.Password), errgo.Is(params.ErrBadRequest))
+}
+
+// ResetPassword resets the password of a user of a local identity
+// provider to a temporary password that must be changed when the user
+// next logs in.
+func (h *handler) ResetPassword(p httprequest.Params, r *candidparams.ResetPasswordRequest) (*candidparams.ResetPasswordResponse, error) {
+	pp, err := h.passwordProvider(r.Username)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	password, err := pp.ResetPassword(p.Context, r.Username)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return &candidparams.ResetPasswordResponse{
+		Password: password,
+	}, nil
+}
+
+// passwordProvider returns the password managing identity provider
+// whose domain matches the given username.
+func (h *handler) passwordProvider(username params.Username) (passwordProvider, error) {
+	for _, idp := range h.params.IdentityProviders {
+		pp, ok := idp.(passwordProvider)
+		if !ok {
+			continue
+		}
+		if domain := pp.Domain(); domain == "" {
+			if !strings.Contains(string(username), "@") {
+				return pp, nil
+			}
+		} else if strings.HasSuffix(string(username), "@"+domain) {
+			return pp, nil
+		}
+	}
+	return nil, errgo.WithCausef(nil, params.ErrNotFound, "no password identity provider for user %q", username)
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/local"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestPasswordAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &passwordSuite{})
+}
+
+type passwordSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *passwordSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		local.NewIdentityProvider(local.Params{
+			Name:   "local",
+			Domain: "example",
+		}),
+		local.NewIdentityProvider(local.Params{
+			Name: "nodomain",
+		}),
+	}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *passwordSuite) TestSetAndResetPassword(c *qt.C) {
+	for _, test := range []struct {
+		username string
+		provider string
+	}{{
+		username: "bob@example",
+		provider: "local",
+	}, {
+		username: "alice",
+		provider: "nodomain",
+	}} {
+		req := &candidparams.SetPasswordRequest{
+			Username: params.Username(test.username),
+		}
+		req.Body.Password = "correct horse"
+		err := s.adminClient.Client.Call(s.srv.Ctx, req, nil)
+		c.Assert(err, qt.Equals, nil)
+		id := store.Identity{
+			ProviderID: store.MakeProviderIdentity(test.provider, test.username),
+		}
+		err = s.store.Store.Identity(s.srv.Ctx, &id)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(id.Username, qt.Equals, test.username)
+
+		var resp candidparams.ResetPasswordResponse
+		err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.ResetPasswordRequest{
+			Username: params.Username(test.username),
+		}, &resp)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(resp.Password, qt.Not(qt.Equals), "")
+	}
+}
+
+func (s *passwordSuite) TestSetPasswordNoProvider(c *qt.C) {
+	req := &candidparams.SetPasswordRequest{
+		Username: "bob@other",
+	}
+	req.Body.Password = "correct horse"
+	err := s.adminClient.Client.Call(s.srv.Ctx, req, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*/v1/u/bob@other/password: no password identity provider for user "bob@other"`)
+}
+
+func (s *passwordSuite) TestSetPasswordUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "agent@candid")
+	req := &candidparams.SetPasswordRequest{
+		Username: "bob@example",
+	}
+	req.Body.Password = "correct horse"
+	err := client.Client.Call(s.srv.Ctx, req, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*/v1/u/bob@example/password: permission denied`)
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"time"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// RevokeSessions revokes all of the discharge tokens and identity
+// macaroons issued to the given user up to now. The user will have to
+// log in again before using any service.
+func (h *handler) RevokeSessions(p httprequest.Params, r *candidparams.RevokeSessionsRequest) error {
+	if string(r.Username) == auth.AdminUsername {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot revoke the sessions of the admin user")
+	}
+	id := store.Identity{
+		Username:  string(r.Username),
+		NotBefore: time.Now(),
+	}
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, store.Update{
+		store.NotBefore: store.Set,
+	}); err != nil {
+		return translateStoreError(err)
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditRevoke,
+		Username: id.Username,
+		Detail:   "all sessions",
+	})
+	return nil
+}
+
+// RevokeMacaroons adds the given macaroon IDs to the revocation list.
+func (h *handler) RevokeMacaroons(p httprequest.Params, r *candidparams.RevokeMacaroonsRequest) error {
+	for _, id := range r.Body.IDs {
+		if err := h.params.Authorizer.RevokeMacaroon(p.Context, id); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		h.audit(p.Context, store.AuditEntry{
+			Type:   store.AuditRevoke,
+			Detail: "macaroon " + id,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestRevokeAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &revokeSuite{})
+}
+
+type revokeSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *revokeSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *revokeSuite) userToken(c *qt.C, username string) *bakery.Macaroon {
+	m, err := s.adminClient.UserToken(s.srv.Ctx, &params.UserTokenRequest{
+		Username: params.Username(username),
+	})
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
+
+func (s *revokeSuite) verify(m *bakery.Macaroon) error {
+	_, err := s.adminClient.VerifyToken(s.srv.Ctx, &params.VerifyTokenRequest{
+		Macaroons: macaroon.Slice{m.M()},
+	})
+	return err
+}
+
+func (s *revokeSuite) TestRevokeSessions(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	s.srv.CreateUser(c, "alice")
+	bobToken := s.userToken(c, "bob")
+	aliceToken := s.userToken(c, "alice")
+	c.Assert(s.verify(bobToken), qt.Equals, nil)
+
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.RevokeSessionsRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.verify(bobToken)
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/verify: verification failure: macaroon discharge required: authentication required`)
+	c.Assert(s.verify(aliceToken), qt.Equals, nil)
+
+	// A new token issued after the revocation is valid.
+	c.Assert(s.verify(s.userToken(c, "bob")), qt.Equals, nil)
+
+	entries, err := s.store.AuditStore.FindAuditEntries(s.srv.Ctx, store.AuditFilter{
+		Username: "bob",
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditRevoke)
+	c.Assert(entries[0].Actor, qt.Equals, auth.AdminUsername)
+	c.Assert(entries[0].Detail, qt.Equals, "all sessions")
+}
+
+func (s *revokeSuite) TestRevokeSessionsNotFound(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.RevokeSessionsRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/u/bob/revoke-sessions: user bob not found`)
+}
+
+func (s *revokeSuite) TestCannotRevokeAdminSessions(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.RevokeSessionsRequest{
+		Username: auth.AdminUsername,
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*: cannot revoke the sessions of the admin user`)
+}
+
+func (s *revokeSuite) TestRevokeSessionsRequiresAdmin(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.Client.Call(s.srv.Ctx, &candidparams.RevokeSessionsRequest{
+		Username: "bob",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*: permission denied`)
+}
+
+func (s *revokeSuite) TestRevokeMacaroons(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	m1 := s.userToken(c, "bob")
+	m2 := s.userToken(c, "bob")
+	id := auth.MacaroonID(m1.M().Id())
+
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.RevokeMacaroonsRequest{
+		Body: candidparams.RevokeMacaroonsBody{
+			IDs: []string{id},
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.verify(m1)
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/verify: verification failure: macaroon discharge required: authentication required`)
+	c.Assert(s.verify(m2), qt.Equals, nil)
+
+	entries, err := s.store.AuditStore.FindAuditEntries(s.srv.Ctx, store.AuditFilter{})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditRevoke)
+	c.Assert(entries[0].Detail, qt.Equals, "macaroon "+id)
+}
+
+func (s *revokeSuite) TestRevokeMacaroonsInvalidID(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.RevokeMacaroonsRequest{
+		Body: candidparams.RevokeMacaroonsBody{
+			IDs: []string{"not base64!"},
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/revoke-macaroons: invalid macaroon id "not base64!"`)
+}
+
+func (s *revokeSuite) TestRevokeMacaroonsRequiresAdmin(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.Client.Call(s.srv.Ctx, &candidparams.RevokeMacaroonsRequest{
+		Body: candidparams.RevokeMacaroonsBody{
+			IDs: []string{"AAAA"},
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post .*: permission denied`)
+}
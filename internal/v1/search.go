@@ -0,0 +1,126 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"encoding/base64"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const (
+	// defaultSearchLimit holds the number of users returned in a
+	// page of search results when no limit is requested.
+	defaultSearchLimit = 100
+
+	// maxSearchLimit holds the maximum number of users returned in a
+	// page of search results.
+	maxSearchLimit = 1000
+)
+
+// SearchUsers returns a page of the users that match the given request.
+// Users are returned in username order. The returned cursor holds the
+// last username returned, so that the next page starts after it even
+// if users are added or removed between requests.
+func (h *handler) SearchUsers(p httprequest.Params, r *candidparams.SearchUsersRequest) (*candidparams.SearchUsersResponse, error) {
+	var identity store.Identity
+	var filter store.Filter
+
+	var match store.Comparison
+	switch r.Match {
+	case "", candidparams.MatchContains:
+		match = store.Contains
+	case candidparams.MatchPrefix:
+		match = store.Prefix
+	case candidparams.MatchExact:
+		match = store.Equal
+	default:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid match type %q", r.Match)
+	}
+	if r.Name != "" {
+		identity.Name = r.Name
+		filter[store.Name] = match
+	}
+	if r.Email != "" {
+		identity.Email = r.Email
+		filter[store.Email] = match
+	}
+	if len(r.Groups) > 0 {
+		identity.Groups = r.Groups
+		filter[store.Groups] = store.Equal
+	}
+	if r.Provider != "" {
+		identity.ProviderID = store.ProviderIdentity(r.Provider + ":")
+		filter[store.ProviderID] = store.Prefix
+	}
+	if !r.LastLoginSince.IsZero() {
+		identity.LastLogin = r.LastLoginSince
+		filter[store.LastLogin] = store.GreaterThanOrEqual
+	}
+	if !r.LastDischargeSince.IsZero() {
+		identity.LastDischarge = r.LastDischargeSince
+		filter[store.LastDischarge] = store.GreaterThanOrEqual
+	}
+	if r.Cursor != "" {
+		username, err := base64.RawURLEncoding.DecodeString(r.Cursor)
+		if err != nil || len(username) == 0 {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid cursor")
+		}
+		identity.Username = string(username)
+		filter[store.Username] = store.GreaterThan
+	}
+	resp := &candidparams.SearchUsersResponse{
+		Usernames: []string{},
+	}
+	if r.Owner != "" {
+		ownerIdentity := store.Identity{
+			Username: string(r.Owner),
+		}
+		err := h.params.Store.Identity(p.Context, &ownerIdentity)
+		if errgo.Cause(err) == store.ErrNotFound {
+			// If the owner doesn't exist then it has no agents.
+			return resp, nil
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		identity.Owner = ownerIdentity.ProviderID
+		filter[store.Owner] = store.Equal
+	}
+
+	limit := r.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	// Fetch one more identity than is returned so that we can tell
+	// whether there is another page.
+	identities, err := h.params.Store.FindIdentities(p.Context, &identity, filter, []store.Sort{{Field: store.Username}}, 0, limit+1)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(identities) > limit {
+		identities = identities[:limit]
+		resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(identities[limit-1].Username))
+	}
+	for i := range identities {
+		resp.Usernames = append(resp.Usernames, identities[i].Username)
+		if !r.Full {
+			continue
+		}
+		u, err := h.userFromIdentity(p.Context, &identities[i])
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		resp.Users = append(resp.Users, *u)
+	}
+	return resp, nil
+}
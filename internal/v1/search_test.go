@@ -0,0 +1,280 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestSearchAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &searchSuite{})
+}
+
+type searchSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+var searchTime = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var searchIdentities = []store.Identity{{
+	ProviderID:    store.MakeProviderIdentity("test", "alice"),
+	Username:      "alice",
+	Name:          "Alice Smith",
+	Email:         "alice@example.com",
+	Groups:        []string{"g1", "g2"},
+	LastLogin:     searchTime,
+	LastDischarge: searchTime.Add(time.Hour),
+}, {
+	ProviderID: store.MakeProviderIdentity("test", "bob"),
+	Username:   "bob",
+	Name:       "Bob Jones",
+	Email:      "bob@example.org",
+	Groups:     []string{"g1"},
+	LastLogin:  searchTime.Add(time.Hour),
+}, {
+	ProviderID:    store.MakeProviderIdentity("other", "carol"),
+	Username:      "carol",
+	Name:          "Carol Smithson",
+	Email:         "carol@example.com",
+	Groups:        []string{"g2"},
+	LastDischarge: searchTime,
+}, {
+	ProviderID: store.MakeProviderIdentity("other", "dave"),
+	Username:   "dave",
+	Name:       "Dave Brown",
+	Email:      "smith@example.net",
+}}
+
+func (s *searchSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+	for _, id := range searchIdentities {
+		id := id
+		err := s.store.Store.UpdateIdentity(s.srv.Ctx, &id, store.Update{
+			store.Username:      store.Set,
+			store.Name:          store.Set,
+			store.Email:         store.Set,
+			store.Groups:        store.Set,
+			store.LastLogin:     store.Set,
+			store.LastDischarge: store.Set,
+		})
+		c.Assert(err, qt.Equals, nil)
+	}
+}
+
+var searchUsersTests = []struct {
+	about       string
+	req         candidparams.SearchUsersRequest
+	expectUsers []string
+	expectError string
+}{{
+	about: "name contains",
+	req: candidparams.SearchUsersRequest{
+		Name:     "smith",
+		Provider: "test",
+	},
+	expectUsers: []string{"alice"},
+}, {
+	about: "name contains any provider",
+	req: candidparams.SearchUsersRequest{
+		Name: "SMITH",
+	},
+	expectUsers: []string{"alice", "carol"},
+}, {
+	about: "name prefix",
+	req: candidparams.SearchUsersRequest{
+		Name:  "smith",
+		Match: candidparams.MatchPrefix,
+	},
+	expectUsers: []string{},
+}, {
+	about: "email prefix",
+	req: candidparams.SearchUsersRequest{
+		Email: "smith",
+		Match: candidparams.MatchPrefix,
+	},
+	expectUsers: []string{"dave"},
+}, {
+	about: "email exact",
+	req: candidparams.SearchUsersRequest{
+		Email: "smith@example.net",
+		Match: candidparams.MatchExact,
+	},
+	expectUsers: []string{"dave"},
+}, {
+	about: "email exact no match",
+	req: candidparams.SearchUsersRequest{
+		Email: "smith@example",
+		Match: candidparams.MatchExact,
+	},
+	expectUsers: []string{},
+}, {
+	about: "email contains",
+	req: candidparams.SearchUsersRequest{
+		Email: "example.com",
+	},
+	expectUsers: []string{"alice", "carol"},
+}, {
+	about: "groups",
+	req: candidparams.SearchUsersRequest{
+		Groups: []string{"g1"},
+	},
+	expectUsers: []string{"alice", "bob"},
+}, {
+	about: "multiple groups",
+	req: candidparams.SearchUsersRequest{
+		Groups: []string{"g1", "g2"},
+	},
+	expectUsers: []string{"alice"},
+}, {
+	about: "provider",
+	req: candidparams.SearchUsersRequest{
+		Provider: "other",
+	},
+	expectUsers: []string{"carol", "dave"},
+}, {
+	about: "last login",
+	req: candidparams.SearchUsersRequest{
+		LastLoginSince: searchTime,
+	},
+	expectUsers: []string{"alice", "bob"},
+}, {
+	about: "last discharge",
+	req: candidparams.SearchUsersRequest{
+		LastDischargeSince: searchTime.Add(time.Minute),
+		Groups:             []string{"g2"},
+	},
+	expectUsers: []string{"alice"},
+}, {
+	about: "combined filters",
+	req: candidparams.SearchUsersRequest{
+		Email:  "example",
+		Groups: []string{"g2"},
+	},
+	expectUsers: []string{"alice", "carol"},
+}, {
+	about: "unknown owner",
+	req: candidparams.SearchUsersRequest{
+		Owner: "nobody",
+	},
+	expectUsers: []string{},
+}, {
+	about: "invalid match",
+	req: candidparams.SearchUsersRequest{
+		Name:  "alice",
+		Match: "regexp",
+	},
+	expectError: `Get .*/v1/users.*: invalid match type "regexp"`,
+}, {
+	about: "invalid cursor",
+	req: candidparams.SearchUsersRequest{
+		Cursor: "!!!",
+	},
+	expectError: `Get .*/v1/users.*: invalid cursor`,
+}}
+
+func (s *searchSuite) TestSearchUsers(c *qt.C) {
+	for _, test := range searchUsersTests {
+		c.Run(test.about, func(c *qt.C) {
+			var resp candidparams.SearchUsersResponse
+			err := s.adminClient.Client.Call(s.srv.Ctx, &test.req, &resp)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(resp.Usernames, qt.DeepEquals, test.expectUsers)
+			c.Assert(resp.Users, qt.IsNil)
+			c.Assert(resp.Cursor, qt.Equals, "")
+		})
+	}
+}
+
+func (s *searchSuite) TestSearchUsersPaging(c *qt.C) {
+	var usernames []string
+	req := candidparams.SearchUsersRequest{
+		Email: "@example",
+		Limit: 3,
+	}
+	pages := 0
+	for {
+		var resp candidparams.SearchUsersResponse
+		err := s.adminClient.Client.Call(s.srv.Ctx, &req, &resp)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(len(resp.Usernames) <= req.Limit, qt.Equals, true)
+		usernames = append(usernames, resp.Usernames...)
+		pages++
+		if resp.Cursor == "" {
+			break
+		}
+		req.Cursor = resp.Cursor
+	}
+	c.Assert(pages, qt.Equals, 2)
+	c.Assert(usernames, qt.DeepEquals, []string{"alice", "bob", "carol", "dave"})
+}
+
+func (s *searchSuite) TestSearchUsersPagingConcurrentChange(c *qt.C) {
+	req := candidparams.SearchUsersRequest{
+		Provider: "test",
+		Limit:    1,
+	}
+	var resp candidparams.SearchUsersResponse
+	err := s.adminClient.Client.Call(s.srv.Ctx, &req, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.Usernames, qt.DeepEquals, []string{"alice"})
+	c.Assert(resp.Cursor, qt.Not(qt.Equals), "")
+
+	// A user added before the cursor does not cause users
+	// to be repeated in the next page.
+	s.srv.CreateUser(c, "aaron")
+
+	req.Cursor = resp.Cursor
+	resp = candidparams.SearchUsersResponse{}
+	err = s.adminClient.Client.Call(s.srv.Ctx, &req, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.Usernames, qt.DeepEquals, []string{"bob"})
+	c.Assert(resp.Cursor, qt.Equals, "")
+}
+
+func (s *searchSuite) TestSearchUsersFull(c *qt.C) {
+	var resp candidparams.SearchUsersResponse
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.SearchUsersRequest{
+		Groups: []string{"g1"},
+		Full:   true,
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.Usernames, qt.DeepEquals, []string{"alice", "bob"})
+	c.Assert(resp.Users, qt.HasLen, 2)
+	c.Assert(string(resp.Users[0].Username), qt.Equals, "alice")
+	c.Assert(resp.Users[0].FullName, qt.Equals, "Alice Smith")
+	c.Assert(resp.Users[0].Email, qt.Equals, "alice@example.com")
+	c.Assert(resp.Users[0].IDPGroups, qt.DeepEquals, []string{"g1", "g2"})
+	c.Assert(string(resp.Users[1].Username), qt.Equals, "bob")
+	c.Assert(resp.Users[1].FullName, qt.Equals, "Bob Jones")
+}
+
+func (s *searchSuite) TestSearchUsersUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	var resp candidparams.SearchUsersResponse
+	err := client.Client.Call(s.srv.Ctx, &candidparams.SearchUsersRequest{}, &resp)
+	c.Assert(err, qt.ErrorMatches, `Get .*/v1/users.*: permission denied`)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// UserStatus returns the status of the given user.
+func (h *handler) UserStatus(p httprequest.Params, r *candidparams.UserStatusRequest) (*candidparams.UserStatusResponse, error) {
+	id := store.Identity{
+		Username: string(r.Username),
+	}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
+		return nil, translateStoreError(err)
+	}
+	status := candidparams.UserActive
+	if id.Suspended {
+		status = candidparams.UserSuspended
+	}
+	return &candidparams.UserStatusResponse{
+		Status: status,
+	}, nil
+}
+
+// SetUserStatus suspends or resumes the given user. While a user is
+// suspended all requests authenticated as that user, including
+// discharge requests, are refused.
+func (h *handler) SetUserStatus(p httprequest.Params, r *candidparams.SetUserStatusRequest) error {
+	id := store.Identity{
+		Username: string(r.Username),
+	}
+	switch r.Body.Status {
+	case candidparams.UserActive:
+	case candidparams.UserSuspended:
+		id.Suspended = true
+	default:
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid status %q", r.Body.Status)
+	}
+	if id.Username == auth.AdminUsername {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot change the status of the admin user")
+	}
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, store.Update{
+		store.Suspended: store.Set,
+	}); err != nil {
+		return translateStoreError(err)
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditStatusChange,
+		Username: id.Username,
+		Detail:   string(r.Body.Status),
+	})
+	return nil
+}
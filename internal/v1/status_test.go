@@ -0,0 +1,144 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+func TestUserStatusAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &userStatusSuite{})
+}
+
+type userStatusSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *userStatusSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+func (s *userStatusSuite) setStatus(c *qt.C, username string, status candidparams.UserStatus) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.SetUserStatusRequest{
+		Username: params.Username(username),
+		Body: candidparams.SetUserStatusBody{
+			Status: status,
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *userStatusSuite) status(c *qt.C, username string) candidparams.UserStatus {
+	var resp candidparams.UserStatusResponse
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.UserStatusRequest{
+		Username: params.Username(username),
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	return resp.Status
+}
+
+func (s *userStatusSuite) TestSuspendAndResume(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid", "g1")
+	_, err := client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.status(c, "bob@candid"), qt.Equals, candidparams.UserActive)
+
+	s.setStatus(c, "bob@candid", candidparams.UserSuspended)
+	c.Assert(s.status(c, "bob@candid"), qt.Equals, candidparams.UserSuspended)
+
+	// The identity macaroon obtained before the user was
+	// suspended is now rejected, and a new one cannot be
+	// obtained.
+	_, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.ErrorMatches, `.*user "bob@candid" is suspended`)
+
+	// Suspending a user does not change its groups.
+	id := store.Identity{
+		Username: "bob@candid",
+	}
+	err = s.store.Store.Identity(s.srv.Ctx, &id)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id.Groups, qt.DeepEquals, []string{"g1"})
+
+	s.setStatus(c, "bob@candid", candidparams.UserActive)
+	c.Assert(s.status(c, "bob@candid"), qt.Equals, candidparams.UserActive)
+	_, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *userStatusSuite) TestSuspendAudited(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	s.setStatus(c, "bob", candidparams.UserSuspended)
+	entries, err := s.store.AuditStore.FindAuditEntries(s.srv.Ctx, store.AuditFilter{
+		Username: "bob",
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditStatusChange)
+	c.Assert(entries[0].Actor, qt.Equals, auth.AdminUsername)
+	c.Assert(entries[0].Detail, qt.Equals, "suspended")
+}
+
+func (s *userStatusSuite) TestSetInvalidStatus(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.SetUserStatusRequest{
+		Username: "bob",
+		Body: candidparams.SetUserStatusBody{
+			Status: "deleted",
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*/v1/u/bob/status: invalid status "deleted"`)
+}
+
+func (s *userStatusSuite) TestSetStatusNotFound(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.SetUserStatusRequest{
+		Username: "bob",
+		Body: candidparams.SetUserStatusBody{
+			Status: candidparams.UserSuspended,
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*/v1/u/bob/status: user bob not found`)
+}
+
+func (s *userStatusSuite) TestCannotSuspendAdmin(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.SetUserStatusRequest{
+		Username: auth.AdminUsername,
+		Body: candidparams.SetUserStatusBody{
+			Status: candidparams.UserSuspended,
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*: cannot change the status of the admin user`)
+}
+
+func (s *userStatusSuite) TestSuspendRequiresAdmin(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.Client.Call(s.srv.Ctx, &candidparams.SetUserStatusRequest{
+		Username: "bob",
+		Body: candidparams.SetUserStatusBody{
+			Status: candidparams.UserSuspended,
+		},
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Put .*: permission denied`)
+}
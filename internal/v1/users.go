@@ -24,6 +24,7 @@ import (
 
 	"github.com/CanonicalLtd/candid/internal/auth"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 var blacklistUsernames = map[params.Username]bool{
@@ -34,6 +35,9 @@ var blacklistUsernames = map[params.Username]bool{
 
 // QueryUsers filters the user database for users that match the given
 // request. If no filters are requested all usernames will be returned.
+//
+// Deprecated: QueryUsers returns all the matching users at once. Use
+// SearchUsers, which returns the results a page at a time.
 func (h *handler) QueryUsers(p httprequest.Params, r *params.QueryUsersRequest) ([]string, error) {
 	var identity store.Identity
 	var filter store.Filter
@@ -77,8 +81,6 @@ func (h *handler) QueryUsers(p httprequest.Params, r *params.QueryUsersRequest)
 		filter[store.Owner] = store.Equal
 	}
 
-	// TODO(mhilton) make sure this endpoint can be queried as a
-	// subset once there are more users.
 	identities, err := h.params.Store.FindIdentities(p.Context, &identity, filter, []store.Sort{{Field: store.Username}}, 0, 0)
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -170,6 +172,20 @@ func (h *handler) CreateAgent(p httprequest.Params, u *params.CreateAgentRequest
 	if err := h.params.Store.UpdateIdentity(p.Context, identity, update); err != nil {
 		return nil, translateStoreError(err)
 	}
+	entry := store.AuditEntry{
+		Type:     store.AuditAgentCreate,
+		Username: identity.Username,
+	}
+	if len(identity.Groups) > 0 {
+		entry.Detail = "groups: " + strings.Join(identity.Groups, " ")
+	}
+	h.audit(ctx, entry)
+	h.params.Webhooks.Notify(ctx, webhook.Event{
+		Type:     webhook.AgentCreated,
+		Username: identity.Username,
+		Owner:    owner.Username,
+		Groups:   identity.Groups,
+	})
 	return &params.CreateAgentResponse{
 		Username: params.Username(identity.Username),
 	}, nil
@@ -229,7 +245,16 @@ func (h *handler) SetUserGroups(p httprequest.Params, r *params.SetUserGroupsReq
 		Username: string(r.Username),
 		Groups:   r.Groups.Groups,
 	}
-	return translateStoreError(h.params.Store.UpdateIdentity(p.Context, &identity, store.Update{store.Groups: store.Set}))
+	if err := h.params.Store.UpdateIdentity(p.Context, &identity, store.Update{store.Groups: store.Set}); err != nil {
+		return translateStoreError(err)
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditGroupsChange,
+		Username: identity.Username,
+		Detail:   "set: " + strings.Join(identity.Groups, " "),
+	})
+	h.notifyGroupsChanged(p.Context, identity.Username)
+	return nil
 }
 
 // ModifyUserGroups updates the groups stored for the given user. Groups
@@ -243,14 +268,25 @@ func (h *handler) ModifyUserGroups(p httprequest.Params, r *params.ModifyUserGro
 	if len(r.Groups.Add) > 0 && len(r.Groups.Remove) > 0 {
 		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot add and remove groups in the same operation")
 	}
+	detail := "add: "
 	if len(r.Groups.Add) > 0 {
 		identity.Groups = r.Groups.Add
 		update[store.Groups] = store.Push
 	} else {
 		identity.Groups = r.Groups.Remove
 		update[store.Groups] = store.Pull
+		detail = "remove: "
+	}
+	if err := h.params.Store.UpdateIdentity(p.Context, &identity, update); err != nil {
+		return translateStoreError(err)
 	}
-	return translateStoreError(h.params.Store.UpdateIdentity(p.Context, &identity, update))
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditGroupsChange,
+		Username: identity.Username,
+		Detail:   detail + strings.Join(identity.Groups, " "),
+	})
+	h.notifyGroupsChanged(p.Context, identity.Username)
+	return nil
 }
 
 // GetSSHKeys returns any SSH keys stored for the given user.
@@ -279,7 +315,20 @@ func (h *handler) PutSSHKeys(p httprequest.Params, r *params.PutSSHKeysRequest)
 	update := store.Update{
 		store.ExtraInfo: store.Push,
 	}
-	return translateStoreError(h.params.Store.UpdateIdentity(p.Context, &id, update))
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, update); err != nil {
+		return translateStoreError(err)
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditSSHKeysChange,
+		Username: id.Username,
+		Detail:   fmt.Sprintf("added %d key(s)", len(r.Body.SSHKeys)),
+	})
+	h.params.Webhooks.Notify(p.Context, webhook.Event{
+		Type:     webhook.SSHKeysAdded,
+		Username: id.Username,
+		SSHKeys:  r.Body.SSHKeys,
+	})
+	return nil
 }
 
 // DeleteSSHKeys removes all of the ssh keys specified from the keys
@@ -295,7 +344,20 @@ func (h *handler) DeleteSSHKeys(p httprequest.Params, r *params.DeleteSSHKeysReq
 	update := store.Update{
 		store.ExtraInfo: store.Pull,
 	}
-	return translateStoreError(h.params.Store.UpdateIdentity(p.Context, &id, update))
+	if err := h.params.Store.UpdateIdentity(p.Context, &id, update); err != nil {
+		return translateStoreError(err)
+	}
+	h.audit(p.Context, store.AuditEntry{
+		Type:     store.AuditSSHKeysChange,
+		Username: id.Username,
+		Detail:   fmt.Sprintf("removed %d key(s)", len(r.Body.SSHKeys)),
+	})
+	h.params.Webhooks.Notify(p.Context, webhook.Event{
+		Type:     webhook.SSHKeysRemoved,
+		Username: id.Username,
+		SSHKeys:  r.Body.SSHKeys,
+	})
+	return nil
 }
 
 // UserToken returns a token, in the form of a macaroon, identifying
@@ -305,13 +367,18 @@ func (h *handler) UserToken(p httprequest.Params, r *params.UserTokenRequest) (*
 	if err != nil {
 		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
+	sid, err := id.StoreIdentity(p.Context)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
 	m, err := h.params.Oven.NewMacaroon(
 		p.Context,
 		httpbakery.RequestVersion(p.Request),
-		[]checkers.Caveat{
+		append([]checkers.Caveat{
 			candidclient.UserDeclaration(id.Id()),
+			auth.IssuedCaveat(time.Now()),
 			checkers.TimeBeforeCaveat(time.Now().Add(24 * time.Hour)),
-		},
+		}, auth.ProviderAuthenticationCaveats(sid)...),
 		identchecker.LoginOp,
 	)
 	if err != nil {
@@ -516,7 +583,7 @@ func translateStoreError(err error) error {
 	switch errgo.Cause(err) {
 	case store.ErrNotFound:
 		cause = params.ErrNotFound
-	case store.ErrDuplicateUsername:
+	case store.ErrDuplicateUsername, store.ErrDuplicateGroup:
 		cause = params.ErrAlreadyExists
 	case nil:
 		return nil
@@ -535,19 +602,20 @@ const (
 // DischargeTokenForUser allows an administrator to create a discharge
 // token for the specified user.
 func (h *handler) DischargeTokenForUser(p httprequest.Params, req *params.DischargeTokenForUserRequest) (params.DischargeTokenForUserResponse, error) {
-	err := h.params.Store.Identity(p.Context, &store.Identity{
+	id := store.Identity{
 		Username: string(req.Username),
-	})
-	if err != nil {
+	}
+	if err := h.params.Store.Identity(p.Context, &id); err != nil {
 		return params.DischargeTokenForUserResponse{}, errgo.NoteMask(err, "cannot get identity", errgo.Is(params.ErrNotFound))
 	}
 	m, err := h.params.Oven.NewMacaroon(
 		p.Context,
 		httpbakery.RequestVersion(p.Request),
-		[]checkers.Caveat{
+		append([]checkers.Caveat{
 			checkers.TimeBeforeCaveat(time.Now().Add(dischargeTokenDuration)),
 			candidclient.UserDeclaration(string(req.Username)),
-		},
+			auth.IssuedCaveat(time.Now()),
+		}, auth.ProviderAuthenticationCaveats(&id)...),
 		identchecker.LoginOp,
 	)
 	if err != nil {
@@ -15,6 +15,7 @@ import (
 	"gopkg.in/CanonicalLtd/candidclient.v1/params"
 	"gopkg.in/httprequest.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	macaroon "gopkg.in/macaroon.v2"
 
@@ -682,6 +683,7 @@ func (s *usersSuite) TestVerifyUserToken(c *qt.C) {
 	c.Assert(declared, qt.DeepEquals, map[string]string{
 		"username": "jbloggs",
 	})
+	s.assertCannotForgeAuthentication(c, m.M())
 
 	badm, err := macaroon.New([]byte{}, []byte("no such macaroon"), "loc", macaroon.LatestVersion)
 	c.Assert(err, qt.Equals, nil)
@@ -724,6 +726,24 @@ func (s *usersSuite) TestDischargeToken(c *qt.C) {
 	c.Assert(declared, qt.DeepEquals, map[string]string{
 		"username": "jbloggs",
 	})
+	s.assertCannotForgeAuthentication(c, resp.DischargeToken.M())
+}
+
+// assertCannotForgeAuthentication checks that the given token declares
+// how the user authenticated, so that the holder cannot add their own
+// declaration.
+func (s *usersSuite) assertCannotForgeAuthentication(c *qt.C, m *macaroon.Macaroon) {
+	declared := checkers.InferDeclared(nil, macaroon.Slice{m})
+	c.Assert(declared["idp"], qt.Equals, "http")
+	c.Assert(declared["mfa"], qt.Equals, "false")
+
+	m = m.Clone()
+	err := m.AddFirstPartyCaveat([]byte("declared mfa true"))
+	c.Assert(err, qt.Equals, nil)
+	_, err = s.adminClient.VerifyToken(s.srv.Ctx, &params.VerifyTokenRequest{
+		Macaroons: macaroon.Slice{m},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/verify: verification failure: .*`)
 }
 
 var userGroupTests = []struct {
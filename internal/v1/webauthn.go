@@ -0,0 +1,101 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	candidparams "github.com/CanonicalLtd/candid/params"
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// A webAuthnProvider is an identity provider that can register WebAuthn
+// credentials for existing users.
+type webAuthnProvider interface {
+	WebAuthnCredentials(ctx context.Context, id *store.Identity) ([]candidparams.WebAuthnCredential, error)
+	StartWebAuthnRegistration(ctx context.Context, id *store.Identity, name string) (*candidparams.StartWebAuthnRegistrationResponse, error)
+	CompleteWebAuthnRegistration(ctx context.Context, id *store.Identity, sessionID string, credential []byte) (*candidparams.WebAuthnCredential, error)
+	RemoveWebAuthnCredential(ctx context.Context, id *store.Identity, credentialID string) error
+}
+
+// WebAuthnCredentials returns the WebAuthn credentials registered for
+// the given user.
+func (h *handler) WebAuthnCredentials(p httprequest.Params, r *candidparams.WebAuthnCredentialsRequest) ([]candidparams.WebAuthnCredential, error) {
+	wp, id, err := h.webAuthnIdentity(p.Context, r.Username)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	creds, err := wp.WebAuthnCredentials(p.Context, id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return creds, nil
+}
+
+// StartWebAuthnRegistration starts the registration of a new WebAuthn
+// credential for the given user. The returned options should be passed
+// to navigator.credentials.create in a browser that has access to the
+// user's authenticator, and the result used to complete the
+// registration.
+func (h *handler) StartWebAuthnRegistration(p httprequest.Params, r *candidparams.StartWebAuthnRegistrationRequest) (*candidparams.StartWebAuthnRegistrationResponse, error) {
+	wp, id, err := h.webAuthnIdentity(p.Context, r.Username)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	resp, err := wp.StartWebAuthnRegistration(p.Context, id, r.Body.Name)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return resp, nil
+}
+
+// CompleteWebAuthnRegistration completes the registration of a WebAuthn
+// credential for the given user.
+func (h *handler) CompleteWebAuthnRegistration(p httprequest.Params, r *candidparams.CompleteWebAuthnRegistrationRequest) (*candidparams.WebAuthnCredential, error) {
+	wp, id, err := h.webAuthnIdentity(p.Context, r.Username)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	cred, err := wp.CompleteWebAuthnRegistration(p.Context, id, r.SessionID, r.Credential)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return cred, nil
+}
+
+// DeleteWebAuthnCredential removes a WebAuthn credential from the given
+// user.
+func (h *handler) DeleteWebAuthnCredential(p httprequest.Params, r *candidparams.DeleteWebAuthnCredentialRequest) error {
+	wp, id, err := h.webAuthnIdentity(p.Context, r.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return errgo.Mask(wp.RemoveWebAuthnCredential(p.Context, id, r.ID), errgo.Is(params.ErrNotFound))
+}
+
+// webAuthnIdentity returns the configured WebAuthn identity provider and
+// the identity with the given username.
+func (h *handler) webAuthnIdentity(ctx context.Context, username params.Username) (webAuthnProvider, *store.Identity, error) {
+	var wp webAuthnProvider
+	for _, idp := range h.params.IdentityProviders {
+		if p, ok := idp.(webAuthnProvider); ok {
+			wp = p
+			break
+		}
+	}
+	if wp == nil {
+		return nil, nil, errgo.WithCausef(nil, params.ErrNotFound, "no webauthn identity provider configured")
+	}
+	id := store.Identity{
+		Username: string(username),
+	}
+	if err := h.params.Store.Identity(ctx, &id); err != nil {
+		return nil, nil, translateStoreError(err)
+	}
+	return wp, &id, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+
+	"github.com/CanonicalLtd/candid/idp"
+	"github.com/CanonicalLtd/candid/idp/webauthn"
+	"github.com/CanonicalLtd/candid/idp/webauthn/webauthntest"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	candidparams "github.com/CanonicalLtd/candid/params"
+)
+
+func TestWebAuthnAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webAuthnSuite{})
+}
+
+type webAuthnSuite struct {
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *webAuthnSuite) Init(c *qt.C) {
+	store := candidtest.NewStore()
+	sp := store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		webauthn.NewIdentityProvider(webauthn.Params{}),
+	}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+	s.srv.CreateUser(c, "bob")
+}
+
+func (s *webAuthnSuite) TestRegisterAndRemove(c *qt.C) {
+	auth, err := webauthntest.NewAuthenticator(s.srv.URL)
+	c.Assert(err, qt.Equals, nil)
+
+	var start candidparams.StartWebAuthnRegistrationResponse
+	req := &candidparams.StartWebAuthnRegistrationRequest{
+		Username: "bob",
+	}
+	req.Body.Name = "my key"
+	err = s.adminClient.Client.Call(s.srv.Ctx, req, &start)
+	c.Assert(err, qt.Equals, nil)
+
+	cred, err := auth.Create(start.Options)
+	c.Assert(err, qt.Equals, nil)
+	var registered candidparams.WebAuthnCredential
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.CompleteWebAuthnRegistrationRequest{
+		Username:   "bob",
+		SessionID:  start.SessionID,
+		Credential: cred,
+	}, &registered)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(registered.Name, qt.Equals, "my key")
+
+	var creds []candidparams.WebAuthnCredential
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.WebAuthnCredentialsRequest{
+		Username: "bob",
+	}, &creds)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(creds, qt.DeepEquals, []candidparams.WebAuthnCredential{registered})
+
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteWebAuthnCredentialRequest{
+		Username: "bob",
+		ID:       registered.ID,
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	creds = nil
+	err = s.adminClient.Client.Call(s.srv.Ctx, &candidparams.WebAuthnCredentialsRequest{
+		Username: "bob",
+	}, &creds)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(creds, qt.HasLen, 0)
+}
+
+func (s *webAuthnSuite) TestDeleteCredentialNotFound(c *qt.C) {
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.DeleteWebAuthnCredentialRequest{
+		Username: "bob",
+		ID:       "AAAA",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Delete .*/v1/u/bob/webauthn/AAAA: credential "AAAA" not found`)
+}
+
+func (s *webAuthnSuite) TestUserNotFound(c *qt.C) {
+	var creds []candidparams.WebAuthnCredential
+	err := s.adminClient.Client.Call(s.srv.Ctx, &candidparams.WebAuthnCredentialsRequest{
+		Username: "alice",
+	}, &creds)
+	c.Assert(err, qt.ErrorMatches, `Get .*/v1/u/alice/webauthn: user alice not found`)
+}
+
+func (s *webAuthnSuite) TestUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "agent@candid")
+	var creds []candidparams.WebAuthnCredential
+	err := client.Client.Call(s.srv.Ctx, &candidparams.WebAuthnCredentialsRequest{
+		Username: "bob",
+	}, &creds)
+	c.Assert(err, qt.ErrorMatches, `Get .*/v1/u/bob/webauthn: permission denied`)
+}
+
+func (s *webAuthnSuite) TestNotConfigured(c *qt.C) {
+	srv := candidtest.NewServer(c, candidtest.NewStore().ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	srv.CreateUser(c, "bob")
+	var creds []candidparams.WebAuthnCredential
+	err := srv.AdminIdentityClient().Client.Call(srv.Ctx, &candidparams.WebAuthnCredentialsRequest{
+		Username: "bob",
+	}, &creds)
+	c.Assert(err, qt.ErrorMatches, `Get .*/v1/u/bob/webauthn: no webauthn identity provider configured`)
+}
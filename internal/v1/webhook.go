@@ -0,0 +1,31 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// notifyGroupsChanged sends a GroupsChanged event containing the
+// groups now stored for the given user.
+func (h *handler) notifyGroupsChanged(ctx context.Context, username string) {
+	if h.params.Webhooks == nil {
+		return
+	}
+	id := store.Identity{
+		Username: username,
+	}
+	if err := h.params.Store.Identity(ctx, &id); err != nil {
+		logger.Errorf("cannot retrieve groups for %s event: %s", webhook.GroupsChanged, err)
+		return
+	}
+	h.params.Webhooks.Notify(ctx, webhook.Event{
+		Type:     webhook.GroupsChanged,
+		Username: username,
+		Groups:   id.Groups,
+	})
+}
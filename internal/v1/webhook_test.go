@@ -0,0 +1,148 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/CanonicalLtd/candidclient.v1"
+	"gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/CanonicalLtd/candid/internal/auth"
+	"github.com/CanonicalLtd/candid/internal/candidtest"
+	"github.com/CanonicalLtd/candid/internal/discharger"
+	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/v1"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webhookSuite{})
+}
+
+type webhookSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+	events      chan webhook.Event
+}
+
+func (s *webhookSuite) Init(c *qt.C) {
+	s.events = make(chan webhook.Event, 20)
+	hooksrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev webhook.Event
+		err := json.NewDecoder(req.Body).Decode(&ev)
+		c.Check(err, qt.Equals, nil)
+		s.events <- ev
+	}))
+	c.Defer(hooksrv.Close)
+
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.Webhooks = []webhook.Hook{{
+		URL: hooksrv.URL,
+	}}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient()
+}
+
+// nextEvent returns the next event delivered to the webhook with the
+// ID and time removed.
+func (s *webhookSuite) nextEvent(c *qt.C) webhook.Event {
+	select {
+	case ev := <-s.events:
+		c.Assert(ev.ID, qt.Not(qt.Equals), "")
+		c.Assert(ev.Time.IsZero(), qt.Equals, false)
+		ev.ID = ""
+		ev.Time = time.Time{}
+		return ev
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for webhook event")
+	}
+	panic("unreachable")
+}
+
+func (s *webhookSuite) TestGroupsChanged(c *qt.C) {
+	s.srv.CreateUser(c, "bob", "g1")
+	err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "bob",
+		Groups: params.ModifyGroups{
+			Add: []string{"g2"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.nextEvent(c), qt.DeepEquals, webhook.Event{
+		Type:     webhook.GroupsChanged,
+		Username: "bob",
+		Groups:   []string{"g1", "g2"},
+	})
+	err = s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+		Username: "bob",
+		Groups: params.Groups{
+			Groups: []string{"g3"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.nextEvent(c), qt.DeepEquals, webhook.Event{
+		Type:     webhook.GroupsChanged,
+		Username: "bob",
+		Groups:   []string{"g3"},
+	})
+}
+
+func (s *webhookSuite) TestSSHKeys(c *qt.C) {
+	s.srv.CreateUser(c, "bob")
+	err := s.adminClient.PutSSHKeys(s.srv.Ctx, &params.PutSSHKeysRequest{
+		Username: "bob",
+		Body: params.PutSSHKeysBody{
+			SSHKeys: []string{"ssh-rsa key1", "ssh-rsa key2"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.nextEvent(c), qt.DeepEquals, webhook.Event{
+		Type:     webhook.SSHKeysAdded,
+		Username: "bob",
+		SSHKeys:  []string{"ssh-rsa key1", "ssh-rsa key2"},
+	})
+	err = s.adminClient.DeleteSSHKeys(s.srv.Ctx, &params.DeleteSSHKeysRequest{
+		Username: "bob",
+		Body: params.DeleteSSHKeysBody{
+			SSHKeys: []string{"ssh-rsa key1"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.nextEvent(c), qt.DeepEquals, webhook.Event{
+		Type:     webhook.SSHKeysRemoved,
+		Username: "bob",
+		SSHKeys:  []string{"ssh-rsa key1"},
+	})
+}
+
+func (s *webhookSuite) TestAgentCreated(c *qt.C) {
+	key, err := bakery.GenerateKey()
+	c.Assert(err, qt.Equals, nil)
+	resp, err := s.adminClient.CreateAgent(s.srv.Ctx, &params.CreateAgentRequest{
+		CreateAgentBody: params.CreateAgentBody{
+			PublicKeys: []*bakery.PublicKey{&key.Public},
+			Groups:     []string{"g1"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.nextEvent(c), qt.DeepEquals, webhook.Event{
+		Type:     webhook.AgentCreated,
+		Username: string(resp.Username),
+		Owner:    auth.AdminUsername,
+		Groups:   []string{"g1"},
+	})
+}
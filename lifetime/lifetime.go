@@ -0,0 +1,119 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package lifetime determines how long the macaroons issued by the
+// identity server remain valid.
+//
+// Lifetimes may be configured globally, per identity provider and per
+// group. A lifetime configured for a group takes precedence over one
+// configured for an identity provider, which in turn takes precedence
+// over the global setting.
+package lifetime
+
+import "time"
+
+const (
+	// DefaultDischarge holds the default lifetime of a discharge
+	// macaroon.
+	DefaultDischarge = 24 * time.Hour
+
+	// DefaultDischargeToken holds the default lifetime of a
+	// discharge token issued after logging in to an identity
+	// provider.
+	DefaultDischargeToken = 28 * 24 * time.Hour
+)
+
+// Durations holds a set of macaroon lifetimes. A zero value indicates
+// that the lifetime has not been set.
+type Durations struct {
+	// Discharge holds the lifetime of discharge macaroons.
+	Discharge time.Duration `yaml:"discharge"`
+
+	// DischargeToken holds the lifetime of discharge tokens, and
+	// hence how long a user stays logged in.
+	DischargeToken time.Duration `yaml:"discharge-token"`
+}
+
+// merge sets any lifetimes that are not set in d from d1.
+func (d *Durations) merge(d1 Durations) {
+	if d.Discharge == 0 {
+		d.Discharge = d1.Discharge
+	}
+	if d.DischargeToken == 0 {
+		d.DischargeToken = d1.DischargeToken
+	}
+}
+
+// Config holds the configured macaroon lifetimes.
+type Config struct {
+	// Durations holds the lifetimes that apply when no more
+	// specific lifetime has been configured.
+	Durations `yaml:",inline"`
+
+	// IdentityProviders holds lifetimes that apply to identities
+	// authenticated by the identity provider with the given name.
+	IdentityProviders map[string]Durations `yaml:"identity-providers"`
+
+	// Groups holds lifetimes that apply to members of the given
+	// group. When an identity is a member of more than one
+	// configured group the shortest lifetime is used.
+	Groups map[string]Durations `yaml:"groups"`
+}
+
+// For returns the lifetimes that apply to an identity authenticated by
+// the given identity provider that is a member of the given groups.
+// Every lifetime in the returned value is set. It is OK to call For on
+// a nil *Config, in which case the default lifetimes are returned.
+func (c *Config) For(idp string, groups []string) Durations {
+	var d Durations
+	if c != nil {
+		for _, g := range groups {
+			gd, ok := c.Groups[g]
+			if !ok {
+				continue
+			}
+			d.Discharge = shortest(d.Discharge, gd.Discharge)
+			d.DischargeToken = shortest(d.DischargeToken, gd.DischargeToken)
+		}
+		d.merge(c.IdentityProviders[idp])
+		d.merge(c.Durations)
+	}
+	d.merge(Durations{
+		Discharge:      DefaultDischarge,
+		DischargeToken: DefaultDischargeToken,
+	})
+	return d
+}
+
+// Max returns the longest lifetime that can be given to any macaroon
+// using the configuration in c. It is OK to call Max on a nil *Config.
+func (c *Config) Max() time.Duration {
+	d := c.For("", nil)
+	l := longest(d.Discharge, d.DischargeToken)
+	if c == nil {
+		return l
+	}
+	for _, m := range []map[string]Durations{c.IdentityProviders, c.Groups} {
+		for _, d := range m {
+			l = longest(l, longest(d.Discharge, d.DischargeToken))
+		}
+	}
+	return l
+}
+
+// shortest returns the shorter of the two given lifetimes, ignoring either
+// one that is not set.
+func shortest(d1, d2 time.Duration) time.Duration {
+	if d1 == 0 || (d2 != 0 && d2 < d1) {
+		return d2
+	}
+	return d1
+}
+
+// longest returns the longer of the two given lifetimes.
+func longest(d1, d2 time.Duration) time.Duration {
+	if d2 > d1 {
+		return d2
+	}
+	return d1
+}
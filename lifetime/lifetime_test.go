@@ -0,0 +1,115 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package lifetime_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/yaml.v2"
+
+	"github.com/CanonicalLtd/candid/lifetime"
+)
+
+var testConfig = `
+discharge: 12h
+identity-providers:
+  ldap:
+    discharge: 2h
+    discharge-token: 24h
+  idm:
+    discharge-token: 2160h
+groups:
+  admin:
+    discharge: 1h
+  operators:
+    discharge: 30m
+  staff:
+    discharge-token: 168h
+`
+
+var forTests = []struct {
+	about        string
+	config       string
+	idp          string
+	groups       []string
+	expectResult lifetime.Durations
+}{{
+	about: "no configuration",
+	idp:   "ldap",
+	expectResult: lifetime.Durations{
+		Discharge:      lifetime.DefaultDischarge,
+		DischargeToken: lifetime.DefaultDischargeToken,
+	},
+}, {
+	about:  "global setting",
+	config: testConfig,
+	idp:    "usso",
+	groups: []string{"other"},
+	expectResult: lifetime.Durations{
+		Discharge:      12 * time.Hour,
+		DischargeToken: lifetime.DefaultDischargeToken,
+	},
+}, {
+	about:  "identity provider setting",
+	config: testConfig,
+	idp:    "ldap",
+	expectResult: lifetime.Durations{
+		Discharge:      2 * time.Hour,
+		DischargeToken: 24 * time.Hour,
+	},
+}, {
+	about:  "identity provider setting longer than default",
+	config: testConfig,
+	idp:    "idm",
+	expectResult: lifetime.Durations{
+		Discharge:      12 * time.Hour,
+		DischargeToken: 90 * 24 * time.Hour,
+	},
+}, {
+	about:  "group setting overrides identity provider",
+	config: testConfig,
+	idp:    "ldap",
+	groups: []string{"staff"},
+	expectResult: lifetime.Durations{
+		Discharge:      2 * time.Hour,
+		DischargeToken: 7 * 24 * time.Hour,
+	},
+}, {
+	about:  "shortest group setting wins",
+	config: testConfig,
+	idp:    "usso",
+	groups: []string{"operators", "admin", "staff"},
+	expectResult: lifetime.Durations{
+		Discharge:      30 * time.Minute,
+		DischargeToken: 7 * 24 * time.Hour,
+	},
+}}
+
+func TestFor(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range forTests {
+		c.Run(test.about, func(c *qt.C) {
+			var conf *lifetime.Config
+			if test.config != "" {
+				conf = new(lifetime.Config)
+				err := yaml.Unmarshal([]byte(test.config), conf)
+				c.Assert(err, qt.Equals, nil)
+			}
+			c.Assert(conf.For(test.idp, test.groups), qt.DeepEquals, test.expectResult)
+		})
+	}
+}
+
+func TestMax(t *testing.T) {
+	c := qt.New(t)
+	var conf *lifetime.Config
+	c.Assert(conf.Max(), qt.Equals, lifetime.DefaultDischargeToken)
+
+	conf = new(lifetime.Config)
+	err := yaml.Unmarshal([]byte(testConfig), conf)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(conf.Max(), qt.Equals, 90*24*time.Hour)
+}
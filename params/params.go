@@ -0,0 +1,390 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package params holds the parameters for the parts of the Candid API
+// that are not covered by gopkg.in/CanonicalLtd/candidclient.v1/params.
+package params
+
+import (
+	"encoding/json"
+	"time"
+
+	candidparams "gopkg.in/CanonicalLtd/candidclient.v1/params"
+	"gopkg.in/httprequest.v1"
+)
+
+// WebAuthnCredentialsRequest is a request for the WebAuthn credentials
+// registered for a user.
+type WebAuthnCredentialsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/u/:username/webauthn"`
+	Username          candidparams.Username `httprequest:"username,path"`
+}
+
+// WebAuthnCredential describes a WebAuthn credential that has been
+// registered for a user.
+type WebAuthnCredential struct {
+	// ID holds the credential ID, encoded with the base64 URL
+	// encoding without padding as used by the WebAuthn API.
+	ID string `json:"id"`
+
+	// Name holds the name given to the credential when it was
+	// registered.
+	Name string `json:"name"`
+
+	// Created holds the time the credential was registered.
+	Created time.Time `json:"created"`
+}
+
+// StartWebAuthnRegistrationRequest is a request to start registering a
+// new WebAuthn credential for a user.
+type StartWebAuthnRegistrationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/webauthn"`
+	Username          candidparams.Username         `httprequest:"username,path"`
+	Body              StartWebAuthnRegistrationBody `httprequest:",body"`
+}
+
+// StartWebAuthnRegistrationBody holds the body of a
+// StartWebAuthnRegistrationRequest.
+type StartWebAuthnRegistrationBody struct {
+	// Name holds a name for the new credential, so that it can be
+	// identified later.
+	Name string `json:"name"`
+}
+
+// StartWebAuthnRegistrationResponse holds the response from a
+// StartWebAuthnRegistrationRequest.
+type StartWebAuthnRegistrationResponse struct {
+	// SessionID holds the ID of the registration session, this must
+	// be used when completing the registration.
+	SessionID string `json:"session_id"`
+
+	// Options holds the options that should be passed to
+	// navigator.credentials.create in the user's browser.
+	Options json.RawMessage `json:"options"`
+}
+
+// CompleteWebAuthnRegistrationRequest is a request to complete the
+// registration of a WebAuthn credential for a user.
+type CompleteWebAuthnRegistrationRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/webauthn/:session"`
+	Username          candidparams.Username `httprequest:"username,path"`
+	SessionID         string                `httprequest:"session,path"`
+
+	// Credential holds the JSON encoded PublicKeyCredential created
+	// by the user's browser.
+	Credential json.RawMessage `httprequest:",body"`
+}
+
+// DeleteWebAuthnCredentialRequest is a request to remove a WebAuthn
+// credential from a user.
+type DeleteWebAuthnCredentialRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/u/:username/webauthn/:id"`
+	Username          candidparams.Username `httprequest:"username,path"`
+	ID                string                `httprequest:"id,path"`
+}
+
+// SetPasswordRequest is a request to set the password of a user of a
+// local identity provider. The user is created if it does not already
+// exist.
+type SetPasswordRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/password"`
+	Username          candidparams.Username `httprequest:"username,path"`
+	Body              SetPasswordBody       `httprequest:",body"`
+}
+
+// SetPasswordBody holds the body of a SetPasswordRequest.
+type SetPasswordBody struct {
+	// Password holds the new password.
+	Password string `json:"password"`
+}
+
+// ResetPasswordRequest is a request to reset the password of a user of
+// a local identity provider to a new temporary password, which the
+// user must change the next time they log in.
+type ResetPasswordRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/password/reset"`
+	Username          candidparams.Username `httprequest:"username,path"`
+}
+
+// ResetPasswordResponse holds the response from a
+// ResetPasswordRequest.
+type ResetPasswordResponse struct {
+	// Password holds the user's temporary password.
+	Password string `json:"password"`
+}
+
+// BearerTokenRequest is a request for a token that can be used to
+// authenticate as the given user by sending it in an HTTP
+// "Authorization: Bearer" header. This is intended for services, such
+// as SCIM clients, that cannot use macaroon discharge.
+type BearerTokenRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/bearer-token"`
+	Username          candidparams.Username `httprequest:"username,path"`
+	Body              BearerTokenBody       `httprequest:",body"`
+}
+
+// BearerTokenBody holds the body of a BearerTokenRequest.
+type BearerTokenBody struct {
+	// Expires holds the time at which the token will expire. If
+	// this is zero then the token will be valid for 30 days.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// BearerTokenResponse holds the response from a BearerTokenRequest.
+type BearerTokenResponse struct {
+	// Token holds the bearer token.
+	Token string `json:"token"`
+
+	// Expires holds the time at which the token will expire.
+	Expires time.Time `json:"expires"`
+}
+
+// UserStatus holds the status of a user.
+type UserStatus string
+
+const (
+	// UserActive is the status of a user that can log in normally.
+	UserActive UserStatus = "active"
+
+	// UserSuspended is the status of a user that has been suspended.
+	// A suspended user cannot log in or obtain discharges, and any
+	// identity macaroons previously issued to the user are
+	// rejected.
+	UserSuspended UserStatus = "suspended"
+)
+
+// UserStatusRequest is a request for the status of a user.
+type UserStatusRequest struct {
+	httprequest.Route `httprequest:"GET /v1/u/:username/status"`
+	Username          candidparams.Username `httprequest:"username,path"`
+}
+
+// UserStatusResponse holds the response from a UserStatusRequest.
+type UserStatusResponse struct {
+	Status UserStatus `json:"status"`
+}
+
+// SetUserStatusRequest is a request to change the status of a user.
+type SetUserStatusRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/status"`
+	Username          candidparams.Username `httprequest:"username,path"`
+	Body              SetUserStatusBody     `httprequest:",body"`
+}
+
+// SetUserStatusBody holds the body of a SetUserStatusRequest.
+type SetUserStatusBody struct {
+	// Status holds the new status of the user.
+	Status UserStatus `json:"status"`
+}
+
+// DeleteUserRequest is a request to delete a user. Any agents owned by
+// the user are also deleted.
+type DeleteUserRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/u/:username"`
+	Username          candidparams.Username `httprequest:"username,path"`
+
+	// Anonymise, if set, causes the user's record to be retained
+	// with all personal information removed, rather than being
+	// deleted. An anonymised user is suspended and cannot log in
+	// again. The user's identifier at its identity provider is kept
+	// so that the same external identity can still be recognised.
+	Anonymise bool `httprequest:"anonymise,form,omitempty"`
+}
+
+// RevokeSessionsRequest is a request to revoke all the discharge tokens
+// and identity macaroons that have previously been issued to a user.
+type RevokeSessionsRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/revoke-sessions"`
+	Username          candidparams.Username `httprequest:"username,path"`
+}
+
+// RevokeMacaroonsRequest is a request to add macaroons to the
+// revocation list.
+type RevokeMacaroonsRequest struct {
+	httprequest.Route `httprequest:"POST /v1/revoke-macaroons"`
+	Body              RevokeMacaroonsBody `httprequest:",body"`
+}
+
+// RevokeMacaroonsBody holds the body of a RevokeMacaroonsRequest.
+type RevokeMacaroonsBody struct {
+	// IDs holds the IDs of the macaroons to revoke, each encoded
+	// with unpadded URL-safe base64.
+	IDs []string `json:"ids"`
+}
+
+// Group holds the details of a group.
+type Group struct {
+	// Name holds the name of the group.
+	Name string `json:"name"`
+
+	// Description holds a human readable description of the group.
+	Description string `json:"description,omitempty"`
+
+	// Owner holds the username of the user that owns the group.
+	Owner candidparams.Username `json:"owner,omitempty"`
+
+	// Groups holds the names of the groups that this group is a
+	// member of.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// GroupsRequest is a request for all the groups known to the identity
+// server.
+type GroupsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/groups"`
+}
+
+// CreateGroupRequest is a request to create a new group.
+type CreateGroupRequest struct {
+	httprequest.Route `httprequest:"POST /v1/groups"`
+	Body              Group `httprequest:",body"`
+}
+
+// GroupRequest is a request for the details of a group.
+type GroupRequest struct {
+	httprequest.Route `httprequest:"GET /v1/groups/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+// GroupMembersRequest is a request for the usernames of the members of
+// a group.
+type GroupMembersRequest struct {
+	httprequest.Route `httprequest:"GET /v1/groups/:name/members"`
+	Name              string `httprequest:"name,path"`
+}
+
+// MatchType specifies how a search term is matched in a
+// SearchUsersRequest.
+type MatchType string
+
+const (
+	// MatchContains matches values that contain the search term,
+	// ignoring case.
+	MatchContains MatchType = "contains"
+
+	// MatchPrefix matches values that start with the search term,
+	// ignoring case.
+	MatchPrefix MatchType = "prefix"
+
+	// MatchExact matches values that are exactly the search term.
+	MatchExact MatchType = "exact"
+)
+
+// SearchUsersRequest is a request to search the users in the system.
+// Matching users are returned in username order, a page at a time.
+type SearchUsersRequest struct {
+	httprequest.Route `httprequest:"GET /v1/users"`
+
+	// Name, if set, matches users whose full name matches the given
+	// search term.
+	Name string `httprequest:"name,form,omitempty"`
+
+	// Email, if set, matches users whose email address matches the
+	// given search term.
+	Email string `httprequest:"email,form,omitempty"`
+
+	// Match specifies how the Name and Email search terms are
+	// matched. If this is empty then MatchContains is used.
+	Match MatchType `httprequest:"match,form,omitempty"`
+
+	// Groups, if set, matches users that are members of all the given
+	// groups.
+	Groups []string `httprequest:"group,form,omitempty"`
+
+	// Provider, if set, matches users from the identity provider
+	// with the given name.
+	Provider string `httprequest:"provider,form,omitempty"`
+
+	// Owner, if set, matches agents owned by the given user.
+	Owner candidparams.Username `httprequest:"owner,form,omitempty"`
+
+	// LastLoginSince, if set, matches users that have logged in at or
+	// after the given time. The time is in RFC 3339 format.
+	LastLoginSince time.Time `httprequest:"last-login-since,form,omitempty"`
+
+	// LastDischargeSince, if set, matches users that have been issued
+	// a discharge at or after the given time. The time is in RFC 3339
+	// format.
+	LastDischargeSince time.Time `httprequest:"last-discharge-since,form,omitempty"`
+
+	// Full, if set, causes the full details of each user to be
+	// returned, rather than just their usernames.
+	Full bool `httprequest:"full,form,omitempty"`
+
+	// Cursor, if set, holds the cursor returned with the previous
+	// page of results. The next page of results is returned.
+	Cursor string `httprequest:"cursor,form,omitempty"`
+
+	// Limit, if set, limits the number of users returned in a
+	// page. The server may return fewer users than requested.
+	Limit int `httprequest:"limit,form,omitempty"`
+}
+
+// SearchUsersResponse holds a page of results from a
+// SearchUsersRequest.
+type SearchUsersResponse struct {
+	// Usernames holds the usernames of the matching users.
+	Usernames []string `json:"usernames"`
+
+	// Users holds the details of the matching users, if they were
+	// requested.
+	Users []candidparams.User `json:"users,omitempty"`
+
+	// Cursor holds the cursor to use to request the next page of
+	// results. If there are no more results then this is empty.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// AuditRequest is a request for entries in the audit log. Entries are
+// returned newest first.
+type AuditRequest struct {
+	httprequest.Route `httprequest:"GET /v1/audit"`
+
+	// User, if set, restricts the results to entries about, or
+	// actions performed by, the given user.
+	User candidparams.Username `httprequest:"user,form,omitempty"`
+
+	// Since, if set, restricts the results to entries that occurred
+	// at or after the given time. The time is in RFC 3339 format.
+	Since time.Time `httprequest:"since,form,omitempty"`
+
+	// Until, if set, restricts the results to entries that occurred
+	// before the given time. The time is in RFC 3339 format.
+	Until time.Time `httprequest:"until,form,omitempty"`
+
+	// Limit, if set, limits the number of entries returned. If it
+	// is not set then at most 100 entries are returned. The server
+	// never returns more than 1000 entries.
+	Limit int `httprequest:"limit,form,omitempty"`
+}
+
+// AuditEntry holds a single entry in the audit log.
+type AuditEntry struct {
+	// ID holds the unique ID of the entry.
+	ID string `json:"id"`
+
+	// Time holds the time at which the event occurred.
+	Time time.Time `json:"time"`
+
+	// Type holds the type of the event, for example "login" or
+	// "groups-change".
+	Type string `json:"type"`
+
+	// Username holds the user the event relates to.
+	Username string `json:"username,omitempty"`
+
+	// Actor holds the user that performed the action, if different
+	// from Username.
+	Actor string `json:"actor,omitempty"`
+
+	// IDP holds the name of the identity provider involved in the
+	// event.
+	IDP string `json:"idp,omitempty"`
+
+	// Caveat holds the third-party caveat condition involved in the
+	// event.
+	Caveat string `json:"caveat,omitempty"`
+
+	// Detail holds further information about the event.
+	Detail string `json:"detail,omitempty"`
+}
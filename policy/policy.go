@@ -0,0 +1,385 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package policy implements declarative policies that are evaluated
+// when the identity server discharges a third-party caveat.
+//
+// A policy is a list of rules. Each rule holds a set of criteria that
+// are matched against the discharge request and an effect that applies
+// when they all match. A matching rule may deny the discharge or add
+// first-party caveats to the discharge macaroon. Rules are evaluated in
+// order; the first matching rule that denies the discharge stops the
+// evaluation, otherwise the caveats from all matching rules are added.
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrDenied is the error cause used when a policy denies a discharge.
+var ErrDenied = errgo.New("denied by policy")
+
+// A Request holds the information about a discharge request that rules
+// are matched against.
+type Request struct {
+	// Username holds the username of the authenticated user.
+	Username string
+
+	// Email holds the email address of the authenticated user.
+	Email string
+
+	// Groups holds all the groups that the user is a member of.
+	Groups []string
+
+	// IDP holds the name of the identity provider that the user
+	// logged in with.
+	IDP string
+
+	// Origin holds the Origin header of the discharge request.
+	Origin string
+
+	// Service holds the public key of the service that added the
+	// third-party caveat, as returned by bakery.PublicKey.String.
+	Service string
+
+	// Condition holds the name of the caveat condition being
+	// discharged, for example "is-authenticated-user".
+	Condition string
+
+	// Time holds the time of the request.
+	Time time.Time
+}
+
+// Policy holds a parsed policy.
+type Policy struct {
+	// Services holds names for the public keys of services, which
+	// may be used in place of the keys in rules.
+	Services map[string]string `yaml:"services"`
+
+	// Rules holds the rules of the policy.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule holds a single rule of a policy.
+type Rule struct {
+	// Name holds the name of the rule, which is used in error
+	// messages and in the audit log.
+	Name string `yaml:"name"`
+
+	// Match holds the criteria that must all be satisfied for the
+	// rule to apply.
+	Match Match `yaml:"match"`
+
+	// Deny holds whether a discharge that matches the rule is
+	// refused.
+	Deny bool `yaml:"deny"`
+
+	// Message holds the message returned when the rule denies a
+	// discharge.
+	Message string `yaml:"message"`
+
+	// Caveats holds first-party caveat conditions that are added to
+	// the discharge macaroon, for example "declared contractor true".
+	Caveats []string `yaml:"caveats"`
+
+	// ExpireAfter holds a maximum lifetime for the discharge
+	// macaroon.
+	ExpireAfter time.Duration `yaml:"expire-after"`
+}
+
+// Match holds the criteria of a rule. Criteria that are not set always
+// match. Criteria that hold a list match when any of the items in the
+// list match.
+type Match struct {
+	// Users matches the username of the user.
+	Users []string `yaml:"users"`
+
+	// Groups matches when the user is a member of any of the groups.
+	Groups []string `yaml:"groups"`
+
+	// NotGroups matches when the user is a member of none of the
+	// groups.
+	NotGroups []string `yaml:"not-groups"`
+
+	// EmailDomains matches the domain of the user's email address.
+	EmailDomains []string `yaml:"email-domains"`
+
+	// IDPs matches the identity provider that the user logged in
+	// with.
+	IDPs []string `yaml:"identity-providers"`
+
+	// Origins matches the Origin header of the request. Each item is
+	// a pattern as accepted by path.Match.
+	Origins []string `yaml:"origins"`
+
+	// Services matches the service that added the caveat. Each item
+	// is either a public key or a name from Policy.Services.
+	Services []string `yaml:"services"`
+
+	// Conditions matches the name of the condition being discharged.
+	Conditions []string `yaml:"conditions"`
+
+	// Hours matches requests made within the given hours.
+	Hours *Hours `yaml:"hours"`
+
+	// OutsideHours matches requests made outside the given hours.
+	OutsideHours *Hours `yaml:"outside-hours"`
+}
+
+// Hours holds a weekly period of time, such as office hours.
+type Hours struct {
+	// Days holds the days of the week, given as the lower case
+	// three-letter abbreviation of their names. If this is empty all
+	// days are included.
+	Days []string `yaml:"days"`
+
+	// Start and End hold the times of day, in the form "15:04", at
+	// which the period starts and ends. If End is before Start then
+	// the period runs past midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	// Timezone holds the name of the time zone, for example
+	// "Europe/London". If this is empty UTC is used.
+	Timezone string `yaml:"timezone"`
+
+	days       map[time.Weekday]bool
+	start, end time.Duration
+	location   *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ReadFile reads and parses the policy file at the given path.
+func ReadFile(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read policy")
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse %q", path)
+	}
+	return p, nil
+}
+
+// Parse parses the given YAML policy.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := p.init(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &p, nil
+}
+
+// init validates the policy and resolves its service names and hours.
+func (p *Policy) init() error {
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if !r.Deny && len(r.Caveats) == 0 && r.ExpireAfter == 0 {
+			return errgo.Newf("%s: rule has no effect", r.Name)
+		}
+		for _, cav := range r.Caveats {
+			if _, _, err := checkers.ParseCaveat(cav); err != nil {
+				return errgo.Notef(err, "%s: invalid caveat %q", r.Name, cav)
+			}
+		}
+		for j, s := range r.Match.Services {
+			if key, ok := p.Services[s]; ok {
+				r.Match.Services[j] = key
+			}
+		}
+		for _, h := range []*Hours{r.Match.Hours, r.Match.OutsideHours} {
+			if h == nil {
+				continue
+			}
+			if err := h.init(); err != nil {
+				return errgo.Notef(err, "%s", r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Hours) init() error {
+	h.days = make(map[time.Weekday]bool)
+	for _, d := range h.Days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return errgo.Newf("invalid day %q", d)
+		}
+		h.days[wd] = true
+	}
+	var err error
+	if h.start, err = parseTimeOfDay(h.Start); err != nil {
+		return errgo.Mask(err)
+	}
+	if h.end, err = parseTimeOfDay(h.End); err != nil {
+		return errgo.Mask(err)
+	}
+	if h.location, err = time.LoadLocation(h.Timezone); err != nil {
+		return errgo.Notef(err, "invalid timezone %q", h.Timezone)
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a time of day in the form "15:04" and returns
+// the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errgo.Newf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t falls within the hours.
+func (h *Hours) contains(t time.Time) bool {
+	t = t.In(h.location)
+	wd := t.Weekday()
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if h.end <= h.start && tod < h.end {
+		// The period started the previous day.
+		wd = (wd + 6) % 7
+		tod += 24 * time.Hour
+	}
+	if len(h.days) > 0 && !h.days[wd] {
+		return false
+	}
+	end := h.end
+	if end <= h.start {
+		end += 24 * time.Hour
+	}
+	return tod >= h.start && tod < end
+}
+
+// Evaluate evaluates the policy for the given request. If the discharge
+// is denied an error with a cause of ErrDenied is returned, otherwise
+// Evaluate returns the caveats that should be added to the discharge
+// macaroon. It is OK to call Evaluate on a nil *Policy.
+func (p *Policy) Evaluate(req *Request) ([]checkers.Caveat, error) {
+	if p == nil {
+		return nil, nil
+	}
+	var caveats []checkers.Caveat
+	var expireAfter time.Duration
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.Match.matches(req) {
+			continue
+		}
+		if r.Deny {
+			msg := r.Message
+			if msg == "" {
+				msg = fmt.Sprintf("denied by policy rule %q", r.Name)
+			}
+			return nil, errgo.WithCausef(nil, ErrDenied, "%s", msg)
+		}
+		for _, cav := range r.Caveats {
+			caveats = append(caveats, checkers.Caveat{Condition: cav})
+		}
+		if r.ExpireAfter > 0 && (expireAfter == 0 || r.ExpireAfter < expireAfter) {
+			expireAfter = r.ExpireAfter
+		}
+	}
+	if expireAfter > 0 {
+		caveats = append(caveats, checkers.TimeBeforeCaveat(req.Time.Add(expireAfter)))
+	}
+	return caveats, nil
+}
+
+// matches reports whether the request satisfies all the criteria.
+func (m *Match) matches(req *Request) bool {
+	if len(m.Users) > 0 && !contains(m.Users, req.Username) {
+		return false
+	}
+	if len(m.Groups) > 0 && !intersects(m.Groups, req.Groups) {
+		return false
+	}
+	if intersects(m.NotGroups, req.Groups) {
+		return false
+	}
+	if len(m.EmailDomains) > 0 {
+		i := strings.LastIndex(req.Email, "@")
+		if i == -1 || !containsFold(m.EmailDomains, req.Email[i+1:]) {
+			return false
+		}
+	}
+	if len(m.IDPs) > 0 && !contains(m.IDPs, req.IDP) {
+		return false
+	}
+	if len(m.Origins) > 0 && !matchesPattern(m.Origins, req.Origin) {
+		return false
+	}
+	if len(m.Services) > 0 && !contains(m.Services, req.Service) {
+		return false
+	}
+	if len(m.Conditions) > 0 && !contains(m.Conditions, req.Condition) {
+		return false
+	}
+	if m.Hours != nil && !m.Hours.contains(req.Time) {
+		return false
+	}
+	if m.OutsideHours != nil && m.OutsideHours.contains(req.Time) {
+		return false
+	}
+	return true
+}
+
+func contains(ss []string, s string) bool {
+	for _, s1 := range ss {
+		if s1 == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, s1 := range ss {
+		if strings.EqualFold(s1, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(ss1, ss2 []string) bool {
+	for _, s := range ss2 {
+		if contains(ss1, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
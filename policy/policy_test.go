@@ -0,0 +1,282 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package policy_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+
+	"github.com/CanonicalLtd/candid/policy"
+)
+
+const testPolicy = `
+services:
+  prod: T+lkM1hKTHZvLn1yhe5jN5B46V5KYKuEUHbyhXyqyFw=
+rules:
+- name: contractors-prod
+  match:
+    groups: [contractors]
+    services: [prod]
+    outside-hours:
+      days: [mon, tue, wed, thu, fri]
+      start: "09:00"
+      end: "17:00"
+      timezone: Europe/London
+  deny: true
+  message: contractors cannot use production services outside office hours
+- name: untrusted-origin
+  match:
+    not-groups: [staff]
+    origins: ["https://*.example.org"]
+  deny: true
+- match:
+    groups: [contractors]
+  caveats:
+  - declared contractor true
+  expire-after: 1h
+- match:
+    email-domains: [example.com]
+    identity-providers: [ldap]
+    conditions: [is-authenticated-user]
+  expire-after: 4h
+`
+
+// Monday 2019-03-04 at 10:00 and 20:00 in London.
+var (
+	officeHours  = time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC)
+	evening      = time.Date(2019, 3, 4, 20, 0, 0, 0, time.UTC)
+	prodKey      = "T+lkM1hKTHZvLn1yhe5jN5B46V5KYKuEUHbyhXyqyFw="
+	otherService = "7GZxvhtbo7lIcrYv4XmEqwyRFsWUg8o5gORbXo0j6Ag="
+)
+
+var evaluateTests = []struct {
+	about         string
+	req           policy.Request
+	expectCaveats []checkers.Caveat
+	expectError   string
+}{{
+	about: "no rules match",
+	req: policy.Request{
+		Username: "bob",
+		Groups:   []string{"staff"},
+		Service:  prodKey,
+		Time:     evening,
+	},
+}, {
+	about: "contractor during office hours",
+	req: policy.Request{
+		Username: "bob",
+		Groups:   []string{"contractors"},
+		Service:  prodKey,
+		Time:     officeHours,
+	},
+	expectCaveats: []checkers.Caveat{
+		{Condition: "declared contractor true"},
+		checkers.TimeBeforeCaveat(officeHours.Add(time.Hour)),
+	},
+}, {
+	about: "contractor outside office hours",
+	req: policy.Request{
+		Username: "bob",
+		Groups:   []string{"contractors"},
+		Service:  prodKey,
+		Time:     evening,
+	},
+	expectError: `contractors cannot use production services outside office hours`,
+}, {
+	about: "contractor outside office hours for other service",
+	req: policy.Request{
+		Username: "bob",
+		Groups:   []string{"contractors"},
+		Service:  otherService,
+		Time:     evening,
+	},
+	expectCaveats: []checkers.Caveat{
+		{Condition: "declared contractor true"},
+		checkers.TimeBeforeCaveat(evening.Add(time.Hour)),
+	},
+}, {
+	about: "untrusted origin",
+	req: policy.Request{
+		Username: "bob",
+		Origin:   "https://www.example.org",
+		Time:     officeHours,
+	},
+	expectError: `denied by policy rule "untrusted-origin"`,
+}, {
+	about: "untrusted origin for staff",
+	req: policy.Request{
+		Username: "bob",
+		Groups:   []string{"staff"},
+		Origin:   "https://www.example.org",
+		Time:     officeHours,
+	},
+}, {
+	about: "shortest expiry is used",
+	req: policy.Request{
+		Username:  "bob",
+		Email:     "bob@EXAMPLE.com",
+		Groups:    []string{"contractors"},
+		IDP:       "ldap",
+		Condition: "is-authenticated-user",
+		Time:      officeHours,
+	},
+	expectCaveats: []checkers.Caveat{
+		{Condition: "declared contractor true"},
+		checkers.TimeBeforeCaveat(officeHours.Add(time.Hour)),
+	},
+}, {
+	about: "identity criteria",
+	req: policy.Request{
+		Username:  "bob",
+		Email:     "bob@example.com",
+		IDP:       "ldap",
+		Condition: "is-authenticated-user",
+		Time:      officeHours,
+	},
+	expectCaveats: []checkers.Caveat{
+		checkers.TimeBeforeCaveat(officeHours.Add(4 * time.Hour)),
+	},
+}, {
+	about: "identity criteria - other condition",
+	req: policy.Request{
+		Username:  "bob",
+		Email:     "bob@example.com",
+		IDP:       "ldap",
+		Condition: "is-member-of",
+		Time:      officeHours,
+	},
+}}
+
+func TestEvaluate(t *testing.T) {
+	c := qt.New(t)
+	p, err := policy.Parse([]byte(testPolicy))
+	c.Assert(err, qt.Equals, nil)
+	for _, test := range evaluateTests {
+		c.Run(test.about, func(c *qt.C) {
+			caveats, err := p.Evaluate(&test.req)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, policy.ErrDenied)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(caveats, qt.DeepEquals, test.expectCaveats)
+		})
+	}
+}
+
+func TestEvaluateNilPolicy(t *testing.T) {
+	c := qt.New(t)
+	var p *policy.Policy
+	caveats, err := p.Evaluate(&policy.Request{Username: "bob"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(caveats, qt.HasLen, 0)
+}
+
+var hoursTests = []struct {
+	about       string
+	hours       string
+	time        time.Time
+	expectMatch bool
+}{{
+	about:       "within hours",
+	hours:       `{start: "09:00", end: "17:00"}`,
+	time:        time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC),
+	expectMatch: true,
+}, {
+	about: "end is exclusive",
+	hours: `{start: "09:00", end: "17:00"}`,
+	time:  time.Date(2019, 3, 4, 17, 0, 0, 0, time.UTC),
+}, {
+	about: "wrong day",
+	hours: `{days: [sat, sun], start: "09:00", end: "17:00"}`,
+	time:  time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC),
+}, {
+	about:       "time zone",
+	hours:       `{start: "09:00", end: "17:00", timezone: America/New_York}`,
+	time:        time.Date(2019, 3, 4, 20, 0, 0, 0, time.UTC),
+	expectMatch: true,
+}, {
+	about:       "past midnight",
+	hours:       `{days: [fri], start: "22:00", end: "06:00"}`,
+	time:        time.Date(2019, 3, 9, 2, 0, 0, 0, time.UTC),
+	expectMatch: true,
+}, {
+	about: "past midnight - wrong day",
+	hours: `{days: [fri], start: "22:00", end: "06:00"}`,
+	time:  time.Date(2019, 3, 8, 2, 0, 0, 0, time.UTC),
+}}
+
+func TestHours(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range hoursTests {
+		c.Run(test.about, func(c *qt.C) {
+			p, err := policy.Parse([]byte("rules:\n- match: {hours: " + test.hours + "}\n  deny: true\n"))
+			c.Assert(err, qt.Equals, nil)
+			_, err = p.Evaluate(&policy.Request{Time: test.time})
+			c.Assert(err != nil, qt.Equals, test.expectMatch)
+		})
+	}
+}
+
+var parseErrorTests = []struct {
+	about       string
+	policy      string
+	expectError string
+}{{
+	about:       "unknown field",
+	policy:      "rules:\n- match: {group: [a]}\n  deny: true\n",
+	expectError: `(?s)yaml: unmarshal errors:.*field group not found.*`,
+}, {
+	about:       "no effect",
+	policy:      "rules:\n- name: r1\n  match: {groups: [a]}\n",
+	expectError: `r1: rule has no effect`,
+}, {
+	about:       "invalid caveat",
+	policy:      "rules:\n- caveats: [\"\"]\n",
+	expectError: `rule 1: invalid caveat "": .*`,
+}, {
+	about:       "invalid day",
+	policy:      "rules:\n- match: {hours: {days: [monday], start: \"09:00\", end: \"17:00\"}}\n  deny: true\n",
+	expectError: `rule 1: invalid day "monday"`,
+}, {
+	about:       "invalid time",
+	policy:      "rules:\n- match: {hours: {start: \"9am\", end: \"17:00\"}}\n  deny: true\n",
+	expectError: `rule 1: invalid time of day "9am"`,
+}, {
+	about:       "invalid timezone",
+	policy:      "rules:\n- match: {hours: {start: \"09:00\", end: \"17:00\", timezone: Nowhere}}\n  deny: true\n",
+	expectError: `rule 1: invalid timezone "Nowhere": .*`,
+}}
+
+func TestParseError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			_, err := policy.Parse([]byte(test.policy))
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	path := filepath.Join(c.Mkdir(), "policy.yaml")
+	err := ioutil.WriteFile(path, []byte(testPolicy), 0600)
+	c.Assert(err, qt.Equals, nil)
+	p, err := policy.ReadFile(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p.Rules, qt.HasLen, 4)
+
+	_, err = policy.ReadFile(filepath.Join(c.Mkdir(), "no-such-file"))
+	c.Assert(err, qt.ErrorMatches, `cannot read policy: .*`)
+}
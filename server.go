@@ -19,21 +19,27 @@ import (
 	"github.com/CanonicalLtd/candid/internal/debug"
 	"github.com/CanonicalLtd/candid/internal/discharger"
 	"github.com/CanonicalLtd/candid/internal/identity"
+	"github.com/CanonicalLtd/candid/internal/scim"
 	"github.com/CanonicalLtd/candid/internal/v1"
+	"github.com/CanonicalLtd/candid/lifetime"
 	"github.com/CanonicalLtd/candid/meeting"
+	"github.com/CanonicalLtd/candid/policy"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 // Versions of the API that can be served.
 const (
 	Debug      = "debug"
 	Discharger = "discharger"
+	SCIM       = "scim"
 	V1         = "v1"
 )
 
 var versions = map[string]identity.NewAPIHandlerFunc{
 	Debug:      debug.NewAPIHandler,
 	Discharger: discharger.NewAPIHandler,
+	SCIM:       scim.NewAPIHandler,
 	V1:         v1.NewAPIHandler,
 }
 
@@ -68,9 +74,23 @@ type ServerParams struct {
 	// AdminPassword holds the password for admin login.
 	AdminPassword string
 
-	// Key holds the keypair to use with the bakery service.
+	// Key holds the keypair to use with the bakery service. If this
+	// is nil then a key pair is generated and, if ProviderDataStore
+	// is set, stored so that it is used by every server.
 	Key *bakery.KeyPair
 
+	// KeyRotationPeriod holds the interval at which the key used to
+	// decrypt third-party caveats is replaced by a newly generated
+	// key. If this is zero, or ProviderDataStore is not set, then
+	// Key is always used.
+	KeyRotationPeriod time.Duration
+
+	// KeyGracePeriod holds the length of time after the key used to
+	// decrypt third-party caveats has been replaced for which the
+	// old key can still be used. If this is zero then
+	// KeyRotationPeriod is used.
+	KeyGracePeriod time.Duration
+
 	// Location holds a URL representing the externally accessible
 	// base URL of the service, without a trailing slash.
 	Location string
@@ -110,6 +130,29 @@ type ServerParams struct {
 
 	// ACLStore holds the ACLStore for the identity server.
 	ACLStore aclstore.ACLStore
+
+	// AuditStore holds the store that is used to record the audit
+	// log. If this is nil then no audit log will be recorded.
+	AuditStore store.AuditStore
+
+	// Webhooks holds the endpoints that are sent notifications of
+	// identity lifecycle events.
+	Webhooks []webhook.Hook
+
+	// WebhookQueue holds the queue used to store webhook deliveries
+	// until they have been completed. It must be set if Webhooks
+	// is not empty.
+	WebhookQueue webhook.Queue
+
+	// Lifetimes holds the lifetimes of the macaroons issued by the
+	// identity server. If this is nil then the default lifetimes are
+	// used.
+	Lifetimes *lifetime.Config
+
+	// Policy holds the policy that is evaluated when discharging
+	// third-party caveats. If this is nil then no policy is
+	// applied.
+	Policy *policy.Policy
 }
 
 // NewServer returns a new handler that handles identity service requests and
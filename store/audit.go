@@ -0,0 +1,124 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// An AuditEventType identifies the kind of event recorded in an audit
+// log entry.
+type AuditEventType string
+
+const (
+	// AuditLogin records a successful login through an identity
+	// provider.
+	AuditLogin AuditEventType = "login"
+
+	// AuditLoginFailure records a failed login attempt through an
+	// identity provider.
+	AuditLoginFailure AuditEventType = "login-failure"
+
+	// AuditDischarge records a successful discharge of a third-party
+	// caveat.
+	AuditDischarge AuditEventType = "discharge"
+
+	// AuditDischargeFailure records a discharge request that was
+	// refused.
+	AuditDischargeFailure AuditEventType = "discharge-failure"
+
+	// AuditGroupsChange records a change to the groups of an
+	// identity.
+	AuditGroupsChange AuditEventType = "groups-change"
+
+	// AuditACLChange records a change to one of the server's ACLs.
+	AuditACLChange AuditEventType = "acl-change"
+
+	// AuditAgentCreate records the creation of an agent identity.
+	AuditAgentCreate AuditEventType = "agent-create"
+
+	// AuditSSHKeysChange records a change to the SSH keys of an
+	// identity.
+	AuditSSHKeysChange AuditEventType = "ssh-keys-change"
+
+	// AuditStatusChange records an identity being suspended or
+	// resumed.
+	AuditStatusChange AuditEventType = "status-change"
+
+	// AuditIdentityDelete records an identity being deleted or
+	// anonymised.
+	AuditIdentityDelete AuditEventType = "identity-delete"
+
+	// AuditRevoke records the revocation of the sessions of an
+	// identity or of individual macaroons.
+	AuditRevoke AuditEventType = "revoke"
+)
+
+// An AuditEntry is a single record in the audit log.
+type AuditEntry struct {
+	// ID holds the unique ID of the entry. It is assigned by the
+	// AuditStore when the entry is added.
+	ID string
+
+	// Time holds the time at which the event occurred. If this is
+	// zero when the entry is added then the current time will be
+	// used.
+	Time time.Time
+
+	// Type holds the type of the event.
+	Type AuditEventType
+
+	// Username holds the username of the identity that the event
+	// relates to, if known.
+	Username string
+
+	// Actor holds the username of the identity that performed the
+	// action, if that is different from Username.
+	Actor string
+
+	// IDP holds the name of the identity provider involved in the
+	// event, if any.
+	IDP string
+
+	// Caveat holds the condition of the third-party caveat involved
+	// in the event, if any.
+	Caveat string
+
+	// Detail holds any further human-readable information about the
+	// event.
+	Detail string
+}
+
+// AuditFilter specifies which entries should be returned by
+// AuditStore.FindAuditEntries.
+type AuditFilter struct {
+	// Username, if non-empty, restricts the results to entries
+	// where either the Username or the Actor is the given user.
+	Username string
+
+	// Since, if non-zero, restricts the results to entries that
+	// occurred at or after the given time.
+	Since time.Time
+
+	// Until, if non-zero, restricts the results to entries that
+	// occurred before the given time.
+	Until time.Time
+
+	// Limit, if greater than zero, limits the number of entries
+	// returned.
+	Limit int
+}
+
+// An AuditStore is used to record and retrieve the audit log.
+type AuditStore interface {
+	// AddAuditEntry adds the given entry to the audit log. The ID
+	// of the entry will be set to the ID assigned by the store, and
+	// the Time will be set to the current time if it is zero.
+	AddAuditEntry(ctx context.Context, entry *AuditEntry) error
+
+	// FindAuditEntries returns the entries matching the given
+	// filter ordered by time, newest first.
+	FindAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
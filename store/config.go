@@ -7,6 +7,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery"
 
 	"github.com/CanonicalLtd/candid/meeting"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 var backends = make(map[string]func(func(interface{}) error) (BackendFactory, error))
@@ -39,6 +40,14 @@ type Backend interface {
 	// ACLs for system functions.
 	ACLStore() aclstore.ACLStore
 
+	// AuditStore returns a new AuditStore that is used to record
+	// the audit log.
+	AuditStore() AuditStore
+
+	// WebhookQueue returns a new webhook.Queue implementation that
+	// uses the backend.
+	WebhookQueue() webhook.Queue
+
 	// Close closes the Backend instance.
 	Close()
 }
@@ -17,6 +17,11 @@ var (
 	// ErrDuplicateUsername is the error cause used when an update
 	// attempts to set a username that is already in use.
 	ErrDuplicateUsername = errgo.New("duplicate username")
+
+	// ErrDuplicateGroup is the error cause used when an update
+	// attempts to create a group with a name that is already in
+	// use.
+	ErrDuplicateGroup = errgo.New("duplicate group")
 )
 
 // NotFoundError creates a new error with a cause of ErrNotFound and an
@@ -31,7 +36,7 @@ func NotFoundError(id string, providerID ProviderIdentity, username string) erro
 	case username != "":
 		msg = fmt.Sprintf("user %s not found", username)
 	}
-	err := errgo.WithCausef(nil, ErrNotFound, msg)
+	err := errgo.WithCausef(nil, ErrNotFound, "%s", msg)
 	err.(*errgo.Err).SetLocation(1)
 	return err
 }
@@ -44,6 +49,29 @@ func DuplicateUsernameError(username string) error {
 	return err
 }
 
+// GroupNotFoundError creates a new error with a cause of ErrNotFound
+// and an appropriate message.
+func GroupNotFoundError(id string, name string) error {
+	msg := "group not specified"
+	switch {
+	case id != "":
+		msg = fmt.Sprintf("group %q not found", id)
+	case name != "":
+		msg = fmt.Sprintf("group %s not found", name)
+	}
+	err := errgo.WithCausef(nil, ErrNotFound, "%s", msg)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
+// DuplicateGroupError creates a new error with a cause of
+// ErrDuplicateGroup and an appropriate message.
+func DuplicateGroupError(name string) error {
+	err := errgo.WithCausef(nil, ErrDuplicateGroup, "group %s already exists", name)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
 // KeyNotFoundError creates a new error with a cause of ErrNotFound and
 // an appropriate message.
 func KeyNotFoundError(key string) error {
@@ -0,0 +1,66 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// NewAuditStore creates a new in-memory store.AuditStore
+// implementation.
+func NewAuditStore() store.AuditStore {
+	return &auditStore{}
+}
+
+type auditStore struct {
+	mu      sync.Mutex
+	entries []store.AuditEntry
+}
+
+// AddAuditEntry implements store.AuditStore.AddAuditEntry.
+func (s *auditStore) AddAuditEntry(_ context.Context, entry *store.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.ID = strconv.Itoa(len(s.entries))
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+// FindAuditEntries implements store.AuditStore.FindAuditEntries.
+func (s *auditStore) FindAuditEntries(_ context.Context, filter store.AuditFilter) ([]store.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []store.AuditEntry
+	// Iterate in reverse so that entries with the same time are
+	// returned most recently added first.
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		if filter.Username != "" && e.Username != filter.Username && e.Actor != filter.Username {
+			continue
+		}
+		if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !e.Time.Before(filter.Until) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
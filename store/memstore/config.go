@@ -8,6 +8,7 @@ import (
 
 	"github.com/CanonicalLtd/candid/meeting"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 func init() {
@@ -18,6 +19,8 @@ func init() {
 			providerData: NewProviderDataStore(),
 			meetingStore: NewMeetingStore(),
 			aclStore:     aclstore.NewACLStore(memsimplekv.NewStore()),
+			auditStore:   NewAuditStore(),
+			webhookQueue: NewWebhookQueue(),
 		}, nil
 	})
 }
@@ -28,6 +31,8 @@ type backend struct {
 	rootKeys     bakery.RootKeyStore
 	meetingStore meeting.Store
 	aclStore     aclstore.ACLStore
+	auditStore   store.AuditStore
+	webhookQueue webhook.Queue
 }
 
 // NewBackend implements store.BackendFactory.NewBackend.
@@ -64,5 +69,15 @@ func (b *backend) ACLStore() aclstore.ACLStore {
 	return b.aclStore
 }
 
+// AuditStore implements store.Backend.AuditStore.
+func (b *backend) AuditStore() store.AuditStore {
+	return b.auditStore
+}
+
+// WebhookQueue implements store.Backend.WebhookQueue.
+func (b *backend) WebhookQueue() webhook.Queue {
+	return b.webhookQueue
+}
+
 func (b *backend) Close() {
 }
@@ -0,0 +1,120 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// Group implements store.Store.Group.
+func (s *memStore) Group(_ context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, err := s.group(group)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	copyGroup(group, g)
+	return nil
+}
+
+// group finds the stored group matching the first non-zero value of
+// ID or Name in the given group. It must be called with s.mu held.
+func (s *memStore) group(group *store.Group) (*store.Group, error) {
+	switch {
+	case group.ID != "":
+		if g := s.groups[group.ID]; g != nil {
+			return g, nil
+		}
+	case group.Name != "":
+		if g := s.groupFromName(group.Name); g != nil {
+			return g, nil
+		}
+	}
+	return nil, store.GroupNotFoundError(group.ID, group.Name)
+}
+
+// groupFromName performs a linear search to find a group with the
+// given name.
+func (s *memStore) groupFromName(name string) *store.Group {
+	for _, g := range s.groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s *memStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := make([]store.Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		var g1 store.Group
+		copyGroup(&g1, g)
+		groups = append(groups, g1)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// CreateGroup implements store.Store.CreateGroup.
+func (s *memStore) CreateGroup(_ context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.groupFromName(group.Name) != nil {
+		return store.DuplicateGroupError(group.Name)
+	}
+	var g store.Group
+	copyGroup(&g, group)
+	g.ID = strconv.Itoa(s.nextGroupID)
+	s.nextGroupID++
+	s.groups[g.ID] = &g
+	group.ID = g.ID
+	return nil
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s *memStore) UpdateGroup(_ context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, err := s.group(group)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	if g1 := s.groupFromName(group.Name); g1 != nil && g1 != g {
+		return store.DuplicateGroupError(group.Name)
+	}
+	id := g.ID
+	copyGroup(g, group)
+	g.ID = id
+	group.ID = id
+	return nil
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s *memStore) RemoveGroup(_ context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, err := s.group(group)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	delete(s.groups, g.ID)
+	return nil
+}
+
+func copyGroup(dst, src *store.Group) {
+	*dst = *src
+	dst.Groups = updateStrings(nil, src.Groups, store.Set)
+}
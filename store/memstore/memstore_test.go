@@ -11,6 +11,7 @@ import (
 	"github.com/CanonicalLtd/candid/store"
 	"github.com/CanonicalLtd/candid/store/memstore"
 	"github.com/CanonicalLtd/candid/store/storetest"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 func TestKeyValueStore(t *testing.T) {
@@ -40,6 +41,24 @@ func TestMeetingStore(t *testing.T) {
 	}, memstore.PutAtTime)
 }
 
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) store.AuditStore {
+		return memstore.NewAuditStore()
+	})
+}
+
+func TestWebhookQueue(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestWebhookQueue(c, func(c *qt.C) webhook.Queue {
+		return memstore.NewWebhookQueue()
+	})
+}
+
 func TestACLStore(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -21,13 +21,22 @@ import (
 )
 
 type memStore struct {
-	mu         sync.Mutex
+	mu sync.Mutex
+
+	// identities holds the stored identities indexed by ID. The
+	// entry for an identity that has been deleted is nil.
 	identities []*store.Identity
+
+	// groups holds the stored groups, keyed by ID.
+	groups      map[string]*store.Group
+	nextGroupID int
 }
 
 // NewStore creates a new in-memory store.Store instance.
 func NewStore() store.Store {
-	return &memStore{}
+	return &memStore{
+		groups: make(map[string]*store.Group),
+	}
 }
 
 // Context implements store.Store.Context by returning the given context
@@ -48,11 +57,12 @@ func (s *memStore) RemoveAll() {
 	defer s.mu.Unlock()
 	var identities []*store.Identity
 	for _, identity := range s.identities {
-		if identity.ProviderID == adminID {
+		if identity != nil && identity.ProviderID == adminID {
 			identities = append(identities, identity)
 		}
 	}
 	s.identities = identities
+	s.groups = make(map[string]*store.Group)
 }
 
 // Identity implements store.Store.Identity.
@@ -62,11 +72,10 @@ func (s *memStore) Identity(_ context.Context, identity *store.Identity) error {
 	var id *store.Identity
 	switch {
 	case identity.ID != "":
-		n, err := strconv.Atoi(identity.ID)
-		if err != nil || n >= len(s.identities) {
+		id = s.identityFromID(identity.ID)
+		if id == nil {
 			return store.NotFoundError(identity.ID, "", "")
 		}
-		id = s.identities[n]
 	case identity.ProviderID != "":
 		id = s.identityFromProviderID(identity.ProviderID)
 		if id == nil {
@@ -84,11 +93,21 @@ func (s *memStore) Identity(_ context.Context, identity *store.Identity) error {
 	return nil
 }
 
+// identityFromID returns the identity with the given ID, or nil if
+// there is none.
+func (s *memStore) identityFromID(id string) *store.Identity {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 || n >= len(s.identities) {
+		return nil
+	}
+	return s.identities[n]
+}
+
 // identityFromProviderID performs a linear search to find an identitty
 // with the given providerID.
 func (s *memStore) identityFromProviderID(providerID store.ProviderIdentity) *store.Identity {
 	for _, id := range s.identities {
-		if id.ProviderID == providerID {
+		if id != nil && id.ProviderID == providerID {
 			return id
 		}
 	}
@@ -99,7 +118,7 @@ func (s *memStore) identityFromProviderID(providerID store.ProviderIdentity) *st
 // with the given username.
 func (s *memStore) identityFromUsername(username string) *store.Identity {
 	for _, id := range s.identities {
-		if id.Username == username {
+		if id != nil && id.Username == username {
 			return id
 		}
 	}
@@ -112,7 +131,7 @@ func (s *memStore) FindIdentities(ctx context.Context, ref *store.Identity, filt
 	defer s.mu.Unlock()
 	identities := make([]store.Identity, 0, len(s.identities))
 	for _, identity := range s.identities {
-		if !matchIdentity(identity, ref, filter) {
+		if identity == nil || !matchIdentity(identity, ref, filter) {
 			continue
 		}
 		var identity1 store.Identity
@@ -135,11 +154,30 @@ func (s *memStore) FindIdentities(ctx context.Context, ref *store.Identity, filt
 	return identities, nil
 }
 
+// CountIdentities implements store.Store.CountIdentities.
+func (s *memStore) CountIdentities(ctx context.Context, ref *store.Identity, filter store.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, identity := range s.identities {
+		if identity != nil && matchIdentity(identity, ref, filter) {
+			n++
+		}
+	}
+	return n, nil
+}
+
 func matchIdentity(a, b *store.Identity, filter store.Filter) bool {
 	for f, c := range filter {
 		if c == store.NoComparison {
 			continue
 		}
+		if c == store.Prefix || c == store.Contains {
+			if !matchSubstring(stringField(a, store.Field(f)), stringField(b, store.Field(f)), c) {
+				return false
+			}
+			continue
+		}
 		var r int
 		switch store.Field(f) {
 		case store.ProviderID:
@@ -156,6 +194,15 @@ func matchIdentity(a, b *store.Identity, filter store.Filter) bool {
 			r = cmpTime(a.LastDischarge, b.LastDischarge)
 		case store.Owner:
 			r = strings.Compare(string(a.Owner), string(b.Owner))
+		case store.Groups:
+			if c != store.Equal {
+				panic("unsupported comparison on Groups field")
+			}
+			for _, g := range b.Groups {
+				if !containsString(a.Groups, g) {
+					r = 1
+				}
+			}
 		default:
 			panic("unsupported filter field")
 		}
@@ -166,6 +213,37 @@ func matchIdentity(a, b *store.Identity, filter store.Filter) bool {
 	return true
 }
 
+// stringField returns the value of the given string field of the given
+// identity.
+func stringField(id *store.Identity, f store.Field) string {
+	switch f {
+	case store.ProviderID:
+		return string(id.ProviderID)
+	case store.Username:
+		return id.Username
+	case store.Name:
+		return id.Name
+	case store.Email:
+		return id.Email
+	case store.Owner:
+		return string(id.Owner)
+	default:
+		panic("unsupported comparison on field")
+	}
+}
+
+// matchSubstring determines whether the value s has the relationship
+// with the reference value ref specified by the given store.Comparison,
+// which must be store.Prefix or store.Contains.
+func matchSubstring(s, ref string, c store.Comparison) bool {
+	s = strings.ToLower(s)
+	ref = strings.ToLower(ref)
+	if c == store.Prefix {
+		return strings.HasPrefix(s, ref)
+	}
+	return strings.Contains(s, ref)
+}
+
 // matchCmp determines whether the given value n which is a result of a
 // "cmp" function such as strings.Compare indicates that the compared
 // values have the relationship specified by the given store.Comparison.
@@ -256,11 +334,10 @@ func (s *memStore) UpdateIdentity(_ context.Context, identity *store.Identity, u
 	var id *store.Identity
 	switch {
 	case identity.ID != "":
-		n, err := strconv.Atoi(identity.ID)
-		if err != nil || n >= len(s.identities) {
+		id = s.identityFromID(identity.ID)
+		if id == nil {
 			return store.NotFoundError(identity.ID, "", "")
 		}
-		id = s.identities[n]
 	case identity.ProviderID != "":
 		id = s.identityFromProviderID(identity.ProviderID)
 		if id == nil {
@@ -292,6 +369,38 @@ func (s *memStore) UpdateIdentity(_ context.Context, identity *store.Identity, u
 	return errgo.Mask(s.updateIdentity(id, identity, update), errgo.Is(store.ErrDuplicateUsername))
 }
 
+// DeleteIdentity implements store.Store.DeleteIdentity.
+func (s *memStore) DeleteIdentity(_ context.Context, identity *store.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id *store.Identity
+	switch {
+	case identity.ID != "":
+		id = s.identityFromID(identity.ID)
+	case identity.ProviderID != "":
+		id = s.identityFromProviderID(identity.ProviderID)
+	case identity.Username != "":
+		id = s.identityFromUsername(identity.Username)
+	}
+	if id == nil {
+		return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+	}
+	s.deleteIdentity(id)
+	return nil
+}
+
+// deleteIdentity removes the given identity and, recursively, all the
+// identities that it owns.
+func (s *memStore) deleteIdentity(id *store.Identity) {
+	n, _ := strconv.Atoi(id.ID)
+	s.identities[n] = nil
+	for _, owned := range s.identities {
+		if owned != nil && owned.Owner == id.ProviderID {
+			s.deleteIdentity(owned)
+		}
+	}
+}
+
 func (s *memStore) updateIdentity(dst, src *store.Identity, update store.Update) error {
 	if update[store.ProviderID] != store.NoUpdate {
 		panic(errgo.Newf("unsupported operation %v requested on ProviderID field", update[store.ProviderID]))
@@ -316,6 +425,8 @@ func (s *memStore) updateIdentity(dst, src *store.Identity, update store.Update)
 	dst.ProviderInfo = updateMap(dst.ProviderInfo, src.ProviderInfo, update[store.ProviderInfo])
 	dst.ExtraInfo = updateMap(dst.ExtraInfo, src.ExtraInfo, update[store.ExtraInfo])
 	dst.Owner = updateProviderIdentity(dst.Owner, src.Owner, update[store.Owner])
+	dst.Suspended = updateBool(dst.Suspended, src.Suspended, update[store.Suspended])
+	dst.NotBefore = updateTime(dst.NotBefore, src.NotBefore, update[store.NotBefore])
 	return nil
 }
 
@@ -345,6 +456,19 @@ func updateProviderIdentity(dst, src store.ProviderIdentity, op store.Operation)
 	}
 }
 
+func updateBool(dst, src bool, op store.Operation) bool {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return src
+	case store.Clear:
+		return false
+	default:
+		panic("unsupported operation requested on bool field")
+	}
+}
+
 func updateTime(dst, src time.Time, op store.Operation) time.Time {
 	switch op {
 	case store.NoUpdate:
@@ -458,7 +582,30 @@ func (s *memStore) IdentityCounts(_ context.Context) (map[string]int, error) {
 	defer s.mu.Unlock()
 	counts := make(map[string]int)
 	for _, id := range s.identities {
-		counts[id.ProviderID.Provider()]++
+		if id != nil {
+			counts[id.ProviderID.Provider()]++
+		}
 	}
 	return counts, nil
 }
+
+// IdentityGroups implements store.Store.IdentityGroups.
+func (s *memStore) IdentityGroups(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	var groups []string
+	for _, id := range s.identities {
+		if id == nil {
+			continue
+		}
+		for _, g := range id.Groups {
+			if !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// NewWebhookQueue creates a new in-memory webhook.Queue
+// implementation.
+func NewWebhookQueue() webhook.Queue {
+	return &webhookQueue{
+		deliveries: make(map[string]*webhook.Delivery),
+	}
+}
+
+type webhookQueue struct {
+	mu         sync.Mutex
+	nextID     int
+	deliveries map[string]*webhook.Delivery
+}
+
+// Add implements webhook.Queue.Add.
+func (q *webhookQueue) Add(_ context.Context, d *webhook.Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d.ID = strconv.Itoa(q.nextID)
+	q.nextID++
+	d1 := *d
+	d1.Body = append([]byte(nil), d.Body...)
+	q.deliveries[d.ID] = &d1
+	return nil
+}
+
+// Claim implements webhook.Queue.Claim.
+func (q *webhookQueue) Claim(_ context.Context, now, until time.Time, limit int) ([]webhook.Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []*webhook.Delivery
+	for _, d := range q.deliveries {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttempt.Equal(due[j].NextAttempt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	deliveries := make([]webhook.Delivery, len(due))
+	for i, d := range due {
+		deliveries[i] = *d
+		d.NextAttempt = until
+	}
+	return deliveries, nil
+}
+
+// Reschedule implements webhook.Queue.Reschedule.
+func (q *webhookQueue) Reschedule(_ context.Context, id string, attempts int, next time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d, ok := q.deliveries[id]; ok {
+		d.Attempts = attempts
+		d.NextAttempt = next
+	}
+	return nil
+}
+
+// Remove implements webhook.Queue.Remove.
+func (q *webhookQueue) Remove(_ context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.deliveries, id)
+	return nil
+}
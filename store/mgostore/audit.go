@@ -0,0 +1,126 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const auditCollection = "audit"
+
+// An auditDocument is the document stored in the audit collection for
+// each store.AuditEntry.
+type auditDocument struct {
+	ID       bson.ObjectId        `bson:"_id"`
+	Time     time.Time            `bson:"time"`
+	Type     store.AuditEventType `bson:"type"`
+	Username string               `bson:"username,omitempty"`
+	Actor    string               `bson:"actor,omitempty"`
+	IDP      string               `bson:"idp,omitempty"`
+	Caveat   string               `bson:"caveat,omitempty"`
+	Detail   string               `bson:"detail,omitempty"`
+}
+
+// auditStore is an implementation of store.AuditStore that uses a
+// mongodb collection for the persistent data store.
+type auditStore struct {
+	b *backend
+}
+
+// AddAuditEntry implements store.AuditStore.AddAuditEntry.
+func (s *auditStore) AddAuditEntry(ctx context.Context, entry *store.AuditEntry) error {
+	coll := s.b.c(ctx, auditCollection)
+	defer coll.Database.Session.Close()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	doc := auditDocument{
+		ID:       bson.NewObjectId(),
+		Time:     entry.Time,
+		Type:     entry.Type,
+		Username: entry.Username,
+		Actor:    entry.Actor,
+		IDP:      entry.IDP,
+		Caveat:   entry.Caveat,
+		Detail:   entry.Detail,
+	}
+	if err := coll.Insert(&doc); err != nil {
+		return errgo.Mask(err)
+	}
+	entry.ID = doc.ID.Hex()
+	return nil
+}
+
+// FindAuditEntries implements store.AuditStore.FindAuditEntries.
+func (s *auditStore) FindAuditEntries(ctx context.Context, filter store.AuditFilter) ([]store.AuditEntry, error) {
+	coll := s.b.c(ctx, auditCollection)
+	defer coll.Database.Session.Close()
+
+	query := make(bson.D, 0, 2)
+	if filter.Username != "" {
+		query = append(query, bson.DocElem{Name: "$or", Value: []bson.D{
+			{{Name: "username", Value: filter.Username}},
+			{{Name: "actor", Value: filter.Username}},
+		}})
+	}
+	timeQuery := make(bson.D, 0, 2)
+	if !filter.Since.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{Name: "$gte", Value: filter.Since})
+	}
+	if !filter.Until.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{Name: "$lt", Value: filter.Until})
+	}
+	if len(timeQuery) > 0 {
+		query = append(query, bson.DocElem{Name: "time", Value: timeQuery})
+	}
+	q := coll.Find(query).Sort("-time", "-_id")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	var entries []store.AuditEntry
+	it := q.Iter()
+	var doc auditDocument
+	for it.Next(&doc) {
+		entries = append(entries, store.AuditEntry{
+			ID:       doc.ID.Hex(),
+			Time:     doc.Time,
+			Type:     doc.Type,
+			Username: doc.Username,
+			Actor:    doc.Actor,
+			IDP:      doc.IDP,
+			Caveat:   doc.Caveat,
+			Detail:   doc.Detail,
+		})
+	}
+	if err := it.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entries, nil
+}
+
+var auditIndexes = []mgo.Index{{
+	Key: []string{"time"},
+}, {
+	Key: []string{"username", "time"},
+}, {
+	Key: []string{"actor", "time"},
+}}
+
+func ensureAuditIndexes(db *mgo.Database) error {
+	coll := db.C(auditCollection)
+	for _, idx := range auditIndexes {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/CanonicalLtd/candid/meeting"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 const aclsCollection = "acls"
@@ -44,6 +45,15 @@ func NewBackend(db *mgo.Database) (_ store.Backend, err error) {
 	if err := ensureIdentityIndexes(db); err != nil {
 		return nil, errgo.Mask(err)
 	}
+	if err := ensureGroupIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := ensureAuditIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := ensureWebhookIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
 	if err := ensureMeetingIndexes(db); err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -137,6 +147,16 @@ func (b *backend) ACLStore() aclstore.ACLStore {
 	return b.aclStore
 }
 
+// AuditStore implements store.Backend.AuditStore.
+func (b *backend) AuditStore() store.AuditStore {
+	return &auditStore{b}
+}
+
+// WebhookQueue implements store.Backend.WebhookQueue.
+func (b *backend) WebhookQueue() webhook.Queue {
+	return &webhookQueue{b}
+}
+
 type collector struct {
 	db *mgo.Database
 }
@@ -147,7 +167,10 @@ func (c collector) Collections() []*mgo.Collection {
 		c.db.C(macaroonCollection),
 		c.db.C(meetingCollection),
 		c.db.C(identitiesCollection),
+		c.db.C(groupsCollection),
 		c.db.C(aclsCollection),
+		c.db.C(auditCollection),
+		c.db.C(webhooksCollection),
 	}
 }
 
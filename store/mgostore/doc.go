@@ -29,6 +29,8 @@ var fieldNames = []string{
 	store.ProviderInfo:  "providerinfo",
 	store.ExtraInfo:     "extrainfo",
 	store.Owner:         "owner",
+	store.Suspended:     "suspended",
+	store.NotBefore:     "notbefore",
 }
 
 // identityDocument holds the in-database representation of a user in the identities
@@ -72,6 +74,13 @@ type identityDocument struct {
 
 	// Owner holds the provider id of the owner.
 	Owner string
+
+	// Suspended holds whether the identity has been suspended.
+	Suspended bool
+
+	// NotBefore holds the time before which sessions issued to
+	// the identity are no longer valid.
+	NotBefore time.Time
 }
 
 // PublicKeys converts the stored public keys into the format used by the
@@ -0,0 +1,175 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+
+	errgo "gopkg.in/errgo.v1"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+const groupsCollection = "groups"
+
+// groupDocument holds the in-database representation of a group in the
+// groups Mongo collection.
+type groupDocument struct {
+	// ID is the internal mongodb id for the group.
+	ID bson.ObjectId `bson:"_id"`
+
+	// Name holds the unique name of the group.
+	Name string `bson:"name"`
+
+	// Description holds the description of the group.
+	Description string `bson:"description,omitempty"`
+
+	// Owner holds the provider ID of the identity that owns the
+	// group.
+	Owner string `bson:"owner,omitempty"`
+
+	// Groups holds the names of the groups that this group is a
+	// member of.
+	Groups []string `bson:"groups,omitempty"`
+}
+
+func (doc *groupDocument) group() store.Group {
+	return store.Group{
+		ID:          doc.ID.Hex(),
+		Name:        doc.Name,
+		Description: doc.Description,
+		Owner:       store.ProviderIdentity(doc.Owner),
+		Groups:      doc.Groups,
+	}
+}
+
+func groupQuery(group *store.Group) bson.D {
+	switch {
+	case group.ID != "":
+		if !bson.IsObjectIdHex(group.ID) {
+			break
+		}
+		return bson.D{{Name: "_id", Value: bson.ObjectIdHex(group.ID)}}
+	case group.Name != "":
+		return bson.D{{Name: "name", Value: group.Name}}
+	}
+	// The group specifies no identifying fields, return something
+	// that will fail.
+	return bson.D{{Name: "_id", Value: ""}}
+}
+
+// Group implements store.Store.Group by retrieving the specified group
+// from the mongodb database.
+func (s *identityStore) Group(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	var doc groupDocument
+	if err := coll.Find(groupQuery(group)).One(&doc); err != nil {
+		if errgo.Cause(err) == mgo.ErrNotFound {
+			return store.GroupNotFoundError(group.ID, group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	*group = doc.group()
+	return nil
+}
+
+// FindGroups implements store.Store.FindGroups by querying the mongodb
+// database.
+func (s *identityStore) FindGroups(ctx context.Context) ([]store.Group, error) {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	it := coll.Find(nil).Sort("name").Iter()
+	var groups []store.Group
+	var doc groupDocument
+	for it.Next(&doc) {
+		groups = append(groups, doc.group())
+		doc = groupDocument{}
+	}
+	if err := it.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return groups, nil
+}
+
+// CreateGroup implements store.Store.CreateGroup by inserting the group
+// into the mongodb database.
+func (s *identityStore) CreateGroup(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	doc := groupDocument{
+		ID:          bson.NewObjectId(),
+		Name:        group.Name,
+		Description: group.Description,
+		Owner:       string(group.Owner),
+		Groups:      group.Groups,
+	}
+	if err := coll.Insert(&doc); err != nil {
+		if mgo.IsDup(err) {
+			return store.DuplicateGroupError(group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	group.ID = doc.ID.Hex()
+	return nil
+}
+
+// UpdateGroup implements store.Store.UpdateGroup by replacing the group
+// in the mongodb database.
+func (s *identityStore) UpdateGroup(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	var doc groupDocument
+	change := mgo.Change{
+		Update: bson.D{{Name: "$set", Value: bson.D{
+			{Name: "name", Value: group.Name},
+			{Name: "description", Value: group.Description},
+			{Name: "owner", Value: string(group.Owner)},
+			{Name: "groups", Value: group.Groups},
+		}}},
+	}
+	if _, err := coll.Find(groupQuery(group)).Apply(change, &doc); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.GroupNotFoundError(group.ID, group.Name)
+		}
+		if mgo.IsDup(err) {
+			return store.DuplicateGroupError(group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	group.ID = doc.ID.Hex()
+	return nil
+}
+
+// RemoveGroup implements store.Store.RemoveGroup by removing the group
+// from the mongodb database.
+func (s *identityStore) RemoveGroup(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	if err := coll.Remove(groupQuery(group)); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.GroupNotFoundError(group.ID, group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+func ensureGroupIndexes(db *mgo.Database) error {
+	coll := db.C(groupsCollection)
+	if err := coll.EnsureIndex(mgo.Index{
+		Key:    []string{"name"},
+		Unique: true,
+	}); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ package mgostore
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
@@ -53,6 +55,8 @@ func (s *identityStore) Identity(ctx context.Context, identity *store.Identity)
 	identity.ProviderInfo = doc.ProviderInfo
 	identity.ExtraInfo = doc.ExtraInfo
 	identity.Owner = store.ProviderIdentity(doc.Owner)
+	identity.Suspended = doc.Suspended
+	identity.NotBefore = doc.NotBefore
 	return nil
 }
 
@@ -116,6 +120,8 @@ func (s *identityStore) FindIdentities(ctx context.Context, ref *store.Identity,
 			ProviderInfo:  doc.ProviderInfo,
 			ExtraInfo:     doc.ExtraInfo,
 			Owner:         store.ProviderIdentity(doc.Owner),
+			Suspended:     doc.Suspended,
+			NotBefore:     doc.NotBefore,
 		})
 	}
 	if err := it.Err(); err != nil {
@@ -124,6 +130,20 @@ func (s *identityStore) FindIdentities(ctx context.Context, ref *store.Identity,
 	return identities, nil
 }
 
+// CountIdentities implements store.Store.CountIdentities by querying
+// the mongodb database. The given context must have a mgo.Session
+// added using ContextWithSession.
+func (s *identityStore) CountIdentities(ctx context.Context, ref *store.Identity, filter store.Filter) (int, error) {
+	coll := s.b.c(ctx, identitiesCollection)
+	defer coll.Database.Session.Close()
+
+	n, err := coll.Find(makeQuery(ref, filter)).Count()
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return n, nil
+}
+
 func makeQuery(ref *store.Identity, filter store.Filter) bson.D {
 	query := make(bson.D, 0, store.NumFields)
 	query = appendComparison(query, fieldNames[store.ProviderID], filter[store.ProviderID], ref.ProviderID)
@@ -133,6 +153,9 @@ func makeQuery(ref *store.Identity, filter store.Filter) bson.D {
 	query = appendComparison(query, fieldNames[store.LastLogin], filter[store.LastLogin], ref.LastLogin)
 	query = appendComparison(query, fieldNames[store.LastDischarge], filter[store.LastDischarge], ref.LastDischarge)
 	query = appendComparison(query, fieldNames[store.Owner], filter[store.Owner], ref.Owner)
+	if filter[store.Groups] == store.Equal {
+		query = append(query, bson.DocElem{Name: fieldNames[store.Groups], Value: bson.D{{Name: "$all", Value: ref.Groups}}})
+	}
 	return query
 }
 
@@ -144,11 +167,32 @@ func appendComparison(query bson.D, fieldName string, p store.Comparison, value
 		// TODO with Mongo 3.0, we could remove this special case
 		// and use $eq instead.
 		return append(query, bson.DocElem{fieldName, value})
+	case store.Prefix:
+		return append(query, bson.DocElem{Name: fieldName, Value: bson.RegEx{
+			Pattern: "^" + regexp.QuoteMeta(stringValue(value)),
+			Options: "i",
+		}})
+	case store.Contains:
+		return append(query, bson.DocElem{Name: fieldName, Value: bson.RegEx{
+			Pattern: regexp.QuoteMeta(stringValue(value)),
+			Options: "i",
+		}})
 	default:
 		return append(query, bson.DocElem{fieldName, bson.D{{comparisonOps[p], value}}})
 	}
 }
 
+// stringValue returns the string held in the given reference value.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case store.ProviderIdentity:
+		return string(v)
+	}
+	panic(errgo.Newf("unsupported value %T in pattern comparison", v))
+}
+
 var comparisonOps = []string{
 	store.NotEqual:           "$ne",
 	store.GreaterThan:        "$gt",
@@ -189,6 +233,42 @@ func (s *identityStore) UpdateIdentity(ctx context.Context, identity *store.Iden
 	return errgo.Mask(err)
 }
 
+// DeleteIdentity implements store.Store.DeleteIdentity by removing the
+// identity, and any identities that it owns, from the mongodb database.
+// The given context must have a mgo.Session added using
+// ContextWithSession.
+func (s *identityStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	coll := s.b.c(ctx, identitiesCollection)
+	defer coll.Database.Session.Close()
+
+	var doc identityDocument
+	if err := coll.Find(identityQuery(identity)).Select(bson.D{{Name: "providerid", Value: 1}}).One(&doc); err != nil {
+		if errgo.Cause(err) == mgo.ErrNotFound {
+			return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+		}
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(deleteIdentity(coll, doc.ID, doc.ProviderID))
+}
+
+// deleteIdentity removes the identity with the given ID and,
+// recursively, all the identities owned by the given provider ID.
+func deleteIdentity(coll *mgo.Collection, id bson.ObjectId, providerID string) error {
+	if err := coll.RemoveId(id); err != nil && err != mgo.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	var owned []identityDocument
+	if err := coll.Find(bson.D{{Name: "owner", Value: providerID}}).Select(bson.D{{Name: "providerid", Value: 1}}).All(&owned); err != nil {
+		return errgo.Mask(err)
+	}
+	for _, doc := range owned {
+		if err := deleteIdentity(coll, doc.ID, doc.ProviderID); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
 func (s *identityStore) upsertIdentity(coll *mgo.Collection, identity *store.Identity, update store.Update) error {
 	changeInfo, err := coll.Upsert(bson.D{{"providerid", identity.ProviderID}}, identityUpdate(identity, update))
 	if err != nil {
@@ -220,6 +300,8 @@ func identityUpdate(identity *store.Identity, update store.Update) updateDocumen
 		doc.addUpdate(update[store.ExtraInfo], fieldNames[store.ExtraInfo]+"."+k, v)
 	}
 	doc.addUpdate(update[store.Owner], fieldNames[store.Owner], identity.Owner)
+	doc.addUpdate(update[store.Suspended], fieldNames[store.Suspended], identity.Suspended)
+	doc.addUpdate(update[store.NotBefore], fieldNames[store.NotBefore], identity.NotBefore)
 	return doc
 }
 
@@ -272,3 +354,16 @@ func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, err
 	}
 	return counts, nil
 }
+
+// IdentityGroups implements store.Store.IdentityGroups.
+func (s *identityStore) IdentityGroups(ctx context.Context) ([]string, error) {
+	coll := s.b.c(ctx, identitiesCollection)
+	defer coll.Database.Session.Close()
+
+	var groups []string
+	if err := coll.Find(nil).Distinct(fieldNames[store.Groups], &groups); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
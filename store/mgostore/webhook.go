@@ -0,0 +1,119 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+const webhooksCollection = "webhooks"
+
+// A webhookDocument is the document stored in the webhooks collection
+// for each pending webhook.Delivery.
+type webhookDocument struct {
+	ID          bson.ObjectId `bson:"_id"`
+	URL         string        `bson:"url"`
+	Body        []byte        `bson:"body"`
+	Attempts    int           `bson:"attempts"`
+	NextAttempt time.Time     `bson:"next"`
+}
+
+// webhookQueue is an implementation of webhook.Queue that uses a
+// mongodb collection for the persistent data store.
+type webhookQueue struct {
+	b *backend
+}
+
+// Add implements webhook.Queue.Add.
+func (q *webhookQueue) Add(ctx context.Context, d *webhook.Delivery) error {
+	coll := q.b.c(ctx, webhooksCollection)
+	defer coll.Database.Session.Close()
+
+	doc := webhookDocument{
+		ID:          bson.NewObjectId(),
+		URL:         d.URL,
+		Body:        d.Body,
+		Attempts:    d.Attempts,
+		NextAttempt: d.NextAttempt,
+	}
+	if err := coll.Insert(&doc); err != nil {
+		return errgo.Mask(err)
+	}
+	d.ID = doc.ID.Hex()
+	return nil
+}
+
+// Claim implements webhook.Queue.Claim.
+func (q *webhookQueue) Claim(ctx context.Context, now, until time.Time, limit int) ([]webhook.Delivery, error) {
+	coll := q.b.c(ctx, webhooksCollection)
+	defer coll.Database.Session.Close()
+
+	var deliveries []webhook.Delivery
+	for limit <= 0 || len(deliveries) < limit {
+		var doc webhookDocument
+		_, err := coll.Find(bson.D{{Name: "next", Value: bson.D{{Name: "$lte", Value: now}}}}).Sort("next", "_id").Apply(mgo.Change{
+			Update: bson.D{{Name: "$set", Value: bson.D{{Name: "next", Value: until}}}},
+		}, &doc)
+		if err == mgo.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return deliveries, errgo.Mask(err)
+		}
+		deliveries = append(deliveries, webhook.Delivery{
+			ID:          doc.ID.Hex(),
+			URL:         doc.URL,
+			Body:        doc.Body,
+			Attempts:    doc.Attempts,
+			NextAttempt: doc.NextAttempt,
+		})
+	}
+	return deliveries, nil
+}
+
+// Reschedule implements webhook.Queue.Reschedule.
+func (q *webhookQueue) Reschedule(ctx context.Context, id string, attempts int, next time.Time) error {
+	if !bson.IsObjectIdHex(id) {
+		return nil
+	}
+	coll := q.b.c(ctx, webhooksCollection)
+	defer coll.Database.Session.Close()
+
+	err := coll.UpdateId(bson.ObjectIdHex(id), bson.D{{Name: "$set", Value: bson.D{
+		{Name: "attempts", Value: attempts},
+		{Name: "next", Value: next},
+	}}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return errgo.Mask(err)
+}
+
+// Remove implements webhook.Queue.Remove.
+func (q *webhookQueue) Remove(ctx context.Context, id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return nil
+	}
+	coll := q.b.c(ctx, webhooksCollection)
+	defer coll.Database.Session.Close()
+
+	err := coll.RemoveId(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return errgo.Mask(err)
+}
+
+func ensureWebhookIndexes(db *mgo.Database) error {
+	return errgo.Mask(db.C(webhooksCollection).EnsureIndex(mgo.Index{
+		Key: []string{"next"},
+	}))
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// auditStore is an implementation of store.AuditStore that uses an sql
+// table.
+type auditStore struct {
+	*backend
+}
+
+type auditEntryParams struct {
+	argBuilder
+	store.AuditEntry
+}
+
+// AddAuditEntry implements store.AuditStore.AddAuditEntry.
+func (s *auditStore) AddAuditEntry(_ context.Context, entry *store.AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	params := &auditEntryParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		AuditEntry: *entry,
+	}
+	id, err := s.driver.queryID(s.db, tmplInsertAuditEntry, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	entry.ID = id
+	return nil
+}
+
+type findAuditEntriesParams struct {
+	argBuilder
+	store.AuditFilter
+}
+
+// FindAuditEntries implements store.AuditStore.FindAuditEntries.
+func (s *auditStore) FindAuditEntries(_ context.Context, filter store.AuditFilter) ([]store.AuditEntry, error) {
+	params := &findAuditEntriesParams{
+		argBuilder:  s.driver.argBuilderFunc(),
+		AuditFilter: filter,
+	}
+	rows, err := s.driver.query(s.db, tmplFindAuditEntries, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var entries []store.AuditEntry
+	for rows.Next() {
+		var e store.AuditEntry
+		var id int
+		if err := rows.Scan(&id, &e.Time, &e.Type, &e.Username, &e.Actor, &e.IDP, &e.Caveat, &e.Detail); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		e.ID = strconv.Itoa(id)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entries, nil
+}
@@ -2,20 +2,22 @@ package sqlstore
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/juju/aclstore/v2"
-	"github.com/juju/simplekv/sqlsimplekv"
+	"github.com/juju/simplekv"
 	"github.com/juju/utils/debugstatus"
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
-	"gopkg.in/macaroon-bakery.v2/bakery/postgresrootkeystore"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
 
 	"github.com/CanonicalLtd/candid/meeting"
 	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 // backend provides a wrapper around an SQL database that can be used
@@ -24,37 +26,64 @@ import (
 type backend struct {
 	db       *sql.DB
 	driver   *driver
-	rootKeys *postgresrootkeystore.RootKeys
+	rootKeys rootKeys
 	aclStore aclstore.ACLStore
 }
 
 // NewBackend creates a new store.Backend implementation using the
 // given driverName and *sql.DB. The driverName must match the value
-// used to open the database.
+// used to open the database. The supported drivers are "postgres",
+// "sqlite3" and "mysql". A MySQL database must be opened with the
+// parseTime option set and with times in UTC (see MySQLParams).
+//
+// The database schema is migrated to the latest version before the
+// backend is returned (see Migrate).
 //
 // Closing the returned Backend will also close db.
 func NewBackend(driverName string, db *sql.DB) (store.Backend, error) {
-	if driverName != "postgres" {
-		return nil, errgo.Newf("unsupported database driver %q", driverName)
+	driver, err := newDriver(driverName)
+	if err != nil {
+		return nil, errgo.Mask(err)
 	}
-	driver, err := newPostgresDriver(db)
+	migrations, err := driver.migrate(db, false)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot initialise database")
 	}
-	rootkeys := postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000)
-	defer rootkeys.Close()
-	aclStore, err := sqlsimplekv.NewStore(driverName, db, "acls")
+	for _, m := range migrations {
+		logger.Infof("applied schema migration %d: %s", m.Version, m.Description)
+	}
+	aclStore, err := driver.keyValueStoreFunc(db, "acls")
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	return &backend{
 		db:       db,
 		driver:   driver,
-		rootKeys: postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000),
+		rootKeys: driver.rootKeysFunc(db),
 		aclStore: aclstore.NewACLStore(aclStore),
 	}, nil
 }
 
+// newDriver returns the driver for the given database driver name.
+func newDriver(driverName string) (*driver, error) {
+	var d *driver
+	var err error
+	switch driverName {
+	case "postgres":
+		d, err = newPostgresDriver()
+	case "sqlite3":
+		d, err = newSQLiteDriver()
+	case "mysql":
+		d, err = newMySQLDriver()
+	default:
+		return nil, errgo.Newf("unsupported database driver %q", driverName)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	return d, nil
+}
+
 func (b *backend) Close() {
 	b.rootKeys.Close()
 	b.db.Close()
@@ -67,7 +96,7 @@ func (b *backend) Store() store.Store {
 }
 
 func (b *backend) BakeryRootKeyStore() bakery.RootKeyStore {
-	return b.rootKeys.NewStore(postgresrootkeystore.Policy{
+	return b.rootKeys.NewStore(dbrootkeystore.Policy{
 		ExpiryDuration: 365 * 24 * time.Hour,
 	})
 }
@@ -88,6 +117,18 @@ func (b *backend) ACLStore() aclstore.ACLStore {
 	return b.aclStore
 }
 
+// AuditStore returns a new store.AuditStore implementation using this
+// database for persistent storage.
+func (b *backend) AuditStore() store.AuditStore {
+	return &auditStore{b}
+}
+
+// WebhookQueue returns a new webhook.Queue implementation using this
+// database for persistent storage.
+func (b *backend) WebhookQueue() webhook.Queue {
+	return &webhookQueue{b}
+}
+
 // DebugStatusCheckerFuncs implements store.Backend.DebugStatusCheckerFuncs.
 func (b *backend) DebugStatusCheckerFuncs() []debugstatus.CheckerFunc {
 	return nil
@@ -96,7 +137,7 @@ func (b *backend) DebugStatusCheckerFuncs() []debugstatus.CheckerFunc {
 // withTx runs f in a new transaction. any error returned by f will not
 // have it's cause masked.
 func (b *backend) withTx(f func(*sql.Tx) error) error {
-	tx, err := b.db.Begin()
+	tx, err := b.driver.begin(b.db)
 	if err != nil {
 		return errgo.Mask(err)
 	}
@@ -118,9 +159,11 @@ const (
 	tmplUpdateIdentity
 	tmplIdentityID
 	tmplUpsertIdentity
+	tmplInsertIdentity
 	tmplClearIdentitySet
 	tmplPushIdentitySet
 	tmplPullIdentitySet
+	tmplRemoveIdentity
 	tmplGetProviderData
 	tmplGetProviderDataForUpdate
 	tmplInsertProviderData
@@ -129,6 +172,34 @@ const (
 	tmplFindMeetings
 	tmplRemoveMeetings
 	tmplIdentityCounts
+	tmplIdentityGroups
+	tmplGroupFrom
+	tmplFindGroups
+	tmplInsertGroup
+	tmplUpdateGroup
+	tmplRemoveGroup
+	tmplSelectGroupGroups
+	tmplClearGroupGroups
+	tmplPushGroupGroups
+	tmplInsertAuditEntry
+	tmplFindAuditEntries
+	tmplAddWebhook
+	tmplFindDueWebhooks
+	tmplClaimWebhooks
+	tmplRescheduleWebhook
+	tmplRemoveWebhook
+	tmplInitKeyValue
+	tmplGetKeyValue
+	tmplGetKeyValueForUpdate
+	tmplInsertKeyValue
+	tmplRemoveExpiredKeyValue
+	tmplGetRootKey
+	tmplFindLatestRootKey
+	tmplInsertRootKey
+	tmplRemoveExpiredRootKeys
+	tmplInitSchemaVersion
+	tmplSchemaVersion
+	tmplInsertSchemaVersion
 	numTmpl
 )
 
@@ -157,6 +228,43 @@ type driver struct {
 	tmpls           [numTmpl]*template.Template
 	argBuilderFunc  func() argBuilder
 	isDuplicateFunc func(error) bool
+
+	// comparisons holds the SQL operator used for each comparison
+	// in a store.Filter.
+	comparisons map[store.Comparison]string
+
+	// keyValueStoreFunc returns a simplekv.Store that uses the given
+	// table to store its values.
+	keyValueStoreFunc func(db *sql.DB, table string) (simplekv.Store, error)
+
+	// rootKeysFunc returns the cache of root keys used by the
+	// bakery root key stores.
+	rootKeysFunc func(db *sql.DB) rootKeys
+
+	// queryIDFunc executes the given query, which inserts, updates or
+	// removes a single row, and returns the id of that row. If no row
+	// was affected then it returns sql.ErrNoRows.
+	queryIDFunc func(q queryer, query string, args []interface{}) (string, error)
+
+	// txOptions holds the options used when starting a transaction.
+	txOptions *sql.TxOptions
+
+	// migrations holds the migrations that create the database
+	// schema, in version order starting at version 1.
+	migrations []Migration
+
+	// schemaLock, if set, holds a query that takes a session lock to
+	// stop other processes migrating the database at the same time.
+	// It returns a single value, which is 1 if the lock was
+	// acquired. The lock is released by executing schemaUnlock once
+	// the migration has been committed.
+	schemaLock   string
+	schemaUnlock string
+}
+
+// begin starts a new transaction on the given database.
+func (d *driver) begin(db *sql.DB) (*sql.Tx, error) {
+	return db.BeginTx(context.Background(), d.txOptions)
 }
 
 // exec performs the Exec method on the given queryer by processing the
@@ -194,6 +302,27 @@ func (d *driver) queryRow(q queryer, tmplID tmplID, params argBuilder) (*sql.Row
 	return q.QueryRow(query, params.args()...), nil
 }
 
+// queryID performs the driver's queryIDFunc on the given queryer by
+// processing the given template with the given params to determine the
+// query to execute. Any error from the database is returned with its
+// cause preserved.
+func (d *driver) queryID(q queryer, tmplID tmplID, params argBuilder) (string, error) {
+	query, err := d.executeTemplate(tmplID, params)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot build query")
+	}
+	id, err := d.queryIDFunc(q, query, params.args())
+	return id, errgo.Mask(err, errgo.Any)
+}
+
+// returningID implements driver.queryIDFunc for databases that support
+// "RETURNING id" clauses.
+func returningID(q queryer, query string, args []interface{}) (string, error) {
+	var id string
+	err := q.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
+
 func (d *driver) parseTemplate(tmplID tmplID, tmpl string) error {
 	var err error
 	d.tmpls[tmplID], err = template.New("").Funcs(template.FuncMap{
@@ -209,12 +338,3 @@ func (d *driver) executeTemplate(tmplID tmplID, params interface{}) (string, err
 	}
 	return buf.String(), nil
 }
-
-var comparisons = map[store.Comparison]string{
-	store.Equal:              "=",
-	store.NotEqual:           "<>",
-	store.GreaterThan:        ">",
-	store.LessThan:           "<",
-	store.GreaterThanOrEqual: ">=",
-	store.LessThanOrEqual:    "<=",
-}
@@ -2,7 +2,9 @@ package sqlstore
 
 import (
 	"database/sql"
+	"net/url"
 
+	"github.com/go-sql-driver/mysql"
 	errgo "gopkg.in/errgo.v1"
 
 	"github.com/CanonicalLtd/candid/store"
@@ -14,8 +16,27 @@ type Params struct {
 	ConnectionString string `yaml:"connection-string"`
 }
 
+// SQLiteParams holds the specification for the parameters of a SQLite
+// backend used in the config file.
+type SQLiteParams struct {
+	// Path holds the path of the database file. It is created if it
+	// does not exist.
+	Path string `yaml:"path"`
+}
+
+// MySQLParams holds the specification for the parameters of a MySQL
+// backend used in the config file.
+type MySQLParams struct {
+	// ConnectionString holds the data source name used to connect to
+	// the database, as described at
+	// https://github.com/go-sql-driver/mysql#dsn-data-source-name.
+	ConnectionString string `yaml:"connection-string"`
+}
+
 func init() {
 	store.Register("postgres", unmarshalBackend)
+	store.Register("sqlite", unmarshalSQLiteBackend)
+	store.Register("mysql", unmarshalMySQLBackend)
 }
 
 func unmarshalBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
@@ -29,13 +50,149 @@ func unmarshalBackend(unmarshal func(interface{}) error) (store.BackendFactory,
 // NewBackend implements store.BackendFactory.
 func (p Params) NewBackend() (store.Backend, error) {
 	logger.Infof("connecting to postgresql")
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	backend, err := NewBackend("postgres", db)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	return backend, nil
+}
+
+// Migrate migrates the schema of the database to the latest version
+// and returns the migrations applied. If dryRun is true the database is
+// not changed. See the Migrate function for details.
+func (p Params) Migrate(dryRun bool) ([]Migration, error) {
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer db.Close()
+	ms, err := Migrate("postgres", db, dryRun)
+	return ms, errgo.Mask(err)
+}
+
+func (p Params) open() (*sql.DB, error) {
 	db, err := sql.Open("postgres", p.ConnectionString)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot connect to database")
 	}
-	backend, err := NewBackend("postgres", db)
+	return db, nil
+}
+
+func unmarshalSQLiteBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
+	var p SQLiteParams
+	if err := unmarshal(&p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if p.Path == "" {
+		return nil, errgo.Newf("missing path in sqlite storage configuration")
+	}
+	return p, nil
+}
+
+// NewBackend implements store.BackendFactory.
+func (p SQLiteParams) NewBackend() (store.Backend, error) {
+	logger.Infof("opening sqlite database %s", p.Path)
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	backend, err := NewBackend("sqlite3", db)
 	if err != nil {
+		db.Close()
 		return nil, errgo.Notef(err, "cannot initialise database")
 	}
 	return backend, nil
 }
+
+// Migrate migrates the schema of the database to the latest version
+// and returns the migrations applied. If dryRun is true the database is
+// not changed. See the Migrate function for details.
+func (p SQLiteParams) Migrate(dryRun bool) ([]Migration, error) {
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer db.Close()
+	ms, err := Migrate("sqlite3", db, dryRun)
+	return ms, errgo.Mask(err)
+}
+
+func (p SQLiteParams) open() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(p.Path))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open database")
+	}
+	return db, nil
+}
+
+// sqliteDSN returns the data source name used to open the SQLite
+// database at the given path. Transactions take the write lock when
+// they start so that they are serialized rather than failing when they
+// conflict, and connections wait for the lock rather than failing
+// immediately. The write-ahead log allows readers to continue while a
+// transaction is writing.
+func sqliteDSN(path string) string {
+	v := url.Values{
+		"_busy_timeout": {"10000"},
+		"_foreign_keys": {"1"},
+		"_journal_mode": {"WAL"},
+		"_txlock":       {"immediate"},
+	}
+	return "file:" + path + "?" + v.Encode()
+}
+
+func unmarshalMySQLBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
+	var p MySQLParams
+	if err := unmarshal(&p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if _, err := mysql.ParseDSN(p.ConnectionString); err != nil {
+		return nil, errgo.Notef(err, "invalid connection-string in mysql storage configuration")
+	}
+	return p, nil
+}
+
+// NewBackend implements store.BackendFactory.
+func (p MySQLParams) NewBackend() (store.Backend, error) {
+	logger.Infof("connecting to mysql")
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	backend, err := NewBackend("mysql", db)
+	if err != nil {
+		db.Close()
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	return backend, nil
+}
+
+// Migrate migrates the schema of the database to the latest version
+// and returns the migrations applied. If dryRun is true the database is
+// not changed. See the Migrate function for details.
+func (p MySQLParams) Migrate(dryRun bool) ([]Migration, error) {
+	db, err := p.open()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer db.Close()
+	ms, err := Migrate("mysql", db, dryRun)
+	return ms, errgo.Mask(err)
+}
+
+func (p MySQLParams) open() (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(p.ConnectionString)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid connection string")
+	}
+	setMySQLOptions(cfg)
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot connect to database")
+	}
+	return sql.OpenDB(connector), nil
+}
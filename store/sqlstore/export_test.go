@@ -5,6 +5,7 @@ package sqlstore
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/CanonicalLtd/candid/meeting"
@@ -13,3 +14,21 @@ import (
 var PutAtTime = func(ctx context.Context, s meeting.Store, id, address string, now time.Time) error {
 	return s.(*meetingStore).put(id, address, now)
 }
+
+var SQLiteDSN = sqliteDSN
+
+var SetMySQLOptions = setMySQLOptions
+
+var SQLiteInit = sqliteInit
+
+// MigrateWithSchemaLock migrates the database as Migrate does, but
+// takes the schema lock by executing the given query.
+func MigrateWithSchemaLock(driverName string, db *sql.DB, lock string) ([]Migration, error) {
+	d, err := newDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	d.schemaLock = lock
+	d.schemaUnlock = "SELECT 1"
+	return d.migrate(db, false)
+}
@@ -0,0 +1,235 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+type groupParams struct {
+	argBuilder
+
+	// Column contains the name of the column to use to determine the
+	// group to be selected or updated.
+	Column string
+
+	// Group contains the value to match with the group column above.
+	Group string
+
+	Name        string
+	Description sql.NullString
+	Owner       sql.NullString
+}
+
+// newGroupParams creates the parameters that identify the given group
+// in a query. If the group cannot be identified then an error with a
+// cause of store.ErrNotFound will be returned.
+func (s *identityStore) newGroupParams(group *store.Group) (*groupParams, error) {
+	params := &groupParams{
+		argBuilder:  s.driver.argBuilderFunc(),
+		Name:        group.Name,
+		Description: sql.NullString{String: group.Description, Valid: group.Description != ""},
+		Owner:       sql.NullString{String: string(group.Owner), Valid: group.Owner != ""},
+	}
+	switch {
+	case group.ID != "":
+		if _, err := strconv.Atoi(group.ID); err != nil {
+			// By definition if id isn't numeric it won't exist.
+			return nil, store.GroupNotFoundError(group.ID, "")
+		}
+		params.Column = "id"
+		params.Group = group.ID
+	case group.Name != "":
+		params.Column = "name"
+		params.Group = group.Name
+	default:
+		return nil, store.GroupNotFoundError("", "")
+	}
+	return params, nil
+}
+
+// Group implements store.Store.Group.
+func (s *identityStore) Group(_ context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params, err := s.newGroupParams(group)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		row, err := s.driver.queryRow(tx, tmplGroupFrom, params)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if err := scanGroup(row, group); err != nil {
+			if errgo.Cause(err) == sql.ErrNoRows {
+				return store.GroupNotFoundError(group.ID, group.Name)
+			}
+			return errgo.Notef(err, "cannot get group")
+		}
+		group.Groups, err = s.getGroupGroups(tx, group.ID)
+		if err != nil {
+			return errgo.Notef(err, "cannot get group")
+		}
+		return nil
+	}), errgo.Is(store.ErrNotFound))
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s *identityStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	var groups []store.Group
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := s.driver.query(tx, tmplFindGroups, s.driver.argBuilderFunc())
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var group store.Group
+			if err := scanGroup(rows, &group); err != nil {
+				return errgo.Mask(err)
+			}
+			groups = append(groups, group)
+		}
+		if err := rows.Err(); err != nil {
+			return errgo.Mask(err)
+		}
+		for i := range groups {
+			groups[i].Groups, err = s.getGroupGroups(tx, groups[i].ID)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find groups")
+	}
+	return groups, nil
+}
+
+// CreateGroup implements store.Store.CreateGroup.
+func (s *identityStore) CreateGroup(_ context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params := &groupParams{
+			argBuilder:  s.driver.argBuilderFunc(),
+			Name:        group.Name,
+			Description: sql.NullString{String: group.Description, Valid: group.Description != ""},
+			Owner:       sql.NullString{String: string(group.Owner), Valid: group.Owner != ""},
+		}
+		return errgo.Mask(s.writeGroup(tx, tmplInsertGroup, params, group), errgo.Is(store.ErrDuplicateGroup))
+	}), errgo.Is(store.ErrDuplicateGroup))
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s *identityStore) UpdateGroup(_ context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params, err := s.newGroupParams(group)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		return errgo.Mask(s.writeGroup(tx, tmplUpdateGroup, params, group), errgo.Is(store.ErrNotFound), errgo.Is(store.ErrDuplicateGroup))
+	}), errgo.Is(store.ErrNotFound), errgo.Is(store.ErrDuplicateGroup))
+}
+
+// writeGroup executes the given insert or update template and then
+// replaces the stored parent groups of the resulting group.
+func (s *identityStore) writeGroup(tx *sql.Tx, tmpl tmplID, params *groupParams, group *store.Group) error {
+	id, err := s.driver.queryID(tx, tmpl, params)
+	if err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return store.GroupNotFoundError(group.ID, group.Name)
+		}
+		if s.driver.isDuplicateFunc(errgo.Cause(err)) {
+			return store.DuplicateGroupError(group.Name)
+		}
+		return errgo.Notef(err, "cannot write group")
+	}
+	group.ID = id
+	if err := s.setGroupGroups(tx, group.ID, group.Groups); err != nil {
+		return errgo.Notef(err, "cannot write group")
+	}
+	return nil
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s *identityStore) RemoveGroup(_ context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params, err := s.newGroupParams(group)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		if _, err := s.driver.queryID(tx, tmplRemoveGroup, params); err != nil {
+			if errgo.Cause(err) == sql.ErrNoRows {
+				return store.GroupNotFoundError(group.ID, group.Name)
+			}
+			return errgo.Notef(err, "cannot remove group")
+		}
+		return nil
+	}), errgo.Is(store.ErrNotFound))
+}
+
+type groupGroupsParams struct {
+	argBuilder
+	ID     string
+	Values []string
+}
+
+func (s *identityStore) getGroupGroups(tx *sql.Tx, id string) ([]string, error) {
+	params := &groupGroupsParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         id,
+	}
+	rows, err := s.driver.query(tx, tmplSelectGroupGroups, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var groups []string
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, errgo.Mask(rows.Err())
+}
+
+func (s *identityStore) setGroupGroups(tx *sql.Tx, id string, groups []string) error {
+	params := &groupGroupsParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         id,
+	}
+	if _, err := s.driver.exec(tx, tmplClearGroupGroups, params); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	params = &groupGroupsParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         id,
+		Values:     groups,
+	}
+	_, err := s.driver.exec(tx, tmplPushGroupGroups, params)
+	return errgo.Mask(err)
+}
+
+func scanGroup(s scanner, group *store.Group) error {
+	var description, owner sql.NullString
+	err := s.Scan(
+		&group.ID,
+		&group.Name,
+		&description,
+		&owner,
+	)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	group.Description = description.String
+	group.Owner = store.ProviderIdentity(owner.String)
+	return nil
+}
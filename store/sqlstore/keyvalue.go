@@ -5,9 +5,11 @@ package sqlstore
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/juju/simplekv"
-	"github.com/juju/simplekv/sqlsimplekv"
+	errgo "gopkg.in/errgo.v1"
 )
 
 // A providerDataStore implements store.ProviderDataStore.
@@ -16,5 +18,163 @@ type providerDataStore struct {
 }
 
 func (s *providerDataStore) KeyValueStore(_ context.Context, idp string) (simplekv.Store, error) {
-	return sqlsimplekv.NewStore(s.b.driver.name, s.b.db, "idpkv_"+idp)
+	return s.b.driver.keyValueStoreFunc(s.b.db, "idpkv_"+idp)
+}
+
+// kvStore implements simplekv.Store using a table accessed through
+// the driver's templates. It is used for databases that are not
+// supported by the sqlsimplekv package.
+type kvStore struct {
+	db        *sql.DB
+	driver    *driver
+	tableName string
+}
+
+type keyValueParams struct {
+	argBuilder
+
+	TableName string
+	Key       string
+	Value     []byte
+	Expire    nullTime
+	Update    bool
+}
+
+// newKVStore returns a new kvStore that uses the given table, creating
+// it if necessary.
+func newKVStore(db *sql.DB, driver *driver, tableName string) (*kvStore, error) {
+	_, err := driver.exec(db, tmplInitKeyValue, &keyValueParams{
+		argBuilder: driver.argBuilderFunc(),
+		TableName:  tableName,
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot initialise table %q", tableName)
+	}
+	return &kvStore{
+		db:        db,
+		driver:    driver,
+		tableName: tableName,
+	}, nil
+}
+
+// Context implements simplekv.Store.Context.
+func (s *kvStore) Context(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+// Get implements simplekv.Store.Get by selecting the blob with the
+// given key from the table.
+func (s *kvStore) Get(_ context.Context, key string) ([]byte, error) {
+	v, err := s.get(s.db, key, false)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(simplekv.ErrNotFound))
+	}
+	return v, nil
+}
+
+// get is like Get except that it operates on a general queryer value.
+// If forUpdate is true, it takes out a lock on the given key so that a
+// subsequent call to set will happen atomically.
+func (s *kvStore) get(q queryer, key string, forUpdate bool) ([]byte, error) {
+	params := &keyValueParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		TableName:  s.tableName,
+		Key:        key,
+	}
+	tmpl := tmplGetKeyValue
+	if forUpdate {
+		tmpl = tmplGetKeyValueForUpdate
+	}
+	row, err := s.driver.queryRow(q, tmpl, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var value []byte
+	if err := row.Scan(&value); err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return nil, simplekv.KeyNotFoundError(key)
+		}
+		return nil, errgo.Mask(err)
+	}
+	return value, nil
+}
+
+// Set implements simplekv.Store.Set by upserting the blob with the
+// given key, value and expire time into the table.
+func (s *kvStore) Set(_ context.Context, key string, value []byte, expire time.Time) error {
+	return s.set(s.db, key, value, expire, false)
+}
+
+// set is like Set except that it operates on a general queryer value.
+// If insertOnly is true, the value will only be set if the key doesn't
+// exist. Any expired values are removed from the table first.
+func (s *kvStore) set(q queryer, key string, value []byte, expire time.Time, insertOnly bool) error {
+	if value == nil {
+		// A nil value would be stored as NULL.
+		value = []byte{}
+	}
+	_, err := s.driver.exec(q, tmplRemoveExpiredKeyValue, &keyValueParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		TableName:  s.tableName,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	_, err = s.driver.exec(q, tmplInsertKeyValue, &keyValueParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		TableName:  s.tableName,
+		Key:        key,
+		Value:      value,
+		Expire:     nullTime{expire, !expire.IsZero()},
+		Update:     !insertOnly,
+	})
+	return errgo.Mask(err, s.driver.isDuplicateFunc)
+}
+
+// Update implements simplekv.Store.Update.
+func (s *kvStore) Update(_ context.Context, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	for {
+		insertOnly := false
+		err := s.withTx(func(tx *sql.Tx) error {
+			v, err := s.get(tx, key, true)
+			if err != nil {
+				if errgo.Cause(err) != simplekv.ErrNotFound {
+					return errgo.Mask(err)
+				}
+				// The key doesn't exist, so we want to fail if
+				// some other process has inserted it concurrently.
+				insertOnly = true
+			} else if v == nil {
+				v = []byte{}
+			}
+			newVal, err := getVal(v)
+			if err != nil {
+				return errgo.Mask(err, errgo.Any)
+			}
+			return errgo.Mask(s.set(tx, key, newVal, expire, insertOnly), s.driver.isDuplicateFunc)
+		})
+		if !insertOnly || !s.driver.isDuplicateFunc(errgo.Cause(err)) {
+			return errgo.Mask(err, errgo.Any)
+		}
+		// The key didn't previously exist (so we couldn't lock it)
+		// but when we tried the insert, it failed with a
+		// duplicate-key error, so try again now that the key is in
+		// place.
+	}
+}
+
+// withTx runs f in a new transaction. any error returned by f will not
+// have it's cause masked.
+func (s *kvStore) withTx(f func(*sql.Tx) error) error {
+	tx, err := s.driver.begin(s.db)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := f(tx); err != nil {
+		if err := tx.Rollback(); err != nil {
+			logger.Errorf("failed to rollback transaction: %s", err)
+		}
+		return errgo.Mask(err, errgo.Any)
+	}
+	return errgo.Mask(tx.Commit())
 }
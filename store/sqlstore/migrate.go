@@ -0,0 +1,141 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// A Migration describes a schema migration, which migrates the database
+// schema from one version to the next.
+type Migration struct {
+	// Version holds the schema version of the database after the
+	// migration has been applied. The first migration creates
+	// version 1.
+	Version int
+
+	// Description holds a short description of the migration.
+	Description string
+
+	// Statements holds the SQL statements executed by the migration.
+	Statements []string
+}
+
+// Migrate migrates the schema of the given database to the latest
+// version and returns the migrations that were applied. The driverName
+// must match the value used to open the database. If dryRun is true then
+// the migrations that would be applied are returned, but the database
+// is not changed.
+//
+// NewBackend migrates the schema automatically, so Migrate only needs to
+// be used to inspect or apply migrations before the database is used.
+func Migrate(driverName string, db *sql.DB, dryRun bool) ([]Migration, error) {
+	driver, err := newDriver(driverName)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	ms, err := driver.migrate(db, dryRun)
+	return ms, errgo.Mask(err)
+}
+
+type schemaVersionParams struct {
+	argBuilder
+	Version     int
+	Description string
+	Applied     time.Time
+}
+
+// migrate applies any migrations that have not yet been applied to the
+// given database and returns them. The version of the schema is recorded
+// in the schema_version table, which holds a row for each migration
+// applied. Databases that were created before the schema was versioned
+// have no schema_version table, so all the migrations will be applied;
+// the first migration for each database is therefore idempotent. If
+// dryRun is true then the migrations are returned without being
+// applied. Note that MySQL commits schema changes immediately, so in
+// that case the schema_version table is created even when dryRun is
+// true.
+func (d *driver) migrate(db *sql.DB, dryRun bool) (_ []Migration, err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer conn.Close()
+	if d.schemaLock != "" {
+		// Prevent other processes from migrating the database
+		// concurrently. The lock is held by the connection, so it
+		// is released when the connection is closed even if
+		// unlocking fails.
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, d.schemaLock).Scan(&locked); err != nil {
+			return nil, errgo.Notef(err, "cannot lock schema")
+		}
+		if !locked.Valid || locked.Int64 != 1 {
+			return nil, errgo.Newf("cannot lock schema: lock not acquired")
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, d.schemaUnlock); err != nil {
+				logger.Errorf("failed to unlock schema: %s", err)
+			}
+		}()
+	}
+	tx, err := conn.BeginTx(ctx, d.txOptions)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer func() {
+		if err == nil && !dryRun {
+			err = errgo.Mask(tx.Commit())
+			return
+		}
+		if err := tx.Rollback(); err != nil {
+			logger.Errorf("failed to rollback transaction: %s", err)
+		}
+	}()
+	if _, err := d.exec(tx, tmplInitSchemaVersion, d.argBuilderFunc()); err != nil {
+		return nil, errgo.Notef(err, "cannot create schema_version table")
+	}
+	row, err := d.queryRow(tx, tmplSchemaVersion, d.argBuilderFunc())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var version int
+	if err := row.Scan(&version); err != nil {
+		return nil, errgo.Notef(err, "cannot get schema version")
+	}
+	latest := d.migrations[len(d.migrations)-1].Version
+	if version > latest {
+		return nil, errgo.Newf("database schema version %d is newer than the latest supported version %d", version, latest)
+	}
+	var applied []Migration
+	for _, m := range d.migrations {
+		if m.Version <= version {
+			continue
+		}
+		applied = append(applied, m)
+		if dryRun {
+			continue
+		}
+		for _, s := range m.Statements {
+			if _, err := tx.Exec(s); err != nil {
+				return nil, errgo.Notef(err, "cannot apply schema migration %d", m.Version)
+			}
+		}
+		_, err := d.exec(tx, tmplInsertSchemaVersion, &schemaVersionParams{
+			argBuilder:  d.argBuilderFunc(),
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     time.Now(),
+		})
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot record schema migration %d", m.Version)
+		}
+	}
+	return applied, nil
+}
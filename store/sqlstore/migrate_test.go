@@ -0,0 +1,170 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/store/sqlstore"
+)
+
+func TestMigrateDryRun(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	db := openMigrateDB(c)
+	ms, err := sqlstore.Migrate("sqlite3", db, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(migrationVersions(ms), qt.DeepEquals, []int{1, 2})
+	for _, m := range ms {
+		c.Assert(m.Description, qt.Not(qt.Equals), "")
+		c.Assert(m.Statements, qt.Not(qt.HasLen), 0)
+	}
+
+	// The dry run must not have changed the database.
+	var n int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table'`).Scan(&n)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 0)
+}
+
+func TestMigrate(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	db := openMigrateDB(c)
+	ms, err := sqlstore.Migrate("sqlite3", db, false)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(migrationVersions(ms), qt.DeepEquals, []int{1, 2})
+
+	rows, err := db.Query(`SELECT version, description FROM schema_version ORDER BY version`)
+	c.Assert(err, qt.Equals, nil)
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var version int
+		var description string
+		c.Assert(rows.Scan(&version, &description), qt.Equals, nil)
+		c.Assert(description, qt.Equals, ms[version-1].Description)
+		versions = append(versions, version)
+	}
+	c.Assert(rows.Err(), qt.Equals, nil)
+	c.Assert(versions, qt.DeepEquals, []int{1, 2})
+
+	ms, err = sqlstore.Migrate("sqlite3", db, false)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 0)
+
+	ms, err = sqlstore.Migrate("sqlite3", db, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 0)
+}
+
+func TestNewBackendMigrates(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newSQLiteFixture(c)
+	db := f.openDB(c)
+	defer db.Close()
+	ms, err := sqlstore.Migrate("sqlite3", db, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 0)
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	// Create a database as it would have been created before the
+	// schema was versioned.
+	db := openMigrateDB(c)
+	_, err := db.Exec(sqlstore.SQLiteInit)
+	c.Assert(err, qt.Equals, nil)
+	_, err = db.Exec(`INSERT INTO identities (providerid, username) VALUES ('test:test-1', 'test-1')`)
+	c.Assert(err, qt.Equals, nil)
+
+	ms, err := sqlstore.Migrate("sqlite3", db, false)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(migrationVersions(ms), qt.DeepEquals, []int{1, 2})
+
+	backend, err := sqlstore.NewBackend("sqlite3", db)
+	c.Assert(err, qt.Equals, nil)
+	defer backend.Close()
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+	}
+	err = backend.Store().Identity(context.Background(), &identity)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(identity.Username, qt.Equals, "test-1")
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	db := openMigrateDB(c)
+	_, err := sqlstore.Migrate("sqlite3", db, false)
+	c.Assert(err, qt.Equals, nil)
+	_, err = db.Exec(`INSERT INTO schema_version (version, description, applied) VALUES (1000, 'from the future', CURRENT_TIMESTAMP)`)
+	c.Assert(err, qt.Equals, nil)
+
+	_, err = sqlstore.Migrate("sqlite3", db, false)
+	c.Assert(err, qt.ErrorMatches, `database schema version 1000 is newer than the latest supported version 2`)
+
+	_, err = sqlstore.NewBackend("sqlite3", db)
+	c.Assert(err, qt.ErrorMatches, `cannot initialise database: database schema version 1000 is newer than the latest supported version 2`)
+}
+
+func TestMigrateSchemaLockNotAcquired(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	db := openMigrateDB(c)
+	_, err := sqlstore.MigrateWithSchemaLock("sqlite3", db, "SELECT 0")
+	c.Assert(err, qt.ErrorMatches, `cannot lock schema: lock not acquired`)
+	_, err = sqlstore.MigrateWithSchemaLock("sqlite3", db, "SELECT NULL")
+	c.Assert(err, qt.ErrorMatches, `cannot lock schema: lock not acquired`)
+
+	// Nothing has been migrated.
+	ms, err := sqlstore.Migrate("sqlite3", db, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(migrationVersions(ms), qt.DeepEquals, []int{1, 2})
+
+	ms, err = sqlstore.MigrateWithSchemaLock("sqlite3", db, "SELECT 1")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(migrationVersions(ms), qt.DeepEquals, []int{1, 2})
+}
+
+func TestMigrateUnsupportedDriver(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	_, err := sqlstore.Migrate("nosuchdriver", nil, true)
+	c.Assert(err, qt.ErrorMatches, `unsupported database driver "nosuchdriver"`)
+}
+
+// openMigrateDB opens a new, empty SQLite database.
+func openMigrateDB(c *qt.C) *sql.DB {
+	db, err := sql.Open("sqlite3", sqlstore.SQLiteDSN(filepath.Join(c.Mkdir(), "candid.db")))
+	c.Assert(err, qt.Equals, nil)
+	c.Defer(func() {
+		db.Close()
+	})
+	return db
+}
+
+func migrationVersions(ms []sqlstore.Migration) []int {
+	var versions []int
+	for _, m := range ms {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
@@ -0,0 +1,457 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// The MySQL statements below are written with ANSI double-quoted
+// identifiers, which are converted to the back-quoted identifiers used
+// by MySQL with mysqlQuote before use, because back quotes cannot
+// appear in Go raw strings. Only the identifiers that are keywords in
+// MySQL ("key", "groups" and "next") and the names of the key value
+// tables are quoted.
+//
+// All tables use a binary collation so that text comparisons are case
+// sensitive, as they are in the other databases.
+//
+// Provider and extra info values can be arbitrarily long (for example
+// WebAuthn credentials), which is too long for an index, so the
+// uniqueness of those values is enforced on a SHA-256 hash of the value
+// instead.
+
+// mysqlInit holds the statements used to initialise a MySQL database
+// before schema versioning was introduced. It is the first migration
+// and is idempotent so that it can be safely applied to databases
+// created by earlier versions. MySQL does not execute multiple
+// statements in a single query by default, so each one is executed
+// separately.
+var mysqlInit = []string{`
+CREATE TABLE IF NOT EXISTS identities (
+	id INTEGER NOT NULL AUTO_INCREMENT,
+	providerid VARCHAR(255) NOT NULL UNIQUE,
+	username VARCHAR(255) NOT NULL UNIQUE,
+	name TEXT,
+	email TEXT,
+	lastlogin DATETIME(6),
+	lastdischarge DATETIME(6),
+	owner VARCHAR(255),
+	suspended BOOLEAN,
+	notbefore DATETIME(6),
+	PRIMARY KEY (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS identity_groups (
+	identity INTEGER NOT NULL,
+	value VARCHAR(255) NOT NULL,
+	UNIQUE (identity, value),
+	FOREIGN KEY (identity) REFERENCES identities (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS identity_publickeys (
+	identity INTEGER NOT NULL,
+	value VARBINARY(255) NOT NULL,
+	UNIQUE (identity, value),
+	FOREIGN KEY (identity) REFERENCES identities (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS identity_providerinfo (
+	identity INTEGER NOT NULL,
+	"key" VARCHAR(255) NOT NULL,
+	value MEDIUMTEXT NOT NULL,
+	value_hash BINARY(32) AS (UNHEX(SHA2(value, 256))) STORED,
+	UNIQUE (identity, "key", value_hash),
+	FOREIGN KEY (identity) REFERENCES identities (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS identity_extrainfo (
+	identity INTEGER NOT NULL,
+	"key" VARCHAR(255) NOT NULL,
+	value MEDIUMTEXT NOT NULL,
+	value_hash BINARY(32) AS (UNHEX(SHA2(value, 256))) STORED,
+	UNIQUE (identity, "key", value_hash),
+	FOREIGN KEY (identity) REFERENCES identities (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS "groups" (
+	id INTEGER NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL UNIQUE,
+	description TEXT,
+	owner VARCHAR(255),
+	PRIMARY KEY (id)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS group_groups (
+	grp INTEGER NOT NULL,
+	value VARCHAR(255) NOT NULL,
+	UNIQUE (grp, value),
+	FOREIGN KEY (grp) REFERENCES "groups" (id) ON DELETE CASCADE
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS provider_data (
+	provider VARCHAR(255) NOT NULL,
+	"key" VARCHAR(255) NOT NULL,
+	value MEDIUMBLOB NOT NULL,
+	expire DATETIME(6),
+	UNIQUE (provider, "key"),
+	INDEX provider_data_expire (expire)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS meetings (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	address TEXT NOT NULL,
+	created DATETIME(6) NOT NULL,
+	INDEX meetings_created (created)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	time DATETIME(6) NOT NULL,
+	type TEXT NOT NULL,
+	username VARCHAR(255) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	idp TEXT NOT NULL,
+	caveat TEXT NOT NULL,
+	detail TEXT NOT NULL,
+	INDEX audit_log_time (time),
+	INDEX audit_log_username (username, time),
+	INDEX audit_log_actor (actor, time)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS webhook_queue (
+	id INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	url TEXT NOT NULL,
+	body MEDIUMBLOB NOT NULL,
+	attempts INTEGER NOT NULL,
+	"next" DATETIME(6) NOT NULL,
+	INDEX webhook_queue_next ("next")
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`, `
+CREATE TABLE IF NOT EXISTS rootkeys (
+	id VARBINARY(255) NOT NULL PRIMARY KEY,
+	rootkey BLOB,
+	created DATETIME(6) NOT NULL,
+	expires DATETIME(6) NOT NULL,
+	INDEX rootkeys_created (created),
+	INDEX rootkeys_expires (expires)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`,
+}
+
+// MySQL does not support "RETURNING" clauses, so statements that need
+// to return the id of an updated row set it with LAST_INSERT_ID(id)
+// (see mysqlQueryID). MySQL also cannot restrict an upsert to conflicts
+// on a single unique column, so tmplUpsertIdentity only updates an
+// existing identity and tmplInsertIdentity is used if there isn't one.
+var mysqlTmpls = [numTmpl]string{
+	tmplIdentityFrom: `
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore
+		FROM identities
+		WHERE {{.Column}}={{.Identity | .Arg}}`,
+	tmplSelectIdentitySet: `
+		SELECT {{if .Key}}"key", {{end}}value FROM {{.Table}}
+		WHERE identity={{.Identity | .Arg}}
+		ORDER BY {{if .Key}}"key", {{end}}value`,
+	tmplFindIdentities: `
+		SELECT {{if .Count}}COUNT(1){{else}}id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore{{end}} FROM identities
+		{{if or .Where .Groups}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{if $w.Pattern}}LOWER({{$w.Column}}){{$w.Comparison}}LOWER({{$w.Value | $.Arg}}){{else}}{{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}{{end}}{{range $i, $g := .Groups}}{{if or (gt $i 0) $.Where}} AND{{end}} id IN (SELECT identity FROM identity_groups WHERE value={{$g | $.Arg}}){{end}}{{end}}
+		{{if .Sort}}ORDER BY {{range $i, $s := .Sort}}{{if gt $i 0}}, {{end}}{{$s}}{{end}}{{end}}
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{else if gt .Skip 0}}LIMIT 18446744073709551615{{end}}
+		{{if gt .Skip 0}}OFFSET {{.Skip}}{{end}}`,
+	tmplUpdateIdentity: `
+		UPDATE identities
+		SET id=LAST_INSERT_ID(id){{range .Updates}}, {{.Column}}={{.Value | $.Arg}}{{end}}
+		WHERE {{.Column}}={{.Identity | .Arg}}`,
+	tmplIdentityID: `
+		SELECT id FROM identities
+		WHERE {{.Column}}={{.Identity | .Arg}}`,
+	tmplUpsertIdentity: `
+		UPDATE identities
+		SET id=LAST_INSERT_ID(id){{range .Updates}}, {{.Column}}={{.Value | $.Arg}}{{end}}
+		WHERE providerid={{.Identity | .Arg}}`,
+	tmplInsertIdentity: `
+		INSERT INTO identities (providerid{{range .Updates}}, {{.Column}}{{end}})
+		VALUES ({{.Identity | .Arg}}{{range .Updates}}, {{.Value | $.Arg}}{{end}})`,
+	tmplClearIdentitySet: `
+		DELETE FROM {{.Table}}
+		WHERE identity={{.ID | .Arg}}{{if .Key}} AND "key"={{.Key | .Arg}}{{end}}`,
+	tmplPushIdentitySet: `
+		INSERT INTO {{.Table}} (identity, {{if .Key}}"key", {{end}}value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{if $.Key}}{{$.Key | $.Arg}}, {{end}}{{$v | $.Arg}}){{end}}
+		ON DUPLICATE KEY UPDATE value=value`,
+	tmplPullIdentitySet: `
+		DELETE FROM {{.Table}}
+		WHERE identity={{.ID | $.Arg}}{{if .Key}} AND "key"={{.Key | $.Arg}}{{end}}
+		AND value IN ({{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}{{$v | $.Arg}}{{end}})`,
+	tmplRemoveIdentity: `
+		DELETE FROM identities
+		WHERE id={{.ID | .Arg}}`,
+	tmplGetProviderData: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND "key"={{.Key | .Arg}} AND (expire IS NULL OR expire > UTC_TIMESTAMP(6))`,
+	tmplGetProviderDataForUpdate: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND "key"={{.Key | .Arg}} AND (expire IS NULL OR expire > UTC_TIMESTAMP(6))
+		FOR UPDATE`,
+	tmplInsertProviderData: `
+		INSERT INTO provider_data (provider, "key", value, expire)
+		VALUES ({{.Provider | .Arg}}, {{.Key | .Arg}}, {{.Value | .Arg}}, {{.Expire | .Arg}})
+		{{if .Update}}ON DUPLICATE KEY UPDATE
+		value={{.Value | .Arg}}, expire={{.Expire | .Arg}}{{end}}`,
+	tmplGetMeeting: `
+		SELECT address, created FROM meetings
+		WHERE id={{.ID | .Arg}}`,
+	tmplPutMeeting: `
+		INSERT INTO meetings (id, address, created)
+		VALUES ({{.ID | .Arg}}, {{.Address | .Arg}}, {{.Time | .Arg}})`,
+	tmplFindMeetings: `
+		SELECT id FROM meetings
+		WHERE created < {{.Time | .Arg}}{{if .Address}} AND address={{.Address | .Arg}}{{end}}`,
+	tmplRemoveMeetings: `
+		DELETE FROM meetings
+		WHERE id IN({{range $i, $id := .IDs}}{{if gt $i 0}}, {{end}}{{$id | $.Arg}}{{end}})`,
+	tmplIdentityCounts: `
+		SELECT SUBSTRING_INDEX(providerid, ':', 1) AS idp, COUNT(1)
+		FROM identities GROUP BY idp`,
+	tmplIdentityGroups: `
+		SELECT DISTINCT value FROM identity_groups
+		ORDER BY value`,
+	tmplGroupFrom: `
+		SELECT id, name, description, owner FROM "groups"
+		WHERE {{.Column}}={{.Group | .Arg}}`,
+	tmplFindGroups: `
+		SELECT id, name, description, owner FROM "groups"
+		ORDER BY name`,
+	tmplInsertGroup: `
+		INSERT INTO "groups" (name, description, owner)
+		VALUES ({{.Name | .Arg}}, {{.Description | .Arg}}, {{.Owner | .Arg}})`,
+	tmplUpdateGroup: `
+		UPDATE "groups"
+		SET id=LAST_INSERT_ID(id), name={{.Name | .Arg}}, description={{.Description | .Arg}}, owner={{.Owner | .Arg}}
+		WHERE {{.Column}}={{.Group | .Arg}}`,
+	tmplRemoveGroup: `
+		DELETE FROM "groups"
+		WHERE {{.Column}}={{.Group | .Arg}}`,
+	tmplSelectGroupGroups: `
+		SELECT value FROM group_groups
+		WHERE grp={{.ID | .Arg}}
+		ORDER BY value`,
+	tmplClearGroupGroups: `
+		DELETE FROM group_groups
+		WHERE grp={{.ID | .Arg}}`,
+	tmplPushGroupGroups: `
+		INSERT INTO group_groups (grp, value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{$v | $.Arg}}){{end}}
+		ON DUPLICATE KEY UPDATE value=value`,
+	tmplInsertAuditEntry: `
+		INSERT INTO audit_log (time, type, username, actor, idp, caveat, detail)
+		VALUES ({{.Time | .Arg}}, {{.Type | .Arg}}, {{.Username | .Arg}}, {{.Actor | .Arg}}, {{.IDP | .Arg}}, {{.Caveat | .Arg}}, {{.Detail | .Arg}})`,
+	tmplFindAuditEntries: `
+		SELECT id, time, type, username, actor, idp, caveat, detail FROM audit_log
+		WHERE TRUE{{if .Username}} AND (username={{.Username | .Arg}} OR actor={{.Username | .Arg}}){{end}}{{if not .Since.IsZero}} AND time >= {{.Since | .Arg}}{{end}}{{if not .Until.IsZero}} AND time < {{.Until | .Arg}}{{end}}
+		ORDER BY time DESC, id DESC
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplAddWebhook: `
+		INSERT INTO webhook_queue (url, body, attempts, "next")
+		VALUES ({{.URL | .Arg}}, {{.Body | .Arg}}, {{.Attempts | .Arg}}, {{.NextAttempt | .Arg}})`,
+	tmplFindDueWebhooks: `
+		SELECT id, url, body, attempts, "next" FROM webhook_queue
+		WHERE "next" <= {{.Now | .Arg}}
+		ORDER BY "next", id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}
+		FOR UPDATE SKIP LOCKED`,
+	tmplClaimWebhooks: `
+		UPDATE webhook_queue SET "next"={{.Until | .Arg}}
+		WHERE id IN ({{range $i, $id := .IDs}}{{if gt $i 0}}, {{end}}{{$id | $.Arg}}{{end}})`,
+	tmplRescheduleWebhook: `
+		UPDATE webhook_queue SET attempts={{.Attempts | .Arg}}, "next"={{.NextAttempt | .Arg}}
+		WHERE id={{.ID | .Arg}}`,
+	tmplRemoveWebhook: `
+		DELETE FROM webhook_queue
+		WHERE id={{.ID | .Arg}}`,
+	tmplInitKeyValue: `
+		CREATE TABLE IF NOT EXISTS "{{.TableName}}" (
+			"key" VARCHAR(255) NOT NULL PRIMARY KEY,
+			value MEDIUMBLOB NOT NULL,
+			expire DATETIME(6),
+			INDEX expire (expire)
+		) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`,
+	tmplGetKeyValue: `
+		SELECT value FROM "{{.TableName}}"
+		WHERE "key"={{.Key | .Arg}} AND (expire IS NULL OR expire > UTC_TIMESTAMP(6))`,
+	tmplGetKeyValueForUpdate: `
+		SELECT value FROM "{{.TableName}}"
+		WHERE "key"={{.Key | .Arg}} AND (expire IS NULL OR expire > UTC_TIMESTAMP(6))
+		FOR UPDATE`,
+	tmplInsertKeyValue: `
+		INSERT INTO "{{.TableName}}" ("key", value, expire)
+		VALUES ({{.Key | .Arg}}, {{.Value | .Arg}}, {{.Expire | .Arg}})
+		{{if .Update}}ON DUPLICATE KEY UPDATE
+		value={{.Value | .Arg}}, expire={{.Expire | .Arg}}{{end}}`,
+	tmplRemoveExpiredKeyValue: `
+		DELETE FROM "{{.TableName}}"
+		WHERE expire < UTC_TIMESTAMP(6)`,
+	tmplGetRootKey: `
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE id={{.ID | .Arg}}`,
+	tmplFindLatestRootKey: `
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE created >= {{.CreatedAfter | .Arg}} AND expires >= {{.ExpiresAfter | .Arg}} AND expires <= {{.ExpiresBefore | .Arg}}
+		ORDER BY created DESC
+		LIMIT 1`,
+	tmplInsertRootKey: `
+		INSERT INTO rootkeys (id, rootkey, created, expires)
+		VALUES ({{.ID | .Arg}}, {{.Key | .Arg}}, {{.Created | .Arg}}, {{.Expires | .Arg}})`,
+	tmplRemoveExpiredRootKeys: `
+		DELETE FROM rootkeys
+		WHERE expires < UTC_TIMESTAMP(6)`,
+	tmplInitSchemaVersion: `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER NOT NULL PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied DATETIME(6) NOT NULL
+		) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`,
+	tmplSchemaVersion: `
+		SELECT COALESCE(MAX(version), 0) FROM schema_version`,
+	tmplInsertSchemaVersion: `
+		INSERT INTO schema_version (version, description, applied)
+		VALUES ({{.Version | .Arg}}, {{.Description | .Arg}}, {{.Applied | .Arg}})`,
+}
+
+// mysqlMigrations holds the migrations for a MySQL database. Note that
+// MySQL implicitly commits the current transaction when the schema is
+// changed, so a migration that fails part way through is not rolled
+// back.
+var mysqlMigrations = []Migration{{
+	Version:     1,
+	Description: "initial schema",
+	Statements:  mysqlInit,
+}, {
+	Version:     2,
+	Description: "index identity groups by value",
+	Statements: []string{
+		`CREATE INDEX identity_groups_value ON identity_groups (value)`,
+	},
+}}
+
+// newMySQLDriver creates a MySQL driver.
+func newMySQLDriver() (*driver, error) {
+	d := &driver{
+		name: "mysql",
+		argBuilderFunc: func() argBuilder {
+			return &mysqlArgBuilder{}
+		},
+		isDuplicateFunc: mysqlIsDuplicate,
+		comparisons:     mysqlComparisons,
+		queryIDFunc:     mysqlQueryID,
+		// InnoDB's default repeatable read isolation locks the gaps
+		// around rows that are not found by "FOR UPDATE" queries,
+		// which causes concurrent inserts of the same key to
+		// deadlock rather than fail with a duplicate key error.
+		txOptions:    &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+		schemaLock:   `SELECT GET_LOCK('candid_schema_migration', 600)`,
+		schemaUnlock: `SELECT RELEASE_LOCK('candid_schema_migration')`,
+	}
+	for _, m := range mysqlMigrations {
+		var statements []string
+		for _, s := range m.Statements {
+			statements = append(statements, mysqlQuote(s))
+		}
+		m.Statements = statements
+		d.migrations = append(d.migrations, m)
+	}
+	d.keyValueStoreFunc = func(db *sql.DB, table string) (simplekv.Store, error) {
+		return newKVStore(db, d, table)
+	}
+	d.rootKeysFunc = func(db *sql.DB) rootKeys {
+		return newSQLRootKeys(db, d)
+	}
+	for i, t := range mysqlTmpls {
+		if err := d.parseTemplate(tmplID(i), mysqlQuote(t)); err != nil {
+			return nil, errgo.Notef(err, "cannot parse template %v", t)
+		}
+	}
+	return d, nil
+}
+
+// mysqlQuote converts the double-quoted identifiers in the given
+// statement to back-quoted identifiers.
+func mysqlQuote(s string) string {
+	return strings.Replace(s, `"`, "`", -1)
+}
+
+// mysqlComparisons holds the SQL operators used for comparisons in
+// MySQL. The pattern comparisons are made case insensitive by
+// converting both sides to lower case in tmplFindIdentities.
+var mysqlComparisons = map[store.Comparison]string{
+	store.Equal:              "=",
+	store.NotEqual:           "<>",
+	store.GreaterThan:        ">",
+	store.LessThan:           "<",
+	store.GreaterThanOrEqual: ">=",
+	store.LessThanOrEqual:    "<=",
+	store.Prefix:             " LIKE ",
+	store.Contains:           " LIKE ",
+}
+
+// mysqlErrDupEntry is the MySQL error number for a duplicate key.
+const mysqlErrDupEntry = 1062
+
+func mysqlIsDuplicate(err error) bool {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrDupEntry {
+		return true
+	}
+	return false
+}
+
+// mysqlQueryID implements driver.queryIDFunc for MySQL. The id of an
+// inserted row is returned by the database automatically, statements
+// that update a row must return its id by setting it to
+// LAST_INSERT_ID(id). The id of a removed row is not available, so an
+// empty id is returned.
+func mysqlQueryID(q queryer, query string, args []interface{}) (string, error) {
+	res, err := q.Exec(query, args...)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if id != 0 {
+		return strconv.FormatInt(id, 10), nil
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return "", nil
+}
+
+// setMySQLOptions sets the options required by the backend in the
+// given MySQL configuration. Times are parsed into time.Time values and
+// are stored in UTC, which is also the time zone used by UTC_TIMESTAMP
+// when checking for expired values.
+func setMySQLOptions(cfg *mysql.Config) {
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+}
+
+// mysqlArgBuilder implements an argBuilder that produces "?"
+// placeholders.
+type mysqlArgBuilder struct {
+	args_ []interface{}
+}
+
+// Arg implements argbuilder.Arg.
+func (b *mysqlArgBuilder) Arg(a interface{}) string {
+	b.args_ = append(b.args_, a)
+	return "?"
+}
+
+// args implements argbuilder.args.
+func (b *mysqlArgBuilder) args() []interface{} {
+	return b.args_
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/go-sql-driver/mysql"
+	aclstore "github.com/juju/aclstore/v2"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/meeting"
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/store/sqlstore"
+	"github.com/CanonicalLtd/candid/store/storetest"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+func TestMySQLKeyValueStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestKeyValueStore(c, func(c *qt.C) store.ProviderDataStore {
+		return newMySQLFixture(c).backend.ProviderDataStore()
+	})
+}
+
+func TestMySQLStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestStore(c, func(c *qt.C) store.Store {
+		return newMySQLFixture(c).backend.Store()
+	})
+}
+
+func TestMySQLMeetingStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestMeetingStore(c, func(c *qt.C) meeting.Store {
+		return newMySQLFixture(c).backend.MeetingStore()
+	}, sqlstore.PutAtTime)
+}
+
+func TestMySQLAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) store.AuditStore {
+		return newMySQLFixture(c).backend.AuditStore()
+	})
+}
+
+func TestMySQLWebhookQueue(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestWebhookQueue(c, func(c *qt.C) webhook.Queue {
+		return newMySQLFixture(c).backend.WebhookQueue()
+	})
+}
+
+func TestMySQLACLStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestACLStore(c, func(c *qt.C) aclstore.ACLStore {
+		return newMySQLFixture(c).backend.ACLStore()
+	})
+}
+
+func TestMySQLRootKeyStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+	ctx := context.Background()
+
+	rks := f.backend.BakeryRootKeyStore()
+	key, id, err := rks.RootKey(ctx)
+	c.Assert(err, qt.Equals, nil)
+
+	// Use a new store so that the key is read from the database
+	// rather than a cache.
+	backend, err := sqlstore.NewBackend("mysql", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	defer backend.Close()
+	key2, err := backend.BakeryRootKeyStore().Get(ctx, id)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(key2, qt.DeepEquals, key)
+
+	_, err = backend.BakeryRootKeyStore().Get(ctx, []byte("no-such-key"))
+	c.Assert(err, qt.ErrorMatches, `not found`)
+}
+
+func TestMySQLUpdateIDNotFound(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+
+	err := f.backend.Store().UpdateIdentity(
+		context.Background(),
+		&store.Identity{
+			ID:   "1000000",
+			Name: "test-user",
+		},
+		store.Update{
+			store.Name: store.Set,
+		},
+	)
+	c.Assert(err, qt.ErrorMatches, `identity "1000000" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func TestMySQLUpsertDuplicateUsername(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+	ctx := context.Background()
+
+	id1 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+		Username:   "test-user",
+	}
+	err := f.backend.Store().UpdateIdentity(ctx, &id1, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// Creating a second identity with the same username must not
+	// update the first one.
+	id2 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-2"),
+		Username:   "test-user",
+		Name:       "Test User",
+	}
+	err = f.backend.Store().UpdateIdentity(ctx, &id2, store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrDuplicateUsername)
+
+	id3 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+	}
+	err = f.backend.Store().Identity(ctx, &id3)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id3.Name, qt.Equals, "")
+}
+
+func TestMySQLLongInfoValues(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+	ctx := context.Background()
+
+	// Values longer than can be indexed directly must be stored
+	// and must still be unique.
+	long1 := strings.Repeat("a", 5000)
+	long2 := strings.Repeat("a", 4999) + "b"
+	id1 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+		Username:   "test-1",
+		ProviderInfo: map[string][]string{
+			"pk1": {long1, long2},
+		},
+		ExtraInfo: map[string][]string{
+			"ek1": {long1},
+		},
+	}
+	err := f.backend.Store().UpdateIdentity(ctx, &id1, store.Update{
+		store.Username:     store.Set,
+		store.ProviderInfo: store.Set,
+		store.ExtraInfo:    store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	err = f.backend.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: id1.ProviderID,
+		ProviderInfo: map[string][]string{
+			"pk1": {long1},
+		},
+	}, store.Update{
+		store.ProviderInfo: store.Push,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	id2 := store.Identity{
+		ProviderID: id1.ProviderID,
+	}
+	err = f.backend.Store().Identity(ctx, &id2)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id2.ProviderInfo, qt.DeepEquals, id1.ProviderInfo)
+	c.Assert(id2.ExtraInfo, qt.DeepEquals, id1.ExtraInfo)
+}
+
+func TestMySQLInitIdempotent(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+
+	id1 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+		Username:   "test-1",
+		Name:       "Test User",
+		Groups:     []string{"g1", "g2"},
+		ProviderInfo: map[string][]string{
+			"pk1": {"pk1v1", "pk1v2"},
+		},
+		ExtraInfo: map[string][]string{
+			"ek1": {"ek1v1", "ek1v2"},
+		},
+	}
+	err := f.backend.Store().UpdateIdentity(
+		context.Background(),
+		&id1,
+		store.Update{
+			store.Username:     store.Set,
+			store.Name:         store.Set,
+			store.Groups:       store.Set,
+			store.ProviderInfo: store.Set,
+			store.ExtraInfo:    store.Set,
+		},
+	)
+	c.Assert(err, qt.Equals, nil)
+	backend, err := sqlstore.NewBackend("mysql", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	defer backend.Close()
+	id2 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+	}
+	err = backend.Store().Identity(context.Background(), &id2)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id2, qt.DeepEquals, id1)
+}
+
+func TestMySQLConfigUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newMySQLFixture(c)
+	storetest.TestUnmarshal(c, `
+storage:
+    type: mysql
+    connection-string: '`+f.dsn+`'
+`)
+}
+
+type mysqlFixture struct {
+	backend store.Backend
+	dsn     string
+}
+
+// newMySQLFixture creates a new database on the MySQL server specified
+// by the MYSQLCONNECTIONSTRING environment variable and returns a
+// fixture that uses it. The test is skipped if the variable is not set.
+func newMySQLFixture(c *qt.C) *mysqlFixture {
+	connStr := os.Getenv("MYSQLCONNECTIONSTRING")
+	if connStr == "" {
+		c.Skip("MYSQLCONNECTIONSTRING not set")
+	}
+	cfg, err := mysql.ParseDSN(connStr)
+	c.Assert(err, qt.Equals, nil)
+	sqlstore.SetMySQLOptions(cfg)
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	c.Assert(err, qt.Equals, nil)
+	c.Defer(func() {
+		db.Close()
+	})
+
+	cfg.DBName = fmt.Sprintf("candid_test_%d", time.Now().UnixNano())
+	_, err = db.Exec("CREATE DATABASE " + cfg.DBName)
+	c.Assert(err, qt.Equals, nil)
+	dbName := cfg.DBName
+	c.Defer(func() {
+		if _, err := db.Exec("DROP DATABASE " + dbName); err != nil {
+			c.Logf("cannot drop database %s: %s", dbName, err)
+		}
+	})
+
+	f := &mysqlFixture{
+		dsn: cfg.FormatDSN(),
+	}
+	backend, err := sqlstore.NewBackend("mysql", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	// Note: closing backend also closes the db.
+	c.Defer(backend.Close)
+	f.backend = backend
+	return f
+}
+
+// openDB opens a new connection to the fixture's database.
+func (f *mysqlFixture) openDB(c *qt.C) *sql.DB {
+	db, err := sql.Open("mysql", f.dsn)
+	c.Assert(err, qt.Equals, nil)
+	return db
+}
@@ -4,10 +4,18 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/juju/simplekv"
+	"github.com/juju/simplekv/sqlsimplekv"
 	"github.com/lib/pq"
 	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/postgresrootkeystore"
+
+	"github.com/CanonicalLtd/candid/store"
 )
 
+// postgresInit holds the schema as it was before schema versioning was
+// introduced. It is the first migration and is idempotent so that it
+// can be safely applied to databases created by earlier versions.
 const postgresInit = `
 CREATE TABLE IF NOT EXISTS identities ( 
 	id SERIAL PRIMARY KEY,
@@ -31,6 +39,26 @@ DO $$
     END;
 $$;
 
+DO $$ 
+    BEGIN
+        BEGIN
+            ALTER TABLE identities ADD COLUMN suspended BOOLEAN;
+        EXCEPTION
+            WHEN duplicate_column THEN RETURN;
+        END;
+    END;
+$$;
+
+DO $$ 
+    BEGIN
+        BEGIN
+            ALTER TABLE identities ADD COLUMN notbefore TIMESTAMP WITH TIME ZONE;
+        EXCEPTION
+            WHEN duplicate_column THEN RETURN;
+        END;
+    END;
+$$;
+
 CREATE TABLE IF NOT EXISTS identity_groups ( 
 	identity INTEGER REFERENCES identities NOT NULL,
 	value TEXT NOT NULL,
@@ -57,6 +85,19 @@ CREATE TABLE IF NOT EXISTS identity_extrainfo (
 	UNIQUE (identity, key, value)
 );
 
+CREATE TABLE IF NOT EXISTS groups ( 
+	id SERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT,
+	owner TEXT
+);
+
+CREATE TABLE IF NOT EXISTS group_groups ( 
+	grp INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (grp, value)
+);
+
 CREATE TABLE IF NOT EXISTS provider_data ( 
 	provider TEXT NOT NULL,
 	key TEXT NOT NULL,
@@ -85,19 +126,56 @@ CREATE TABLE IF NOT EXISTS meetings (
 	address TEXT NOT NULL,
 	created TIMESTAMP WITH TIME ZONE NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS audit_log ( 
+	id SERIAL PRIMARY KEY,
+	time TIMESTAMP WITH TIME ZONE NOT NULL,
+	type TEXT NOT NULL,
+	username TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	idp TEXT NOT NULL,
+	caveat TEXT NOT NULL,
+	detail TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log (time);
+CREATE INDEX IF NOT EXISTS audit_log_username ON audit_log (username, time);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, time);
+
+CREATE TABLE IF NOT EXISTS webhook_queue ( 
+	id SERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	body BYTEA NOT NULL,
+	attempts INTEGER NOT NULL,
+	next TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_queue_next ON webhook_queue (next);
 `
 
+var postgresMigrations = []Migration{{
+	Version:     1,
+	Description: "initial schema",
+	Statements:  []string{postgresInit},
+}, {
+	Version:     2,
+	Description: "index identity groups by value",
+	Statements: []string{
+		`CREATE INDEX identity_groups_value ON identity_groups (value)`,
+	},
+}}
+
 var postgresTmpls = [numTmpl]string{
 	tmplIdentityFrom: `
-		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore
 		FROM identities
 		WHERE {{.Column}}={{.Identity | .Arg}}`,
 	tmplSelectIdentitySet: `
 		SELECT {{if .Key}}key, {{end}}value FROM {{.Table}} 
 		WHERE identity={{.Identity | .Arg}}`,
 	tmplFindIdentities: `
-		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner FROM identities
-		{{if .Where}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}{{end}}
+		SELECT {{if .Count}}COUNT(1){{else}}id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore{{end}} FROM identities
+		{{if or .Where .Groups}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}{{range $i, $g := .Groups}}{{if or (gt $i 0) $.Where}} AND{{end}} id IN (SELECT identity FROM identity_groups WHERE value={{$g | $.Arg}}){{end}}{{end}}
 		{{if .Sort}}ORDER BY {{join .Sort ", "}}{{end}}
 		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}
 		{{if gt .Skip 0}}OFFSET {{.Skip}}{{end}}`,
@@ -116,6 +194,10 @@ var postgresTmpls = [numTmpl]string{
 		SET{{range $i, $u := .Updates}}{{if gt $i 0}}, {{end}} {{$u.Column}}={{$u.Value | $.Arg}}{{end}}
 		WHERE identities.providerid={{.Identity | .Arg}}
 		RETURNING id`,
+	tmplInsertIdentity: `
+		INSERT INTO identities (providerid{{range .Updates}}, {{.Column}}{{end}})
+		VALUES ({{.Identity | .Arg}}{{range .Updates}}, {{.Value | $.Arg}}{{end}})
+		RETURNING id`,
 	tmplClearIdentitySet: `
 		DELETE FROM {{.Table}}
 		WHERE identity={{.ID | .Arg}}{{if .Key}} AND key={{.Key | .Arg}}{{end}}`,
@@ -127,6 +209,9 @@ var postgresTmpls = [numTmpl]string{
 		DELETE FROM {{.Table}}
 		WHERE identity={{.ID | $.Arg}}{{if .Key}} AND key={{.Key | $.Arg}}{{end}}
 		AND value IN ({{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}{{$v | $.Arg}}{{end}})`,
+	tmplRemoveIdentity: `
+		DELETE FROM identities
+		WHERE id={{.ID | .Arg}}`,
 	tmplGetProviderData: `
 		SELECT value FROM provider_data
 		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > now())`,
@@ -154,20 +239,104 @@ var postgresTmpls = [numTmpl]string{
 	tmplIdentityCounts: `
 		SELECT substring(providerid, '^[^:]*') as idp, COUNT(1) 
 		FROM identities GROUP BY idp`,
+	tmplIdentityGroups: `
+		SELECT DISTINCT value FROM identity_groups
+		ORDER BY value`,
+	tmplGroupFrom: `
+		SELECT id, name, description, owner FROM groups
+		WHERE {{.Column}}={{.Group | .Arg}}`,
+	tmplFindGroups: `
+		SELECT id, name, description, owner FROM groups
+		ORDER BY name`,
+	tmplInsertGroup: `
+		INSERT INTO groups (name, description, owner)
+		VALUES ({{.Name | .Arg}}, {{.Description | .Arg}}, {{.Owner | .Arg}})
+		RETURNING id`,
+	tmplUpdateGroup: `
+		UPDATE groups
+		SET name={{.Name | .Arg}}, description={{.Description | .Arg}}, owner={{.Owner | .Arg}}
+		WHERE {{.Column}}={{.Group | .Arg}}
+		RETURNING id`,
+	tmplRemoveGroup: `
+		DELETE FROM groups
+		WHERE {{.Column}}={{.Group | .Arg}}
+		RETURNING id`,
+	tmplSelectGroupGroups: `
+		SELECT value FROM group_groups
+		WHERE grp={{.ID | .Arg}}
+		ORDER BY value`,
+	tmplClearGroupGroups: `
+		DELETE FROM group_groups
+		WHERE grp={{.ID | .Arg}}`,
+	tmplPushGroupGroups: `
+		INSERT INTO group_groups (grp, value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{$v | $.Arg}}){{end}}
+		ON CONFLICT (grp, value) DO NOTHING`,
+	tmplInsertAuditEntry: `
+		INSERT INTO audit_log (time, type, username, actor, idp, caveat, detail)
+		VALUES ({{.Time | .Arg}}, {{.Type | .Arg}}, {{.Username | .Arg}}, {{.Actor | .Arg}}, {{.IDP | .Arg}}, {{.Caveat | .Arg}}, {{.Detail | .Arg}})
+		RETURNING id`,
+	tmplFindAuditEntries: `
+		SELECT id, time, type, username, actor, idp, caveat, detail FROM audit_log
+		WHERE TRUE{{if .Username}} AND (username={{.Username | .Arg}} OR actor={{.Username | .Arg}}){{end}}{{if not .Since.IsZero}} AND time >= {{.Since | .Arg}}{{end}}{{if not .Until.IsZero}} AND time < {{.Until | .Arg}}{{end}}
+		ORDER BY time DESC, id DESC
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplAddWebhook: `
+		INSERT INTO webhook_queue (url, body, attempts, next)
+		VALUES ({{.URL | .Arg}}, {{.Body | .Arg}}, {{.Attempts | .Arg}}, {{.NextAttempt | .Arg}})
+		RETURNING id`,
+	tmplFindDueWebhooks: `
+		SELECT id, url, body, attempts, next FROM webhook_queue
+		WHERE next <= {{.Now | .Arg}}
+		ORDER BY next, id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}
+		FOR UPDATE SKIP LOCKED`,
+	tmplClaimWebhooks: `
+		UPDATE webhook_queue SET next={{.Until | .Arg}}
+		WHERE id IN ({{range $i, $id := .IDs}}{{if gt $i 0}}, {{end}}{{$id | $.Arg}}{{end}})`,
+	tmplRescheduleWebhook: `
+		UPDATE webhook_queue SET attempts={{.Attempts | .Arg}}, next={{.NextAttempt | .Arg}}
+		WHERE id={{.ID | .Arg}}`,
+	tmplRemoveWebhook: `
+		DELETE FROM webhook_queue
+		WHERE id={{.ID | .Arg}}`,
+	tmplInitSchemaVersion: `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+	tmplSchemaVersion: `
+		SELECT COALESCE(MAX(version), 0) FROM schema_version`,
+	tmplInsertSchemaVersion: `
+		INSERT INTO schema_version (version, description, applied)
+		VALUES ({{.Version | .Arg}}, {{.Description | .Arg}}, {{.Applied | .Arg}})`,
 }
 
-// newPostgresDriver creates a postgres driver using the given DB.
-func newPostgresDriver(db *sql.DB) (*driver, error) {
-	_, err := db.Exec(postgresInit)
-	if err != nil {
-		return nil, errgo.Mask(err)
-	}
+// postgresSchemaLockID holds the key of the advisory lock taken while
+// migrating the schema. It is the string "candid" interpreted as a
+// big-endian integer.
+const postgresSchemaLockID = "109270115051876"
+
+// newPostgresDriver creates a postgres driver.
+func newPostgresDriver() (*driver, error) {
 	d := &driver{
 		name: "postgres",
 		argBuilderFunc: func() argBuilder {
 			return &postgresArgBuilder{}
 		},
 		isDuplicateFunc: postgresIsDuplicate,
+		comparisons:     postgresComparisons,
+		queryIDFunc:     returningID,
+		keyValueStoreFunc: func(db *sql.DB, table string) (simplekv.Store, error) {
+			return sqlsimplekv.NewStore("postgres", db, table)
+		},
+		rootKeysFunc: func(db *sql.DB) rootKeys {
+			return postgresRootKeys{postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000)}
+		},
+		migrations:   postgresMigrations,
+		schemaLock:   `SELECT 1 FROM pg_advisory_lock(` + postgresSchemaLockID + `)`,
+		schemaUnlock: `SELECT pg_advisory_unlock(` + postgresSchemaLockID + `)`,
 	}
 	for i, t := range postgresTmpls {
 		if err := d.parseTemplate(tmplID(i), t); err != nil {
@@ -177,6 +346,17 @@ func newPostgresDriver(db *sql.DB) (*driver, error) {
 	return d, nil
 }
 
+var postgresComparisons = map[store.Comparison]string{
+	store.Equal:              "=",
+	store.NotEqual:           "<>",
+	store.GreaterThan:        ">",
+	store.LessThan:           "<",
+	store.GreaterThanOrEqual: ">=",
+	store.LessThanOrEqual:    "<=",
+	store.Prefix:             " ILIKE ",
+	store.Contains:           " ILIKE ",
+}
+
 func postgresIsDuplicate(err error) bool {
 	if pqerr, ok := err.(*pq.Error); ok && pqerr.Code.Name() == "unique_violation" {
 		return true
@@ -14,6 +14,7 @@ import (
 	"github.com/CanonicalLtd/candid/store"
 	"github.com/CanonicalLtd/candid/store/sqlstore"
 	"github.com/CanonicalLtd/candid/store/storetest"
+	"github.com/CanonicalLtd/candid/webhook"
 )
 
 func TestKeyValueStore(t *testing.T) {
@@ -43,6 +44,24 @@ func TestMeetingStore(t *testing.T) {
 	}, sqlstore.PutAtTime)
 }
 
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) store.AuditStore {
+		return newFixture(c).backend.AuditStore()
+	})
+}
+
+func TestWebhookQueue(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestWebhookQueue(c, func(c *qt.C) webhook.Queue {
+		return newFixture(c).backend.WebhookQueue()
+	})
+}
+
 func TestACLStore(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
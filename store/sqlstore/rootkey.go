@@ -0,0 +1,142 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+	"gopkg.in/macaroon-bakery.v2/bakery/postgresrootkeystore"
+)
+
+// rootKeys is the interface implemented by the caches of macaroon root
+// keys used by each database driver.
+type rootKeys interface {
+	// NewStore returns a new bakery.RootKeyStore that uses the given
+	// policy.
+	NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore
+
+	// Close releases any resources used by the cache.
+	Close() error
+}
+
+// postgresRootKeys implements rootKeys using the postgresrootkeystore
+// package.
+type postgresRootKeys struct {
+	*postgresrootkeystore.RootKeys
+}
+
+// NewStore implements rootKeys.NewStore.
+func (rk postgresRootKeys) NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore {
+	return rk.RootKeys.NewStore(postgresrootkeystore.Policy(policy))
+}
+
+// sqlRootKeys implements rootKeys using the rootkeys table accessed
+// through the driver's templates.
+type sqlRootKeys struct {
+	keys    *dbrootkeystore.RootKeys
+	backing rootKeyBacking
+}
+
+func newSQLRootKeys(db *sql.DB, driver *driver) rootKeys {
+	return &sqlRootKeys{
+		keys: dbrootkeystore.NewRootKeys(1000, nil),
+		backing: rootKeyBacking{
+			db:     db,
+			driver: driver,
+		},
+	}
+}
+
+// NewStore implements rootKeys.NewStore.
+func (rk *sqlRootKeys) NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore {
+	return rk.keys.NewStore(rk.backing, policy)
+}
+
+// Close implements rootKeys.Close.
+func (rk *sqlRootKeys) Close() error {
+	return nil
+}
+
+// rootKeyBacking implements dbrootkeystore.Backing.
+type rootKeyBacking struct {
+	db     *sql.DB
+	driver *driver
+}
+
+type rootKeyParams struct {
+	argBuilder
+	ID            []byte
+	Created       time.Time
+	Expires       time.Time
+	Key           []byte
+	CreatedAfter  time.Time
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+}
+
+// GetKey implements dbrootkeystore.Backing.GetKey.
+func (b rootKeyBacking) GetKey(id []byte) (dbrootkeystore.RootKey, error) {
+	params := &rootKeyParams{
+		argBuilder: b.driver.argBuilderFunc(),
+		ID:         id,
+	}
+	row, err := b.driver.queryRow(b.db, tmplGetRootKey, params)
+	if err != nil {
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	var key dbrootkeystore.RootKey
+	if err := row.Scan(&key.Id, &key.Created, &key.Expires, &key.RootKey); err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return dbrootkeystore.RootKey{}, bakery.ErrNotFound
+		}
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	return key, nil
+}
+
+// FindLatestKey implements dbrootkeystore.Backing.FindLatestKey.
+func (b rootKeyBacking) FindLatestKey(createdAfter, expiresAfter, expiresBefore time.Time) (dbrootkeystore.RootKey, error) {
+	params := &rootKeyParams{
+		argBuilder:    b.driver.argBuilderFunc(),
+		CreatedAfter:  createdAfter,
+		ExpiresAfter:  expiresAfter,
+		ExpiresBefore: expiresBefore,
+	}
+	row, err := b.driver.queryRow(b.db, tmplFindLatestRootKey, params)
+	if err != nil {
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	var key dbrootkeystore.RootKey
+	if err := row.Scan(&key.Id, &key.Created, &key.Expires, &key.RootKey); err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return dbrootkeystore.RootKey{}, nil
+		}
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	return key, nil
+}
+
+// InsertKey implements dbrootkeystore.Backing.InsertKey. Any expired
+// keys are removed first.
+func (b rootKeyBacking) InsertKey(key dbrootkeystore.RootKey) error {
+	_, err := b.driver.exec(b.db, tmplRemoveExpiredRootKeys, &rootKeyParams{
+		argBuilder: b.driver.argBuilderFunc(),
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	params := &rootKeyParams{
+		argBuilder: b.driver.argBuilderFunc(),
+		ID:         key.Id,
+		Created:    key.Created,
+		Expires:    key.Expires,
+		Key:        key.RootKey,
+	}
+	_, err = b.driver.exec(b.db, tmplInsertRootKey, params)
+	return errgo.Mask(err)
+}
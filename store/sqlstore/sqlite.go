@@ -0,0 +1,410 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/juju/simplekv"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// sqliteInit holds the schema as it was before schema versioning was
+// introduced. It is the first migration and is idempotent so that it
+// can be safely applied to databases created by earlier versions.
+const sqliteInit = `
+CREATE TABLE IF NOT EXISTS identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	providerid TEXT UNIQUE NOT NULL,
+	username TEXT UNIQUE NOT NULL,
+	name TEXT,
+	email TEXT,
+	lastlogin TIMESTAMP,
+	lastdischarge TIMESTAMP,
+	owner TEXT,
+	suspended BOOLEAN,
+	notbefore TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS identity_groups (
+	identity INTEGER REFERENCES identities NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_publickeys (
+	identity INTEGER REFERENCES identities NOT NULL,
+	value BLOB NOT NULL,
+	UNIQUE (identity, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_providerinfo (
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_extrainfo (
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT,
+	owner TEXT
+);
+
+CREATE TABLE IF NOT EXISTS group_groups (
+	grp INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (grp, value)
+);
+
+CREATE TABLE IF NOT EXISTS provider_data (
+	provider TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value BLOB NOT NULL,
+	expire TIMESTAMP,
+	UNIQUE (provider, key)
+);
+
+CREATE INDEX IF NOT EXISTS provider_data_expire ON provider_data (expire);
+CREATE TRIGGER IF NOT EXISTS provider_data_expire_tr
+	BEFORE INSERT ON provider_data
+	BEGIN
+		DELETE FROM provider_data WHERE expire < strftime('%Y-%m-%d %H:%M:%f', 'now');
+	END;
+
+CREATE TABLE IF NOT EXISTS meetings (
+	id TEXT NOT NULL PRIMARY KEY,
+	address TEXT NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TIMESTAMP NOT NULL,
+	type TEXT NOT NULL,
+	username TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	idp TEXT NOT NULL,
+	caveat TEXT NOT NULL,
+	detail TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log (time);
+CREATE INDEX IF NOT EXISTS audit_log_username ON audit_log (username, time);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, time);
+
+CREATE TABLE IF NOT EXISTS webhook_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	body BLOB NOT NULL,
+	attempts INTEGER NOT NULL,
+	next TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_queue_next ON webhook_queue (next);
+
+CREATE TABLE IF NOT EXISTS rootkeys (
+	id BLOB PRIMARY KEY NOT NULL,
+	rootkey BLOB,
+	created TIMESTAMP NOT NULL,
+	expires TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rootkeys_created ON rootkeys (created);
+CREATE INDEX IF NOT EXISTS rootkeys_expires ON rootkeys (expires);
+`
+
+// SQLite has no row locking, instead the database is locked by each
+// writing transaction. The "FOR UPDATE" queries therefore rely on the
+// database being opened with immediate transactions (see
+// SQLiteParams.NewBackend), which take the write lock when the
+// transaction starts.
+var sqliteMigrations = []Migration{{
+	Version:     1,
+	Description: "initial schema",
+	Statements:  []string{sqliteInit},
+}, {
+	Version:     2,
+	Description: "index identity groups by value",
+	Statements: []string{
+		`CREATE INDEX identity_groups_value ON identity_groups (value)`,
+	},
+}}
+
+var sqliteTmpls = [numTmpl]string{
+	tmplIdentityFrom: `
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore
+		FROM identities
+		WHERE {{.Column}}={{.Identity | .Arg}}`,
+	tmplSelectIdentitySet: `
+		SELECT {{if .Key}}key, {{end}}value FROM {{.Table}}
+		WHERE identity={{.Identity | .Arg}}`,
+	tmplFindIdentities: `
+		SELECT {{if .Count}}COUNT(1){{else}}id, providerid, username, name, email, lastlogin, lastdischarge, owner, suspended, notbefore{{end}} FROM identities
+		{{if or .Where .Groups}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{if $w.Pattern}} ESCAPE '\'{{end}}{{end}}{{range $i, $g := .Groups}}{{if or (gt $i 0) $.Where}} AND{{end}} id IN (SELECT identity FROM identity_groups WHERE value={{$g | $.Arg}}){{end}}{{end}}
+		{{if .Sort}}ORDER BY {{join .Sort ", "}}{{end}}
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{else if gt .Skip 0}}LIMIT -1{{end}}
+		{{if gt .Skip 0}}OFFSET {{.Skip}}{{end}}`,
+	tmplUpdateIdentity: `
+		UPDATE identities
+		SET {{range $i, $u := .Updates}}{{if gt $i 0}}, {{end}} {{$u.Column}}={{$u.Value | $.Arg}}{{end}}
+		WHERE {{.Column}}={{.Identity | .Arg}}
+		RETURNING id`,
+	tmplIdentityID: `
+		SELECT id FROM identities
+		WHERE {{.Column}}={{.Identity | .Arg}}`,
+	tmplUpsertIdentity: `
+		INSERT INTO identities (providerid{{range .Updates}}, {{.Column}}{{end}})
+		VALUES ({{.Identity | .Arg}}{{range .Updates}}, {{.Value | $.Arg}}{{end}})
+		ON CONFLICT (providerid) DO UPDATE
+		SET{{range $i, $u := .Updates}}{{if gt $i 0}}, {{end}} {{$u.Column}}={{$u.Value | $.Arg}}{{end}}
+		WHERE identities.providerid={{.Identity | .Arg}}
+		RETURNING id`,
+	tmplInsertIdentity: `
+		INSERT INTO identities (providerid{{range .Updates}}, {{.Column}}{{end}})
+		VALUES ({{.Identity | .Arg}}{{range .Updates}}, {{.Value | $.Arg}}{{end}})
+		RETURNING id`,
+	tmplClearIdentitySet: `
+		DELETE FROM {{.Table}}
+		WHERE identity={{.ID | .Arg}}{{if .Key}} AND key={{.Key | .Arg}}{{end}}`,
+	tmplPushIdentitySet: `
+		INSERT INTO {{.Table}} (identity, {{if .Key}}key, {{end}}value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{if $.Key}}{{$.Key | $.Arg}}, {{end}}{{$v | $.Arg}}){{end}}
+		ON CONFLICT (identity, {{if .Key}}key, {{end}}value) DO NOTHING`,
+	tmplPullIdentitySet: `
+		DELETE FROM {{.Table}}
+		WHERE identity={{.ID | $.Arg}}{{if .Key}} AND key={{.Key | $.Arg}}{{end}}
+		AND value IN ({{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}{{$v | $.Arg}}{{end}})`,
+	tmplRemoveIdentity: `
+		DELETE FROM identities
+		WHERE id={{.ID | .Arg}}`,
+	tmplGetProviderData: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > strftime('%Y-%m-%d %H:%M:%f', 'now'))`,
+	tmplGetProviderDataForUpdate: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > strftime('%Y-%m-%d %H:%M:%f', 'now'))`,
+	tmplInsertProviderData: `
+		INSERT INTO provider_data (provider, key, value, expire)
+		VALUES ({{.Provider | .Arg}}, {{.Key | .Arg}}, {{.Value | .Arg}}, {{.Expire | .Arg}})
+		{{if .Update}}ON CONFLICT (provider, key) DO UPDATE
+		SET value={{.Value | .Arg}}, expire={{.Expire | .Arg}}{{end}}`,
+	tmplGetMeeting: `
+		SELECT address, created FROM meetings
+		WHERE id={{.ID | .Arg}}`,
+	tmplPutMeeting: `
+		INSERT INTO meetings (id, address, created)
+		VALUES ({{.ID | .Arg}}, {{.Address | .Arg}}, {{.Time | .Arg}})`,
+	tmplFindMeetings: `
+		SELECT id FROM meetings
+		WHERE created < {{.Time | .Arg}}{{if .Address}} AND address={{.Address | .Arg}}{{end}}`,
+	tmplRemoveMeetings: `
+		DELETE FROM meetings
+		WHERE id IN({{range $i, $id := .IDs}}{{if gt $i 0}}, {{end}}{{$id | $.Arg}}{{end}})`,
+	tmplIdentityCounts: `
+		SELECT CASE WHEN instr(providerid, ':') > 0 THEN substr(providerid, 1, instr(providerid, ':') - 1) ELSE providerid END AS idp, COUNT(1)
+		FROM identities GROUP BY idp`,
+	tmplIdentityGroups: `
+		SELECT DISTINCT value FROM identity_groups
+		ORDER BY value`,
+	tmplGroupFrom: `
+		SELECT id, name, description, owner FROM groups
+		WHERE {{.Column}}={{.Group | .Arg}}`,
+	tmplFindGroups: `
+		SELECT id, name, description, owner FROM groups
+		ORDER BY name`,
+	tmplInsertGroup: `
+		INSERT INTO groups (name, description, owner)
+		VALUES ({{.Name | .Arg}}, {{.Description | .Arg}}, {{.Owner | .Arg}})
+		RETURNING id`,
+	tmplUpdateGroup: `
+		UPDATE groups
+		SET name={{.Name | .Arg}}, description={{.Description | .Arg}}, owner={{.Owner | .Arg}}
+		WHERE {{.Column}}={{.Group | .Arg}}
+		RETURNING id`,
+	tmplRemoveGroup: `
+		DELETE FROM groups
+		WHERE {{.Column}}={{.Group | .Arg}}
+		RETURNING id`,
+	tmplSelectGroupGroups: `
+		SELECT value FROM group_groups
+		WHERE grp={{.ID | .Arg}}
+		ORDER BY value`,
+	tmplClearGroupGroups: `
+		DELETE FROM group_groups
+		WHERE grp={{.ID | .Arg}}`,
+	tmplPushGroupGroups: `
+		INSERT INTO group_groups (grp, value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{$v | $.Arg}}){{end}}
+		ON CONFLICT (grp, value) DO NOTHING`,
+	tmplInsertAuditEntry: `
+		INSERT INTO audit_log (time, type, username, actor, idp, caveat, detail)
+		VALUES ({{.Time | .Arg}}, {{.Type | .Arg}}, {{.Username | .Arg}}, {{.Actor | .Arg}}, {{.IDP | .Arg}}, {{.Caveat | .Arg}}, {{.Detail | .Arg}})
+		RETURNING id`,
+	tmplFindAuditEntries: `
+		SELECT id, time, type, username, actor, idp, caveat, detail FROM audit_log
+		WHERE TRUE{{if .Username}} AND (username={{.Username | .Arg}} OR actor={{.Username | .Arg}}){{end}}{{if not .Since.IsZero}} AND time >= {{.Since | .Arg}}{{end}}{{if not .Until.IsZero}} AND time < {{.Until | .Arg}}{{end}}
+		ORDER BY time DESC, id DESC
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplAddWebhook: `
+		INSERT INTO webhook_queue (url, body, attempts, next)
+		VALUES ({{.URL | .Arg}}, {{.Body | .Arg}}, {{.Attempts | .Arg}}, {{.NextAttempt | .Arg}})
+		RETURNING id`,
+	tmplFindDueWebhooks: `
+		SELECT id, url, body, attempts, next FROM webhook_queue
+		WHERE next <= {{.Now | .Arg}}
+		ORDER BY next, id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplClaimWebhooks: `
+		UPDATE webhook_queue SET next={{.Until | .Arg}}
+		WHERE id IN ({{range $i, $id := .IDs}}{{if gt $i 0}}, {{end}}{{$id | $.Arg}}{{end}})`,
+	tmplRescheduleWebhook: `
+		UPDATE webhook_queue SET attempts={{.Attempts | .Arg}}, next={{.NextAttempt | .Arg}}
+		WHERE id={{.ID | .Arg}}`,
+	tmplRemoveWebhook: `
+		DELETE FROM webhook_queue
+		WHERE id={{.ID | .Arg}}`,
+	tmplInitKeyValue: `
+		CREATE TABLE IF NOT EXISTS {{.TableName}} (
+			key TEXT NOT NULL,
+			value BLOB NOT NULL,
+			expire TIMESTAMP,
+			UNIQUE (key)
+		);
+		CREATE INDEX IF NOT EXISTS {{.TableName}}_expire ON {{.TableName}} (expire);`,
+	tmplGetKeyValue: `
+		SELECT value FROM {{.TableName}}
+		WHERE key={{.Key | .Arg}} AND (expire IS NULL OR expire > strftime('%Y-%m-%d %H:%M:%f', 'now'))`,
+	tmplGetKeyValueForUpdate: `
+		SELECT value FROM {{.TableName}}
+		WHERE key={{.Key | .Arg}} AND (expire IS NULL OR expire > strftime('%Y-%m-%d %H:%M:%f', 'now'))`,
+	tmplInsertKeyValue: `
+		INSERT INTO {{.TableName}} (key, value, expire)
+		VALUES ({{.Key | .Arg}}, {{.Value | .Arg}}, {{.Expire | .Arg}})
+		{{if .Update}}ON CONFLICT (key) DO UPDATE
+		SET value={{.Value | .Arg}}, expire={{.Expire | .Arg}}{{end}}`,
+	tmplRemoveExpiredKeyValue: `
+		DELETE FROM {{.TableName}}
+		WHERE expire < strftime('%Y-%m-%d %H:%M:%f', 'now')`,
+	tmplGetRootKey: `
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE id={{.ID | .Arg}}`,
+	tmplFindLatestRootKey: `
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE created >= {{.CreatedAfter | .Arg}} AND expires >= {{.ExpiresAfter | .Arg}} AND expires <= {{.ExpiresBefore | .Arg}}
+		ORDER BY created DESC
+		LIMIT 1`,
+	tmplInsertRootKey: `
+		INSERT INTO rootkeys (id, rootkey, created, expires)
+		VALUES ({{.ID | .Arg}}, {{.Key | .Arg}}, {{.Created | .Arg}}, {{.Expires | .Arg}})`,
+	tmplRemoveExpiredRootKeys: `
+		DELETE FROM rootkeys
+		WHERE expires < strftime('%Y-%m-%d %H:%M:%f', 'now')`,
+	tmplInitSchemaVersion: `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied TIMESTAMP NOT NULL
+		)`,
+	tmplSchemaVersion: `
+		SELECT COALESCE(MAX(version), 0) FROM schema_version`,
+	tmplInsertSchemaVersion: `
+		INSERT INTO schema_version (version, description, applied)
+		VALUES ({{.Version | .Arg}}, {{.Description | .Arg}}, {{.Applied | .Arg}})`,
+}
+
+// newSQLiteDriver creates a SQLite driver.
+func newSQLiteDriver() (*driver, error) {
+	d := &driver{
+		name: "sqlite3",
+		argBuilderFunc: func() argBuilder {
+			return &sqliteArgBuilder{}
+		},
+		isDuplicateFunc: sqliteIsDuplicate,
+		comparisons:     sqliteComparisons,
+		queryIDFunc:     returningID,
+		migrations:      sqliteMigrations,
+	}
+	d.keyValueStoreFunc = func(db *sql.DB, table string) (simplekv.Store, error) {
+		return newKVStore(db, d, table)
+	}
+	d.rootKeysFunc = func(db *sql.DB) rootKeys {
+		return newSQLRootKeys(db, d)
+	}
+	for i, t := range sqliteTmpls {
+		if err := d.parseTemplate(tmplID(i), t); err != nil {
+			return nil, errgo.Notef(err, "cannot parse template %v", t)
+		}
+	}
+	return d, nil
+}
+
+// sqliteComparisons holds the SQL operators used for comparisons in
+// SQLite. Note that LIKE in SQLite only ignores the case of ASCII
+// characters.
+var sqliteComparisons = map[store.Comparison]string{
+	store.Equal:              "=",
+	store.NotEqual:           "<>",
+	store.GreaterThan:        ">",
+	store.LessThan:           "<",
+	store.GreaterThanOrEqual: ">=",
+	store.LessThanOrEqual:    "<=",
+	store.Prefix:             " LIKE ",
+	store.Contains:           " LIKE ",
+}
+
+func sqliteIsDuplicate(err error) bool {
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return true
+		}
+	}
+	return false
+}
+
+// sqliteTimeFormat holds the format used to store times in SQLite.
+// SQLite has no time type, so times are stored as text and compared as
+// strings. All times are therefore stored in UTC with a fixed number
+// of digits.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// sqliteArgBuilder implements an argBuilder that produces "?"
+// placeholders.
+type sqliteArgBuilder struct {
+	args_ []interface{}
+}
+
+// Arg implements argbuilder.Arg.
+func (b *sqliteArgBuilder) Arg(a interface{}) string {
+	switch v := a.(type) {
+	case time.Time:
+		a = v.UTC().Format(sqliteTimeFormat)
+	case nullTime:
+		if v.Valid {
+			a = v.Time.UTC().Format(sqliteTimeFormat)
+		} else {
+			a = null{}
+		}
+	}
+	b.args_ = append(b.args_, a)
+	return "?"
+}
+
+// args implements argbuilder.args.
+func (b *sqliteArgBuilder) args() []interface{} {
+	return b.args_
+}
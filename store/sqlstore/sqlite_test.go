@@ -0,0 +1,195 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	aclstore "github.com/juju/aclstore/v2"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/meeting"
+	"github.com/CanonicalLtd/candid/store"
+	"github.com/CanonicalLtd/candid/store/sqlstore"
+	"github.com/CanonicalLtd/candid/store/storetest"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+func TestSQLiteKeyValueStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestKeyValueStore(c, func(c *qt.C) store.ProviderDataStore {
+		return newSQLiteFixture(c).backend.ProviderDataStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestStore(c, func(c *qt.C) store.Store {
+		return newSQLiteFixture(c).backend.Store()
+	})
+}
+
+func TestSQLiteMeetingStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestMeetingStore(c, func(c *qt.C) meeting.Store {
+		return newSQLiteFixture(c).backend.MeetingStore()
+	}, sqlstore.PutAtTime)
+}
+
+func TestSQLiteAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) store.AuditStore {
+		return newSQLiteFixture(c).backend.AuditStore()
+	})
+}
+
+func TestSQLiteWebhookQueue(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestWebhookQueue(c, func(c *qt.C) webhook.Queue {
+		return newSQLiteFixture(c).backend.WebhookQueue()
+	})
+}
+
+func TestSQLiteACLStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestACLStore(c, func(c *qt.C) aclstore.ACLStore {
+		return newSQLiteFixture(c).backend.ACLStore()
+	})
+}
+
+func TestSQLiteRootKeyStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newSQLiteFixture(c)
+	ctx := context.Background()
+
+	rks := f.backend.BakeryRootKeyStore()
+	key, id, err := rks.RootKey(ctx)
+	c.Assert(err, qt.Equals, nil)
+
+	// Use a new store so that the key is read from the database
+	// rather than a cache.
+	backend, err := sqlstore.NewBackend("sqlite3", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	defer backend.Close()
+	key2, err := backend.BakeryRootKeyStore().Get(ctx, id)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(key2, qt.DeepEquals, key)
+
+	_, err = backend.BakeryRootKeyStore().Get(ctx, []byte("no-such-key"))
+	c.Assert(err, qt.ErrorMatches, `not found`)
+}
+
+func TestSQLiteUpdateIDNotFound(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newSQLiteFixture(c)
+
+	err := f.backend.Store().UpdateIdentity(
+		context.Background(),
+		&store.Identity{
+			ID:   "1000000",
+			Name: "test-user",
+		},
+		store.Update{
+			store.Name: store.Set,
+		},
+	)
+	c.Assert(err, qt.ErrorMatches, `identity "1000000" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func TestSQLiteInitIdempotent(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	f := newSQLiteFixture(c)
+
+	id1 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+		Username:   "test-1",
+		Name:       "Test User",
+		Groups:     []string{"g1", "g2"},
+		ProviderInfo: map[string][]string{
+			"pk1": {"pk1v1", "pk1v2"},
+		},
+		ExtraInfo: map[string][]string{
+			"ek1": {"ek1v1", "ek1v2"},
+		},
+	}
+	err := f.backend.Store().UpdateIdentity(
+		context.Background(),
+		&id1,
+		store.Update{
+			store.Username:     store.Set,
+			store.Name:         store.Set,
+			store.Groups:       store.Set,
+			store.ProviderInfo: store.Set,
+			store.ExtraInfo:    store.Set,
+		},
+	)
+	c.Assert(err, qt.Equals, nil)
+	backend, err := sqlstore.NewBackend("sqlite3", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	defer backend.Close()
+	id2 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+	}
+	err = backend.Store().Identity(context.Background(), &id2)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id2, qt.DeepEquals, id1)
+}
+
+func TestSQLiteConfigUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestUnmarshal(c, `
+storage:
+    type: sqlite
+    path: '`+filepath.Join(c.Mkdir(), "candid.db")+`'
+`)
+}
+
+type sqliteFixture struct {
+	backend store.Backend
+	path    string
+}
+
+func newSQLiteFixture(c *qt.C) *sqliteFixture {
+	f := &sqliteFixture{
+		path: filepath.Join(c.Mkdir(), "candid.db"),
+	}
+	backend, err := sqlstore.NewBackend("sqlite3", f.openDB(c))
+	c.Assert(err, qt.Equals, nil)
+	// Note: closing backend also closes the db.
+	c.Defer(backend.Close)
+	f.backend = backend
+	return f
+}
+
+// openDB opens a new connection to the fixture's database.
+func (f *sqliteFixture) openDB(c *qt.C) *sql.DB {
+	db, err := sql.Open("sqlite3", sqlstore.SQLiteDSN(f.path))
+	c.Assert(err, qt.Equals, nil)
+	return db
+}
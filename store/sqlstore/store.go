@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	sqldriver "database/sql/driver"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/juju/loggo"
@@ -24,6 +25,8 @@ var identityColumns = [store.NumFields]string{
 	store.LastLogin:     "lastlogin",
 	store.LastDischarge: "lastdischarge",
 	store.Owner:         "owner",
+	store.Suspended:     "suspended",
+	store.NotBefore:     "notbefore",
 }
 
 type identityStore struct {
@@ -101,26 +104,89 @@ type where struct {
 	Column     string
 	Comparison string
 	Value      interface{}
+
+	// Pattern holds whether Value is a LIKE pattern escaped with
+	// escapeLike.
+	Pattern bool
 }
 
 type findIdentitiesParams struct {
 	argBuilder
-	Where []where
-	Sort  []string
-	Limit int
-	Skip  int
+	Where  []where
+	Groups []string
+	Sort   []string
+	Limit  int
+	Skip   int
+
+	// Count holds whether the query should return the number of
+	// matching identities rather than the identities themselves.
+	Count bool
 }
 
 func (s *identityStore) findIdentities(tx *sql.Tx, ref *store.Identity, filter store.Filter, sort []store.Sort, skip, limit int) ([]store.Identity, error) {
+	rows, err := s.driver.query(tx, tmplFindIdentities, s.findIdentitiesParams(ref, filter, sort, skip, limit))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var identities []store.Identity
+	for rows.Next() {
+		var identity store.Identity
+		if err := scanIdentity(rows, &identity); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for i := range identities {
+		if err := s.completeIdentity(tx, &identities[i]); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return identities, nil
+}
+
+// CountIdentities implements store.CountIdentities.
+func (s *identityStore) CountIdentities(ctx context.Context, ref *store.Identity, filter store.Filter) (int, error) {
+	params := s.findIdentitiesParams(ref, filter, nil, 0, 0)
+	params.Count = true
+	row, err := s.driver.queryRow(s.db, tmplFindIdentities, params)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot count identities")
+	}
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return 0, errgo.Notef(err, "cannot count identities")
+	}
+	return n, nil
+}
+
+// findIdentitiesParams returns the parameters for a tmplFindIdentities
+// query with the given arguments.
+func (s *identityStore) findIdentitiesParams(ref *store.Identity, filter store.Filter, sort []store.Sort, skip, limit int) *findIdentitiesParams {
 	var wheres []where
 	for f, op := range filter {
 		col := identityColumns[f]
-		cond := comparisons[op]
+		cond := s.driver.comparisons[op]
 		if col == "" || cond == "" {
 			continue
 		}
-
-		wheres = append(wheres, where{col, cond, fieldValue(store.Field(f), ref)})
+		w := where{
+			Column:     col,
+			Comparison: cond,
+			Value:      fieldValue(store.Field(f), ref),
+		}
+		switch op {
+		case store.Prefix:
+			w.Value = escapeLike(stringValue(w.Value)) + "%"
+			w.Pattern = true
+		case store.Contains:
+			w.Value = "%" + escapeLike(stringValue(w.Value)) + "%"
+			w.Pattern = true
+		}
+		wheres = append(wheres, w)
 	}
 
 	sorts := make([]string, 0, len(sort))
@@ -142,28 +208,10 @@ func (s *identityStore) findIdentities(tx *sql.Tx, ref *store.Identity, filter s
 		Limit:      limit,
 		Skip:       skip,
 	}
-	rows, err := s.driver.query(tx, tmplFindIdentities, params)
-	if err != nil {
-		return nil, errgo.Mask(err)
+	if filter[store.Groups] == store.Equal {
+		params.Groups = ref.Groups
 	}
-	defer rows.Close()
-	var identities []store.Identity
-	for rows.Next() {
-		var identity store.Identity
-		if err := scanIdentity(rows, &identity); err != nil {
-			return nil, errgo.Mask(err)
-		}
-		identities = append(identities, identity)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, errgo.Mask(err)
-	}
-	for i := range identities {
-		if err := s.completeIdentity(tx, &identities[i]); err != nil {
-			return nil, errgo.Mask(err)
-		}
-	}
-	return identities, nil
+	return params
 }
 
 func fieldValue(f store.Field, id *store.Identity) interface{} {
@@ -182,10 +230,37 @@ func fieldValue(f store.Field, id *store.Identity) interface{} {
 		return nullTime{id.LastDischarge, !id.LastDischarge.IsZero()}
 	case store.Owner:
 		return sql.NullString{string(id.Owner), id.Owner != ""}
+	case store.Suspended:
+		return sql.NullBool{Bool: id.Suspended, Valid: id.Suspended}
+	case store.NotBefore:
+		return nullTime{id.NotBefore, !id.NotBefore.IsZero()}
 	}
 	return nil
 }
 
+// stringValue returns the string held in the given field value.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case store.ProviderIdentity:
+		return string(v)
+	case sql.NullString:
+		return v.String
+	}
+	panic(errgo.Newf("unsupported value %T in pattern comparison", v))
+}
+
+// likeEscaper escapes the characters that have a special meaning in a
+// LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike returns s escaped so that it matches literally in a LIKE
+// pattern using the default escape character.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 func (s *identityStore) completeIdentity(tx *sql.Tx, identity *store.Identity) error {
 	var err error
 	identity.Groups, err = s.getGroups(tx, identity.ID)
@@ -366,19 +441,17 @@ func (s *identityStore) updateIdentity(tx *sql.Tx, identity *store.Identity, upd
 	if len(params.Updates) == 0 {
 		tmpl = tmplIdentityID
 	}
-	row, err := s.driver.queryRow(tx, tmpl, params)
+	id, err := s.writeIdentity(tx, tmpl, &params)
 	if err != nil {
-		return errgo.Notef(err, "cannot update identity")
-	}
-	if err := row.Scan(&identity.ID); err != nil {
 		if errgo.Cause(err) == sql.ErrNoRows {
 			return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
 		}
-		if s.driver.isDuplicateFunc(err) {
+		if s.driver.isDuplicateFunc(errgo.Cause(err)) {
 			return store.DuplicateUsernameError(identity.Username)
 		}
 		return errgo.Notef(err, "cannot update identity")
 	}
+	identity.ID = id
 
 	if err := s.updateGroups(tx, identity.ID, upd[store.Groups], identity.Groups); err != nil {
 		return errgo.Notef(err, "cannot update identity")
@@ -400,6 +473,29 @@ func (s *identityStore) updateIdentity(tx *sql.Tx, identity *store.Identity, upd
 	return nil
 }
 
+// writeIdentity executes the given identity template and returns the id
+// of the identity. If the identity does not exist then an error with a
+// cause of sql.ErrNoRows is returned.
+func (s *identityStore) writeIdentity(tx *sql.Tx, tmpl tmplID, params *updateIdentityParams) (string, error) {
+	if tmpl == tmplIdentityID {
+		row, err := s.driver.queryRow(tx, tmpl, params)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		var id string
+		return id, errgo.Mask(row.Scan(&id), errgo.Any)
+	}
+	id, err := s.driver.queryID(tx, tmpl, params)
+	if tmpl == tmplUpsertIdentity && errgo.Cause(err) == sql.ErrNoRows {
+		// The database cannot insert or update the identity in a
+		// single statement, so the upsert only updates an existing
+		// identity. Insert the identity as it doesn't exist.
+		params.argBuilder = s.driver.argBuilderFunc()
+		id, err = s.driver.queryID(tx, tmplInsertIdentity, params)
+	}
+	return id, errgo.Mask(err, errgo.Any)
+}
+
 type updateSetParams struct {
 	argBuilder
 	Table  string
@@ -481,6 +577,61 @@ func (s *identityStore) updateExtraInfo(tx *sql.Tx, id, key string, op store.Ope
 	return errgo.Mask(s.updateSet(tx, "identity_extrainfo", id, key, op, vals))
 }
 
+// identitySetTables contains the tables that hold the multi-valued
+// fields of an identity.
+var identitySetTables = []string{
+	"identity_groups",
+	"identity_publickeys",
+	"identity_providerinfo",
+	"identity_extrainfo",
+}
+
+// DeleteIdentity implements store.Store.DeleteIdentity.
+func (s *identityStore) DeleteIdentity(_ context.Context, identity *store.Identity) error {
+	if identity.ID != "" {
+		if _, err := strconv.Atoi(identity.ID); err != nil {
+			// By definition if id isn't numeric it won't exist.
+			return store.NotFoundError(identity.ID, "", "")
+		}
+	}
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		return s.deleteIdentity(tx, identity)
+	}), errgo.Is(store.ErrNotFound))
+}
+
+func (s *identityStore) deleteIdentity(tx *sql.Tx, identity *store.Identity) error {
+	if err := s.identity(tx, identity); err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	for _, table := range identitySetTables {
+		params := &updateSetParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			Table:      table,
+			ID:         identity.ID,
+		}
+		if _, err := s.driver.exec(tx, tmplClearIdentitySet, params); err != nil {
+			return errgo.Notef(err, "cannot delete identity")
+		}
+	}
+	params := &updateSetParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         identity.ID,
+	}
+	if _, err := s.driver.exec(tx, tmplRemoveIdentity, params); err != nil {
+		return errgo.Notef(err, "cannot delete identity")
+	}
+	owned, err := s.findIdentities(tx, &store.Identity{Owner: identity.ProviderID}, store.Filter{store.Owner: store.Equal}, nil, 0, 0)
+	if err != nil {
+		return errgo.Notef(err, "cannot delete identity")
+	}
+	for i := range owned {
+		if err := s.deleteIdentity(tx, &store.Identity{ID: owned[i].ID}); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
 // IdentityCounts implements store.IdentityCounts.
 func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, error) {
 	counts := make(map[string]int)
@@ -500,6 +651,24 @@ func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, err
 	return counts, errgo.Mask(rows.Err())
 }
 
+// IdentityGroups implements store.IdentityGroups.
+func (s *identityStore) IdentityGroups(ctx context.Context) ([]string, error) {
+	rows, err := s.driver.query(s.db, tmplIdentityGroups, s.driver.argBuilderFunc())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var groups []string
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, errgo.Mask(rows.Err())
+}
+
 type nullTime struct {
 	Time  time.Time
 	Valid bool
@@ -543,25 +712,34 @@ type scanner interface {
 }
 
 func scanIdentity(s scanner, identity *store.Identity) error {
+	// Some drivers return text as []byte, which cannot be scanned
+	// into a store.ProviderIdentity directly.
+	var providerID string
 	var name, email, owner sql.NullString
-	var lastLogin, lastDischarge nullTime
+	var lastLogin, lastDischarge, notBefore nullTime
+	var suspended sql.NullBool
 	err := s.Scan(
 		&identity.ID,
-		&identity.ProviderID,
+		&providerID,
 		&identity.Username,
 		&name,
 		&email,
 		&lastLogin,
 		&lastDischarge,
 		&owner,
+		&suspended,
+		&notBefore,
 	)
 	if err != nil {
 		return errgo.Mask(err, errgo.Any)
 	}
+	identity.ProviderID = store.ProviderIdentity(providerID)
 	identity.Name = name.String
 	identity.Email = email.String
 	identity.LastLogin = lastLogin.Time
 	identity.LastDischarge = lastDischarge.Time
 	identity.Owner = store.ProviderIdentity(owner.String)
+	identity.Suspended = suspended.Bool
+	identity.NotBefore = notBefore.Time
 	return nil
 }
@@ -0,0 +1,142 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// webhookQueue is an implementation of webhook.Queue that uses an sql
+// table.
+type webhookQueue struct {
+	*backend
+}
+
+type webhookParams struct {
+	argBuilder
+	ID          int
+	URL         string
+	Body        []byte
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// Add implements webhook.Queue.Add.
+func (q *webhookQueue) Add(_ context.Context, d *webhook.Delivery) error {
+	params := &webhookParams{
+		argBuilder:  q.driver.argBuilderFunc(),
+		URL:         d.URL,
+		Body:        d.Body,
+		Attempts:    d.Attempts,
+		NextAttempt: d.NextAttempt,
+	}
+	id, err := q.driver.queryID(q.db, tmplAddWebhook, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	d.ID = id
+	return nil
+}
+
+type claimWebhooksParams struct {
+	argBuilder
+	Now   time.Time
+	Until time.Time
+	Limit int
+	IDs   []string
+}
+
+// Claim implements webhook.Queue.Claim.
+func (q *webhookQueue) Claim(_ context.Context, now, until time.Time, limit int) ([]webhook.Delivery, error) {
+	var deliveries []webhook.Delivery
+	err := q.withTx(func(tx *sql.Tx) error {
+		var err error
+		deliveries, err = q.claim(tx, now, until, limit)
+		return errgo.Mask(err)
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot claim webhooks")
+	}
+	return deliveries, nil
+}
+
+// claim finds the deliveries that are due and moves their next attempt
+// to until. The deliveries found are locked for the duration of the
+// transaction so that they cannot be claimed concurrently.
+func (q *webhookQueue) claim(tx *sql.Tx, now, until time.Time, limit int) ([]webhook.Delivery, error) {
+	params := &claimWebhooksParams{
+		argBuilder: q.driver.argBuilderFunc(),
+		Now:        now,
+		Limit:      limit,
+	}
+	rows, err := q.driver.query(tx, tmplFindDueWebhooks, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var id int
+		var d webhook.Delivery
+		if err := rows.Scan(&id, &d.URL, &d.Body, &d.Attempts, &d.NextAttempt); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		d.ID = strconv.Itoa(id)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	params = &claimWebhooksParams{
+		argBuilder: q.driver.argBuilderFunc(),
+		Until:      until,
+	}
+	for _, d := range deliveries {
+		params.IDs = append(params.IDs, d.ID)
+	}
+	if _, err := q.driver.exec(tx, tmplClaimWebhooks, params); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return deliveries, nil
+}
+
+// Reschedule implements webhook.Queue.Reschedule.
+func (q *webhookQueue) Reschedule(_ context.Context, id string, attempts int, next time.Time) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+	params := &webhookParams{
+		argBuilder:  q.driver.argBuilderFunc(),
+		ID:          n,
+		Attempts:    attempts,
+		NextAttempt: next,
+	}
+	_, err = q.driver.exec(q.db, tmplRescheduleWebhook, params)
+	return errgo.Mask(err)
+}
+
+// Remove implements webhook.Queue.Remove.
+func (q *webhookQueue) Remove(_ context.Context, id string) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+	params := &webhookParams{
+		argBuilder: q.driver.argBuilderFunc(),
+		ID:         n,
+	}
+	_, err = q.driver.exec(q.db, tmplRemoveWebhook, params)
+	return errgo.Mask(err)
+}
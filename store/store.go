@@ -28,6 +28,8 @@ const (
 	ProviderInfo
 	ExtraInfo
 	Owner
+	Suspended
+	NotBefore
 	NumFields
 )
 
@@ -83,10 +85,23 @@ const (
 	LessThan
 	GreaterThanOrEqual
 	LessThanOrEqual
+
+	// Prefix matches values that start with the reference value,
+	// ignoring case.
+	Prefix
+
+	// Contains matches values that contain the reference value,
+	// ignoring case.
+	Contains
 )
 
 // A Filter is used in a Store.FindEntities call to specify how the
 // identities should be filtered.
+//
+// The only comparison supported on the Groups field is Equal, which
+// matches identities that are members of all the groups in the
+// reference identity. The Prefix and Contains comparisons are only
+// supported on the ProviderID, Username, Name, Email and Owner fields.
 type Filter [NumFields]Comparison
 
 // A Sort specifies the sort order of returned identities in a call to
@@ -125,6 +140,12 @@ type Store interface {
 	// will be skipped before those that are returned.
 	FindIdentities(ctx context.Context, ref *Identity, filter Filter, sort []Sort, skip, limit int) ([]Identity, error)
 
+	// CountIdentities returns the number of identities that match
+	// the given ref when the given filter has been applied, that is
+	// the number of identities that FindIdentities would return
+	// without a skip or limit.
+	CountIdentities(ctx context.Context, ref *Identity, filter Filter) (int, error)
+
 	// UpdateIdentity stores the data from the given identity in
 	// persistant storage. The identity that is updated will be the
 	// one matching the first non-zero value of ID, ProviderID or
@@ -143,9 +164,56 @@ type Store interface {
 	// will be returned.
 	UpdateIdentity(ctx context.Context, identity *Identity, update Update) error
 
+	// DeleteIdentity removes the identity matching the first
+	// non-zero value of ID, ProviderID or Username from persistent
+	// storage, along with its groups, public keys, provider info and
+	// extra info. Any identities owned by the removed identity, such
+	// as agents, are also removed. Any data held about the identity
+	// by its identity provider in the ProviderDataStore is not
+	// removed. If no match can be found then an error with the cause
+	// ErrNotFound will be returned.
+	DeleteIdentity(ctx context.Context, identity *Identity) error
+
 	// IdentityCounts returns the number of identities stored in the
 	// store split by provider ID.
 	IdentityCounts(ctx context.Context) (map[string]int, error)
+
+	// IdentityGroups returns the names of all the groups that have
+	// at least one identity as a member, sorted by name.
+	IdentityGroups(ctx context.Context) ([]string, error)
+
+	// Group reads the given group from persistent storage and
+	// completes all the fields. The given group will be matched
+	// using the first non-zero value of ID or Name. If no match can
+	// be found for the given group then an error with the cause
+	// ErrNotFound will be returned.
+	Group(ctx context.Context, group *Group) error
+
+	// FindGroups returns all the groups in the store sorted by
+	// name.
+	FindGroups(ctx context.Context) ([]Group, error)
+
+	// CreateGroup adds the given group to persistent storage, the
+	// assigned ID will be written back into the given group. If a
+	// group with the same name already exists then an error with
+	// the cause ErrDuplicateGroup will be returned.
+	CreateGroup(ctx context.Context, group *Group) error
+
+	// UpdateGroup replaces the stored group matching the first
+	// non-zero value of ID or Name with the given group. If the
+	// group is matched by ID then the name of the group will also
+	// be updated. If no match can be found then an error with the
+	// cause ErrNotFound will be returned. If the update would result
+	// in a duplicate group name then an error with the cause
+	// ErrDuplicateGroup will be returned.
+	UpdateGroup(ctx context.Context, group *Group) error
+
+	// RemoveGroup removes the group matching the first non-zero
+	// value of ID or Name from persistent storage. If no match can
+	// be found then an error with the cause ErrNotFound will be
+	// returned. Removing a group does not change the Groups field
+	// of any identity.
+	RemoveGroup(ctx context.Context, group *Group) error
 }
 
 // A ProviderIdentity is a provider-specific unique identity.
@@ -234,4 +302,37 @@ type Identity struct {
 	// Owner contains the ProviderIdentity of the identity that owns
 	// this one.
 	Owner ProviderIdentity
+
+	// Suspended is true when the identity has been suspended. A
+	// suspended identity cannot log in or obtain discharges.
+	Suspended bool
+
+	// NotBefore contains the time before which any discharge tokens
+	// or identity macaroons issued to the identity are no longer
+	// valid. It is set to revoke all of the identity's existing
+	// sessions.
+	NotBefore time.Time
+}
+
+// Group represents a group in the store. Identities are members of a
+// group when the group's name is in their Groups field.
+type Group struct {
+	// ID is the internal ID of the group, this is allocated by the
+	// store when the group is created.
+	ID string
+
+	// Name contains the unique name of the group.
+	Name string
+
+	// Description contains a human readable description of the
+	// group.
+	Description string
+
+	// Owner contains the ProviderIdentity of the identity that owns
+	// the group.
+	Owner ProviderIdentity
+
+	// Groups contains the names of the groups that this group is
+	// itself a member of.
+	Groups []string
 }
@@ -0,0 +1,164 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"context"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/store"
+)
+
+// auditSuite contains a set of tests for store.AuditStore
+// implementations.
+type auditSuite struct {
+	newStore func(c *qt.C) store.AuditStore
+
+	Store store.AuditStore
+	ctx   context.Context
+}
+
+// TestAuditStore tests the store.AuditStore returned from the given
+// function.
+func TestAuditStore(c *qt.C, newStore func(c *qt.C) store.AuditStore) {
+	qtsuite.Run(c, &auditSuite{
+		newStore: newStore,
+	})
+}
+
+func (s *auditSuite) Init(c *qt.C) {
+	s.Store = s.newStore(c)
+	s.ctx = context.Background()
+}
+
+var auditEpoch = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var auditEntries = []store.AuditEntry{{
+	Time:     auditEpoch.Add(3 * time.Minute),
+	Type:     store.AuditDischarge,
+	Username: "bob",
+	Caveat:   "is-member-of g1",
+}, {
+	Time:     auditEpoch,
+	Type:     store.AuditLogin,
+	Username: "bob",
+	IDP:      "test",
+}, {
+	Time:     auditEpoch.Add(time.Minute),
+	Type:     store.AuditLoginFailure,
+	IDP:      "test",
+	Detail:   "invalid password",
+	Username: "alice",
+}, {
+	Time:     auditEpoch.Add(2 * time.Minute),
+	Type:     store.AuditGroupsChange,
+	Username: "alice",
+	Actor:    "bob",
+	Detail:   "add g1",
+}}
+
+var findAuditEntriesTests = []struct {
+	about  string
+	filter store.AuditFilter
+	expect []int
+}{{
+	about:  "all entries",
+	expect: []int{0, 3, 2, 1},
+}, {
+	about: "username",
+	filter: store.AuditFilter{
+		Username: "bob",
+	},
+	expect: []int{0, 3, 1},
+}, {
+	about: "username only as subject",
+	filter: store.AuditFilter{
+		Username: "alice",
+	},
+	expect: []int{3, 2},
+}, {
+	about: "since",
+	filter: store.AuditFilter{
+		Since: auditEpoch.Add(time.Minute),
+	},
+	expect: []int{0, 3, 2},
+}, {
+	about: "until",
+	filter: store.AuditFilter{
+		Until: auditEpoch.Add(2 * time.Minute),
+	},
+	expect: []int{2, 1},
+}, {
+	about: "since and until",
+	filter: store.AuditFilter{
+		Since: auditEpoch.Add(time.Minute),
+		Until: auditEpoch.Add(3 * time.Minute),
+	},
+	expect: []int{3, 2},
+}, {
+	about: "limit",
+	filter: store.AuditFilter{
+		Limit: 2,
+	},
+	expect: []int{0, 3},
+}, {
+	about: "no matches",
+	filter: store.AuditFilter{
+		Username: "charlie",
+	},
+}}
+
+func (s *auditSuite) TestFindAuditEntries(c *qt.C) {
+	for i := range auditEntries {
+		e := auditEntries[i]
+		err := s.Store.AddAuditEntry(s.ctx, &e)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(e.ID, qt.Not(qt.Equals), "")
+	}
+	for _, test := range findAuditEntriesTests {
+		c.Run(test.about, func(c *qt.C) {
+			entries, err := s.Store.FindAuditEntries(s.ctx, test.filter)
+			c.Assert(err, qt.Equals, nil)
+			var expect []store.AuditEntry
+			for _, i := range test.expect {
+				expect = append(expect, auditEntries[i])
+			}
+			c.Assert(normalizeAuditEntries(entries), qt.DeepEquals, expect)
+		})
+	}
+}
+
+func (s *auditSuite) TestAddAuditEntrySetsTime(c *qt.C) {
+	before := time.Now().Add(-time.Second)
+	e := store.AuditEntry{
+		Type:     store.AuditAgentCreate,
+		Username: "agent@candid",
+		Actor:    "bob",
+	}
+	err := s.Store.AddAuditEntry(s.ctx, &e)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(e.Time.Before(before), qt.Equals, false)
+
+	entries, err := s.Store.FindAuditEntries(s.ctx, store.AuditFilter{})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].ID, qt.Equals, e.ID)
+	c.Assert(entries[0].Type, qt.Equals, store.AuditAgentCreate)
+	c.Assert(entries[0].Username, qt.Equals, "agent@candid")
+	c.Assert(entries[0].Actor, qt.Equals, "bob")
+}
+
+// normalizeAuditEntries removes the store assigned IDs from the given
+// entries and converts their times to UTC so that they can be compared
+// with the original values.
+func normalizeAuditEntries(entries []store.AuditEntry) []store.AuditEntry {
+	for i := range entries {
+		entries[i].ID = ""
+		entries[i].Time = entries[i].Time.UTC()
+	}
+	return entries
+}
@@ -690,6 +690,62 @@ var updateIdentityTests = []struct {
 		store.Owner: store.Clear,
 	},
 	expectIdentity: &store.Identity{},
+}, {
+	about:         "set suspended",
+	startIdentity: &store.Identity{},
+	updateIdentity: &store.Identity{
+		Suspended: true,
+	},
+	update: store.Update{
+		store.Suspended: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		Suspended: true,
+	},
+}, {
+	about: "unset suspended",
+	startIdentity: &store.Identity{
+		Suspended: true,
+	},
+	updateIdentity: &store.Identity{
+		Suspended: false,
+	},
+	update: store.Update{
+		store.Suspended: store.Set,
+	},
+	expectIdentity: &store.Identity{},
+}, {
+	about: "clear suspended",
+	startIdentity: &store.Identity{
+		Suspended: true,
+	},
+	updateIdentity: &store.Identity{},
+	update: store.Update{
+		store.Suspended: store.Clear,
+	},
+	expectIdentity: &store.Identity{},
+}, {
+	about:         "set not before",
+	startIdentity: &store.Identity{},
+	updateIdentity: &store.Identity{
+		NotBefore: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+	},
+	update: store.Update{
+		store.NotBefore: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		NotBefore: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+	},
+}, {
+	about: "clear not before",
+	startIdentity: &store.Identity{
+		NotBefore: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+	},
+	updateIdentity: &store.Identity{},
+	update: store.Update{
+		store.NotBefore: store.Clear,
+	},
+	expectIdentity: &store.Identity{},
 }, {
 	about: "username not found",
 	updateIdentity: &store.Identity{
@@ -778,6 +834,12 @@ func (s *storeSuite) TestUpdateIdentity(c *qt.C) {
 				if !test.startIdentity.LastLogin.IsZero() {
 					update[store.LastLogin] = store.Set
 				}
+				if test.startIdentity.Suspended {
+					update[store.Suspended] = store.Set
+				}
+				if !test.startIdentity.NotBefore.IsZero() {
+					update[store.NotBefore] = store.Set
+				}
 				err := s.Store.UpdateIdentity(s.ctx, test.startIdentity, update)
 				c.Assert(err, qt.Equals, nil)
 			}
@@ -1038,6 +1100,7 @@ var testIdentities = []store.Identity{{
 	Username:      "test3",
 	Name:          "Test User 3",
 	Email:         "test3@example.com",
+	Groups:        []string{"g2"},
 	LastLogin:     time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC),
 	LastDischarge: time.Date(2017, 2, 7, 0, 0, 0, 0, time.UTC),
 }, {
@@ -1384,6 +1447,94 @@ var findIdentitiesTests = []struct {
 		store.Owner: store.Equal,
 	},
 	expect: []int{5},
+}, {
+	about: "member of group",
+	ref: store.Identity{
+		Groups: []string{"g2"},
+	},
+	filter: store.Filter{
+		store.Groups: store.Equal,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	expect: []int{0, 2},
+}, {
+	about: "member of all groups",
+	ref: store.Identity{
+		Groups: []string{"g1", "g2"},
+	},
+	filter: store.Filter{
+		store.Groups: store.Equal,
+	},
+	expect: []int{0},
+}, {
+	about: "member of group and other filter",
+	ref: store.Identity{
+		Groups:   []string{"g2"},
+		Username: "test3",
+	},
+	filter: store.Filter{
+		store.Groups:   store.Equal,
+		store.Username: store.Equal,
+	},
+	expect: []int{2},
+}, {
+	about: "name contains",
+	ref: store.Identity{
+		Name: "user 1",
+	},
+	filter: store.Filter{
+		store.Name: store.Contains,
+	},
+	expect: []int{0},
+}, {
+	about: "name prefix does not match within value",
+	ref: store.Identity{
+		Name: "User",
+	},
+	filter: store.Filter{
+		store.Name: store.Prefix,
+	},
+}, {
+	about: "email prefix",
+	ref: store.Identity{
+		Email: "TEST9@",
+	},
+	filter: store.Filter{
+		store.Email: store.Prefix,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	expect: []int{6, 8},
+}, {
+	about: "provider ID prefix with limit",
+	ref: store.Identity{
+		ProviderID: "test:",
+	},
+	filter: store.Filter{
+		store.ProviderID: store.Prefix,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	limit:  2,
+	expect: []int{0, 1},
+}, {
+	about: "contains matches pattern characters literally",
+	ref: store.Identity{
+		Email: "test_@%",
+	},
+	filter: store.Filter{
+		store.Email: store.Contains,
+	},
+}, {
+	about: "prefix and greater than on different fields",
+	ref: store.Identity{
+		Email:    "test",
+		Username: "test7",
+	},
+	filter: store.Filter{
+		store.Email:    store.Prefix,
+		store.Username: store.GreaterThan,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	expect: []int{7, 8},
 }}
 
 func (s *storeSuite) TestFindIdentities(c *qt.C) {
@@ -1431,6 +1582,13 @@ func (s *storeSuite) TestFindIdentities(c *qt.C) {
 		for i, identity := range identities {
 			candidtest.AssertEqualIdentity(c, &identity, &testIdentities[test.expect[i]])
 		}
+		// CountIdentities counts all the matches, ignoring the
+		// skip and limit.
+		all, err := s.Store.FindIdentities(s.ctx, &test.ref, test.filter, nil, 0, 0)
+		c.Assert(err, qt.Equals, nil)
+		n, err := s.Store.CountIdentities(s.ctx, &test.ref, test.filter)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(n, qt.Equals, len(all))
 	}
 }
 
@@ -1454,3 +1612,330 @@ func (s *storeSuite) TestIdentityCounts(c *qt.C) {
 		"c": 1,
 	})
 }
+
+func (s *storeSuite) TestIdentityGroups(c *qt.C) {
+	groups, err := s.Store.IdentityGroups(s.ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.HasLen, 0)
+
+	for i, g := range [][]string{{"g2", "g1"}, nil, {"g3", "g2"}} {
+		username := fmt.Sprintf("user%d", i)
+		err := s.Store.UpdateIdentity(s.ctx, &store.Identity{
+			ProviderID: store.MakeProviderIdentity("test", username),
+			Username:   username,
+			Groups:     g,
+		}, store.Update{
+			store.Username: store.Set,
+			store.Groups:   store.Set,
+		})
+		c.Assert(err, qt.Equals, nil)
+	}
+	groups, err = s.Store.IdentityGroups(s.ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.DeepEquals, []string{"g1", "g2", "g3"})
+}
+
+var deleteIdentityTests = []struct {
+	about    string
+	identity func(id *store.Identity) *store.Identity
+}{{
+	about: "by id",
+	identity: func(id *store.Identity) *store.Identity {
+		return &store.Identity{ID: id.ID}
+	},
+}, {
+	about: "by provider id",
+	identity: func(id *store.Identity) *store.Identity {
+		return &store.Identity{ProviderID: id.ProviderID}
+	},
+}, {
+	about: "by username",
+	identity: func(id *store.Identity) *store.Identity {
+		return &store.Identity{Username: id.Username}
+	},
+}}
+
+func (s *storeSuite) TestDeleteIdentity(c *qt.C) {
+	for i, test := range deleteIdentityTests {
+		c.Run(test.about, func(c *qt.C) {
+			username := fmt.Sprintf("delete%d", i)
+			identity := store.Identity{
+				ProviderID: store.MakeProviderIdentity("test", username),
+				Username:   username,
+				Groups:     []string{"g1", "g2"},
+				PublicKeys: []bakery.PublicKey{pk1},
+				ProviderInfo: map[string][]string{
+					"pf1": {"pf1v1"},
+				},
+				ExtraInfo: map[string][]string{
+					"ef1": {"ef1v1"},
+				},
+			}
+			err := s.Store.UpdateIdentity(s.ctx, &identity, store.Update{
+				store.Username:     store.Set,
+				store.Groups:       store.Set,
+				store.PublicKeys:   store.Set,
+				store.ProviderInfo: store.Set,
+				store.ExtraInfo:    store.Set,
+			})
+			c.Assert(err, qt.Equals, nil)
+
+			err = s.Store.DeleteIdentity(s.ctx, test.identity(&identity))
+			c.Assert(err, qt.Equals, nil)
+
+			err = s.Store.Identity(s.ctx, &store.Identity{Username: username})
+			c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+			// Recreating the identity must not resurrect any of
+			// the deleted data.
+			identity2 := store.Identity{
+				ProviderID: identity.ProviderID,
+				Username:   username,
+			}
+			err = s.Store.UpdateIdentity(s.ctx, &identity2, store.Update{
+				store.Username: store.Set,
+			})
+			c.Assert(err, qt.Equals, nil)
+			identity3 := store.Identity{
+				Username: username,
+			}
+			err = s.Store.Identity(s.ctx, &identity3)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(identity3.Groups, qt.HasLen, 0)
+			c.Assert(identity3.PublicKeys, qt.HasLen, 0)
+			c.Assert(identity3.ProviderInfo, qt.HasLen, 0)
+			c.Assert(identity3.ExtraInfo, qt.HasLen, 0)
+		})
+	}
+}
+
+func (s *storeSuite) TestDeleteIdentityOwned(c *qt.C) {
+	owner := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "owner"),
+		Username:   "owner",
+	}
+	err := s.Store.UpdateIdentity(s.ctx, &owner, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	agent := store.Identity{
+		ProviderID: store.MakeProviderIdentity("agent", "agent1@candid"),
+		Username:   "agent1@candid",
+		Groups:     []string{"g1"},
+		PublicKeys: []bakery.PublicKey{pk1},
+		Owner:      owner.ProviderID,
+	}
+	err = s.Store.UpdateIdentity(s.ctx, &agent, store.Update{
+		store.Username:   store.Set,
+		store.Groups:     store.Set,
+		store.PublicKeys: store.Set,
+		store.Owner:      store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	subagent := store.Identity{
+		ProviderID: store.MakeProviderIdentity("agent", "agent2@candid"),
+		Username:   "agent2@candid",
+		Owner:      agent.ProviderID,
+	}
+	err = s.Store.UpdateIdentity(s.ctx, &subagent, store.Update{
+		store.Username: store.Set,
+		store.Owner:    store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+	other := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "other"),
+		Username:   "other",
+	}
+	err = s.Store.UpdateIdentity(s.ctx, &other, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.Store.DeleteIdentity(s.ctx, &store.Identity{Username: "owner"})
+	c.Assert(err, qt.Equals, nil)
+
+	for _, username := range []string{"owner", "agent1@candid", "agent2@candid"} {
+		err = s.Store.Identity(s.ctx, &store.Identity{Username: username})
+		c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound, qt.Commentf("%s", username))
+	}
+	err = s.Store.Identity(s.ctx, &store.Identity{Username: "other"})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFound(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{Username: "no-such-user"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `user no-such-user not found`)
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFoundNoQuery(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `identity not specified`)
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFoundBadID(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{ID: "1234"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `identity "1234" not found`)
+}
+
+func (s *storeSuite) TestCreateGroup(c *qt.C) {
+	group := store.Group{
+		Name:        "g1",
+		Description: "Group One",
+		Owner:       "test:admin",
+		Groups:      []string{"g2", "g3"},
+	}
+	err := s.Store.CreateGroup(s.ctx, &group)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(group.ID, qt.Not(qt.Equals), "")
+
+	group1 := store.Group{
+		ID: group.ID,
+	}
+	err = s.Store.Group(s.ctx, &group1)
+	c.Assert(err, qt.Equals, nil)
+	assertEqualGroup(c, &group1, &group)
+
+	group2 := store.Group{
+		Name: "g1",
+	}
+	err = s.Store.Group(s.ctx, &group2)
+	c.Assert(err, qt.Equals, nil)
+	assertEqualGroup(c, &group2, &group)
+}
+
+func (s *storeSuite) TestCreateDuplicateGroup(c *qt.C) {
+	err := s.Store.CreateGroup(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(err, qt.Equals, nil)
+	err = s.Store.CreateGroup(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrDuplicateGroup)
+	c.Assert(err, qt.ErrorMatches, `group g1 already exists`)
+}
+
+func (s *storeSuite) TestGroupNotFound(c *qt.C) {
+	err := s.Store.Group(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `group g1 not found`)
+
+	err = s.Store.Group(s.ctx, &store.Group{ID: "1234"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `group "1234" not found`)
+
+	err = s.Store.Group(s.ctx, &store.Group{})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `group not specified`)
+}
+
+func (s *storeSuite) TestUpdateGroup(c *qt.C) {
+	group := store.Group{
+		Name:        "g1",
+		Description: "Group One",
+		Groups:      []string{"g2"},
+	}
+	err := s.Store.CreateGroup(s.ctx, &group)
+	c.Assert(err, qt.Equals, nil)
+
+	// Update by name.
+	update := store.Group{
+		Name:        "g1",
+		Description: "The first group",
+		Owner:       "test:admin",
+		Groups:      []string{"g3", "g4"},
+	}
+	err = s.Store.UpdateGroup(s.ctx, &update)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(update.ID, qt.Equals, group.ID)
+	group1 := store.Group{Name: "g1"}
+	err = s.Store.Group(s.ctx, &group1)
+	c.Assert(err, qt.Equals, nil)
+	assertEqualGroup(c, &group1, &update)
+
+	// Rename by ID.
+	update = store.Group{
+		ID:   group.ID,
+		Name: "g5",
+	}
+	err = s.Store.UpdateGroup(s.ctx, &update)
+	c.Assert(err, qt.Equals, nil)
+	group1 = store.Group{ID: group.ID}
+	err = s.Store.Group(s.ctx, &group1)
+	c.Assert(err, qt.Equals, nil)
+	assertEqualGroup(c, &group1, &update)
+	err = s.Store.Group(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestUpdateGroupDuplicateName(c *qt.C) {
+	group := store.Group{Name: "g1"}
+	err := s.Store.CreateGroup(s.ctx, &group)
+	c.Assert(err, qt.Equals, nil)
+	err = s.Store.CreateGroup(s.ctx, &store.Group{Name: "g2"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.Store.UpdateGroup(s.ctx, &store.Group{ID: group.ID, Name: "g2"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrDuplicateGroup)
+	c.Assert(err, qt.ErrorMatches, `group g2 already exists`)
+}
+
+func (s *storeSuite) TestUpdateGroupNotFound(c *qt.C) {
+	err := s.Store.UpdateGroup(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `group g1 not found`)
+}
+
+func (s *storeSuite) TestRemoveGroup(c *qt.C) {
+	group := store.Group{
+		Name:   "g1",
+		Groups: []string{"g2"},
+	}
+	err := s.Store.CreateGroup(s.ctx, &group)
+	c.Assert(err, qt.Equals, nil)
+
+	err = s.Store.RemoveGroup(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(err, qt.Equals, nil)
+	err = s.Store.Group(s.ctx, &store.Group{ID: group.ID})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.RemoveGroup(s.ctx, &store.Group{ID: group.ID})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	// The name can be reused.
+	err = s.Store.CreateGroup(s.ctx, &store.Group{Name: "g1"})
+	c.Assert(err, qt.Equals, nil)
+}
+
+func (s *storeSuite) TestFindGroups(c *qt.C) {
+	groups, err := s.Store.FindGroups(s.ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.HasLen, 0)
+
+	expect := make([]store.Group, 3)
+	for i, name := range []string{"b", "c", "a"} {
+		group := store.Group{
+			Name:        name,
+			Description: "group " + name,
+		}
+		err := s.Store.CreateGroup(s.ctx, &group)
+		c.Assert(err, qt.Equals, nil)
+		expect[(i+1)%3] = group
+	}
+	groups, err = s.Store.FindGroups(s.ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(groups, qt.HasLen, len(expect))
+	for i := range groups {
+		assertEqualGroup(c, &groups[i], &expect[i])
+	}
+}
+
+// assertEqualGroup checks that the given groups are equal, treating
+// nil and empty Groups fields as equivalent.
+func assertEqualGroup(c *qt.C, obtained, expected *store.Group) {
+	if len(obtained.Groups) == 0 && len(expected.Groups) == 0 {
+		obtained1, expected1 := *obtained, *expected
+		obtained1.Groups, expected1.Groups = nil, nil
+		obtained, expected = &obtained1, &expected1
+	}
+	c.Assert(obtained, qt.DeepEquals, expected)
+}
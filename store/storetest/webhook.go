@@ -0,0 +1,129 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"context"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+// webhookSuite contains a set of tests for webhook.Queue
+// implementations.
+type webhookSuite struct {
+	newQueue func(c *qt.C) webhook.Queue
+
+	Queue webhook.Queue
+	ctx   context.Context
+}
+
+// TestWebhookQueue tests the webhook.Queue returned from the given
+// function.
+func TestWebhookQueue(c *qt.C, newQueue func(c *qt.C) webhook.Queue) {
+	qtsuite.Run(c, &webhookSuite{
+		newQueue: newQueue,
+	})
+}
+
+func (s *webhookSuite) Init(c *qt.C) {
+	s.Queue = s.newQueue(c)
+	s.ctx = context.Background()
+}
+
+var webhookEpoch = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (s *webhookSuite) addDeliveries(c *qt.C) []webhook.Delivery {
+	deliveries := []webhook.Delivery{{
+		URL:         "https://example.com/1",
+		Body:        []byte(`{"id":"1"}`),
+		NextAttempt: webhookEpoch.Add(time.Minute),
+	}, {
+		URL:         "https://example.com/2",
+		Body:        []byte(`{"id":"2"}`),
+		NextAttempt: webhookEpoch,
+	}, {
+		URL:         "https://example.com/3",
+		Body:        []byte(`{"id":"3"}`),
+		Attempts:    2,
+		NextAttempt: webhookEpoch.Add(time.Hour),
+	}}
+	for i := range deliveries {
+		err := s.Queue.Add(s.ctx, &deliveries[i])
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(deliveries[i].ID, qt.Not(qt.Equals), "")
+	}
+	return deliveries
+}
+
+func (s *webhookSuite) TestClaim(c *qt.C) {
+	deliveries := s.addDeliveries(c)
+
+	claimed, err := s.Queue.Claim(s.ctx, webhookEpoch.Add(time.Minute), webhookEpoch.Add(10*time.Minute), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(normalizeDeliveries(claimed), qt.DeepEquals, []webhook.Delivery{deliveries[1], deliveries[0]})
+
+	// The claimed deliveries are not available again until the
+	// claim expires.
+	claimed, err = s.Queue.Claim(s.ctx, webhookEpoch.Add(2*time.Minute), webhookEpoch.Add(20*time.Minute), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(claimed, qt.HasLen, 0)
+
+	claimed, err = s.Queue.Claim(s.ctx, webhookEpoch.Add(time.Hour), webhookEpoch.Add(2*time.Hour), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(claimed, qt.HasLen, 3)
+	c.Assert(claimed[0].ID, qt.Equals, deliveries[0].ID)
+	c.Assert(claimed[1].ID, qt.Equals, deliveries[1].ID)
+	c.Assert(claimed[2].ID, qt.Equals, deliveries[2].ID)
+}
+
+func (s *webhookSuite) TestClaimLimit(c *qt.C) {
+	deliveries := s.addDeliveries(c)
+
+	claimed, err := s.Queue.Claim(s.ctx, webhookEpoch.Add(time.Hour), webhookEpoch.Add(2*time.Hour), 1)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(claimed, qt.HasLen, 1)
+	c.Assert(claimed[0].ID, qt.Equals, deliveries[1].ID)
+}
+
+func (s *webhookSuite) TestReschedule(c *qt.C) {
+	deliveries := s.addDeliveries(c)
+
+	err := s.Queue.Reschedule(s.ctx, deliveries[2].ID, 3, webhookEpoch.Add(-time.Minute))
+	c.Assert(err, qt.Equals, nil)
+
+	claimed, err := s.Queue.Claim(s.ctx, webhookEpoch, webhookEpoch.Add(time.Hour), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(claimed, qt.HasLen, 2)
+	c.Assert(claimed[0].ID, qt.Equals, deliveries[2].ID)
+	c.Assert(claimed[0].Attempts, qt.Equals, 3)
+	c.Assert(claimed[1].ID, qt.Equals, deliveries[1].ID)
+}
+
+func (s *webhookSuite) TestRemove(c *qt.C) {
+	deliveries := s.addDeliveries(c)
+
+	err := s.Queue.Remove(s.ctx, deliveries[1].ID)
+	c.Assert(err, qt.Equals, nil)
+	err = s.Queue.Remove(s.ctx, deliveries[1].ID)
+	c.Assert(err, qt.Equals, nil)
+
+	claimed, err := s.Queue.Claim(s.ctx, webhookEpoch.Add(time.Hour), webhookEpoch.Add(2*time.Hour), 10)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(claimed, qt.HasLen, 2)
+	c.Assert(claimed[0].ID, qt.Equals, deliveries[0].ID)
+	c.Assert(claimed[1].ID, qt.Equals, deliveries[2].ID)
+}
+
+// normalizeDeliveries converts the times in the given deliveries to UTC
+// so that they can be compared with the original values.
+func normalizeDeliveries(ds []webhook.Delivery) []webhook.Delivery {
+	for i := range ds {
+		ds[i].NextAttempt = ds[i].NextAttempt.UTC()
+	}
+	return ds
+}
@@ -0,0 +1,415 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package webhook delivers signed notifications of identity lifecycle
+// events to configured HTTP endpoints.
+//
+// Events are first written to a durable queue held in the storage
+// backend and are then delivered by a Dispatcher running in each
+// server. Failed deliveries are retried with an increasing delay.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/loggo"
+	"github.com/juju/utils"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/tomb.v2"
+)
+
+var logger = loggo.GetLogger("candid.webhook")
+
+var (
+	// pollInterval holds the interval at which the dispatcher
+	// polls the queue for deliveries that are due.
+	pollInterval = 10 * time.Second
+
+	// claimDuration holds the length of time for which a delivery
+	// is reserved by a dispatcher while it is being attempted.
+	claimDuration = 5 * time.Minute
+
+	// claimLimit holds the maximum number of deliveries claimed
+	// from the queue at once.
+	claimLimit = 20
+
+	// retryInterval holds the delay before the first retry of a
+	// failed delivery. The delay doubles with each subsequent
+	// attempt up to maxRetryInterval.
+	retryInterval = 30 * time.Second
+
+	// maxRetryInterval holds the maximum delay between attempts.
+	maxRetryInterval = time.Hour
+
+	// defaultMaxAttempts holds the default number of attempts made
+	// to deliver an event before it is discarded.
+	defaultMaxAttempts = 10
+
+	// defaultTimeout holds the default timeout for a single
+	// delivery attempt.
+	defaultTimeout = 30 * time.Second
+
+	// Clock holds the clock implementation used by the webhook
+	// package. This is exported so it can be changed for testing
+	// purposes.
+	Clock clock.Clock = clock.WallClock
+)
+
+// An EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// IdentityCreated is sent when a new identity is added to the
+	// server.
+	IdentityCreated EventType = "identity-created"
+
+	// IdentityDeleted is sent when an identity is deleted or
+	// anonymised.
+	IdentityDeleted EventType = "identity-deleted"
+
+	// FirstLogin is sent the first time an identity logs in.
+	FirstLogin EventType = "first-login"
+
+	// GroupsChanged is sent when the groups of an identity are
+	// changed through the API.
+	GroupsChanged EventType = "groups-changed"
+
+	// SSHKeysAdded is sent when SSH keys are added to an identity.
+	SSHKeysAdded EventType = "ssh-keys-added"
+
+	// SSHKeysRemoved is sent when SSH keys are removed from an
+	// identity.
+	SSHKeysRemoved EventType = "ssh-keys-removed"
+
+	// AgentCreated is sent when a new agent identity is created.
+	AgentCreated EventType = "agent-created"
+)
+
+// An Event is the JSON body POSTed to a webhook.
+type Event struct {
+	// ID holds a unique ID for the event. It is assigned by
+	// Dispatcher.Notify.
+	ID string `json:"id"`
+
+	// Type holds the type of the event.
+	Type EventType `json:"type"`
+
+	// Time holds the time at which the event occurred. If it is
+	// zero when the event is passed to Dispatcher.Notify then the
+	// current time is used.
+	Time time.Time `json:"time"`
+
+	// Username holds the username of the identity the event
+	// relates to.
+	Username string `json:"username"`
+
+	// IDP holds the name of the identity provider involved in the
+	// event, if any.
+	IDP string `json:"idp,omitempty"`
+
+	// Owner holds the username of the owner of a newly created
+	// agent.
+	Owner string `json:"owner,omitempty"`
+
+	// Groups holds the groups of the identity after a change, or
+	// the groups of a newly created agent.
+	Groups []string `json:"groups,omitempty"`
+
+	// SSHKeys holds the SSH keys that were added or removed.
+	SSHKeys []string `json:"ssh-keys,omitempty"`
+}
+
+// Hook holds the configuration of a single webhook endpoint.
+type Hook struct {
+	// URL holds the address to which events are POSTed.
+	URL string `yaml:"url"`
+
+	// Secret holds the key used to sign the events sent to the
+	// URL. It must not be empty.
+	Secret string `yaml:"secret"`
+}
+
+// A Delivery is an entry in the webhook queue representing an event
+// that is to be sent to a single URL.
+type Delivery struct {
+	// ID holds the ID of the delivery. It is assigned by the Queue
+	// when the delivery is added.
+	ID string
+
+	// URL holds the address the event is to be sent to.
+	URL string
+
+	// Body holds the encoded event.
+	Body []byte
+
+	// Attempts holds the number of delivery attempts that have
+	// failed so far.
+	Attempts int
+
+	// NextAttempt holds the earliest time at which the delivery
+	// should next be attempted.
+	NextAttempt time.Time
+}
+
+// Queue defines the durable storage used to hold deliveries until they
+// have been completed. Deliveries added to the queue should be visible
+// to every server sharing the storage backend.
+type Queue interface {
+	// Add adds the given delivery to the queue. The ID of the
+	// delivery is set to the ID assigned by the queue.
+	Add(ctx context.Context, d *Delivery) error
+
+	// Claim returns up to limit deliveries whose NextAttempt is not
+	// after now, ordered by NextAttempt. The NextAttempt of each
+	// returned delivery is atomically set to until so that no other
+	// dispatcher will attempt the same delivery concurrently.
+	Claim(ctx context.Context, now, until time.Time, limit int) ([]Delivery, error)
+
+	// Reschedule updates the number of failed attempts and the
+	// time of the next attempt of the delivery with the given ID.
+	Reschedule(ctx context.Context, id string, attempts int, next time.Time) error
+
+	// Remove removes the delivery with the given ID. It is not an
+	// error if the delivery has already been removed.
+	Remove(ctx context.Context, id string) error
+}
+
+// Params holds the parameters for NewDispatcher.
+type Params struct {
+	// Queue holds the queue used to store pending deliveries.
+	Queue Queue
+
+	// Hooks holds the endpoints that events are sent to. Queued
+	// deliveries to other URLs are left for the servers sharing
+	// the queue that have them configured.
+	Hooks []Hook
+
+	// Client holds the HTTP client used to make deliveries. If
+	// this is nil then a client with a default timeout is used.
+	Client *http.Client
+
+	// MaxAttempts holds the number of attempts made to deliver an
+	// event before it is discarded. If this is zero then a default
+	// value is used.
+	MaxAttempts int
+}
+
+// A Dispatcher sends events to the configured webhooks.
+type Dispatcher struct {
+	tomb        tomb.Tomb
+	queue       Queue
+	hooks       []Hook
+	secrets     map[string]string
+	client      *http.Client
+	maxAttempts int
+	wake        chan struct{}
+}
+
+// NewDispatcher returns a new Dispatcher that delivers events to the
+// given hooks. The Dispatcher must be closed when it is no longer
+// required.
+func NewDispatcher(p Params) *Dispatcher {
+	if p.Client == nil {
+		p.Client = &http.Client{
+			Timeout: defaultTimeout,
+		}
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	d := &Dispatcher{
+		queue:       p.Queue,
+		hooks:       p.Hooks,
+		secrets:     make(map[string]string),
+		client:      p.Client,
+		maxAttempts: p.MaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, h := range p.Hooks {
+		d.secrets[h.URL] = h.Secret
+	}
+	d.tomb.Go(d.run)
+	return d
+}
+
+// Close stops the dispatcher. Any undelivered events remain in the
+// queue.
+func (d *Dispatcher) Close() {
+	d.tomb.Kill(nil)
+	d.tomb.Wait()
+}
+
+// Notify queues the given event for delivery to every configured hook.
+// It is safe to call Notify on a nil Dispatcher, in which case nothing
+// is sent. Errors queueing the event are logged but not returned so
+// that the operation that triggered the event is not affected.
+func (d *Dispatcher) Notify(ctx context.Context, ev Event) {
+	if d == nil || len(d.hooks) == 0 {
+		return
+	}
+	id, err := utils.NewUUID()
+	if err != nil {
+		logger.Errorf("cannot create %s event: %s", ev.Type, err)
+		return
+	}
+	ev.ID = id.String()
+	if ev.Time.IsZero() {
+		ev.Time = Clock.Now()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		logger.Errorf("cannot marshal %s event: %s", ev.Type, err)
+		return
+	}
+	now := Clock.Now()
+	for _, h := range d.hooks {
+		if err := d.queue.Add(ctx, &Delivery{
+			URL:         h.URL,
+			Body:        body,
+			NextAttempt: now,
+		}); err != nil {
+			logger.Errorf("cannot queue %s event for %s: %s", ev.Type, h.URL, err)
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the main loop of the dispatcher.
+func (d *Dispatcher) run() error {
+	for {
+		d.deliverDue(context.Background(), Clock.Now())
+		select {
+		case <-d.wake:
+		case <-Clock.After(pollInterval):
+		case <-d.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// deliverDue attempts all deliveries that are due at the given time.
+func (d *Dispatcher) deliverDue(ctx context.Context, now time.Time) {
+	for {
+		deliveries, err := d.queue.Claim(ctx, now, now.Add(claimDuration), claimLimit)
+		if err != nil {
+			logger.Errorf("cannot claim webhook deliveries: %s", err)
+			return
+		}
+		for _, del := range deliveries {
+			d.attempt(ctx, del)
+			if !d.tomb.Alive() {
+				return
+			}
+		}
+		if len(deliveries) < claimLimit {
+			return
+		}
+	}
+}
+
+// attempt makes a single attempt at the given delivery and updates the
+// queue with the result.
+func (d *Dispatcher) attempt(ctx context.Context, del Delivery) {
+	secret, ok := d.secrets[del.URL]
+	if !ok {
+		// The queue may be shared with servers that have a
+		// different configuration, so leave the delivery for
+		// one that knows the URL, making it available again
+		// at their next poll.
+		logger.Debugf("skipping webhook delivery %s to unconfigured URL %s", del.ID, del.URL)
+		if err := d.queue.Reschedule(ctx, del.ID, del.Attempts, Clock.Now().Add(pollInterval)); err != nil {
+			logger.Errorf("cannot reschedule webhook delivery %s: %s", del.ID, err)
+		}
+		return
+	}
+	err := d.deliver(ctx, del, secret)
+	if err == nil {
+		d.remove(ctx, del.ID)
+		return
+	}
+	del.Attempts++
+	if del.Attempts >= d.maxAttempts {
+		logger.Errorf("discarding webhook delivery %s to %s after %d attempts: %s", del.ID, del.URL, del.Attempts, err)
+		d.remove(ctx, del.ID)
+		return
+	}
+	logger.Infof("webhook delivery %s to %s failed (attempt %d): %s", del.ID, del.URL, del.Attempts, err)
+	next := Clock.Now().Add(retryDelay(del.Attempts))
+	if err := d.queue.Reschedule(ctx, del.ID, del.Attempts, next); err != nil {
+		logger.Errorf("cannot reschedule webhook delivery %s: %s", del.ID, err)
+	}
+}
+
+func (d *Dispatcher) remove(ctx context.Context, id string) {
+	if err := d.queue.Remove(ctx, id); err != nil {
+		logger.Errorf("cannot remove webhook delivery %s: %s", id, err)
+	}
+}
+
+// deliver POSTs the given delivery to its URL, signing it and the
+// current time with the given secret.
+func (d *Dispatcher) deliver(ctx context.Context, del Delivery, secret string) error {
+	req, err := http.NewRequest("POST", del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req = req.WithContext(ctx)
+	now := Clock.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Candid-Delivery", del.ID)
+	req.Header.Set("Candid-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("Candid-Signature", Sign(secret, now, del.Body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errgo.Newf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
+
+// retryDelay returns the delay before the next attempt of a delivery
+// that has failed the given number of times.
+func retryDelay(attempts int) time.Duration {
+	delay := retryInterval
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryInterval {
+			return maxRetryInterval
+		}
+	}
+	return delay
+}
+
+// Sign returns the value of the Candid-Signature header for a request
+// with the given body sent at the given time, signed with the given
+// secret. The value is "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the value of the Candid-Timestamp header (the time in
+// seconds since the Unix epoch), a "." and the body. Receivers can
+// verify a request by computing the same value and comparing it with
+// hmac.Equal, and should reject requests with a timestamp that is not
+// recent so that requests cannot be replayed.
+func Sign(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,253 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/clock/testclock"
+
+	"github.com/CanonicalLtd/candid/store/memstore"
+	"github.com/CanonicalLtd/candid/webhook"
+)
+
+var epoch = time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newServer starts a server that sends each request it receives on
+// the returned channel and responds with the next status in statuses,
+// or 200 once they have all been used.
+func newServer(c *qt.C, statuses ...int) (*httptest.Server, <-chan request) {
+	ch := make(chan request, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		c.Check(err, qt.Equals, nil)
+		ch <- request{header: req.Header, body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	c.Defer(srv.Close)
+	return srv, ch
+}
+
+// waitIdle waits until the dispatcher has finished processing the
+// queue and is waiting for more work.
+func waitIdle(c *qt.C, clock *testclock.Clock) {
+	select {
+	case <-clock.Alarms():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for dispatcher")
+	}
+}
+
+func receive(c *qt.C, ch <-chan request) request {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for delivery")
+	}
+	panic("unreachable")
+}
+
+// pending returns all the deliveries remaining in the given queue.
+func pending(c *qt.C, q webhook.Queue) []webhook.Delivery {
+	ds, err := q.Claim(context.Background(), epoch.Add(1000*time.Hour), epoch.Add(1000*time.Hour), 100)
+	c.Assert(err, qt.Equals, nil)
+	return ds
+}
+
+func TestNotify(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&webhook.Clock, clock)
+	srv, ch := newServer(c)
+	q := memstore.NewWebhookQueue()
+	d := webhook.NewDispatcher(webhook.Params{
+		Queue: q,
+		Hooks: []webhook.Hook{{
+			URL:    srv.URL,
+			Secret: "secret",
+		}},
+	})
+	defer d.Close()
+	waitIdle(c, clock)
+
+	d.Notify(context.Background(), webhook.Event{
+		Type:     webhook.SSHKeysAdded,
+		Username: "bob",
+		SSHKeys:  []string{"ssh-rsa AAAA"},
+	})
+	r := receive(c, ch)
+	c.Assert(r.header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(r.header.Get("Candid-Delivery"), qt.Not(qt.Equals), "")
+	c.Assert(r.header.Get("Candid-Timestamp"), qt.Equals, strconv.FormatInt(epoch.Unix(), 10))
+	c.Assert(r.header.Get("Candid-Signature"), qt.Equals, webhook.Sign("secret", epoch, r.body))
+	var ev webhook.Event
+	err := json.Unmarshal(r.body, &ev)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ev.ID, qt.Not(qt.Equals), "")
+	ev.ID = ""
+	c.Assert(ev.Time.Equal(epoch), qt.Equals, true)
+	ev.Time = time.Time{}
+	c.Assert(ev, qt.DeepEquals, webhook.Event{
+		Type:     webhook.SSHKeysAdded,
+		Username: "bob",
+		SSHKeys:  []string{"ssh-rsa AAAA"},
+	})
+	waitIdle(c, clock)
+	c.Assert(pending(c, q), qt.HasLen, 0)
+}
+
+func TestRetry(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&webhook.Clock, clock)
+	srv, ch := newServer(c, http.StatusInternalServerError)
+	q := memstore.NewWebhookQueue()
+	d := webhook.NewDispatcher(webhook.Params{
+		Queue: q,
+		Hooks: []webhook.Hook{{
+			URL:    srv.URL,
+			Secret: "secret",
+		}},
+	})
+	defer d.Close()
+	waitIdle(c, clock)
+
+	d.Notify(context.Background(), webhook.Event{
+		Type:     webhook.FirstLogin,
+		Username: "bob",
+	})
+	r1 := receive(c, ch)
+	waitIdle(c, clock)
+	ds := pending(c, q)
+	c.Assert(ds, qt.HasLen, 1)
+	c.Assert(ds[0].Attempts, qt.Equals, 1)
+	// Put the delivery back to when it was scheduled.
+	err := q.Reschedule(context.Background(), ds[0].ID, 1, epoch.Add(webhook.RetryDelay(1)))
+	c.Assert(err, qt.Equals, nil)
+
+	clock.Advance(webhook.RetryDelay(1))
+	r2 := receive(c, ch)
+	c.Assert(r2.header.Get("Candid-Delivery"), qt.Equals, r1.header.Get("Candid-Delivery"))
+	c.Assert(string(r2.body), qt.Equals, string(r1.body))
+	// Each attempt is signed with the time at which it was made.
+	t2 := epoch.Add(webhook.RetryDelay(1))
+	c.Assert(r2.header.Get("Candid-Timestamp"), qt.Equals, strconv.FormatInt(t2.Unix(), 10))
+	c.Assert(r2.header.Get("Candid-Signature"), qt.Equals, webhook.Sign("secret", t2, r2.body))
+	c.Assert(r2.header.Get("Candid-Signature"), qt.Not(qt.Equals), r1.header.Get("Candid-Signature"))
+	waitIdle(c, clock)
+	c.Assert(pending(c, q), qt.HasLen, 0)
+}
+
+func TestDiscardAfterMaxAttempts(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&webhook.Clock, clock)
+	srv, ch := newServer(c, http.StatusInternalServerError)
+	q := memstore.NewWebhookQueue()
+	d := webhook.NewDispatcher(webhook.Params{
+		Queue: q,
+		Hooks: []webhook.Hook{{
+			URL: srv.URL,
+		}},
+		MaxAttempts: 1,
+	})
+	defer d.Close()
+	waitIdle(c, clock)
+
+	d.Notify(context.Background(), webhook.Event{
+		Type:     webhook.FirstLogin,
+		Username: "bob",
+	})
+	receive(c, ch)
+	waitIdle(c, clock)
+	c.Assert(pending(c, q), qt.HasLen, 0)
+}
+
+func TestSkipUnconfiguredURL(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	clock := testclock.NewClock(epoch)
+	c.Patch(&webhook.Clock, clock)
+	q := memstore.NewWebhookQueue()
+	err := q.Add(context.Background(), &webhook.Delivery{
+		URL:         "https://removed.example.com",
+		Body:        []byte("{}"),
+		NextAttempt: epoch,
+	})
+	c.Assert(err, qt.Equals, nil)
+	srv, _ := newServer(c)
+	d := webhook.NewDispatcher(webhook.Params{
+		Queue: q,
+		Hooks: []webhook.Hook{{
+			URL: srv.URL,
+		}},
+	})
+	defer d.Close()
+	waitIdle(c, clock)
+
+	// The delivery is left for a server that has the URL
+	// configured, without counting an attempt.
+	ds := pending(c, q)
+	c.Assert(ds, qt.HasLen, 1)
+	c.Assert(ds[0].URL, qt.Equals, "https://removed.example.com")
+	c.Assert(ds[0].Attempts, qt.Equals, 0)
+	c.Assert(ds[0].NextAttempt.After(epoch), qt.Equals, true)
+}
+
+func TestNotifyNilDispatcher(t *testing.T) {
+	var d *webhook.Dispatcher
+	d.Notify(context.Background(), webhook.Event{
+		Type:     webhook.FirstLogin,
+		Username: "bob",
+	})
+}
+
+var retryDelayTests = []struct {
+	attempts int
+	expect   time.Duration
+}{{
+	attempts: 1,
+	expect:   30 * time.Second,
+}, {
+	attempts: 2,
+	expect:   time.Minute,
+}, {
+	attempts: 5,
+	expect:   8 * time.Minute,
+}, {
+	attempts: 20,
+	expect:   time.Hour,
+}}
+
+func TestRetryDelay(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range retryDelayTests {
+		c.Check(webhook.RetryDelay(test.attempts), qt.Equals, test.expect, qt.Commentf("attempts %d", test.attempts))
+	}
+}
+
+func TestSign(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(webhook.Sign("key", epoch, []byte("The quick brown fox jumps over the lazy dog")), qt.Equals, "sha256=d5e1968d7d50b9348a93d6334236044294642f3ba8abb70d82123d48ae10f20d")
+}